@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/cover"
+)
+
+// 覆盖率中同一文件的一个版本
+type fileVersion struct {
+	gitHash string // 为空表示覆盖率文件名不带 git hash(普通的 go test 覆盖率)
+	lines   map[int]int
+}
+
+// annotate-diff: 输出 base..head 的 unified diff, 新增的行前面标记覆盖情况:
+// ✓ 已覆盖, ✗ 未覆盖, ? 未知(没有对应版本的覆盖率, 或该行不是语句)
+func runAnnotateDiff(args []string) error {
+	fs := NewSubCommandFlagSet("annotate-diff", "[options] base..head")
+	strCoverFile := fs.String("cover", "cover.txt", "覆盖率文件, 通常是合并输出的 cover.txt")
+	fs.Parse(args)
+	if fs.NArg() != 1 || !strings.Contains(fs.Arg(0), "..") {
+		fs.Usage()
+		return fmt.Errorf("Error: base..head required.")
+	}
+	strRange := fs.Arg(0)
+	_, head, _ := strings.Cut(strings.Replace(strRange, "...", "..", 1), "..")
+	if head == "" {
+		head = "HEAD"
+	}
+	headHash, err := GitRevParse(head)
+	if err != nil {
+		return err
+	}
+
+	profiles, err := ParseProfileFile(*strCoverFile)
+	if err != nil {
+		return fmt.Errorf("failed to parse profiles %s: %v", *strCoverFile, err)
+	}
+	versions := make(map[string][]*fileVersion) // 文件名(不带 git hash) -> 各版本
+	for _, p := range profiles {
+		fileName, gitHash := splitVersionedName(p.FileName)
+		versions[fileName] = append(versions[fileName], &fileVersion{gitHash: gitHash, lines: profileLineCounts(p)})
+	}
+
+	cmd := GitCommand("diff", "--no-color", "--no-ext-diff", strRange)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("git diff %s: %v: %s", strRange, err, strings.TrimSpace(stderr.String()))
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	var current *fileVersion
+	var bGoFile bool
+	headLine := 0
+	s := bufio.NewScanner(bytes.NewReader(out))
+	s.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for s.Scan() {
+		line := s.Text()
+		marker := "  "
+		switch {
+		case strings.HasPrefix(line, "+++ "):
+			filePath := strings.TrimPrefix(strings.TrimPrefix(line, "+++ "), "b/")
+			bGoFile = strings.HasSuffix(filePath, ".go")
+			current = findHeadVersion(versions, filePath, head, headHash)
+		case strings.HasPrefix(line, "--- "), strings.HasPrefix(line, "diff "), strings.HasPrefix(line, "index "):
+		case strings.HasPrefix(line, "@@"):
+			headLine = parseHunkHeadStart(line)
+		case strings.HasPrefix(line, "+"):
+			if bGoFile {
+				marker = "? "
+				if current != nil {
+					if count, ok := current.lines[headLine]; ok {
+						marker = "✗ "
+						if count > 0 {
+							marker = "✓ "
+						}
+					}
+				}
+			}
+			headLine++
+		case strings.HasPrefix(line, "-"), strings.HasPrefix(line, "\\"):
+		default:
+			headLine++
+		}
+		fmt.Fprintln(w, marker+line)
+	}
+	return s.Err()
+}
+
+// 拆分合并输出中的文件名 <file>.<githash>, 以 .go 结尾的文件名不带版本
+func splitVersionedName(name string) (fileName string, gitHash string) {
+	if strings.HasSuffix(name, ".go") {
+		return name, ""
+	}
+	i := strings.LastIndex(name, ".")
+	if i < 0 {
+		return name, ""
+	}
+	return name[:i], name[i+1:]
+}
+
+// 覆盖率文件中与 head 对应的版本: git hash 与 head 相同, 或内容与 head 相同的版本,
+// 文件名不带版本时认为就是 head 的覆盖率
+func findHeadVersion(versions map[string][]*fileVersion, filePath string, head string, headHash string) *fileVersion {
+	fileName, _ := SourceFileName(filePath)
+	for _, v := range versions[fileName] {
+		if v.gitHash == "" || strings.HasPrefix(headHash, v.gitHash) {
+			return v
+		}
+	}
+	for _, v := range versions[fileName] {
+		if bSame, _ := CompareVersions(v.gitHash, head, filePath); bSame {
+			return v
+		}
+	}
+	return nil
+}
+
+// 每行的最大计数
+func profileLineCounts(p *cover.Profile) map[int]int {
+	lines := make(map[int]int)
+	for _, row := range ProfileLines(p, "") {
+		lines[row.Line] = row.Count
+	}
+	return lines
+}
+
+// 解析 @@ -a,b +c,d @@ 中 head 的起始行号
+func parseHunkHeadStart(line string) int {
+	fields := strings.Fields(line)
+	if len(fields) < 3 || !strings.HasPrefix(fields[2], "+") {
+		return 0
+	}
+	start, _, _ := strings.Cut(strings.TrimPrefix(fields[2], "+"), ",")
+	n, _ := strconv.Atoi(start)
+	return n
+}
+
+// 解析引用为完整的 commit hash
+func GitRevParse(ref string) (string, error) {
+	out, err := GitCommand("rev-parse", "--verify", ref+"^{commit}").Output()
+	if err != nil {
+		return "", fmt.Errorf("unknown revision %s", ref)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// 判断输入是否为压缩包(.tar.gz, .tgz, .tar.zst, .tzst, .zip)
+func IsArchive(fileName string) bool {
+	for _, suffix := range []string{".tar.gz", ".tgz", ".tar.zst", ".tzst", ".zip"} {
+		if strings.HasSuffix(fileName, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// 读取压缩包中所有文件名带版本信息的覆盖率文件, 其余文件忽略.
+// 压缩包里的文件内容读入内存, FileName 为 <压缩包>:<包内路径>
+func ParseArchiveInputs(fileName string) ([]*CoverFileInfo, error) {
+	var fileInfos []*CoverFileInfo
+	add := func(name string, r io.Reader) error {
+		if !IsCoverFileName(name) {
+			return nil
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("failed to read %s in %s: %v", name, fileName, err)
+		}
+		fileInfo, err := ParseCoverFileInfoFrom(fileName+":"+name, bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("failed to parse version profiles %s in %s: %v", name, fileName, err)
+		}
+		fileInfos = append(fileInfos, fileInfo)
+		return nil
+	}
+
+	var err error
+	if strings.HasSuffix(fileName, ".zip") {
+		err = walkZip(fileName, add)
+	} else {
+		err = walkTar(fileName, add)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(fileInfos) == 0 {
+		return nil, fmt.Errorf("no cover.txt.xxx.xxx file found in %s", fileName)
+	}
+	return fileInfos, nil
+}
+
+func walkZip(fileName string, fn func(name string, r io.Reader) error) error {
+	zr, err := zip.OpenReader(fileName)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = fn(f.Name, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 遍历 gzip 或 zstd 压缩的 tar 包
+func walkTar(fileName string, fn func(name string, r io.Reader) error) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, closeReader, err := NewDecompressReader(f)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", fileName, err)
+	}
+	defer closeReader()
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", fileName, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(hdr.Name, tr); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,44 @@
+//go:build ignore
+
+// 检查报告资源没有外部引用(go generate 时运行): go run assets/checkoffline.go assets
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// 与 offline.go 中的 g_reExternalRef 一致
+var g_reExternalRef = regexp.MustCompile(`(?i)(\b(?:src|href|action|poster|srcset|data)\s*=\s*["']\s*(?:https?:)?//|url\(\s*["']?\s*(?:https?:)?//|@import\s+(?:url\()?\s*["']?\s*(?:https?:)?//|\bfetch\(\s*["'](?:https?:)?//)`)
+
+func main() {
+	dir := "assets"
+	if len(os.Args) > 1 {
+		dir = os.Args[1]
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	bFailed := false
+	for _, file := range files {
+		if filepath.Ext(file) == ".go" {
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		for _, loc := range g_reExternalRef.FindAllIndex(data, -1) {
+			fmt.Printf("%s: external URL %q\n", file, data[loc[0]:loc[1]])
+			bFailed = true
+		}
+	}
+	if bFailed {
+		os.Exit(1)
+	}
+}
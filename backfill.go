@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"golang.org/x/tools/cover"
+)
+
+// 合并输出中文件名的 git hash 后缀, 如 example.com/foo/foo.go.e24dac6
+var g_reHashSuffix = regexp.MustCompile(`^(.+\.[^./]+)\.([0-9a-f]{4,40})$`)
+
+// backfill: 把以前生成的合并结果(cover.txt)导入历史库, 让趋势图从已有的历史开始.
+// 版本信息取自文件名(cover.txt.timestamp.hash 或 -name-pattern), 开头的注释, 或 -hash/-timestamp
+func runBackfill(args []string) error {
+	flags := NewSubCommandFlagSet("backfill", "[options] dir/|cover.txt ...")
+	strDB := flags.String("db", "cover.db", "覆盖率历史库(SQLite)")
+	strTags := flags.String("tags", "", "导入的运行额外带上的标签, 所有运行都带有 backfill=true")
+	strInclude := flags.String("include", "cover.txt*", "目录中导入的文件名(通配符, 匹配文件名)")
+	strHash := flags.String("hash", "", "文件名和内容都没有版本信息时使用的 git hash(为空时跳过这些文件)")
+	timestamp := flags.Int64("timestamp", 0, "与 -hash 一起使用的时间戳, 为 0 时取文件的修改时间")
+	flags.StringVar(g_strNamePattern, "name-pattern", "", "从路径中提取版本信息的正则表达式, 同主命令的 -name-pattern")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("Error: dir or cover file required.")
+	}
+	if *g_strNamePattern != "" {
+		if _, err := namePattern(); err != nil {
+			return err
+		}
+	}
+	if _, err := filepath.Match(*strInclude, ""); err != nil {
+		return fmt.Errorf("bad -include pattern %q", *strInclude)
+	}
+	tags, err := ParseTags(*strTags)
+	if err != nil {
+		return err
+	}
+	tags["backfill"] = "true"
+
+	var files []string
+	for _, arg := range flags.Args() {
+		stat, err := os.Stat(arg)
+		if err != nil {
+			return err
+		}
+		if !stat.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		err = filepath.WalkDir(arg, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if bMatch, _ := filepath.Match(*strInclude, d.Name()); bMatch && !d.IsDir() {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	sort.Strings(files)
+	if len(files) == 0 {
+		return fmt.Errorf("no file matching %s found", *strInclude)
+	}
+
+	store, err := OpenStore(*strDB)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	commitTimes := make(map[string]int64)
+	for _, file := range files {
+		fileInfo, profiles, err := readBackfillFile(file, *strHash, *timestamp)
+		if err != nil {
+			fmt.Println("skip ", file, ": ", err)
+			continue
+		}
+		profiles = latestFileVersions(profiles, fileInfo.GitHash, commitTimes)
+		runID, bDuplicate, err := store.ImportRun(fileInfo, profiles, MergeTags(tags, fileInfo.Tags), "")
+		if err != nil {
+			return fmt.Errorf("failed to import %s: %v", file, err)
+		}
+		if bDuplicate {
+			fmt.Println("skip ", file, ", already imported as run ", runID, ".")
+			continue
+		}
+		fmt.Println("import ", file, " as run ", runID, " ok.")
+	}
+	return nil
+}
+
+// 读取一个合并结果和它的版本信息, 没有版本信息时使用 gitHash 和 timestamp(为 0 时取修改时间)
+func readBackfillFile(file string, gitHash string, timestamp int64) (*CoverFileInfo, []*cover.Profile, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	fileInfo, err := ParseCoverFileInfoFrom(file, f)
+	if err != nil {
+		if gitHash == "" {
+			return nil, nil, fmt.Errorf("no version in name or header, use -hash")
+		}
+		if timestamp == 0 {
+			stat, err := f.Stat()
+			if err != nil {
+				return nil, nil, err
+			}
+			timestamp = stat.ModTime().Unix()
+		}
+		if _, err := f.Seek(0, 0); err != nil {
+			return nil, nil, err
+		}
+		fileInfo = &CoverFileInfo{Timestamp: timestamp, GitHash: gitHash, FileName: file, Reader: f}
+	}
+	profiles, err := fileInfo.ReadProfiles()
+	if err != nil {
+		return nil, nil, fmt.Errorf("not a cover profile: %v", err)
+	}
+	if len(profiles) == 0 {
+		return nil, nil, fmt.Errorf("no coverage data")
+	}
+	fileInfo.Reader = nil
+	return fileInfo, profiles, nil
+}
+
+// 合并结果的文件名带有 git hash 后缀, 同一文件可能有多个版本. 历史库中每次运行的文件名不带后缀,
+// 每个文件只保留一个版本: 运行的 git hash 对应的版本, 没有时取提交时间最晚的版本
+func latestFileVersions(profiles []*cover.Profile, runHash string, commitTimes map[string]int64) []*cover.Profile {
+	type version struct {
+		gitHash string
+		profile *cover.Profile
+	}
+	latest := make(map[string]version)
+	var names []string
+	for _, p := range profiles {
+		name, gitHash := p.FileName, ""
+		if m := g_reHashSuffix.FindStringSubmatch(p.FileName); m != nil {
+			name, gitHash = m[1], m[2]
+		}
+		old, ok := latest[name]
+		if !ok {
+			names = append(names, name)
+		} else if old.gitHash == runHash || (gitHash != runHash && !newerCommit(gitHash, old.gitHash, commitTimes)) {
+			continue
+		}
+		latest[name] = version{gitHash: gitHash, profile: p}
+	}
+	sort.Strings(names)
+	result := make([]*cover.Profile, 0, len(names))
+	for _, name := range names {
+		p := *latest[name].profile
+		p.FileName = name
+		result = append(result, &p)
+	}
+	return result
+}
+
+// 比较两个提交的提交时间, 取不到时间(如提交不在本地仓库)时按 0 处理, 时间相同时按 hash 比较
+func newerCommit(a, b string, commitTimes map[string]int64) bool {
+	ta, tb := commitTime(a, commitTimes), commitTime(b, commitTimes)
+	if ta != tb {
+		return ta > tb
+	}
+	return a > b
+}
+
+func commitTime(gitHash string, commitTimes map[string]int64) int64 {
+	if gitHash == "" {
+		return 0
+	}
+	if t, ok := commitTimes[gitHash]; ok {
+		return t
+	}
+	var t int64
+	if commit, err := GitCommitInfo(gitHash); err == nil {
+		t = commit.Time
+	}
+	commitTimes[gitHash] = t
+	return t
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/cover"
+)
+
+var (
+	g_strBadge           = flag.String("badge", "", "输出 shields.io 风格的总覆盖率 SVG 徽章, 如 coverage.svg(为空不输出)")
+	g_strBadgeThresholds = flag.String("badge-thresholds", "0:red,50:orange,70:yellow,80:yellowgreen,90:brightgreen", "徽章颜色: 覆盖率(%)不低于 min 时使用 color, 格式 min:color,..., color 为 shields.io 颜色名或 #rrggbb")
+)
+
+// shields.io 的颜色名
+var g_mapBadgeColors = map[string]string{
+	"brightgreen": "#4c1",
+	"green":       "#97ca00",
+	"yellowgreen": "#a4a61d",
+	"yellow":      "#dfb317",
+	"orange":      "#fe7d37",
+	"red":         "#e05d44",
+	"lightgrey":   "#9f9f9f",
+	"blue":        "#007ec6",
+}
+
+type badgeThreshold struct {
+	min   float64
+	color string
+}
+
+// 解析 min:color,... 形式的颜色阈值, 按 min 从小到大排序
+func ParseBadgeThresholds(s string) ([]badgeThreshold, error) {
+	var thresholds []badgeThreshold
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		strMin, color, ok := strings.Cut(item, ":")
+		if !ok {
+			return nil, fmt.Errorf("badge threshold %q is not in min:color form", item)
+		}
+		fMin, err := strconv.ParseFloat(strMin, 64)
+		if err != nil {
+			return nil, fmt.Errorf("badge threshold %q: invalid min", item)
+		}
+		if hex, ok := g_mapBadgeColors[color]; ok {
+			color = hex
+		} else if !isHexColor(color) {
+			return nil, fmt.Errorf("badge threshold %q: unknown color", item)
+		}
+		thresholds = append(thresholds, badgeThreshold{min: fMin, color: color})
+	}
+	if len(thresholds) == 0 {
+		return nil, fmt.Errorf("no badge thresholds")
+	}
+	sort.Slice(thresholds, func(i, j int) bool { return thresholds[i].min < thresholds[j].min })
+	return thresholds, nil
+}
+
+func isHexColor(s string) bool {
+	if !strings.HasPrefix(s, "#") || (len(s) != 4 && len(s) != 7) {
+		return false
+	}
+	_, err := strconv.ParseUint(s[1:], 16, 32)
+	return err == nil
+}
+
+// 覆盖率对应的颜色: min 不超过覆盖率的最大阈值, 低于所有阈值时使用最小的阈值
+func badgeColor(thresholds []badgeThreshold, percent float64) string {
+	color := thresholds[0].color
+	for _, t := range thresholds {
+		if percent >= t.min {
+			color = t.color
+		}
+	}
+	return color
+}
+
+// 文字宽度的近似值(Verdana 11px), 徽章不需要精确
+func badgeTextWidth(s string) int {
+	return len(s)*7 + 10
+}
+
+// 写出总覆盖率徽章
+func WriteBadge(fileName string, profiles []*cover.Profile) error {
+	thresholds, err := ParseBadgeThresholds(*g_strBadgeThresholds)
+	if err != nil {
+		return err
+	}
+	var covered, total int
+	for _, stat := range ComputeFileCoverage(profiles) {
+		covered += stat.Covered
+		total += stat.Statements
+	}
+	percent := FileCoverage{Statements: total, Covered: covered}.Percent()
+	label, value := "coverage", fmt.Sprintf("%.1f%%", percent)
+	color := badgeColor(thresholds, percent)
+	if total == 0 {
+		value, color = "unknown", g_mapBadgeColors["lightgrey"]
+	}
+
+	labelWidth, valueWidth := badgeTextWidth(label), badgeTextWidth(value)
+	width := labelWidth + valueWidth
+	svg := fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[4]s: %[5]s">
+<title>%[4]s: %[5]s</title>
+<linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="%[1]d" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)"><rect width="%[2]d" height="20" fill="#555"/><rect x="%[2]d" width="%[3]d" height="20" fill="%[6]s"/><rect width="%[1]d" height="20" fill="url(#s)"/></g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="%[7]d" y="15" fill="#010101" fill-opacity=".3">%[4]s</text><text x="%[7]d" y="14">%[4]s</text>
+<text x="%[8]d" y="15" fill="#010101" fill-opacity=".3">%[5]s</text><text x="%[8]d" y="14">%[5]s</text>
+</g>
+</svg>
+`, width, labelWidth, valueWidth, label, value, color, labelWidth/2, labelWidth+valueWidth/2)
+
+	outFile, upload, err := LocalOutput(fileName)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(outFile, []byte(svg), 0644); err != nil {
+		return err
+	}
+	return upload()
+}
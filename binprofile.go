@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"golang.org/x/tools/cover"
+)
+
+// 二进制中间文件格式, 所有整数使用 varint 编码:
+//
+//	magic "GCMB" | 格式版本(1 字节)
+//	版本记录: githash | timestamp | 标签数 | (key, value)... | 文件数 | 文件记录...
+//	文件记录: 文件名 | mode | 块数 | 块... (StartLine 相对上一个块做差分)
+//	索引: 条目数 | (githash, 文件名, 文件记录偏移)...
+//	尾部: 索引偏移(8 字节小端) | magic "GCMI"
+//
+// 索引使得只查询单个文件时不需要读入整个中间文件. 格式版本 1 没有标签, 仍然可以读取
+const (
+	binProfileMagic      = "GCMB"
+	binProfileIndexMagic = "GCMI"
+	binProfileVersion    = 2
+)
+
+// 索引条目
+type BinProfileIndexEntry struct {
+	GitHash  string
+	FileName string
+	Offset   int64
+}
+
+// 带偏移计数的写入器
+type binProfileWriter struct {
+	w      *bufio.Writer
+	offset int64
+	buf    [binary.MaxVarintLen64]byte
+}
+
+func (bw *binProfileWriter) write(p []byte) error {
+	n, err := bw.w.Write(p)
+	bw.offset += int64(n)
+	return err
+}
+
+func (bw *binProfileWriter) uvarint(v uint64) error {
+	return bw.write(bw.buf[:binary.PutUvarint(bw.buf[:], v)])
+}
+
+func (bw *binProfileWriter) varint(v int64) error {
+	return bw.write(bw.buf[:binary.PutVarint(bw.buf[:], v)])
+}
+
+func (bw *binProfileWriter) string(s string) error {
+	if err := bw.uvarint(uint64(len(s))); err != nil {
+		return err
+	}
+	return bw.write([]byte(s))
+}
+
+// 写出二进制中间文件
+func WriteBinaryPartial(coverFiles []*CoverFileInfo, out io.Writer) error {
+	bw := &binProfileWriter{w: bufio.NewWriter(out)}
+	if err := bw.write(append([]byte(binProfileMagic), binProfileVersion)); err != nil {
+		return err
+	}
+
+	var index []BinProfileIndexEntry
+	for _, coverFile := range coverFiles {
+		if err := bw.string(coverFile.GitHash); err != nil {
+			return err
+		}
+		if err := bw.varint(coverFile.Timestamp); err != nil {
+			return err
+		}
+		if err := writeBinaryTags(bw, coverFile.Tags); err != nil {
+			return err
+		}
+		if err := bw.uvarint(uint64(len(coverFile.Profiles))); err != nil {
+			return err
+		}
+		for _, p := range coverFile.Profiles {
+			index = append(index, BinProfileIndexEntry{GitHash: coverFile.GitHash, FileName: p.FileName, Offset: bw.offset})
+			if err := writeBinaryProfile(bw, p); err != nil {
+				return err
+			}
+		}
+	}
+
+	indexOffset := bw.offset
+	if err := bw.uvarint(uint64(len(index))); err != nil {
+		return err
+	}
+	for _, entry := range index {
+		if err := bw.string(entry.GitHash); err != nil {
+			return err
+		}
+		if err := bw.string(entry.FileName); err != nil {
+			return err
+		}
+		if err := bw.uvarint(uint64(entry.Offset)); err != nil {
+			return err
+		}
+	}
+	var tail [8]byte
+	binary.LittleEndian.PutUint64(tail[:], uint64(indexOffset))
+	if err := bw.write(append(tail[:], binProfileIndexMagic...)); err != nil {
+		return err
+	}
+	return bw.w.Flush()
+}
+
+// 按 key 排序写出标签, 内容相同的输入写出的文件相同
+func writeBinaryTags(bw *binProfileWriter, tags map[string]string) error {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if err := bw.uvarint(uint64(len(keys))); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := bw.string(key); err != nil {
+			return err
+		}
+		if err := bw.string(tags[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readBinaryTags(br *binProfileReader) map[string]string {
+	n := br.count(2)
+	if n == 0 || br.err != nil {
+		return nil
+	}
+	tags := make(map[string]string, n)
+	for i := uint64(0); i < n && br.err == nil; i++ {
+		key := br.string()
+		tags[key] = br.string()
+	}
+	return tags
+}
+
+func writeBinaryProfile(bw *binProfileWriter, p *cover.Profile) error {
+	if err := bw.string(p.FileName); err != nil {
+		return err
+	}
+	if err := bw.string(p.Mode); err != nil {
+		return err
+	}
+	if err := bw.uvarint(uint64(len(p.Blocks))); err != nil {
+		return err
+	}
+	lastLine := 0
+	for _, b := range p.Blocks {
+		for _, v := range []int{b.StartLine - lastLine, b.StartCol, b.EndLine - b.StartLine, b.EndCol, b.NumStmt} {
+			if err := bw.varint(int64(v)); err != nil {
+				return err
+			}
+		}
+		if err := bw.uvarint(uint64(b.Count)); err != nil {
+			return err
+		}
+		lastLine = b.StartLine
+	}
+	return nil
+}
+
+// 带错误记录的读取器, 出错后后续读取都返回零值, 由调用方统一检查 err.
+// remain 是段中剩下的字节数, 文件中的长度和个数都先与它比较, 损坏的文件不会导致超大的分配
+type binProfileReader struct {
+	r      *bufio.Reader
+	remain int64
+	err    error
+}
+
+var errBinProfileLength = errors.New("length out of range")
+
+// 读取文件 [offset, end) 段的读取器
+func newBinProfileReader(f *os.File, offset int64, end int64) *binProfileReader {
+	return &binProfileReader{r: bufio.NewReader(io.NewSectionReader(f, offset, end-offset)), remain: end - offset}
+}
+
+func (br *binProfileReader) ReadByte() (byte, error) {
+	b, err := br.r.ReadByte()
+	if err == nil {
+		br.remain--
+	}
+	return b, err
+}
+
+func (br *binProfileReader) read(buf []byte) {
+	if br.err != nil {
+		return
+	}
+	var n int
+	n, br.err = io.ReadFull(br.r, buf)
+	br.remain -= int64(n)
+}
+
+func (br *binProfileReader) uvarint() uint64 {
+	if br.err != nil {
+		return 0
+	}
+	var v uint64
+	v, br.err = binary.ReadUvarint(br)
+	return v
+}
+
+func (br *binProfileReader) varint() int64 {
+	if br.err != nil {
+		return 0
+	}
+	var v int64
+	v, br.err = binary.ReadVarint(br)
+	return v
+}
+
+// 读取个数, 每项至少占 minSize 字节, 剩下的字节放不下时出错
+func (br *binProfileReader) count(minSize int64) uint64 {
+	n := br.uvarint()
+	if br.err == nil && n > uint64(br.remain/minSize) {
+		br.err = errBinProfileLength
+		return 0
+	}
+	return n
+}
+
+func (br *binProfileReader) string() string {
+	n := br.count(1)
+	if br.err != nil {
+		return ""
+	}
+	buf := make([]byte, n)
+	br.read(buf)
+	return string(buf)
+}
+
+func readBinaryProfile(br *binProfileReader) *cover.Profile {
+	p := &cover.Profile{
+		FileName: br.string(),
+		Mode:     br.string(),
+	}
+	// 每个块是 6 个变长整数
+	n := br.count(6)
+	if br.err != nil {
+		return nil
+	}
+	lastLine := 0
+	for i := uint64(0); i < n && br.err == nil; i++ {
+		var b cover.ProfileBlock
+		b.StartLine = lastLine + int(br.varint())
+		b.StartCol = int(br.varint())
+		b.EndLine = b.StartLine + int(br.varint())
+		b.EndCol = int(br.varint())
+		b.NumStmt = int(br.varint())
+		// 写出时计数不会超过 math.MaxInt, 更大的值只来自其他写入方, 饱和而不是回绕为负数
+		b.Count = saturatedCount(br.uvarint())
+		p.Blocks = append(p.Blocks, b)
+		lastLine = b.StartLine
+	}
+	return p
+}
+
+// 判断文件是否为二进制中间文件
+func IsBinaryPartial(fileName string) bool {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	magic := make([]byte, len(binProfileMagic))
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return false
+	}
+	return string(magic) == binProfileMagic
+}
+
+// 读取整个二进制中间文件, 每个版本一个 CoverFileInfo
+func ReadBinaryPartial(fileName string) ([]*CoverFileInfo, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	indexOffset, _, err := readBinaryPartialTail(f)
+	if err != nil {
+		return nil, err
+	}
+
+	br := newBinProfileReader(f, 0, indexOffset)
+	header := make([]byte, len(binProfileMagic)+1)
+	if br.read(header); br.err != nil {
+		return nil, br.err
+	}
+	version := header[len(binProfileMagic)]
+	if version != 1 && version != binProfileVersion {
+		return nil, fmt.Errorf("unsupported binary partial version %d", version)
+	}
+
+	var coverFiles []*CoverFileInfo
+	for br.remain > 0 {
+		coverFile := &CoverFileInfo{
+			GitHash:   br.string(),
+			Timestamp: br.varint(),
+			FileName:  fileName,
+		}
+		if version >= 2 {
+			coverFile.Tags = readBinaryTags(br)
+		}
+		// 每个文件记录至少有文件名, mode 和块数 3 个字节
+		n := br.count(3)
+		coverFile.Profiles = []*cover.Profile{}
+		for i := uint64(0); i < n && br.err == nil; i++ {
+			coverFile.Profiles = append(coverFile.Profiles, readBinaryProfile(br))
+		}
+		if br.err != nil {
+			return nil, fmt.Errorf("corrupt binary partial file: %v", br.err)
+		}
+		coverFiles = append(coverFiles, coverFile)
+	}
+	return coverFiles, nil
+}
+
+// 读取尾部, 返回索引的起止偏移
+func readBinaryPartialTail(f *os.File) (indexOffset int64, indexEnd int64, err error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	tailSize := int64(8 + len(binProfileIndexMagic))
+	if stat.Size() < int64(len(binProfileMagic)+1)+tailSize {
+		return 0, 0, fmt.Errorf("binary partial file is truncated")
+	}
+	tail := make([]byte, tailSize)
+	if _, err := f.ReadAt(tail, stat.Size()-tailSize); err != nil {
+		return 0, 0, err
+	}
+	if string(tail[8:]) != binProfileIndexMagic {
+		return 0, 0, fmt.Errorf("binary partial file is truncated")
+	}
+	indexEnd = stat.Size() - tailSize
+	offset := binary.LittleEndian.Uint64(tail[:8])
+	if offset < uint64(len(binProfileMagic)+1) || offset > uint64(indexEnd) {
+		return 0, 0, fmt.Errorf("corrupt binary partial index offset")
+	}
+	return int64(offset), indexEnd, nil
+}
+
+// 只读取二进制中间文件的索引
+func ReadBinaryPartialIndex(fileName string) ([]BinProfileIndexEntry, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	indexOffset, indexEnd, err := readBinaryPartialTail(f)
+	if err != nil {
+		return nil, err
+	}
+	br := newBinProfileReader(f, indexOffset, indexEnd)
+	n := br.count(3)
+	var index []BinProfileIndexEntry
+	for i := uint64(0); i < n && br.err == nil; i++ {
+		entry := BinProfileIndexEntry{
+			GitHash:  br.string(),
+			FileName: br.string(),
+		}
+		// 文件记录在索引之前
+		if offset := br.uvarint(); br.err == nil && offset >= uint64(indexOffset) {
+			br.err = errBinProfileLength
+		} else {
+			entry.Offset = int64(offset)
+		}
+		index = append(index, entry)
+	}
+	if br.err != nil {
+		return nil, fmt.Errorf("corrupt binary partial index: %v", br.err)
+	}
+	sort.Slice(index, func(i, j int) bool {
+		if index[i].GitHash != index[j].GitHash {
+			return index[i].GitHash < index[j].GitHash
+		}
+		return index[i].FileName < index[j].FileName
+	})
+	return index, nil
+}
+
+// 通过索引读取指定版本的单个文件覆盖率
+func LookupBinaryProfile(fileName string, entry BinProfileIndexEntry) (*cover.Profile, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	indexOffset, _, err := readBinaryPartialTail(f)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Offset < 0 || entry.Offset >= indexOffset {
+		return nil, fmt.Errorf("corrupt binary partial index: offset %d out of range", entry.Offset)
+	}
+	br := newBinProfileReader(f, entry.Offset, indexOffset)
+	p := readBinaryProfile(br)
+	if br.err != nil {
+		return nil, fmt.Errorf("corrupt binary partial file: %v", br.err)
+	}
+	return p, nil
+}
+
+// inspect: 查看二进制中间文件的索引, 或按索引输出某个版本某个文件的覆盖率
+func runInspect(args []string) error {
+	fs := NewSubCommandFlagSet("inspect", "cover.partial [githash file]")
+	fs.Parse(args)
+	if fs.NArg() != 1 && fs.NArg() != 3 {
+		fs.Usage()
+		return fmt.Errorf("Error: binary partial file required.")
+	}
+
+	fileName := fs.Arg(0)
+	index, err := ReadBinaryPartialIndex(fileName)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() == 1 {
+		for _, entry := range index {
+			fmt.Printf("%s\t%s\t%d\n", entry.GitHash, entry.FileName, entry.Offset)
+		}
+		return nil
+	}
+
+	gitHash, file := fs.Arg(1), fs.Arg(2)
+	for _, entry := range index {
+		if entry.GitHash == gitHash && entry.FileName == file {
+			p, err := LookupBinaryProfile(fileName, entry)
+			if err != nil {
+				return err
+			}
+			return DumpProfiles([]*cover.Profile{p}, os.Stdout)
+		}
+	}
+	return fmt.Errorf("%s not found in version %s", file, gitHash)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/cover"
+)
+
+func TestBinaryPartialRoundTrip(t *testing.T) {
+	coverFiles := []*CoverFileInfo{
+		{
+			GitHash:   "0b57328",
+			Timestamp: 100,
+			Tags:      map[string]string{"suite": "unit", "env": "ci x"},
+			Profiles: []*cover.Profile{
+				{FileName: "example.com/foo/bar.go", Mode: "count", Blocks: []cover.ProfileBlock{
+					{StartLine: 3, StartCol: 14, EndLine: 5, EndCol: 2, NumStmt: 1, Count: 7},
+					{StartLine: 7, StartCol: 1, EndLine: 9, EndCol: 2, NumStmt: 2, Count: 0},
+				}},
+				{FileName: "example.com/foo/foo.go", Mode: "count", Blocks: []cover.ProfileBlock{
+					{StartLine: 10, StartCol: 20, EndLine: 12, EndCol: 3, NumStmt: 3, Count: 1 << 40},
+				}},
+			},
+		},
+		{
+			GitHash:   "0512fda",
+			Timestamp: 200,
+			Profiles: []*cover.Profile{
+				{FileName: "example.com/foo/bar.go", Mode: "count", Blocks: []cover.ProfileBlock{
+					{StartLine: 4, StartCol: 1, EndLine: 4, EndCol: 9, NumStmt: 1, Count: 2},
+				}},
+			},
+		},
+	}
+	fileName := filepath.Join(t.TempDir(), "partial.bin")
+	f, err := os.Create(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteBinaryPartial(coverFiles, f); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsBinaryPartial(fileName) {
+		t.Fatal("IsBinaryPartial = false")
+	}
+	read, err := ReadBinaryPartial(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != len(coverFiles) {
+		t.Fatalf("read %d versions, want %d", len(read), len(coverFiles))
+	}
+	for i, want := range coverFiles {
+		got := read[i]
+		if got.GitHash != want.GitHash || got.Timestamp != want.Timestamp || !reflect.DeepEqual(got.Tags, want.Tags) {
+			t.Errorf("version %d = %s %d %v, want %s %d %v", i, got.GitHash, got.Timestamp, got.Tags, want.GitHash, want.Timestamp, want.Tags)
+		}
+		if !reflect.DeepEqual(got.Profiles, want.Profiles) {
+			t.Errorf("version %d profiles differ:\n got %+v\nwant %+v", i, got.Profiles, want.Profiles)
+		}
+	}
+
+	index, err := ReadBinaryPartialIndex(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index) != 3 {
+		t.Fatalf("index has %d entries, want 3", len(index))
+	}
+	for _, entry := range index {
+		p, err := LookupBinaryProfile(fileName, entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want *cover.Profile
+		for _, coverFile := range coverFiles {
+			for _, wp := range coverFile.Profiles {
+				if coverFile.GitHash == entry.GitHash && wp.FileName == entry.FileName {
+					want = wp
+				}
+			}
+		}
+		if !reflect.DeepEqual(p, want) {
+			t.Errorf("lookup %s %s = %+v, want %+v", entry.GitHash, entry.FileName, p, want)
+		}
+	}
+}
+
+func TestBinaryPartialTruncated(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "partial.bin")
+	if err := os.WriteFile(fileName, []byte(binProfileMagic+"\x02"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadBinaryPartial(fileName); err == nil {
+		t.Error("no error reading truncated file")
+	}
+}
+
+// 手工构造的中间文件: 头部, 版本记录, 索引和尾部
+func writeRawBinaryPartial(t *testing.T, records []byte, index []byte) string {
+	data := append([]byte(binProfileMagic), binProfileVersion)
+	data = append(data, records...)
+	indexOffset := len(data)
+	data = append(data, index...)
+	data = binary.LittleEndian.AppendUint64(data, uint64(indexOffset))
+	data = append(data, binProfileIndexMagic...)
+	fileName := filepath.Join(t.TempDir(), "partial.bin")
+	if err := os.WriteFile(fileName, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+// 损坏的长度和个数应该返回错误, 而不是 panic 或按长度分配内存
+func TestBinaryPartialHugeLength(t *testing.T) {
+	huge := binary.AppendUvarint(nil, 1<<62)
+	cat := func(parts ...[]byte) []byte {
+		var b []byte
+		for _, part := range parts {
+			b = append(b, part...)
+		}
+		return b
+	}
+	empty := []byte{0} // 空字符串, 时间戳 0 或个数 0
+	for name, records := range map[string][]byte{
+		"git hash":     huge,
+		"tag count":    cat(empty, empty, huge),
+		"file count":   cat(empty, empty, empty, huge),
+		"file name":    cat(empty, empty, empty, []byte{1}, huge),
+		"block count":  cat(empty, empty, empty, []byte{1}, empty, empty, huge),
+		"string > max": cat(binary.AppendUvarint(nil, math.MaxUint64)),
+	} {
+		fileName := writeRawBinaryPartial(t, records, empty)
+		if _, err := ReadBinaryPartial(fileName); err == nil || !strings.Contains(err.Error(), "corrupt binary partial file") {
+			t.Errorf("%s: err = %v, want corrupt binary partial file", name, err)
+		}
+	}
+
+	fileName := writeRawBinaryPartial(t, nil, huge)
+	if _, err := ReadBinaryPartialIndex(fileName); err == nil {
+		t.Error("index count: no error")
+	}
+	fileName = writeRawBinaryPartial(t, nil, cat([]byte{1}, empty, empty, huge))
+	if _, err := ReadBinaryPartialIndex(fileName); err == nil {
+		t.Error("index offset: no error")
+	}
+}
+
+// 其他写入方写出的超过 math.MaxInt 的计数饱和, 而不是回绕为负数
+func TestBinaryPartialSaturate(t *testing.T) {
+	var records []byte
+	for _, v := range []uint64{0, 0, 0, 1, 0, 0, 1} { // 空 hash, 时间戳 0, 无标签, 1 个文件(空文件名和 mode), 1 个块
+		records = binary.AppendUvarint(records, v)
+	}
+	for range 5 {
+		records = binary.AppendVarint(records, 0)
+	}
+	records = binary.AppendUvarint(records, math.MaxUint64)
+	coverFiles, err := ReadBinaryPartial(writeRawBinaryPartial(t, records, []byte{0}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := coverFiles[0].Profiles[0].Blocks[0].Count; count != math.MaxInt {
+		t.Errorf("count = %d, want %d", count, math.MaxInt)
+	}
+}
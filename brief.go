@@ -0,0 +1,245 @@
+package main
+
+import (
+	_ "embed"
+	"flag"
+	"fmt"
+	"html"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/tools/cover"
+)
+
+var (
+	g_strReport    = flag.String("report", "", "额外生成的报告: per-package-brief 每个包一页的简要报告(适合打印), per-package 每个包的覆盖率统计, facts 每个包的覆盖率要点(适合放入代码助手的提示)(为空不生成)")
+	g_strOutReport = flag.String("outreport", "", "-report 报告的输出文件, 默认 per-package-brief 为 cover-brief.html, per-package 和 facts 输出到标准输出(.csv 或 .json 结尾时输出 CSV 或 JSON)")
+)
+
+// 每个包列出的覆盖率最低的函数个数
+const briefWorstFuncs = 10
+
+//go:embed assets/brief.css
+var g_briefCSS string
+
+// 简要报告中的一个函数
+type briefFunc struct {
+	name       string
+	file       string // 显示的位置, 文件有多个版本时带 git hash
+	line       int
+	statements int
+	covered    int
+	exported   bool
+}
+
+// 简要报告中的一个包
+type briefPackage struct {
+	importPath string
+	files      map[string]int // 文件 -> 版本数
+	statements int
+	covered    int
+	funcs      []briefFunc
+}
+
+func percentOf(covered int, statements int) float64 {
+	if statements == 0 {
+		return 0
+	}
+	return float64(covered) * 100 / float64(statements)
+}
+
+// 按 -report 生成额外的报告, profiles 的文件名带 git hash 后缀, 对应的源码在 go/src 下,
+// latestProfiles 和 latestHashes 为每个文件的最新版本(来自 LatestVersionProfiles)
+func WriteReport(profiles []*cover.Profile, latestProfiles []*cover.Profile, latestHashes map[string]string) error {
+	outReport := *g_strOutReport
+	var write func(fileName string) error
+	switch *g_strReport {
+	case "":
+		return nil
+	case "per-package-brief":
+		if outReport == "" {
+			outReport = "cover-brief.html"
+		}
+		write = func(fileName string) error { return WritePackageBrief(fileName, profiles) }
+	case "per-package":
+		stats, err := ComputePackageCoverage(latestProfiles)
+		if err != nil {
+			return err
+		}
+		if outReport == "" || outReport == "-" {
+			return PrintPackageCoverage(os.Stdout, stats)
+		}
+		write = func(fileName string) error { return WritePackageCoverage(fileName, stats) }
+	case "facts":
+		facts, err := ComputeCoverageFacts(latestProfiles, latestHashes, factsLimit)
+		if err != nil {
+			return err
+		}
+		if outReport == "" || outReport == "-" {
+			return WriteCoverageFacts(os.Stdout, facts, false)
+		}
+		write = func(fileName string) error {
+			outFile, err := os.Create(fileName)
+			if err != nil {
+				return fmt.Errorf("error creating outFile: %v", err)
+			}
+			defer outFile.Close()
+			if err := WriteCoverageFacts(outFile, facts, path.Ext(outReport) == ".json"); err != nil {
+				return err
+			}
+			return outFile.Close()
+		}
+	default:
+		return fmt.Errorf("unsupported report '%s'", *g_strReport)
+	}
+	outFile, upload, err := LocalOutput(outReport)
+	if err != nil {
+		return err
+	}
+	if err := write(outFile); err != nil {
+		return err
+	}
+	if err := upload(); err != nil {
+		return err
+	}
+	fmt.Println("generate ", outReport, " ok.")
+	return nil
+}
+
+// 每个包一页: 覆盖率汇总, 覆盖率最低的函数, 没有覆盖的导出函数和方法
+func WritePackageBrief(fileName string, profiles []*cover.Profile) error {
+	packages := make(map[string]*briefPackage)
+	for _, p := range profiles {
+		importPath := path.Dir(p.FileName)
+		pkg := packages[importPath]
+		if pkg == nil {
+			pkg = &briefPackage{importPath: importPath, files: make(map[string]int)}
+			packages[importPath] = pkg
+		}
+		pkg.files[trimHashSuffix(p.FileName)]++
+	}
+	for _, p := range profiles {
+		pkg := packages[path.Dir(p.FileName)]
+		// 文件有多个版本时位置带上版本
+		file := path.Base(trimHashSuffix(p.FileName))
+		if pkg.files[trimHashSuffix(p.FileName)] > 1 {
+			file = path.Base(p.FileName)
+		}
+		funcs, _ := profileFuncs(p)
+		for _, f := range funcs {
+			bf := briefFunc{name: f.name, file: file, exported: isExportedFunc(f.name) && !f.lit}
+			for i, b := range f.blocks {
+				if i == 0 || b.StartLine < bf.line {
+					bf.line = b.StartLine
+				}
+				bf.statements += b.NumStmt
+				if b.Count > 0 {
+					bf.covered += b.NumStmt
+				}
+			}
+			pkg.statements += bf.statements
+			pkg.covered += bf.covered
+			pkg.funcs = append(pkg.funcs, bf)
+		}
+	}
+	importPaths := make([]string, 0, len(packages))
+	for importPath := range packages {
+		importPaths = append(importPaths, importPath)
+	}
+	sort.Strings(importPaths)
+
+	var sb strings.Builder
+	sb.WriteString("<!DOCTYPE html>\n<html lang=\"en\">\n<head>\n<meta charset=\"utf-8\">\n<title>Coverage brief</title>\n<style>\n")
+	sb.WriteString(g_briefCSS)
+	sb.WriteString("</style>\n</head>\n<body>\n")
+	for _, importPath := range importPaths {
+		writePackageBrief(&sb, packages[importPath])
+	}
+	sb.WriteString("</body>\n</html>\n")
+	if err := CheckOfflineHTML(sb.String()); err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, []byte(sb.String()), 0644)
+}
+
+func writePackageBrief(sb *strings.Builder, pkg *briefPackage) {
+	fmt.Fprintf(sb, "<section class=\"package\">\n<h1><code>%s</code></h1>\n", html.EscapeString(pkg.importPath))
+	// GroupOf 按文件名取包, 包路径加上 / 即是包内的文件
+	if group := g_packageGroups.GroupOf(pkg.importPath + "/"); group != "" {
+		fmt.Fprintf(sb, "<p>Group: <strong>%s</strong></p>\n", html.EscapeString(group))
+	}
+	fmt.Fprintf(sb, "<p class=\"summary\"><strong>%.1f%%</strong> of statements covered (%d/%d) in %d files, %d functions</p>\n",
+		percentOf(pkg.covered, pkg.statements), pkg.covered, pkg.statements, len(pkg.files), len(pkg.funcs))
+
+	// 覆盖率最低的在前, 相同时未覆盖语句多的在前
+	worst := make([]briefFunc, 0, len(pkg.funcs))
+	for _, f := range pkg.funcs {
+		if f.statements > f.covered {
+			worst = append(worst, f)
+		}
+	}
+	sort.SliceStable(worst, func(i, j int) bool {
+		pi, pj := percentOf(worst[i].covered, worst[i].statements), percentOf(worst[j].covered, worst[j].statements)
+		if pi != pj {
+			return pi < pj
+		}
+		return worst[i].statements-worst[i].covered > worst[j].statements-worst[j].covered
+	})
+	if len(worst) > briefWorstFuncs {
+		worst = worst[:briefWorstFuncs]
+	}
+	sb.WriteString("<h2>Least covered functions</h2>\n")
+	if len(worst) == 0 {
+		sb.WriteString("<p class=\"none\">All functions are fully covered.</p>\n")
+	} else {
+		sb.WriteString("<table>\n<tr><th>Function</th><th>Location</th><th class=\"num\">Coverage</th><th class=\"num\">Uncovered statements</th></tr>\n")
+		for _, f := range worst {
+			fmt.Fprintf(sb, "<tr><td><code>%s</code></td><td>%s:%d</td><td class=\"num\">%.1f%%</td><td class=\"num\">%d</td></tr>\n",
+				html.EscapeString(f.name), html.EscapeString(f.file), f.line, percentOf(f.covered, f.statements), f.statements-f.covered)
+		}
+		sb.WriteString("</table>\n")
+	}
+
+	var uncovered []briefFunc
+	for _, f := range pkg.funcs {
+		if f.exported && f.covered == 0 {
+			uncovered = append(uncovered, f)
+		}
+	}
+	sort.Slice(uncovered, func(i, j int) bool { return uncovered[i].name < uncovered[j].name })
+	sb.WriteString("<h2>Uncovered exported API</h2>\n")
+	if len(uncovered) == 0 {
+		sb.WriteString("<p class=\"none\">Every exported function is at least partly covered.</p>\n")
+	} else {
+		sb.WriteString("<table>\n<tr><th>Function</th><th>Location</th><th class=\"num\">Statements</th></tr>\n")
+		for _, f := range uncovered {
+			fmt.Fprintf(sb, "<tr><td><code>%s</code></td><td>%s:%d</td><td class=\"num\">%d</td></tr>\n",
+				html.EscapeString(f.name), html.EscapeString(f.file), f.line, f.statements)
+		}
+		sb.WriteString("</table>\n")
+	}
+	sb.WriteString("</section>\n")
+}
+
+// 函数名(Func, T.Method 或 *T.Method)是否导出, 方法要求类型和方法名都导出
+func isExportedFunc(name string) bool {
+	for _, part := range strings.Split(strings.TrimPrefix(name, "*"), ".") {
+		r, _ := utf8.DecodeRuneInString(part)
+		if !unicode.IsUpper(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// 去掉 RenameByHash 加上的 .<githash> 后缀
+func trimHashSuffix(fileName string) string {
+	if i := strings.LastIndex(fileName, "."); i > 0 && !strings.Contains(fileName[i:], "/") && path.Ext(fileName[:i]) != "" {
+		return fileName[:i]
+	}
+	return fileName
+}
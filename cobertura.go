@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+
+	"golang.org/x/tools/cover"
+)
+
+// Cobertura XML 中的 <class> 元素
+type coberturaClass struct {
+	FileName string `xml:"filename,attr"`
+	Lines    []struct {
+		Number int `xml:"number,attr"`
+		Hits   int `xml:"hits,attr"`
+	} `xml:"lines>line"`
+}
+
+// 解析 Cobertura XML 覆盖率报告, 每个 <line> 转换为覆盖整行的块(从该行第 1 列到下一行第 1 列),
+// mode 为 count. 文件名保持报告中 filename 属性的原样
+func ParseCoberturaXML(r io.Reader) ([]*cover.Profile, error) {
+	files := make(map[string]map[int]int) // 文件 -> 行号 -> 命中次数
+	dec := xml.NewDecoder(r)
+	bRoot := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid cobertura xml: %v", err)
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if !bRoot {
+			if start.Name.Local != "coverage" {
+				return nil, fmt.Errorf("invalid cobertura xml: root element is <%s>, not <coverage>", start.Name.Local)
+			}
+			bRoot = true
+			continue
+		}
+		if start.Name.Local != "class" {
+			continue
+		}
+		var class coberturaClass
+		if err := dec.DecodeElement(&class, &start); err != nil {
+			return nil, fmt.Errorf("invalid cobertura xml: %v", err)
+		}
+		if class.FileName == "" {
+			continue
+		}
+		lines := files[class.FileName]
+		if lines == nil {
+			lines = make(map[int]int)
+			files[class.FileName] = lines
+		}
+		for _, line := range class.Lines {
+			if line.Number <= 0 || line.Hits < 0 {
+				return nil, fmt.Errorf("invalid cobertura line %d with %d hits in %s", line.Number, line.Hits, class.FileName)
+			}
+			lines[line.Number] = AddCount(lines[line.Number], line.Hits)
+		}
+	}
+
+	profiles := make([]*cover.Profile, 0, len(files))
+	for fileName, lines := range files {
+		p := &cover.Profile{FileName: fileName, Mode: "count"}
+		for number, hits := range lines {
+			p.Blocks = append(p.Blocks, cover.ProfileBlock{
+				StartLine: number,
+				StartCol:  1,
+				EndLine:   number + 1,
+				EndCol:    1,
+				NumStmt:   1,
+				Count:     hits,
+			})
+		}
+		sort.Slice(p.Blocks, func(i, j int) bool { return p.Blocks[i].StartLine < p.Blocks[j].StartLine })
+		profiles = append(profiles, p)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].FileName < profiles[j].FileName })
+	return profiles, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/tools/cover"
+)
+
+var (
+	g_strCodecov       = flag.String("codecov", "", "输出 codecov 上传文件(network 格式, 每个文件取最新版本), 如 codecov.txt(为空不输出)")
+	g_bCodecovUpload   = flag.Bool("codecov-upload", false, "上传到 Codecov, 提交为最新版本的 git hash, token 取自认证信息 codecov 或 CODECOV_TOKEN")
+	g_strCodecovURL    = flag.String("codecov-url", "https://codecov.io", "Codecov 地址(自建 Codecov 时修改)")
+	g_strCodecovBranch = flag.String("codecov-branch", "", "上传到 Codecov 的分支(为空取 CODECOV_BRANCH)")
+)
+
+const codecovTimeout = 60 * time.Second
+
+// 生成 codecov 上传文件: 文件列表(network), 然后是 Codecov JSON 格式的覆盖率:
+//
+//	go/src/example.com/foo/foo.go
+//	<<<<<< network
+//	# path=coverage.json
+//	{"coverage":{"go/src/example.com/foo/foo.go":{"3":1,"7":0}}}
+//	<<<<<< EOF
+func BuildCodecovReport(profiles []*cover.Profile) ([]byte, error) {
+	coverage := make(map[string]map[string]int, len(profiles))
+	var buf bytes.Buffer
+	for _, p := range profiles {
+		name := RepoPath(p.FileName)
+		buf.WriteString(name + "\n")
+		lines := make(map[string]int)
+		for _, row := range ProfileLines(p, "") {
+			lines[strconv.Itoa(row.Line)] = row.Count
+		}
+		coverage[name] = lines
+	}
+	data, err := json.Marshal(map[string]interface{}{"coverage": coverage})
+	if err != nil {
+		return nil, err
+	}
+	buf.WriteString("<<<<<< network\n# path=coverage.json\n")
+	buf.Write(data)
+	buf.WriteString("\n<<<<<< EOF\n")
+	return buf.Bytes(), nil
+}
+
+// 按 -codecov 写出, 按 -codecov-upload 上传, profiles 来自 LatestVersionProfiles
+func WriteCodecov(profiles []*cover.Profile, latest *CoverFileInfo) error {
+	report, err := BuildCodecovReport(profiles)
+	if err != nil {
+		return err
+	}
+	if *g_strCodecov != "" {
+		if err := os.WriteFile(*g_strCodecov, report, 0644); err != nil {
+			return err
+		}
+	}
+	if !*g_bCodecovUpload {
+		return nil
+	}
+	if latest == nil {
+		return fmt.Errorf("no version to upload to codecov")
+	}
+	cred, err := GetCredential("codecov")
+	if err != nil {
+		return err
+	}
+	token := cred.Token
+	if token == "" {
+		token = os.Getenv("CODECOV_TOKEN")
+		AddSecret(token)
+	}
+	branch := *g_strCodecovBranch
+	if branch == "" {
+		branch = os.Getenv("CODECOV_BRANCH")
+	}
+	return UploadCodecov(*g_strCodecovURL, token, fullCommitHash(latest.GitHash), branch, report)
+}
+
+// Codecov 需要完整的提交 hash, 不能解析时原样使用
+func fullCommitHash(gitHash string) string {
+	full, err := resolveRef(gitHash)
+	if err != nil {
+		return gitHash
+	}
+	return full
+}
+
+// 使用 Codecov 的 v4 上传接口: 先申请上传地址, 再把报告 PUT 到返回的地址. 公开仓库在 CI 中可以不需要 token
+func UploadCodecov(baseURL string, token string, commit string, branch string, report []byte) error {
+	query := url.Values{"commit": {commit}, "package": {"gocovmerge"}}
+	if token != "" {
+		query.Set("token", token)
+	}
+	if branch != "" {
+		query.Set("branch", branch)
+	}
+	client := &http.Client{Timeout: codecovTimeout}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(baseURL, "/")+"/upload/v4?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/plain")
+	body, err := doCodecovRequest(client, req)
+	if err != nil {
+		return err
+	}
+	// 第一行是报告地址, 第二行是上传地址
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	if len(lines) < 2 {
+		return fmt.Errorf("failed to upload to codecov: unexpected response %q", strings.TrimSpace(string(body)))
+	}
+	req, err = http.NewRequest(http.MethodPut, strings.TrimSpace(lines[1]), bytes.NewReader(report))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("x-amz-acl", "public-read")
+	if _, err := doCodecovRequest(client, req); err != nil {
+		return err
+	}
+	fmt.Println("upload to codecov ok:", strings.TrimSpace(lines[0]))
+	return nil
+}
+
+func doCodecovRequest(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload to codecov: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("failed to upload to codecov: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/cover"
+)
+
+var (
+	g_strExternal    = flag.String("external", "", "其他语言组件的覆盖率报告(LCOV 或 Cobertura XML), 格式 name=file,..., 如 engine=lcov.info,scripts=luacov.xml, 与 Go 覆盖率一起汇总到 -outcombined")
+	g_strOutCombined = flag.String("outcombined", "", "输出 Go, -luacov 和 -external 组件汇总的覆盖率报告, .json 结尾时输出 JSON, 否则输出 HTML(为空不输出)")
+)
+
+//go:embed assets/combined.css
+var g_combinedCSS string
+
+// 汇总报告中的一个组件. 各语言的覆盖单位不同, 统一按行统计: Go 为块覆盖的行, LCOV/Cobertura 为报告中的行
+type CombinedComponent struct {
+	Name    string         `json:"name"`
+	Format  string         `json:"format"` // go, luacov, lcov 或 cobertura
+	Lines   int            `json:"lines"`
+	Covered int            `json:"covered"`
+	Percent float64        `json:"percent"`
+	Files   []FileCoverage `json:"-"`
+}
+
+type combinedFile struct {
+	File    string  `json:"file"`
+	Lines   int     `json:"lines"`
+	Covered int     `json:"covered"`
+	Percent float64 `json:"percent"`
+}
+
+// 读取 -external 指定的报告, 按内容识别 Cobertura XML, 其他按 LCOV 解析
+func ReadExternalReport(name string, fileName string) (*CombinedComponent, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var profiles []*cover.Profile
+	component := &CombinedComponent{Name: name}
+	if IsXMLContent(data) {
+		component.Format = "cobertura"
+		profiles, err = ParseCoberturaXML(bytes.NewReader(data))
+	} else {
+		component.Format = "lcov"
+		profiles, err = ParseLCOV(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("external %s (%s): %v", name, fileName, err)
+	}
+	// 每个块是一行, 语句数为 1
+	component.setFiles(ComputeFileCoverage(profiles))
+	return component, nil
+}
+
+// Go 组件: 每个文件的最新版本, 块覆盖的每一行都算, 行上有任意块被覆盖即为已覆盖
+func GoComponent(profiles []*cover.Profile) *CombinedComponent {
+	files := make([]FileCoverage, 0, len(profiles))
+	for _, p := range profiles {
+		stat := FileCoverage{FileName: p.FileName}
+		for _, row := range ProfileLines(p, "") {
+			stat.Statements++
+			if row.Covered {
+				stat.Covered++
+			}
+		}
+		files = append(files, stat)
+	}
+	component := &CombinedComponent{Name: "go", Format: "go"}
+	component.setFiles(files)
+	return component
+}
+
+func (c *CombinedComponent) setFiles(files []FileCoverage) {
+	c.Files = files
+	c.Lines, c.Covered = 0, 0
+	for _, stat := range files {
+		c.Lines += stat.Statements
+		c.Covered += stat.Covered
+	}
+	c.Percent = roundPercent(FileCoverage{Statements: c.Lines, Covered: c.Covered}.Percent())
+}
+
+// 解析 -external, 按名称排序, 前面是 Go 组件和 -luacov 的 Lua 组件
+func CombinedComponents(profiles []*cover.Profile, external string) ([]*CombinedComponent, error) {
+	components := []*CombinedComponent{GoComponent(profiles)}
+	names := map[string]bool{"go": true}
+	if g_luaScripts != nil {
+		lua := &CombinedComponent{Name: "lua", Format: "luacov"}
+		lua.setFiles(ComputeFileCoverage(LuaProfiles(g_luaScripts)))
+		components = append(components, lua)
+		names["lua"] = true
+	}
+	var externals []*CombinedComponent
+	for _, item := range strings.Split(external, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, fileName, ok := strings.Cut(item, "=")
+		if !ok || name == "" || fileName == "" {
+			return nil, fmt.Errorf("external report %q is not in name=file form", item)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("duplicate external component name %q", name)
+		}
+		names[name] = true
+		component, err := ReadExternalReport(name, fileName)
+		if err != nil {
+			return nil, err
+		}
+		externals = append(externals, component)
+	}
+	sort.Slice(externals, func(i, j int) bool { return externals[i].Name < externals[j].Name })
+	return append(components, externals...), nil
+}
+
+// 输出汇总报告, profiles 为每个文件的最新版本
+func WriteCombinedReport(fileName string, profiles []*cover.Profile) error {
+	components, err := CombinedComponents(profiles, *g_strExternal)
+	if err != nil {
+		return err
+	}
+	var total FileCoverage
+	for _, c := range components {
+		total.Statements += c.Lines
+		total.Covered += c.Covered
+	}
+	if strings.HasSuffix(fileName, ".json") {
+		return writeCombinedJSON(fileName, components, total)
+	}
+	return writeCombinedHTML(fileName, components, total)
+}
+
+func writeCombinedJSON(fileName string, components []*CombinedComponent, total FileCoverage) error {
+	type jsonComponent struct {
+		*CombinedComponent
+		Files []combinedFile `json:"files"`
+	}
+	report := struct {
+		Lines      int             `json:"lines"`
+		Covered    int             `json:"covered"`
+		Percent    float64         `json:"percent"`
+		Components []jsonComponent `json:"components"`
+	}{Lines: total.Statements, Covered: total.Covered, Percent: roundPercent(total.Percent())}
+	for _, c := range components {
+		jc := jsonComponent{CombinedComponent: c, Files: make([]combinedFile, 0, len(c.Files))}
+		for _, stat := range c.Files {
+			jc.Files = append(jc.Files, combinedFile{File: stat.FileName, Lines: stat.Statements, Covered: stat.Covered, Percent: roundPercent(stat.Percent())})
+		}
+		report.Components = append(report.Components, jc)
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, append(data, '\n'), 0644)
+}
+
+func writeCombinedHTML(fileName string, components []*CombinedComponent, total FileCoverage) error {
+	var sb strings.Builder
+	sb.WriteString("<!DOCTYPE html>\n<html lang=\"en\">\n<head>\n<meta charset=\"utf-8\">\n<title>Combined coverage</title>\n<style>\n")
+	sb.WriteString(g_combinedCSS)
+	sb.WriteString("</style>\n</head>\n<body>\n<h1>Combined coverage</h1>\n")
+	fmt.Fprintf(&sb, "<p class=\"summary\"><strong>%.1f%%</strong> of lines covered (%d/%d) in %d components</p>\n",
+		total.Percent(), total.Covered, total.Statements, len(components))
+	sb.WriteString("<table>\n<tr><th>Component</th><th>Format</th><th class=\"num\">Files</th><th class=\"num\">Lines</th><th class=\"num\">Covered</th><th class=\"num\">Coverage</th><th></th></tr>\n")
+	for _, c := range components {
+		fmt.Fprintf(&sb, "<tr><td>%s</td><td>%s</td><td class=\"num\">%d</td><td class=\"num\">%d</td><td class=\"num\">%d</td><td class=\"num\">%.1f%%</td>%s</tr>\n",
+			html.EscapeString(c.Name), c.Format, len(c.Files), c.Lines, c.Covered, c.Percent, combinedBar(c.Percent))
+	}
+	sb.WriteString("</table>\n")
+	for _, c := range components {
+		// 覆盖率最低的文件在前
+		files := append([]FileCoverage(nil), c.Files...)
+		sort.SliceStable(files, func(i, j int) bool {
+			if files[i].Percent() != files[j].Percent() {
+				return files[i].Percent() < files[j].Percent()
+			}
+			return files[i].FileName < files[j].FileName
+		})
+		fmt.Fprintf(&sb, "<details>\n<summary>%s: %.1f%%</summary>\n<table>\n<tr><th>File</th><th class=\"num\">Lines</th><th class=\"num\">Covered</th><th class=\"num\">Coverage</th><th></th></tr>\n",
+			html.EscapeString(c.Name), c.Percent)
+		for _, stat := range files {
+			fmt.Fprintf(&sb, "<tr><td><code>%s</code></td><td class=\"num\">%d</td><td class=\"num\">%d</td><td class=\"num\">%.1f%%</td>%s</tr>\n",
+				html.EscapeString(stat.FileName), stat.Statements, stat.Covered, stat.Percent(), combinedBar(stat.Percent()))
+		}
+		sb.WriteString("</table>\n</details>\n")
+	}
+	sb.WriteString("</body>\n</html>\n")
+	if err := CheckOfflineHTML(sb.String()); err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, []byte(sb.String()), 0644)
+}
+
+func combinedBar(percent float64) string {
+	return fmt.Sprintf("<td class=\"bar\"><div><span style=\"width: %.1f%%\"></span></div></td>", percent)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+
+	"golang.org/x/tools/cover"
+)
+
+var (
+	g_strOutComment      = flag.String("outcomment", "", "输出合并结果的 Markdown 摘要, 作为 GitHub, GitLab, Gerrit 等评论的正文(为空不输出)")
+	g_strCommentTemplate = flag.String("comment-template", "", "-outcomment 使用的 text/template 模板文件, 代替内置的格式, 可用的数据见 README")
+)
+
+// 评论模板的数据
+type CommentData struct {
+	Version  string            // 最新版本的 git hash
+	Summary  *MergeSummary     // 总覆盖率, 文件数和门禁结果
+	Policy   *PolicyReport     // 门禁规则的评估结果, 与 -outpolicy 相同
+	Packages []PackageCoverage // 每个包的覆盖率, 按导入路径排序
+	Files    []FileCoverage    // 每个文件最新版本的覆盖率, 按文件名排序
+}
+
+const defaultCommentTemplate = `### Coverage {{percent .Summary.Total}}
+
+{{if eq .Summary.Threshold "none"}}No coverage gate{{else}}Gate **{{.Summary.Threshold}}**{{end}} for ` + "`{{.Version}}`" + `, {{.Summary.Files}} files.
+{{- if .Summary.Override}} Bypassed by {{.Summary.Override}}.{{end}}
+{{with .Policy.Rules}}
+| Rule | Target | Threshold | Measured | Result |
+| --- | --- | ---: | ---: | --- |
+{{range .}}| {{.Rule}} | ` + "`{{.Target}}`" + ` | {{percent .Threshold}} | {{percent .Measured}} | {{if .Passed}}pass{{else}}fail{{end}} |
+{{end}}{{end}}
+{{- with lowest 10 .Files}}
+<details><summary>Least covered files</summary>
+
+| File | Statements | Coverage |
+| --- | ---: | ---: |
+{{range .}}| ` + "`{{.FileName}}`" + ` | {{.Statements}} | {{percent .Percent}} |
+{{end}}
+</details>
+{{end}}`
+
+var g_commentFuncs = template.FuncMap{
+	"percent": func(f float64) string { return fmt.Sprintf("%.1f%%", f) },
+	// 有语句的文件中覆盖率最低的 n 个
+	"lowest": func(n int, files []FileCoverage) []FileCoverage {
+		var result []FileCoverage
+		for _, file := range files {
+			if file.Statements > 0 {
+				result = append(result, file)
+			}
+		}
+		sort.SliceStable(result, func(i, j int) bool { return result[i].Percent() < result[j].Percent() })
+		if len(result) > n {
+			result = result[:n]
+		}
+		return result
+	},
+}
+
+var g_commentTemplate *template.Template
+
+// 合并前解析评论模板, 模板有错误时不用等到合并完成才发现
+func checkCommentTemplate(fileName string) error {
+	text := defaultCommentTemplate
+	if fileName != "" {
+		data, err := os.ReadFile(fileName)
+		if err != nil {
+			return err
+		}
+		text = string(data)
+	}
+	tmpl, err := template.New("comment").Funcs(g_commentFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return fmt.Errorf("comment template: %v", err)
+	}
+	g_commentTemplate = tmpl
+	return nil
+}
+
+// 输出 -outcomment, profiles 为每个文件的最新版本
+func WriteComment(fileName string, gitHash string, summary *MergeSummary, profiles []*cover.Profile) error {
+	policy, err := EvaluatePolicies(summary, profiles)
+	if err != nil {
+		return err
+	}
+	packages, err := ComputePackageCoverage(profiles)
+	if err != nil {
+		return err
+	}
+	data := CommentData{
+		Version:  gitHash,
+		Summary:  summary,
+		Policy:   policy,
+		Packages: packages,
+		Files:    ComputeFileCoverage(profiles),
+	}
+	var sb strings.Builder
+	if err := g_commentTemplate.Execute(&sb, data); err != nil {
+		return fmt.Errorf("comment template: %v", err)
+	}
+	outFile, upload, err := LocalOutput(fileName)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(outFile, []byte(sb.String()), 0644); err != nil {
+		return err
+	}
+	return upload()
+}
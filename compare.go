@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"go/scanner"
+	"go/token"
+
+	"golang.org/x/tools/cover"
+)
+
+var g_strCompare = flag.String("compare", "bytes", "跨版本比较文件的方式: bytes 内容完全相同, gofmt 格式化后相同, ast 忽略注释和格式后语法相同. "+
+	"gofmt 和 ast 认为相同时, 后一个版本的块位置按 token 对应到前一个版本后再合并")
+
+func checkCompare(mode string) error {
+	switch mode {
+	case "bytes", "gofmt", "ast":
+		return nil
+	}
+	return fmt.Errorf("unsupported compare '%s', expected bytes, gofmt or ast", mode)
+}
+
+// 源码中的一个 token(不包括注释)
+type sourceToken struct {
+	tok        token.Token
+	lit        string
+	start, end blockPoint
+	bAuto      bool // 换行自动插入的分号, 没有对应的源码
+}
+
+func scanTokens(filePath string, src []byte) ([]sourceToken, error) {
+	fset := token.NewFileSet()
+	file := fset.AddFile(filePath, -1, len(src))
+	var s scanner.Scanner
+	s.Init(file, src, nil, 0)
+	var tokens []sourceToken
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		text := lit
+		if text == "" {
+			text = tok.String()
+		}
+		t := sourceToken{tok: tok, lit: lit, bAuto: tok == token.SEMICOLON && lit == "\n"}
+		start := fset.Position(pos)
+		t.start = blockPoint{start.Line, start.Column}
+		if !t.bAuto {
+			end := fset.Position(pos + token.Pos(len(text)))
+			t.end = blockPoint{end.Line, end.Column}
+		}
+		tokens = append(tokens, t)
+	}
+	if s.ErrorCount > 0 {
+		return nil, fmt.Errorf("%s: %d syntax errors", filePath, s.ErrorCount)
+	}
+	return tokens, nil
+}
+
+// 按 -compare 判断 commit2 中的文件是否与 commit1 中的等价, 等价时返回 commit2 中 token 的起止位置 -> commit1 中对应的位置
+func EquivalentVersions(commit1, commit2, filePath string) (map[blockPoint]blockPoint, bool) {
+	content1, err := GitGetFileContent(commit1, filePath)
+	if err != nil {
+		return nil, false
+	}
+	content2, err := GitGetFileContent(commit2, filePath)
+	if err != nil {
+		return nil, false
+	}
+	src1, src2 := []byte(content1), []byte(content2)
+	if *g_strCompare == "gofmt" {
+		formatted1, err1 := format.Source(src1)
+		formatted2, err2 := format.Source(src2)
+		if err1 != nil || err2 != nil || !bytes.Equal(formatted1, formatted2) {
+			return nil, false
+		}
+	}
+	tokens1, err1 := scanTokens(filePath, src1)
+	tokens2, err2 := scanTokens(filePath, src2)
+	if err1 != nil || err2 != nil || len(tokens1) != len(tokens2) {
+		return nil, false
+	}
+	positions := make(map[blockPoint]blockPoint)
+	for i := range tokens1 {
+		t1, t2 := tokens1[i], tokens2[i]
+		if t1.tok != t2.tok || (t1.tok != token.SEMICOLON && t1.lit != t2.lit) {
+			return nil, false
+		}
+		positions[t2.start] = t1.start
+		if !t1.bAuto && !t2.bAuto {
+			positions[t2.end] = t1.end
+		}
+	}
+	return positions, true
+}
+
+// commit2 的覆盖率与 commit1 的文件等价(-compare gofmt 或 ast)时, 返回块位置对应到 commit1 的覆盖率.
+// 块的起止位置都是 token 的边界, 有对应不上的位置时认为不等价
+func RemapEquivalentProfile(commit1, commit2, filePath string, p *cover.Profile) (*cover.Profile, bool) {
+	positions, ok := EquivalentVersions(commit1, commit2, filePath)
+	if !ok {
+		return nil, false
+	}
+	remapped := &cover.Profile{FileName: p.FileName, Mode: p.Mode, Blocks: make([]cover.ProfileBlock, len(p.Blocks))}
+	for i, b := range p.Blocks {
+		start, ok1 := positions[blockStart(b)]
+		end, ok2 := positions[blockEnd(b)]
+		if !ok1 || !ok2 {
+			return nil, false
+		}
+		b.StartLine, b.StartCol, b.EndLine, b.EndCol = start.line, start.col, end.line, end.col
+		remapped.Blocks[i] = b
+	}
+	return remapped, true
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+)
+
+// 合并时饱和在 math.MaxInt 的计数个数, 长时间运行的服务累加很多份覆盖率时计数可能溢出
+var g_nSaturatedCounts atomic.Int64
+
+// 累加计数, 溢出时饱和在 math.MaxInt 而不是变成负数
+func AddCount(a, b int) int {
+	if b > 0 && a > math.MaxInt-b {
+		g_nSaturatedCounts.Add(1)
+		return math.MaxInt
+	}
+	return a + b
+}
+
+// 无符号的计数(如二进制中间文件中的计数)转换为 int, 超出范围时饱和
+func saturatedCount(v uint64) int {
+	if v > math.MaxInt {
+		g_nSaturatedCounts.Add(1)
+		return math.MaxInt
+	}
+	return int(v)
+}
+
+// 浮点数表示的计数(如 LCOV 中非常大的计数)转换为 int, 超出范围时饱和
+func floatCount(f float64) int {
+	if f >= math.MaxInt {
+		g_nSaturatedCounts.Add(1)
+		return math.MaxInt
+	}
+	return int(f)
+}
+
+// 有计数饱和时打印警告
+func PrintSaturatedCounts() {
+	if n := g_nSaturatedCounts.Load(); n > 0 {
+		fmt.Printf("warning: %d block counts saturated at %d while merging\n", n, math.MaxInt)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"golang.org/x/tools/cover"
+)
+
+// 判断是否为 Go 1.20+ 的二进制覆盖率目录(GOCOVERDIR, 包含 covmeta.* 文件)
+func IsCoverDataDir(path string) bool {
+	stat, err := os.Stat(path)
+	if err != nil || !stat.IsDir() {
+		return false
+	}
+	matches, _ := filepath.Glob(filepath.Join(path, "covmeta.*"))
+	return len(matches) > 0
+}
+
+// 通过 go tool covdata textfmt 把二进制覆盖率目录转换为文本格式后解析
+func ParseCoverDataDir(dir string) ([]*cover.Profile, error) {
+	tmpFile, err := os.CreateTemp("", "gocovmerge-covdata-*.txt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	cmd := exec.Command("go", "tool", "covdata", "textfmt", "-i="+dir, "-o="+tmpFile.Name())
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to run go tool covdata textfmt: %w", err)
+	}
+	return ParseProfileFile(tmpFile.Name())
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"hash"
+	"hash/fnv"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"time"
+
+	"golang.org/x/tools/cover"
+)
+
+var g_strOutCovData = flag.String("outcovdata", "", "输出 GOCOVERDIR 格式的二进制覆盖率目录, 供只支持 go tool covdata 的工具使用(为空不输出)")
+
+// 以下结构和编码与 Go 1.20+ 运行时写出的 covmeta.* 和 covcounters.* 文件一致(internal/coverage),
+// 所有整数为小端, 变长整数为 ULEB128
+var (
+	covMetaMagic    = [4]byte{0x00, 0x63, 0x76, 0x6d}
+	covCounterMagic = [4]byte{0x00, 0x63, 0x77, 0x6d}
+)
+
+const (
+	covMetaHeaderSize  = 16 + 4 + 4 + 4 + 4 + 4 + 4 + 4
+	covGranularity     = 1 // perblock
+	covCounterULeb128  = 2
+	covMetaFileVersion = 1
+	covCounterVersion  = 1
+)
+
+type covMetaFileHeader struct {
+	Magic        [4]byte
+	Version      uint32
+	TotalLength  uint64
+	Entries      uint64
+	MetaFileHash [16]byte
+	StrTabOffset uint32
+	StrTabLength uint32
+	CMode        uint8
+	CGranularity uint8
+	_            [6]byte
+}
+
+type covMetaSymbolHeader struct {
+	Length     uint32
+	PkgName    uint32
+	PkgPath    uint32
+	ModulePath uint32
+	MetaHash   [16]byte
+	_          byte
+	_          [3]byte
+	NumFiles   uint32
+	NumFuncs   uint32
+}
+
+type covCounterFileHeader struct {
+	Magic     [4]byte
+	Version   uint32
+	MetaHash  [16]byte
+	CFlavor   uint8
+	BigEndian bool
+	_         [6]byte
+}
+
+type covCounterSegmentHeader struct {
+	FcnEntries uint64
+	StrTabLen  uint32
+	ArgsLen    uint32
+}
+
+type covCounterFileFooter struct {
+	Magic       [4]byte
+	_           [4]byte
+	NumSegments uint32
+	_           [4]byte
+}
+
+// 一个函数的覆盖单元(块)和计数
+type covFunc struct {
+	name   string
+	file   string
+	lit    bool
+	blocks []cover.ProfileBlock
+}
+
+type covPackage struct {
+	path  string
+	name  string
+	funcs []*covFunc
+}
+
+// 字符串表, 下标按加入顺序
+type covStringTable struct {
+	index map[string]uint32
+	strs  []string
+}
+
+func newCovStringTable() *covStringTable {
+	t := &covStringTable{index: make(map[string]uint32)}
+	t.lookup("")
+	return t
+}
+
+func (t *covStringTable) lookup(s string) uint32 {
+	if i, ok := t.index[s]; ok {
+		return i
+	}
+	i := uint32(len(t.strs))
+	t.index[s] = i
+	t.strs = append(t.strs, s)
+	return i
+}
+
+func (t *covStringTable) encode() []byte {
+	b := appendUleb128(nil, uint64(len(t.strs)))
+	for _, s := range t.strs {
+		b = appendUleb128(b, uint64(len(s)))
+		b = append(b, s...)
+	}
+	return b
+}
+
+func appendUleb128(b []byte, v uint64) []byte {
+	for {
+		c := uint8(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			c |= 0x80
+		}
+		b = append(b, c)
+		if c&0x80 == 0 {
+			return b
+		}
+	}
+}
+
+func hashUint32(h hash.Hash, v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	h.Write(b[:])
+}
+
+// 把合并后的覆盖率写成 GOCOVERDIR 目录(一个 covmeta 文件和一个 covcounters 文件),
+// 可以用 go tool covdata textfmt/percent/func 等读取. 文件按目录分包, 函数信息来自
+// go/src/<文件名> 的源码(合并时导出的各版本源码), 没有源码时每个文件作为一个函数
+func WriteCoverDataDir(dir string, profiles []*cover.Profile) error {
+	if len(profiles) == 0 {
+		return fmt.Errorf("no profiles to write to %s", dir)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "cov*.*")); len(matches) > 0 {
+		return fmt.Errorf("output directory %s already contains coverage data", dir)
+	}
+	var cmode uint8
+	switch profiles[0].Mode {
+	case "set":
+		cmode = 1
+	case "count":
+		cmode = 2
+	case "atomic":
+		cmode = 3
+	default:
+		return fmt.Errorf("unsupported cover mode %q", profiles[0].Mode)
+	}
+
+	pkgs := make(map[string]*covPackage)
+	for _, p := range profiles {
+		pkgPath := path.Dir(p.FileName)
+		pkg := pkgs[pkgPath]
+		if pkg == nil {
+			pkg = &covPackage{path: pkgPath, name: path.Base(pkgPath)}
+			pkgs[pkgPath] = pkg
+		}
+		funcs, pkgName := profileFuncs(p)
+		if pkgName != "" {
+			pkg.name = pkgName
+		}
+		pkg.funcs = append(pkg.funcs, funcs...)
+	}
+	pkgPaths := make([]string, 0, len(pkgs))
+	for pkgPath := range pkgs {
+		pkgPaths = append(pkgPaths, pkgPath)
+	}
+	sort.Strings(pkgPaths)
+
+	// 元数据: 每个包一个 blob, 总的 hash 由各包的 hash 和模式计算
+	var blobs [][]byte
+	finalHash := fnv.New128a()
+	for _, pkgPath := range pkgPaths {
+		blob, blobHash := encodeCovPackage(pkgs[pkgPath])
+		blobs = append(blobs, blob)
+		finalHash.Write(blobHash[:])
+	}
+	finalHash.Write([]byte(profiles[0].Mode))
+	finalHash.Write([]byte("perblock"))
+	var metaHash [16]byte
+	copy(metaHash[:], finalHash.Sum(nil))
+
+	var meta bytes.Buffer
+	fileStab := newCovStringTable().encode()
+	headerSize := uint64(binary.Size(covMetaFileHeader{}))
+	strTabOffset := headerSize + uint64(16*len(blobs))
+	totalLength := strTabOffset + uint64(len(fileStab))
+	for _, blob := range blobs {
+		totalLength += uint64(len(blob))
+	}
+	binary.Write(&meta, binary.LittleEndian, covMetaFileHeader{
+		Magic:        covMetaMagic,
+		Version:      covMetaFileVersion,
+		TotalLength:  totalLength,
+		Entries:      uint64(len(blobs)),
+		MetaFileHash: metaHash,
+		StrTabOffset: uint32(strTabOffset),
+		StrTabLength: uint32(len(fileStab)),
+		CMode:        cmode,
+		CGranularity: covGranularity,
+	})
+	offset := strTabOffset + uint64(len(fileStab))
+	for _, blob := range blobs {
+		binary.Write(&meta, binary.LittleEndian, offset)
+		offset += uint64(len(blob))
+	}
+	for _, blob := range blobs {
+		binary.Write(&meta, binary.LittleEndian, uint64(len(blob)))
+	}
+	meta.Write(fileStab)
+	for _, blob := range blobs {
+		meta.Write(blob)
+	}
+
+	// 计数: 只写有计数的函数, 一个段
+	segStab := newCovStringTable()
+	args := map[string]string{"argc": "1", "argv0": "gocovmerge", "GOOS": runtime.GOOS, "GOARCH": runtime.GOARCH}
+	argKeys := []string{"GOARCH", "GOOS", "argc", "argv0"}
+	for _, k := range argKeys {
+		segStab.lookup(k)
+		segStab.lookup(args[k])
+	}
+	strTab := segStab.encode()
+	argsData := appendUleb128(nil, uint64(len(argKeys)))
+	for _, k := range argKeys {
+		argsData = appendUleb128(argsData, uint64(segStab.lookup(k)))
+		argsData = appendUleb128(argsData, uint64(segStab.lookup(args[k])))
+	}
+	segHeaderSize := binary.Size(covCounterSegmentHeader{})
+	for (segHeaderSize+len(strTab)+len(argsData))%4 != 0 {
+		argsData = append(argsData, 0)
+	}
+	var counters []byte
+	var nFuncs uint64
+	for pkgID, pkgPath := range pkgPaths {
+		for funcID, f := range pkgs[pkgPath].funcs {
+			bLive := false
+			for _, b := range f.blocks {
+				bLive = bLive || b.Count != 0
+			}
+			if !bLive {
+				continue
+			}
+			nFuncs++
+			counters = appendUleb128(counters, uint64(len(f.blocks)))
+			counters = appendUleb128(counters, uint64(pkgID))
+			counters = appendUleb128(counters, uint64(funcID))
+			for _, b := range f.blocks {
+				count := uint64(b.Count)
+				if b.Count < 0 {
+					count = 0
+				} else if count > math.MaxUint32 {
+					count = math.MaxUint32 // 计数器是 32 位的
+				}
+				counters = appendUleb128(counters, count)
+			}
+		}
+	}
+	var counterData bytes.Buffer
+	binary.Write(&counterData, binary.LittleEndian, covCounterFileHeader{
+		Magic:    covCounterMagic,
+		Version:  covCounterVersion,
+		MetaHash: metaHash,
+		CFlavor:  covCounterULeb128,
+	})
+	binary.Write(&counterData, binary.LittleEndian, covCounterSegmentHeader{
+		FcnEntries: nFuncs,
+		StrTabLen:  uint32(len(strTab)),
+		ArgsLen:    uint32(len(argsData)),
+	})
+	counterData.Write(strTab)
+	counterData.Write(argsData)
+	counterData.Write(counters)
+	binary.Write(&counterData, binary.LittleEndian, covCounterFileFooter{Magic: covCounterMagic, NumSegments: 1})
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("covmeta.%x", metaHash)), meta.Bytes(), 0644); err != nil {
+		return err
+	}
+	counterFile := fmt.Sprintf("covcounters.%x.%d.%d", metaHash, os.Getpid(), time.Now().UnixNano())
+	return os.WriteFile(filepath.Join(dir, counterFile), counterData.Bytes(), 0644)
+}
+
+// 编码一个包的元数据, 返回 blob 和它的 hash
+func encodeCovPackage(pkg *covPackage) ([]byte, [16]byte) {
+	stab := newCovStringTable()
+	pkgPath, pkgName, modulePath := stab.lookup(pkg.path), stab.lookup(pkg.name), stab.lookup("")
+	h := fnv.New128a()
+	h.Write([]byte(pkg.path))
+	h.Write([]byte(pkg.name))
+
+	encodedFuncs := make([][]byte, 0, len(pkg.funcs))
+	for _, f := range pkg.funcs {
+		h.Write([]byte(f.name))
+		h.Write([]byte(f.file))
+		b := appendUleb128(nil, uint64(len(f.blocks)))
+		b = appendUleb128(b, uint64(stab.lookup(f.name)))
+		b = appendUleb128(b, uint64(stab.lookup(f.file)))
+		for _, block := range f.blocks {
+			for _, v := range []int{block.StartLine, block.StartCol, block.EndLine, block.EndCol, block.NumStmt} {
+				hashUint32(h, uint32(v))
+				b = appendUleb128(b, uint64(v))
+			}
+		}
+		lit := uint32(0)
+		if f.lit {
+			lit = 1
+		}
+		hashUint32(h, lit)
+		b = appendUleb128(b, uint64(lit))
+		encodedFuncs = append(encodedFuncs, b)
+	}
+	var digest [16]byte
+	copy(digest[:], h.Sum(nil))
+
+	strTab := stab.encode()
+	funcOffset := covMetaHeaderSize + len(strTab) + 4*len(encodedFuncs)
+	length := funcOffset
+	for _, b := range encodedFuncs {
+		length += len(b)
+	}
+	var blob bytes.Buffer
+	binary.Write(&blob, binary.LittleEndian, covMetaSymbolHeader{
+		Length:     uint32(length),
+		PkgName:    pkgName,
+		PkgPath:    pkgPath,
+		ModulePath: modulePath,
+		MetaHash:   digest,
+		NumFiles:   uint32(len(stab.strs)),
+		NumFuncs:   uint32(len(encodedFuncs)),
+	})
+	for _, b := range encodedFuncs {
+		binary.Write(&blob, binary.LittleEndian, uint32(funcOffset))
+		funcOffset += len(b)
+	}
+	blob.Write(strTab)
+	for _, b := range encodedFuncs {
+		blob.Write(b)
+	}
+	return blob.Bytes(), digest
+}
+
+// 源码中的顶层函数: 函数和方法, 以及包级别的函数字面量(函数内的字面量算在外层函数中), 与 cmd/cover 的划分一致
+type funcRange struct {
+	name       string
+	lit        bool
+	start, end token.Position
+}
+
+func (r funcRange) contains(line, col int) bool {
+	return (line > r.start.Line || line == r.start.Line && col >= r.start.Column) &&
+		(line < r.end.Line || line == r.end.Line && col <= r.end.Column)
+}
+
+// 把文件的块分到函数中, 同时返回源码中的包名(没有源码时为空)
+func profileFuncs(p *cover.Profile) ([]*covFunc, string) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filepath.Join(g_strSourceRoot, p.FileName), nil, parser.SkipObjectResolution)
+	if err != nil {
+		return []*covFunc{{name: path.Base(p.FileName), file: p.FileName, blocks: p.Blocks}}, ""
+	}
+	var ranges []funcRange
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			name := n.Name.Name
+			if n.Recv != nil && len(n.Recv.List) == 1 {
+				t, star := n.Recv.List[0].Type, ""
+				if se, ok := t.(*ast.StarExpr); ok {
+					t, star = se.X, "*"
+				}
+				if id, ok := t.(*ast.Ident); ok {
+					name = star + id.Name + "." + name
+				}
+			}
+			ranges = append(ranges, funcRange{name: name, start: fset.Position(n.Pos()), end: fset.Position(n.End())})
+			return false
+		case *ast.FuncLit:
+			pos := fset.Position(n.Pos())
+			ranges = append(ranges, funcRange{name: fmt.Sprintf("func.L%d.C%d", pos.Line, pos.Column), lit: true, start: pos, end: fset.Position(n.End())})
+			return false
+		}
+		return true
+	})
+
+	funcs := make([]*covFunc, len(ranges))
+	for i, r := range ranges {
+		funcs[i] = &covFunc{name: r.name, file: p.FileName, lit: r.lit}
+	}
+	var other *covFunc // 不在函数中的块(源码与覆盖率对不上)
+	for _, b := range p.Blocks {
+		var f *covFunc
+		for i, r := range ranges {
+			if r.contains(b.StartLine, b.StartCol) {
+				f = funcs[i]
+				break
+			}
+		}
+		if f == nil {
+			if other == nil {
+				other = &covFunc{name: path.Base(p.FileName), file: p.FileName}
+			}
+			f = other
+		}
+		f.blocks = append(f.blocks, b)
+	}
+	// 没有块的函数不写入元数据
+	result := make([]*covFunc, 0, len(funcs)+1)
+	for _, f := range funcs {
+		if len(f.blocks) > 0 {
+			result = append(result, f)
+		}
+	}
+	if other != nil {
+		result = append(result, other)
+	}
+	return result, file.Name.Name
+}
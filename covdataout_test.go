@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/tools/cover"
+)
+
+// 写出的目录由 go tool covdata 读回, 应该得到相同的块和计数
+func TestWriteCoverDataDir(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+	defer func(root string) { g_strSourceRoot = root }(g_strSourceRoot)
+	g_strSourceRoot = t.TempDir() // 没有源码, 每个文件作为一个函数
+
+	profiles := []*cover.Profile{
+		{FileName: "example.com/foo/bar.go", Mode: "count", Blocks: []cover.ProfileBlock{
+			{StartLine: 3, StartCol: 14, EndLine: 5, EndCol: 2, NumStmt: 1, Count: 7},
+			{StartLine: 7, StartCol: 1, EndLine: 9, EndCol: 2, NumStmt: 2, Count: 0},
+		}},
+		{FileName: "example.com/foo/baz/baz.go", Mode: "count", Blocks: []cover.ProfileBlock{
+			{StartLine: 10, StartCol: 20, EndLine: 12, EndCol: 3, NumStmt: 3, Count: 1},
+		}},
+	}
+	dir := filepath.Join(t.TempDir(), "covdata")
+	if err := WriteCoverDataDir(dir, profiles); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteCoverDataDir(dir, profiles); err == nil {
+		t.Error("no error writing into a directory with coverage data")
+	}
+
+	textFile := filepath.Join(t.TempDir(), "cover.txt")
+	if out, err := exec.Command("go", "tool", "covdata", "textfmt", "-i", dir, "-o", textFile).CombinedOutput(); err != nil {
+		t.Fatalf("go tool covdata textfmt: %v\n%s", err, out)
+	}
+	got, err := cover.ParseProfiles(textFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, profiles) {
+		t.Errorf("covdata textfmt:\n got %+v\nwant %+v", got, profiles)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/tools/cover"
+)
+
+var (
+	g_strCoveralls     = flag.String("coveralls", "", "输出 Coveralls job JSON, 如 coveralls.json, 文件中不含 repo_token(为空不输出)")
+	g_bCoverallsUpload = flag.Bool("coveralls-upload", false, "把 Coveralls job JSON 上传到 -coveralls-url, repo token 取自认证信息 coveralls 或 COVERALLS_REPO_TOKEN")
+	g_strCoverallsURL  = flag.String("coveralls-url", "https://coveralls.io/api/v1/jobs", "Coveralls jobs 接口")
+)
+
+const coverallsTimeout = 60 * time.Second
+
+// Coveralls job 格式(https://docs.coveralls.io/api-reference)
+type coverallsJob struct {
+	RepoToken    string                `json:"repo_token,omitempty"`
+	ServiceName  string                `json:"service_name"`
+	ServiceJobID string                `json:"service_job_id,omitempty"`
+	RunAt        string                `json:"run_at,omitempty"`
+	Git          *coverallsGit         `json:"git,omitempty"`
+	SourceFiles  []coverallsSourceFile `json:"source_files"`
+}
+
+type coverallsGit struct {
+	Head struct {
+		ID             string `json:"id"`
+		AuthorName     string `json:"author_name,omitempty"`
+		AuthorEmail    string `json:"author_email,omitempty"`
+		CommitterName  string `json:"committer_name,omitempty"`
+		CommitterEmail string `json:"committer_email,omitempty"`
+		Message        string `json:"message,omitempty"`
+	} `json:"head"`
+	Branch string `json:"branch,omitempty"`
+}
+
+// coverage 每个元素对应源码的一行, 不是语句的行为 null
+type coverallsSourceFile struct {
+	Name         string `json:"name"`
+	SourceDigest string `json:"source_digest"`
+	Coverage     []*int `json:"coverage"`
+}
+
+// 生成 Coveralls job: 每个文件取最新版本的覆盖率(profiles 来自 LatestVersionProfiles, gitHashes 为每个文件的版本),
+// 源码取自导出的 go/src/<文件名>.<git hash>, 提交为 latest 的版本.
+// service_name, service_job_id 和分支取自 COVERALLS_SERVICE_NAME, COVERALLS_SERVICE_JOB_ID, COVERALLS_GIT_BRANCH
+func BuildCoverallsJob(profiles []*cover.Profile, gitHashes map[string]string, latest *CoverFileInfo) (*coverallsJob, error) {
+	job := &coverallsJob{
+		ServiceName:  os.Getenv("COVERALLS_SERVICE_NAME"),
+		ServiceJobID: os.Getenv("COVERALLS_SERVICE_JOB_ID"),
+		SourceFiles:  make([]coverallsSourceFile, 0, len(profiles)),
+	}
+	if job.ServiceName == "" {
+		job.ServiceName = "gocovmerge"
+	}
+	if latest != nil {
+		job.RunAt = time.Unix(latest.Timestamp, 0).Format("2006-01-02 15:04:05 -0700")
+		job.Git = coverallsGitInfo(latest.GitHash)
+	}
+	for _, p := range profiles {
+		source, err := os.ReadFile(VersionedSourcePath(p.FileName, gitHashes[p.FileName]))
+		if err != nil {
+			return nil, err
+		}
+		digest := md5.Sum(source)
+		lines := bytes.Count(source, []byte("\n"))
+		if len(source) > 0 && source[len(source)-1] != '\n' {
+			lines++
+		}
+		file := coverallsSourceFile{
+			Name:         RepoPath(p.FileName),
+			SourceDigest: hex.EncodeToString(digest[:]),
+			Coverage:     make([]*int, lines),
+		}
+		for _, row := range ProfileLines(p, "") {
+			if row.Line >= 1 && row.Line <= lines {
+				count := row.Count
+				file.Coverage[row.Line-1] = &count
+			}
+		}
+		job.SourceFiles = append(job.SourceFiles, file)
+	}
+	return job, nil
+}
+
+// 提交的作者和说明, 读取失败时只有提交 id
+func coverallsGitInfo(gitHash string) *coverallsGit {
+	info := &coverallsGit{Branch: os.Getenv("COVERALLS_GIT_BRANCH")}
+	info.Head.ID = gitHash
+	commit, err := GitCommitInfo(gitHash)
+	if err != nil {
+		return info
+	}
+	info.Head.ID, info.Head.AuthorName, info.Head.AuthorEmail = commit.Hash, commit.AuthorName, commit.AuthorEmail
+	info.Head.CommitterName, info.Head.CommitterEmail, info.Head.Message = commit.CommitterName, commit.CommitterEmail, commit.Subject
+	return info
+}
+
+// 按 -coveralls 写出, 按 -coveralls-upload 上传 Coveralls job
+func WriteCoveralls(profiles []*cover.Profile, gitHashes map[string]string, latest *CoverFileInfo) error {
+	job, err := BuildCoverallsJob(profiles, gitHashes, latest)
+	if err != nil {
+		return err
+	}
+	if *g_strCoveralls != "" {
+		data, err := json.Marshal(job)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(*g_strCoveralls, data, 0644); err != nil {
+			return err
+		}
+	}
+	if !*g_bCoverallsUpload {
+		return nil
+	}
+	cred, err := GetCredential("coveralls")
+	if err != nil {
+		return err
+	}
+	job.RepoToken = cred.Token
+	if job.RepoToken == "" {
+		job.RepoToken = os.Getenv("COVERALLS_REPO_TOKEN")
+		AddSecret(job.RepoToken)
+	}
+	if job.RepoToken == "" {
+		return fmt.Errorf("coveralls repo token required: set GOCOVMERGE_COVERALLS_TOKEN or COVERALLS_REPO_TOKEN")
+	}
+	return UploadCoveralls(*g_strCoverallsURL, job)
+}
+
+// 以 multipart 表单的 json_file 字段上传 job
+func UploadCoveralls(strURL string, job *coverallsJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("json_file", "coveralls.json")
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(data); err != nil {
+		return err
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, strURL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	client := &http.Client{Timeout: coverallsTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload to coveralls: %v", err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("failed to upload to coveralls: %s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	fmt.Println("upload to coveralls ok:", strings.TrimSpace(string(respBody)))
+	return nil
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/tools/cover"
+)
+
+// 生成 HTML 报告, 与 go tool cover -html 的输出相同, 源码直接从导出的各版本源码读取(<file>.<githash>),
+// 不需要 GOPATH 或模块的目录结构. 然后插入搜索框等(见 InsertAdditionHTML)
+func GenerateCoverHTML(profiles []*cover.Profile, outputFile string) error {
+	var d coverHTMLData
+	for _, p := range profiles {
+		if p.Mode == "set" {
+			d.Set = true
+		}
+		src, err := os.ReadFile(filepath.Join(g_strSourceRoot, p.FileName))
+		if err != nil {
+			return fmt.Errorf("can't read %q: %v", p.FileName, err)
+		}
+		var sb strings.Builder
+		if err := writeCoverHTMLBody(&sb, src, p.Boundaries(src)); err != nil {
+			return err
+		}
+		d.Files = append(d.Files, &coverHTMLFile{
+			Name:     p.FileName,
+			Body:     template.HTML(sb.String()),
+			Coverage: percentCovered(p),
+		})
+	}
+
+	out, err := os.Create(outputFile)
+	if err != nil {
+		return err
+	}
+	err = g_coverHTMLTemplate.Execute(out, d)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", outputFile, err)
+	}
+	return InsertAdditionHTML(outputFile)
+}
+
+// 文件中已覆盖语句的百分比
+func percentCovered(p *cover.Profile) float64 {
+	var total, covered int64
+	for _, b := range p.Blocks {
+		total += int64(b.NumStmt)
+		if b.Count > 0 {
+			covered += int64(b.NumStmt)
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(covered) / float64(total) * 100
+}
+
+// 按块的边界给源码加上着色的 span: covN 中 0 为未覆盖, 1-10 按计数
+func writeCoverHTMLBody(w io.Writer, src []byte, boundaries []cover.Boundary) error {
+	dst := bufio.NewWriter(w)
+	for i := range src {
+		for len(boundaries) > 0 && boundaries[0].Offset == i {
+			b := boundaries[0]
+			if b.Start {
+				n := 0
+				if b.Count > 0 {
+					n = int(math.Floor(b.Norm*9)) + 1
+				}
+				fmt.Fprintf(dst, `<span class="cov%v" title="%v">`, n, b.Count)
+			} else {
+				dst.WriteString("</span>")
+			}
+			boundaries = boundaries[1:]
+		}
+		switch b := src[i]; b {
+		case '>':
+			dst.WriteString("&gt;")
+		case '<':
+			dst.WriteString("&lt;")
+		case '&':
+			dst.WriteString("&amp;")
+		case '\t':
+			dst.WriteString("        ")
+		default:
+			dst.WriteByte(b)
+		}
+	}
+	return dst.Flush()
+}
+
+// 覆盖等级的颜色: 0 为红色, 1-10 从灰色渐变到绿色
+func coverHTMLColor(n int) string {
+	if n == 0 {
+		return "rgb(192, 0, 0)"
+	}
+	r := 128 - 12*(n-1)
+	g := 128 + 12*(n-1)
+	b := 128 + 3*(n-1)
+	return fmt.Sprintf("rgb(%v, %v, %v)", r, g, b)
+}
+
+func coverHTMLColors() template.CSS {
+	var sb strings.Builder
+	for i := 0; i < 11; i++ {
+		fmt.Fprintf(&sb, ".cov%v { color: %v }\n", i, coverHTMLColor(i))
+	}
+	return template.CSS(sb.String())
+}
+
+var g_coverHTMLTemplate = template.Must(template.New("html").Funcs(template.FuncMap{
+	"colors": coverHTMLColors,
+}).Parse(coverHTMLTemplate))
+
+type coverHTMLData struct {
+	Files []*coverHTMLFile
+	Set   bool
+}
+
+// 标题中的包名: 第一个文件路径的倒数第二段, 如 foo.bar/baz/foo.go 为 baz
+func (d coverHTMLData) PackageName() string {
+	if len(d.Files) == 0 {
+		return ""
+	}
+	elems := strings.Split(d.Files[0].Name, "/")
+	for i := len(elems) - 2; i >= 0; i-- {
+		if elems[i] != "" {
+			return elems[i]
+		}
+	}
+	return ""
+}
+
+type coverHTMLFile struct {
+	Name     string
+	Body     template.HTML
+	Coverage float64
+}
+
+// go tool cover -html 的页面模板, InsertAdditionHTML 和 NumberReportLines 依赖其中的 #files 和 pre.file
+const coverHTMLTemplate = `
+<!DOCTYPE html>
+<html>
+	<head>
+		<meta http-equiv="Content-Type" content="text/html; charset=utf-8">
+		<title>{{$pkg := .PackageName}}{{if $pkg}}{{$pkg}}: {{end}}Go Coverage Report</title>
+		<style>
+			body {
+				background: black;
+				color: rgb(80, 80, 80);
+			}
+			body, pre, #legend span {
+				font-family: Menlo, monospace;
+				font-weight: bold;
+			}
+			#topbar {
+				background: black;
+				position: fixed;
+				top: 0; left: 0; right: 0;
+				height: 42px;
+				border-bottom: 1px solid rgb(80, 80, 80);
+			}
+			#content {
+				margin-top: 50px;
+			}
+			#nav, #legend {
+				float: left;
+				margin-left: 10px;
+			}
+			#legend {
+				margin-top: 12px;
+			}
+			#nav {
+				margin-top: 10px;
+			}
+			#legend span {
+				margin: 0 5px;
+			}
+			{{colors}}
+		</style>
+	</head>
+	<body>
+		<div id="topbar">
+			<div id="nav">
+				<select id="files">
+				{{range $i, $f := .Files}}
+				<option value="file{{$i}}">{{$f.Name}} ({{printf "%.1f" $f.Coverage}}%)</option>
+				{{end}}
+				</select>
+			</div>
+			<div id="legend">
+				<span>not tracked</span>
+			{{if .Set}}
+				<span class="cov0">not covered</span>
+				<span class="cov8">covered</span>
+			{{else}}
+				<span class="cov0">no coverage</span>
+				<span class="cov1">low coverage</span>
+				<span class="cov2">*</span>
+				<span class="cov3">*</span>
+				<span class="cov4">*</span>
+				<span class="cov5">*</span>
+				<span class="cov6">*</span>
+				<span class="cov7">*</span>
+				<span class="cov8">*</span>
+				<span class="cov9">*</span>
+				<span class="cov10">high coverage</span>
+			{{end}}
+			</div>
+		</div>
+		<div id="content">
+		{{range $i, $f := .Files}}
+		<pre class="file" id="file{{$i}}" style="display: none">{{$f.Body}}</pre>
+		{{end}}
+		</div>
+	</body>
+	<script>
+	(function() {
+		var files = document.getElementById('files');
+		var visible;
+		files.addEventListener('change', onChange, false);
+		function select(part) {
+			if (visible)
+				visible.style.display = 'none';
+			visible = document.getElementById(part);
+			if (!visible)
+				return;
+			files.value = part;
+			visible.style.display = 'block';
+			location.hash = part;
+		}
+		function onChange() {
+			select(files.value);
+			window.scrollTo(0, 0);
+		}
+		if (location.hash != "") {
+			select(location.hash.substr(1));
+		}
+		if (!visible) {
+			select("file0");
+		}
+	})();
+	</script>
+</html>
+`
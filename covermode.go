@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"golang.org/x/tools/cover"
+)
+
+var g_strMode = flag.String("mode", "", "把所有输入转换为该覆盖模式后再合并: set, count 或 atomic. 转换为 set 时计数大于 0 的记为 1, "+
+	"set 转换为 count/atomic 时计数不变(0 或 1). 为空时不转换, 输入的模式不同时报错")
+
+// 检查输入的覆盖模式是否一致, 指定了 -mode 时先转换. 零值可以直接使用
+type ModeChecker struct {
+	mode     string
+	fileName string // 第一个确定模式的输入
+}
+
+func (c *ModeChecker) Check(fileName string, profiles []*cover.Profile) error {
+	if *g_strMode != "" {
+		return ConvertMode(profiles, *g_strMode)
+	}
+	for _, p := range profiles {
+		if c.mode == "" {
+			c.mode, c.fileName = p.Mode, fileName
+			continue
+		}
+		if p.Mode != c.mode {
+			return fmt.Errorf("cannot merge profiles with different modes: %s in %s, %s in %s; use -mode to convert them", c.mode, c.fileName, p.Mode, fileName)
+		}
+	}
+	return nil
+}
+
+// 把覆盖率转换为指定的模式
+func ConvertMode(profiles []*cover.Profile, mode string) error {
+	switch mode {
+	case "set", "count", "atomic":
+	default:
+		return fmt.Errorf("unsupported mode '%s', expected set, count or atomic", mode)
+	}
+	for _, p := range profiles {
+		// set 模式的输入也可能有大于 1 的计数(如手工拼接的文件), 同样截断
+		if mode == "set" {
+			for i := range p.Blocks {
+				if p.Blocks[i].Count > 0 {
+					p.Blocks[i].Count = 1
+				}
+			}
+		}
+		p.Mode = mode
+	}
+	return nil
+}
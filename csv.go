@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+
+	"golang.org/x/tools/cover"
+)
+
+var g_strOutCSV = flag.String("outcsv", "", "输出每个文件每个版本的覆盖率 CSV: file,package,group,statements,covered,percent,git_hash(为空不输出)")
+
+// 写出每个版本合并后每个文件的覆盖率, 文件名不带 git hash 后缀, 按文件和 git hash 排序
+func WriteCSV(fileName string, mergedByHash map[string][]*cover.Profile) error {
+	type csvRow struct {
+		gitHash string
+		stat    FileCoverage
+	}
+	var rows []csvRow
+	for gitHash, profiles := range mergedByHash {
+		for _, stat := range ComputeFileCoverage(profiles) {
+			rows = append(rows, csvRow{gitHash: gitHash, stat: stat})
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].stat.FileName != rows[j].stat.FileName {
+			return rows[i].stat.FileName < rows[j].stat.FileName
+		}
+		return rows[i].gitHash < rows[j].gitHash
+	})
+
+	outFile, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("error creating outFile: %v", err)
+	}
+	defer outFile.Close()
+	w := csv.NewWriter(outFile)
+	w.Write([]string{"file", "package", "group", "statements", "covered", "percent", "git_hash"})
+	for _, row := range rows {
+		w.Write([]string{
+			row.stat.FileName,
+			path.Dir(row.stat.FileName),
+			g_packageGroups.GroupOf(row.stat.FileName),
+			strconv.Itoa(row.stat.Statements),
+			strconv.Itoa(row.stat.Covered),
+			strconv.FormatFloat(row.stat.Percent(), 'f', 1, 64),
+			row.gitHash,
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return outFile.Close()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"sort"
+
+	"golang.org/x/tools/cover"
+)
+
+var g_bKeepDuplicates = flag.Bool("keep-duplicates", false, "不跳过重复的输入. 默认同一版本内容相同的输入(如产物重复上传, 与文件名, 时间戳和块的顺序无关)只合并一次, 避免 count 模式重复计数")
+
+// 同一版本内已经合并过的输入: 内容的校验和 -> 输入文件名
+type DuplicateChecker map[string]string
+
+// 输入内容的校验和: git hash, 权重和按文件名排序的覆盖率, 与输入文件名, 时间戳和标签无关
+func inputChecksum(fileInfo *CoverFileInfo, profiles []*cover.Profile) (string, error) {
+	sorted := make([]*cover.Profile, len(profiles))
+	for i, p := range profiles {
+		blocks := append([]cover.ProfileBlock(nil), p.Blocks...)
+		sort.Slice(blocks, func(i, j int) bool { return blockStart(blocks[i]).less(blockStart(blocks[j])) })
+		sorted[i] = &cover.Profile{FileName: p.FileName, Mode: p.Mode, Blocks: blocks}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].FileName < sorted[j].FileName })
+	weight := fileInfo.Weight
+	if weight == 0 {
+		weight = 1
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %g\n", fileInfo.GitHash, weight)
+	if err := DumpProfiles(sorted, h); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// 输入与已经合并过的输入重复时返回 true 并打印警告, -keep-duplicates 时总是返回 false
+func (c DuplicateChecker) Check(fileInfo *CoverFileInfo, profiles []*cover.Profile) (bool, error) {
+	if *g_bKeepDuplicates {
+		return false, nil
+	}
+	checksum, err := inputChecksum(fileInfo, profiles)
+	if err != nil {
+		return false, err
+	}
+	if first, ok := c[checksum]; ok {
+		fmt.Println(Redact(fmt.Sprint("warning: skip duplicate input ", fileInfo.FileName, " identical to ", first)))
+		return true, nil
+	}
+	c[checksum] = fileInfo.FileName
+	return false, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/cover"
+)
+
+var g_bDropDeleted = flag.Bool("drop-deleted", false, "去掉最新版本中已经删除的文件的覆盖率, 否则保留在原来的版本并打印这些文件")
+
+// 处理最新版本 latestHash 中不存在的文件: 默认保留并打印提示, -drop-deleted 时从合并结果中去掉, 返回这些文件
+func HandleDeletedFiles(mergedByHash map[string][]*cover.Profile, latestHash string) []string {
+	type versionFile struct {
+		gitHash, fileName string
+	}
+	var deleted []versionFile
+	for gitHash, profiles := range mergedByHash {
+		for _, p := range profiles {
+			if _, err := GitBlobHash(latestHash, RepoPath(p.FileName)); err != nil {
+				deleted = append(deleted, versionFile{gitHash, p.FileName})
+			}
+		}
+	}
+	if len(deleted) == 0 {
+		return nil
+	}
+	sort.Slice(deleted, func(i, j int) bool {
+		if deleted[i].fileName != deleted[j].fileName {
+			return deleted[i].fileName < deleted[j].fileName
+		}
+		return deleted[i].gitHash < deleted[j].gitHash
+	})
+	names := make([]string, 0, len(deleted))
+	for _, f := range deleted {
+		if *g_bDropDeleted {
+			removeProfile(mergedByHash, f.gitHash, f.fileName)
+		}
+		names = append(names, fmt.Sprintf("%s.%s", f.fileName, f.gitHash))
+	}
+	if *g_bDropDeleted {
+		fmt.Printf("dropped %d file versions deleted at %s\n", len(names), latestHash)
+	} else {
+		fmt.Printf("note: %d file versions no longer exist at %s and are kept under their hash-suffixed names (drop them with -drop-deleted): %s\n",
+			len(names), latestHash, strings.Join(names, ", "))
+	}
+	return names
+}
+
+// 文件在 commit 中不存在时(如覆盖率的版本号晚于删除文件的提交), 找到删除前最后一个有该文件的提交
+func lastCommitWithFile(commit string, filePath string) (string, error) {
+	if g_gitBackend != nil {
+		return g_gitBackend.LastCommitWithFile(commit, filePath)
+	}
+	out, err := GitCommand("log", "-1", "--format=%H", "--diff-filter=D", commit, "--", filePath).Output()
+	if err != nil {
+		return "", fmt.Errorf("git log %s -- %s: %v", commit, filePath, err)
+	}
+	deletedBy := strings.TrimSpace(string(out))
+	if deletedBy == "" {
+		return "", fmt.Errorf("%s does not exist in %s or its history", filePath, commit)
+	}
+	return deletedBy + "^", nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// 展开输入中的目录和通配符:
+//   - 目录(GOCOVERDIR 除外)递归查找文件名带版本信息或带有 .meta.json 元数据文件的覆盖率文件和 GOCOVERDIR 目录
+//   - 含 * ? [ 的参数按通配符匹配, ** 匹配任意多层目录, 例如 runs/**/cover.txt.*.*
+//
+// 其他输入(文件, -, goc://, http(s)://, 对象存储)原样返回
+func ExpandInputs(inputs []string) ([]string, error) {
+	var expanded []string
+	for _, input := range inputs {
+		if input == "-" || IsGocInput(input) || IsHTTPInput(input) || IsObjectURL(input) {
+			expanded = append(expanded, input)
+			continue
+		}
+		if stat, err := os.Stat(input); err == nil {
+			if stat.IsDir() && !IsCoverDataDir(input) {
+				files, err := DiscoverCoverFiles(input)
+				if err != nil {
+					return nil, err
+				}
+				if len(files) == 0 {
+					return nil, fmt.Errorf("no cover.txt.xxx.xxx file found in %s", input)
+				}
+				expanded = append(expanded, files...)
+			} else {
+				expanded = append(expanded, input)
+			}
+			continue
+		}
+		if !strings.ContainsAny(input, "*?[") {
+			expanded = append(expanded, input)
+			continue
+		}
+		files, err := ExpandGlob(input)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no file matches %s", input)
+		}
+		expanded = append(expanded, files...)
+	}
+	return expanded, nil
+}
+
+// 递归查找目录下文件名带版本信息或带有元数据文件的覆盖率文件和 GOCOVERDIR 目录, 按路径排序
+func DiscoverCoverFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// 通过元数据文件找到文件名不带版本的输入
+		if !d.IsDir() && strings.HasSuffix(p, sidecarSuffix) {
+			target := strings.TrimSuffix(p, sidecarSuffix)
+			if _, err := os.Stat(target); err == nil && !IsCoverFileName(target) {
+				files = append(files, target)
+			}
+			return nil
+		}
+		if !IsCoverFileName(p) {
+			return nil
+		}
+		if d.IsDir() {
+			if IsCoverDataDir(p) {
+				files = append(files, p)
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		files = append(files, p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// 按通配符查找文件, 支持 ** 匹配任意多层目录, 结果按路径排序
+func ExpandGlob(pattern string) ([]string, error) {
+	pattern = filepath.ToSlash(pattern)
+	segments := strings.Split(pattern, "/")
+	// 从第一个含通配符的目录开始遍历
+	i := 0
+	for ; i < len(segments)-1 && !strings.ContainsAny(segments[i], "*?["); i++ {
+	}
+	root := "."
+	if i > 0 {
+		root = strings.Join(segments[:i], "/")
+		if root == "" {
+			root = "/"
+		}
+	}
+	segments = segments[i:]
+	for _, segment := range segments {
+		if _, err := path.Match(segment, ""); err != nil {
+			return nil, fmt.Errorf("bad pattern %s: %v", pattern, err)
+		}
+	}
+
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	bRecursive := false
+	for _, segment := range segments {
+		bRecursive = bRecursive || segment == "**"
+	}
+
+	var files []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		names := strings.Split(filepath.ToSlash(rel), "/")
+		if matchSegments(segments, names) {
+			files = append(files, p)
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// 没有 ** 时不需要进入比模式更深的目录
+		if d.IsDir() && !bRecursive && len(names) >= len(segments) {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// 按段匹配路径, ** 匹配零或多段
+func matchSegments(pattern []string, names []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for k := 0; k <= len(names); k++ {
+				if matchSegments(pattern[1:], names[k:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(names) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], names[0]); !ok {
+			return false
+		}
+		pattern, names = pattern[1:], names[1:]
+	}
+	return len(names) == 0
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// 导出行中的运行信息, 每个输入文件是一次运行
+type ExportRunMeta struct {
+	Source    string `json:"source"`
+	GitHash   string `json:"git_hash"`
+	Timestamp int64  `json:"timestamp"`
+	Tags      string `json:"tags"`
+}
+
+// 按文件导出的行
+type ExportFileRow struct {
+	ExportRunMeta
+	FileName   string  `json:"file_name"`
+	Statements int     `json:"statements"`
+	Covered    int     `json:"covered_statements"`
+	Coverage   float64 `json:"coverage"`
+}
+
+// 按块导出的行
+type ExportBlockRow struct {
+	ExportRunMeta
+	FileName  string `json:"file_name"`
+	StartLine int    `json:"start_line"`
+	StartCol  int    `json:"start_col"`
+	EndLine   int    `json:"end_line"`
+	EndCol    int    `json:"end_col"`
+	NumStmt   int    `json:"num_stmt"`
+	Count     int    `json:"count"`
+}
+
+// export: 把覆盖率按文件(或按块)逐行导出到 ClickHouse / BigQuery, 便于在数仓中和其他数据关联
+func runExport(args []string) error {
+	fs := NewSubCommandFlagSet("export", "[options] [cover.txt.timestamp.hash ...]")
+	strTo := fs.String("to", "jsonl", "导出目标: clickhouse, bigquery 或 jsonl(输出到标准输出)")
+	strURL := fs.String("url", "http://localhost:8123", "ClickHouse HTTP 接口地址, 用户名密码取自 CLICKHOUSE_USER/CLICKHOUSE_PASSWORD")
+	strTable := fs.String("table", "", "目标表, ClickHouse 为 db.table, BigQuery 为 dataset.table")
+	bBlocks := fs.Bool("blocks", false, "按块导出, 否则按文件导出")
+	strTags := fs.String("tags", "", "附加到每一行的运行标签, 例如 env=prod,suite=e2e")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("Error: cover.txt.xxx.xxx file required.")
+	}
+	if *strTo != "jsonl" && *strTable == "" {
+		return fmt.Errorf("Error: -table required.")
+	}
+
+	tags, err := ParseTags(*strTags)
+	if err != nil {
+		return err
+	}
+	fileInfos, err := ParseCoverFileInfos(fs.Args())
+	if err != nil {
+		return err
+	}
+
+	// 边解析边写入管道, 由导出目标读取, 不在内存中保留全部行
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(WriteExportRows(fileInfos, tags, *bBlocks, pw))
+	}()
+	defer pr.Close()
+
+	switch *strTo {
+	case "jsonl":
+		_, err = io.Copy(os.Stdout, pr)
+		return err
+	case "clickhouse":
+		return ExportToClickHouse(*strURL, *strTable, pr)
+	case "bigquery":
+		return ExportToBigQuery(*strTable, pr)
+	default:
+		return fmt.Errorf("unsupported export target '%s'", *strTo)
+	}
+}
+
+// 把每个输入文件的覆盖率写成 JSON Lines, 行的标签与 import 相同: tags 加上输入自己的标签(-name-pattern, 元数据文件)
+func WriteExportRows(fileInfos []*CoverFileInfo, tags map[string]string, bBlocks bool, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for _, fileInfo := range fileInfos {
+		profiles, err := fileInfo.ReadProfiles()
+		if err != nil {
+			return fmt.Errorf("failed to parse profiles: %v", err)
+		}
+		meta := ExportRunMeta{
+			Source:    fileInfo.FileName,
+			GitHash:   fileInfo.GitHash,
+			Timestamp: fileInfo.Timestamp,
+			Tags:      FormatTags(MergeTags(tags, fileInfo.Tags)),
+		}
+		if !bBlocks {
+			for _, stat := range ComputeFileCoverage(profiles) {
+				row := ExportFileRow{ExportRunMeta: meta, FileName: stat.FileName, Statements: stat.Statements, Covered: stat.Covered, Coverage: stat.Percent()}
+				if err := enc.Encode(row); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		for _, p := range profiles {
+			for _, b := range p.Blocks {
+				row := ExportBlockRow{ExportRunMeta: meta, FileName: p.FileName, StartLine: b.StartLine, StartCol: b.StartCol, EndLine: b.EndLine, EndCol: b.EndCol, NumStmt: b.NumStmt, Count: b.Count}
+				if err := enc.Encode(row); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// 通过 ClickHouse HTTP 接口以 JSONEachRow 格式流式写入
+func ExportToClickHouse(strURL string, table string, rows io.Reader) error {
+	u, err := url.Parse(strURL)
+	if err != nil {
+		return fmt.Errorf("invalid clickhouse url: %v", err)
+	}
+	q := u.Query()
+	q.Set("query", fmt.Sprintf("INSERT INTO %s FORMAT JSONEachRow", table))
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(http.MethodPost, u.String(), rows)
+	if err != nil {
+		return err
+	}
+	cred, err := GetCredential("clickhouse")
+	if err != nil {
+		return err
+	}
+	// 兼容之前的环境变量
+	if cred.User == "" {
+		cred.User, cred.Password = os.Getenv("CLICKHOUSE_USER"), os.Getenv("CLICKHOUSE_PASSWORD")
+		AddSecret(cred.Password)
+	}
+	if cred.User != "" {
+		req.Header.Set("X-ClickHouse-User", cred.User)
+		req.Header.Set("X-ClickHouse-Key", cred.Password)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to export to clickhouse: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("failed to export to clickhouse: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// 通过 bq insert 流式写入 BigQuery, 认证沿用 gcloud/bq 的配置
+func ExportToBigQuery(table string, rows io.Reader) error {
+	cmd := exec.Command("bq", "insert", table)
+	cmd.Stdin = rows
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to run bq insert: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/cover"
+)
+
+// -report facts 每个包最多列出的未覆盖导出函数和文件数, 控制篇幅以便放入代码助手的提示
+const factsLimit = 5
+
+type FileFact struct {
+	File    string  `json:"file"`
+	Percent float64 `json:"percent"`
+}
+
+type PackageFacts struct {
+	ImportPath        string     `json:"package"`
+	Percent           float64    `json:"percent"`
+	Statements        int        `json:"statements"`
+	Covered           int        `json:"covered"`
+	Files             int        `json:"files"`
+	UncoveredExported []string   `json:"uncovered_exported"`
+	MoreUncovered     int        `json:"more_uncovered,omitempty"` // 超过 factsLimit 没有列出的未覆盖导出函数数
+	WorstFiles        []FileFact `json:"worst_files"`
+}
+
+type CoverageFacts struct {
+	Percent    float64        `json:"percent"`
+	Statements int            `json:"statements"`
+	Covered    int            `json:"covered"`
+	Packages   []PackageFacts `json:"packages"`
+}
+
+// 汇总每个文件最新版本的覆盖率要点, 按包的导入路径排序(与 ComputePackageCoverage 一样由 go list 解析), 函数取自导出的源码
+func ComputeCoverageFacts(profiles []*cover.Profile, latestHashes map[string]string, limit int) (*CoverageFacts, error) {
+	byDir := make(map[string]*PackageFacts)
+	for i, stat := range ComputeFileCoverage(profiles) {
+		dir := path.Dir(stat.FileName)
+		pkg := byDir[dir]
+		if pkg == nil {
+			pkg = &PackageFacts{ImportPath: dir, UncoveredExported: []string{}, WorstFiles: []FileFact{}}
+			byDir[dir] = pkg
+		}
+		pkg.Files++
+		pkg.Statements += stat.Statements
+		pkg.Covered += stat.Covered
+		if stat.Statements > 0 {
+			pkg.WorstFiles = append(pkg.WorstFiles, FileFact{File: path.Base(stat.FileName), Percent: roundPercent(stat.Percent())})
+		}
+		p := profiles[i]
+		versioned := &cover.Profile{FileName: p.FileName + "." + latestHashes[p.FileName], Mode: p.Mode, Blocks: p.Blocks}
+		funcs, _ := profileFuncs(versioned)
+		for _, f := range funcs {
+			statements, covered := 0, 0
+			for _, b := range f.blocks {
+				statements += b.NumStmt
+				if b.Count > 0 {
+					covered += b.NumStmt
+				}
+			}
+			if f.lit || statements == 0 || covered > 0 || !isExportedFunc(f.name) {
+				continue
+			}
+			if len(pkg.UncoveredExported) < limit {
+				pkg.UncoveredExported = append(pkg.UncoveredExported, f.name)
+			} else {
+				pkg.MoreUncovered++
+			}
+		}
+	}
+	dirs := make([]string, 0, len(byDir))
+	for dir := range byDir {
+		dirs = append(dirs, dir)
+	}
+	listed, err := listPackages(dirs)
+	if err != nil {
+		return nil, err
+	}
+	facts := &CoverageFacts{Packages: make([]PackageFacts, 0, len(dirs))}
+	for _, dir := range dirs {
+		pkg := byDir[dir]
+		if info, ok := listed[dir]; ok {
+			pkg.ImportPath = info[0]
+		}
+		pkg.Percent = roundPercent(FileCoverage{Statements: pkg.Statements, Covered: pkg.Covered}.Percent())
+		sort.SliceStable(pkg.WorstFiles, func(i, j int) bool { return pkg.WorstFiles[i].Percent < pkg.WorstFiles[j].Percent })
+		if len(pkg.WorstFiles) > limit {
+			pkg.WorstFiles = pkg.WorstFiles[:limit]
+		}
+		facts.Statements += pkg.Statements
+		facts.Covered += pkg.Covered
+		facts.Packages = append(facts.Packages, *pkg)
+	}
+	sort.Slice(facts.Packages, func(i, j int) bool { return facts.Packages[i].ImportPath < facts.Packages[j].ImportPath })
+	facts.Percent = roundPercent(FileCoverage{Statements: facts.Statements, Covered: facts.Covered}.Percent())
+	return facts, nil
+}
+
+func (facts *CoverageFacts) Text() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "coverage: %.1f%% of %d statements, %d packages\n", facts.Percent, facts.Statements, len(facts.Packages))
+	for _, pkg := range facts.Packages {
+		fmt.Fprintf(&sb, "%s: %.1f%% of %d statements in %d files\n", pkg.ImportPath, pkg.Percent, pkg.Statements, pkg.Files)
+		if len(pkg.UncoveredExported) > 0 {
+			more := ""
+			if pkg.MoreUncovered > 0 {
+				more = fmt.Sprintf(" (+%d more)", pkg.MoreUncovered)
+			}
+			fmt.Fprintf(&sb, "  uncovered exported: %s%s\n", strings.Join(pkg.UncoveredExported, ", "), more)
+		}
+		if len(pkg.WorstFiles) > 0 {
+			files := make([]string, len(pkg.WorstFiles))
+			for i, f := range pkg.WorstFiles {
+				files[i] = fmt.Sprintf("%s %.1f%%", f.File, f.Percent)
+			}
+			fmt.Fprintf(&sb, "  worst files: %s\n", strings.Join(files, ", "))
+		}
+	}
+	return sb.String()
+}
+
+// 输出 -report facts, bJSON 时输出 JSON, 否则输出文本
+func WriteCoverageFacts(w io.Writer, facts *CoverageFacts, bJSON bool) error {
+	if !bJSON {
+		_, err := io.WriteString(w, facts.Text())
+		return err
+	}
+	data, err := json.MarshalIndent(facts, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/tools/cover"
+)
+
+// 解析单个覆盖率文件, 开启 -mmap 时走内存映射的快速路径
+func ParseProfileFile(fileName string) ([]*cover.Profile, error) {
+	if *g_bMmap {
+		return ParseProfilesMmap(fileName)
+	}
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseProfilesFrom(f)
+}
+
+// 从 Reader 解析覆盖率数据, 供网络连接, 压缩包等非文件输入使用.
+// 根据内容识别格式: Go cover profile 或 Cobertura XML, 可以是 gzip 或 zstd 压缩的
+func ParseProfilesFrom(r io.Reader) ([]*cover.Profile, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(512)
+	if IsGzipContent(head) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		return ParseProfilesFrom(gz)
+	}
+	if IsZstdContent(head) {
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return ParseProfilesFrom(zr)
+	}
+	if IsXMLContent(head) {
+		return ParseCoberturaXML(br)
+	}
+	// 与 mmap 路径使用同一个解析器, 相同位置的块累加时饱和
+	var pp profileParser
+	sc := bufio.NewScanner(br)
+	sc.Buffer(nil, math.MaxInt32)
+	for sc.Scan() {
+		if err := pp.line(sc.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return pp.profiles()
+}
+
+// 判断内容是否为 gzip 压缩数据
+func IsGzipContent(head []byte) bool {
+	return len(head) >= 2 && head[0] == 0x1f && head[1] == 0x8b
+}
+
+// 判断内容是否为 XML(用于识别 Cobertura 报告)
+func IsXMLContent(head []byte) bool {
+	head = bytes.TrimPrefix(head, []byte("\xef\xbb\xbf"))
+	head = bytes.TrimLeft(head, " \t\r\n")
+	return bytes.HasPrefix(head, []byte("<?xml")) || bytes.HasPrefix(head, []byte("<coverage"))
+}
+
+// 使用 mmap 读取覆盖率文件并逐行扫描, 行本身不产生内存分配,
+// 结果与 cover.ParseProfiles 一致(块排序, 相同位置的块合并)
+func ParseProfilesMmap(fileName string) ([]*cover.Profile, error) {
+	data, unmap, err := MmapFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer unmap()
+	if IsGzipContent(data) || IsZstdContent(data) {
+		return ParseProfilesFrom(bytes.NewReader(data))
+	}
+	if IsXMLContent(data) {
+		return ParseCoberturaXML(bytes.NewReader(data))
+	}
+	return ParseProfilesBytes(data)
+}
+
+// 从内存中的覆盖率数据解析 Profile
+func ParseProfilesBytes(data []byte) ([]*cover.Profile, error) {
+	var pp profileParser
+	for len(data) > 0 {
+		var line []byte
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, data = data[:i], data[i+1:]
+		} else {
+			line, data = data, nil
+		}
+		if err := pp.line(line); err != nil {
+			return nil, err
+		}
+	}
+	return pp.profiles()
+}
+
+// 逐行解析覆盖率数据, 行本身不会被保留
+type profileParser struct {
+	files map[string]*cover.Profile
+	mode  string
+	last  *cover.Profile
+}
+
+func (pp *profileParser) line(line []byte) error {
+	line = bytes.TrimSuffix(line, []byte{'\r'})
+	if pp.mode == "" {
+		// mode 行之前的注释行(版本信息)
+		if bytes.HasPrefix(line, []byte("#")) {
+			return nil
+		}
+		const p = "mode: "
+		if !bytes.HasPrefix(line, []byte(p)) || len(line) == len(p) {
+			return fmt.Errorf("bad mode line: %s", line)
+		}
+		pp.mode = string(line[len(p):])
+		pp.files = make(map[string]*cover.Profile)
+		return nil
+	}
+	if len(line) == 0 {
+		return nil
+	}
+
+	fn, b, ok := parseLineBytes(line)
+	if !ok {
+		return fmt.Errorf("line %q doesn't match expected format", line)
+	}
+	// 同一文件的块通常是连续的, 先和上一行比较, 避免查表
+	if pp.last == nil || pp.last.FileName != string(fn) {
+		pp.last = pp.files[string(fn)]
+		if pp.last == nil {
+			pp.last = &cover.Profile{
+				FileName: string(fn),
+				Mode:     pp.mode,
+			}
+			pp.files[pp.last.FileName] = pp.last
+		}
+	}
+	pp.last.Blocks = append(pp.last.Blocks, b)
+	return nil
+}
+
+// 排序并合并相同位置的块, 返回按文件名排序的结果
+func (pp *profileParser) profiles() ([]*cover.Profile, error) {
+	files, mode := pp.files, pp.mode
+	for _, p := range files {
+		sort.Slice(p.Blocks, func(i, j int) bool {
+			bi, bj := p.Blocks[i], p.Blocks[j]
+			return bi.StartLine < bj.StartLine || bi.StartLine == bj.StartLine && bi.StartCol < bj.StartCol
+		})
+		// 合并相同位置的块
+		j := 1
+		for i := 1; i < len(p.Blocks); i++ {
+			b := p.Blocks[i]
+			pb := p.Blocks[j-1]
+			if b.StartLine == pb.StartLine && b.StartCol == pb.StartCol && b.EndLine == pb.EndLine && b.EndCol == pb.EndCol {
+				if b.NumStmt != pb.NumStmt {
+					return nil, fmt.Errorf("inconsistent NumStmt: changed from %d to %d", pb.NumStmt, b.NumStmt)
+				}
+				if mode == "set" {
+					p.Blocks[j-1].Count |= b.Count
+				} else {
+					p.Blocks[j-1].Count = AddCount(p.Blocks[j-1].Count, b.Count)
+				}
+				continue
+			}
+			p.Blocks[j] = b
+			j++
+		}
+		p.Blocks = p.Blocks[:j]
+	}
+
+	profiles := make([]*cover.Profile, 0, len(files))
+	for _, p := range files {
+		profiles = append(profiles, p)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].FileName < profiles[j].FileName })
+	return profiles, nil
+}
+
+// 从行尾向前解析 name.go:line.column,line.column numberOfStatements count
+func parseLineBytes(l []byte) (fileName []byte, b cover.ProfileBlock, ok bool) {
+	end := len(l)
+	if b.Count, end, ok = seekBackBytes(l, ' ', end); !ok {
+		return nil, b, false
+	}
+	if b.NumStmt, end, ok = seekBackBytes(l, ' ', end); !ok {
+		return nil, b, false
+	}
+	if b.EndCol, end, ok = seekBackBytes(l, '.', end); !ok {
+		return nil, b, false
+	}
+	if b.EndLine, end, ok = seekBackBytes(l, ',', end); !ok {
+		return nil, b, false
+	}
+	if b.StartCol, end, ok = seekBackBytes(l, '.', end); !ok {
+		return nil, b, false
+	}
+	if b.StartLine, end, ok = seekBackBytes(l, ':', end); !ok {
+		return nil, b, false
+	}
+	if end == 0 {
+		return nil, b, false
+	}
+	return l[:end], b, true
+}
+
+// 向前找到 sep, 把 sep 和 end 之间的十进制数字解析为整数
+func seekBackBytes(l []byte, sep byte, end int) (value int, nextSep int, ok bool) {
+	start := end - 1
+	for ; start >= 0 && l[start] != sep; start-- {
+	}
+	if start < 0 || start+1 == end {
+		return 0, 0, false
+	}
+	for _, c := range l[start+1 : end] {
+		if c < '0' || c > '9' {
+			return 0, 0, false
+		}
+		// 超出 int 范围时与 strconv.Atoi 一样视为格式错误, 而不是回绕
+		if value > (math.MaxInt-int(c-'0'))/10 {
+			return 0, 0, false
+		}
+		value = value*10 + int(c-'0')
+	}
+	return value, start, true
+}
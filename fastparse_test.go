@@ -0,0 +1,35 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/cover"
+)
+
+// 同一文件中相同位置的块累加时饱和, 流式解析和 mmap 路径的结果相同
+func TestParseProfilesSaturate(t *testing.T) {
+	data := "# timestamp: 100\nmode: count\nexample.com/foo/foo.go:3.24,4.11 1 9223372036854775807\nexample.com/foo/foo.go:3.24,4.11 1 5\nexample.com/foo/foo.go:4.11,6.3 1 2\r\n"
+	fromReader, err := ParseProfilesFrom(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromBytes, err := ParseProfilesBytes([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, p := range map[string][]*cover.Profile{"reader": fromReader, "bytes": fromBytes} {
+		if len(p) != 1 || len(p[0].Blocks) != 2 {
+			t.Fatalf("%s: got %+v, want 1 file with 2 blocks", name, p)
+		}
+		if p[0].Blocks[0].Count != math.MaxInt || p[0].Blocks[1].Count != 2 {
+			t.Errorf("%s: counts %d, %d, want %d, 2", name, p[0].Blocks[0].Count, p[0].Blocks[1].Count, math.MaxInt)
+		}
+	}
+
+	// 超出 int 范围的计数是格式错误
+	if _, err := ParseProfilesFrom(strings.NewReader("mode: count\nexample.com/foo/foo.go:3.24,4.11 1 99999999999999999999\n")); err == nil {
+		t.Error("no error for a count out of range")
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/cover"
+)
+
+var g_bFollowRenames = flag.Bool("follow-renames", false, "按 git diff -M 识别版本之间重命名或移动的文件, 内容相同时与最新版本的路径合并为同一个文件")
+
+// 文件从 oldHash 到 newHash 的重命名: 覆盖率中的旧文件名 -> 新文件名(按 -src-template 对应)
+func GitRenames(oldHash string, newHash string) (map[string]string, error) {
+	out, err := GitCommand("diff", "-M", "--name-status", "-z", "--diff-filter=R", oldHash, newHash).Output()
+	if err != nil {
+		return nil, fmt.Errorf("git diff -M %s %s: %v", oldHash, newHash, err)
+	}
+	renames := make(map[string]string)
+	// R<相似度> NUL <旧路径> NUL <新路径> NUL
+	fields := strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+	for i := 0; i+2 < len(fields); i += 3 {
+		if !strings.HasPrefix(fields[i], "R") {
+			return nil, fmt.Errorf("git diff -M %s %s: unexpected status %q", oldHash, newHash, fields[i])
+		}
+		oldName, ok1 := SourceFileName(fields[i+1])
+		newName, ok2 := SourceFileName(fields[i+2])
+		if ok1 && ok2 {
+			renames[oldName] = newName
+		}
+	}
+	return renames, nil
+}
+
+// 每个版本中重命名过的文件到最新版本路径的映射: git hash -> 旧路径 -> 最新版本的路径, coverFiles 按时间排序
+func FollowRenames(coverFiles []*CoverFileInfo) map[string]map[string]string {
+	renamesByHash := make(map[string]map[string]string)
+	latestHash := coverFiles[len(coverFiles)-1].GitHash
+	for _, coverFile := range coverFiles {
+		if coverFile.GitHash == latestHash || renamesByHash[coverFile.GitHash] != nil {
+			continue
+		}
+		renames, err := GitRenames(coverFile.GitHash, latestHash)
+		if err != nil {
+			fmt.Println("warning: follow renames:", err)
+			continue
+		}
+		renamesByHash[coverFile.GitHash] = renames
+	}
+	return renamesByHash
+}
+
+// 从 gitHash 版本的覆盖率中取出文件, 没有时返回 nil
+func takeProfile(mergedByHash map[string][]*cover.Profile, gitHash string, fileName string) *cover.Profile {
+	profiles := mergedByHash[gitHash]
+	i := sort.Search(len(profiles), func(i int) bool { return profiles[i].FileName >= fileName })
+	if i == len(profiles) || profiles[i].FileName != fileName {
+		return nil
+	}
+	p := profiles[i]
+	removeProfile(mergedByHash, gitHash, fileName)
+	return p
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var g_strGitBackend = flag.String("git-backend", "exec", "读取各版本源码的方式: exec 执行 git 命令, go-git 在进程内读取(没有 git 命令的容器中也可以使用, 需要用 -tags gogit 编译, 不支持 -remap-lines 和 -follow-renames)")
+
+// 进程内读取 git 对象的实现, 代替逐个执行 git show 和 git ls-tree
+type gitBackend interface {
+	// 文件在提交中的内容
+	FileContent(commit string, filePath string) ([]byte, error)
+	// 提交中所有文件的 blob hash: 路径 -> blob hash
+	Tree(commit string) (map[string]string, error)
+	// 引用(分支, tag 或 hash)对应的完整 commit hash
+	Resolve(ref string) (string, error)
+	// 工作区中已跟踪的文件是否有改动, 未被忽略的未跟踪文件, 以及当前分支(不在分支上时为空), 裸仓库没有改动
+	Status() (modified bool, untracked []string, branch string, err error)
+	// 索引中已跟踪的文件, 路径相对工作区根目录
+	TrackedFiles() ([]string, error)
+	// 提交的作者, 提交者, 标题和提交时间
+	Commit(ref string) (*gitCommit, error)
+	// 从 commit 往前找到删除 filePath 的提交, 返回删除前最后一个有该文件的提交
+	LastCommitWithFile(commit string, filePath string) (string, error)
+}
+
+type gitCommit struct {
+	Hash           string
+	AuthorName     string
+	AuthorEmail    string
+	CommitterName  string
+	CommitterEmail string
+	Subject        string
+	Time           int64 // 提交时间, unix 秒
+}
+
+// 读取提交信息
+func GitCommitInfo(ref string) (*gitCommit, error) {
+	if g_gitBackend != nil {
+		return g_gitBackend.Commit(ref)
+	}
+	out, err := GitCommand("log", "-1", "--format=%H%n%an%n%ae%n%cn%n%ce%n%ct%n%s", ref).Output()
+	if err != nil {
+		return nil, fmt.Errorf("git log %s: %v", ref, err)
+	}
+	fields := strings.SplitN(strings.TrimRight(string(out), "\n"), "\n", 7)
+	if len(fields) != 7 {
+		return nil, fmt.Errorf("git log %s: unexpected output %q", ref, out)
+	}
+	t, _ := strconv.ParseInt(fields[5], 10, 64)
+	return &gitCommit{Hash: fields[0], AuthorName: fields[1], AuthorEmail: fields[2],
+		CommitterName: fields[3], CommitterEmail: fields[4], Time: t, Subject: fields[6]}, nil
+}
+
+// 为空时执行 git 命令
+var g_gitBackend gitBackend
+
+// 用 -tags gogit 编译时由 gitbackend_gogit.go 设置, 参数为 git 目录或工作区
+var g_newGoGitBackend func(dir string) (gitBackend, error)
+
+// 按 -git-backend 选择实现, 在 SetupRepo 中解析完 -repo 和 -git-dir 之后调用
+func setupGitBackend() error {
+	switch *g_strGitBackend {
+	case "exec":
+		g_gitBackend = nil
+		return nil
+	case "go-git":
+		if g_newGoGitBackend == nil {
+			return fmt.Errorf("-git-backend go-git is not available in this build, rebuild with -tags gogit")
+		}
+		// 这两项依赖 git diff 的改动和重命名检测, 没有进程内的实现
+		if *g_bRemapLines || *g_bFollowRenames {
+			return fmt.Errorf("-remap-lines and -follow-renames need the git command and can not be used with -git-backend go-git")
+		}
+		backend, err := g_newGoGitBackend(firstNonEmpty(*g_strGitDir, *g_strRepo, "."))
+		if err != nil {
+			return fmt.Errorf("go-git: %v", err)
+		}
+		g_gitBackend = backend
+		return nil
+	}
+	return fmt.Errorf("unsupported git-backend '%s', expected exec or go-git", *g_strGitBackend)
+}
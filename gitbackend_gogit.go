@@ -0,0 +1,198 @@
+//go:build gogit
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func init() {
+	g_newGoGitBackend = newGoGitBackend
+}
+
+// 基于 go-git 的实现, 对象存储不保证并发安全, 用锁串行访问
+type goGitBackend struct {
+	mutex sync.Mutex
+	repo  *git.Repository
+}
+
+func newGoGitBackend(dir string) (gitBackend, error) {
+	repo, err := git.PlainOpenWithOptions(dir, &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return nil, err
+	}
+	return &goGitBackend{repo: repo}, nil
+}
+
+func (b *goGitBackend) commit(ref string) (*object.Commit, error) {
+	hash, err := b.repo.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return nil, fmt.Errorf("resolve %s: %v", ref, err)
+	}
+	c, err := b.repo.CommitObject(*hash)
+	if err != nil {
+		return nil, fmt.Errorf("commit %s: %v", ref, err)
+	}
+	return c, nil
+}
+
+func (b *goGitBackend) tree(commit string) (*object.Tree, error) {
+	c, err := b.commit(commit)
+	if err != nil {
+		return nil, err
+	}
+	return c.Tree()
+}
+
+func (b *goGitBackend) Resolve(ref string) (string, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	hash, err := b.repo.ResolveRevision(plumbing.Revision(ref + "^{commit}"))
+	if err != nil {
+		return "", fmt.Errorf("unknown revision %s", ref)
+	}
+	return hash.String(), nil
+}
+
+func (b *goGitBackend) FileContent(commit string, filePath string) ([]byte, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	tree, err := b.tree(commit)
+	if err != nil {
+		return nil, err
+	}
+	file, err := tree.File(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%s: %v", commit, filePath, err)
+	}
+	r, err := file.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func (b *goGitBackend) Tree(commit string) (map[string]string, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	tree, err := b.tree(commit)
+	if err != nil {
+		return nil, err
+	}
+	blobs := make(map[string]string)
+	err = tree.Files().ForEach(func(f *object.File) error {
+		blobs[f.Name] = f.Hash.String()
+		return nil
+	})
+	return blobs, err
+}
+
+func (b *goGitBackend) Status() (bool, []string, string, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	branch := ""
+	if head, err := b.repo.Head(); err == nil && head.Name().IsBranch() {
+		branch = head.Name().Short()
+	}
+	wt, err := b.repo.Worktree()
+	if errors.Is(err, git.ErrIsBareRepository) {
+		return false, nil, branch, nil
+	}
+	if err != nil {
+		return false, nil, branch, err
+	}
+	status, err := wt.Status()
+	if err != nil {
+		return false, nil, branch, err
+	}
+	modified := false
+	var untracked []string
+	for file, s := range status {
+		switch {
+		case s.Worktree == git.Untracked:
+			untracked = append(untracked, file)
+		case s.Staging != git.Unmodified || s.Worktree != git.Unmodified:
+			modified = true
+		}
+	}
+	sort.Strings(untracked)
+	return modified, untracked, branch, nil
+}
+
+func (b *goGitBackend) TrackedFiles() ([]string, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	idx, err := b.repo.Storer.Index()
+	if err != nil {
+		return nil, fmt.Errorf("read index: %v", err)
+	}
+	names := make([]string, 0, len(idx.Entries))
+	for _, entry := range idx.Entries {
+		names = append(names, entry.Name)
+	}
+	return names, nil
+}
+
+func (b *goGitBackend) Commit(ref string) (*gitCommit, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	c, err := b.commit(ref)
+	if err != nil {
+		return nil, err
+	}
+	return &gitCommit{
+		Hash:           c.Hash.String(),
+		AuthorName:     c.Author.Name,
+		AuthorEmail:    c.Author.Email,
+		CommitterName:  c.Committer.Name,
+		CommitterEmail: c.Committer.Email,
+		Subject:        strings.TrimSpace(strings.SplitN(c.Message, "\n", 2)[0]),
+		Time:           c.Committer.When.Unix(),
+	}, nil
+}
+
+// 和 git log --diff-filter=D 一样只看改动过 filePath 的提交, 第一个没有该文件而父提交有的就是删除它的提交
+func (b *goGitBackend) LastCommitWithFile(commit string, filePath string) (string, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	start, err := b.commit(commit)
+	if err != nil {
+		return "", err
+	}
+	iter, err := b.repo.Log(&git.LogOptions{From: start.Hash, FileName: &filePath})
+	if err != nil {
+		return "", fmt.Errorf("log %s -- %s: %v", commit, filePath, err)
+	}
+	defer iter.Close()
+	found := ""
+	errStop := errors.New("stop")
+	err = iter.ForEach(func(c *object.Commit) error {
+		if _, err := c.File(filePath); err == nil {
+			return nil
+		}
+		return c.Parents().ForEach(func(parent *object.Commit) error {
+			if _, err := parent.File(filePath); err != nil {
+				return nil
+			}
+			found = parent.Hash.String()
+			return errStop
+		})
+	})
+	if err != nil && err != errStop {
+		return "", fmt.Errorf("log %s -- %s: %v", commit, filePath, err)
+	}
+	if found == "" {
+		return "", fmt.Errorf("%s does not exist in %s or its history", filePath, commit)
+	}
+	return found, nil
+}
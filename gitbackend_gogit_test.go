@@ -0,0 +1,121 @@
+//go:build gogit
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// go-git 的实现与 git 命令的结果一致
+func TestGoGitBackend(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	repo, err := newSelftestRepo(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.git("tag", "light", repo.commits[1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.git("tag", "-a", "-m", "release", "v1.0.0", repo.commits[2]); err != nil {
+		t.Fatal(err)
+	}
+	backend, err := newGoGitBackend(repo.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ref := range []string{repo.commits[0], "HEAD", "light", "v1.0.0"} {
+		want, err := repo.git("rev-parse", ref+"^{commit}")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := backend.Resolve(ref)
+		if err != nil || got != want {
+			t.Errorf("Resolve(%s) = %s, %v, want %s", ref, got, err, want)
+		}
+	}
+	if _, err := backend.Resolve("no-such-ref"); err == nil {
+		t.Error("Resolve(no-such-ref): no error")
+	}
+
+	content, err := backend.FileContent(repo.commits[2], "go/src/example.com/m/a.go")
+	if err != nil || string(content) != selftestV2 {
+		t.Errorf("FileContent = %q, %v, want %q", content, err, selftestV2)
+	}
+	blobs, err := backend.Tree(repo.commits[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := repo.git("rev-parse", repo.commits[1]+":go/src/example.com/m/b.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blobs["go/src/example.com/m/b.go"] != want {
+		t.Errorf("Tree: b.go = %s, want %s", blobs["go/src/example.com/m/b.go"], want)
+	}
+
+	const cGo = "go/src/example.com/m/c.go"
+	got, err := backend.LastCommitWithFile(repo.commits[5], cGo)
+	if err != nil || !strings.HasPrefix(got, repo.commits[4]) {
+		t.Errorf("LastCommitWithFile(%s) = %s, %v, want %s", cGo, got, err, repo.commits[4])
+	}
+	if got, err := backend.LastCommitWithFile(repo.commits[5], "go/src/example.com/m/a.go"); err == nil {
+		t.Errorf("LastCommitWithFile(a.go) = %s, want error", got)
+	}
+
+	commit, err := backend.Commit("v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantCommit, err := repo.git("log", "-1", "--format=%H|%an|%ae|%cn|%ce|%ct|%s", "v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotCommit := fmt.Sprintf("%s|%s|%s|%s|%s|%d|%s", commit.Hash, commit.AuthorName, commit.AuthorEmail,
+		commit.CommitterName, commit.CommitterEmail, commit.Time, commit.Subject)
+	if gotCommit != wantCommit {
+		t.Errorf("Commit = %s, want %s", gotCommit, wantCommit)
+	}
+
+	tracked, err := backend.TrackedFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTracked, err := repo.git("ls-files", "--full-name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(tracked, "\n") != wantTracked {
+		t.Errorf("TrackedFiles = %v, want %q", tracked, wantTracked)
+	}
+
+	if err := os.WriteFile(filepath.Join(repo.dir, "new.txt"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	modified, untracked, branch, err := backend.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantBranch, _ := repo.git("symbolic-ref", "--short", "HEAD")
+	if modified || strings.Join(untracked, ",") != "new.txt" || branch != wantBranch {
+		t.Errorf("Status = %v, %v, %q, want false, [new.txt], %q", modified, untracked, branch, wantBranch)
+	}
+}
+
+// 依赖 git diff 的选项不能和 go-git 一起使用
+func TestGoGitBackendRejectsDiffFlags(t *testing.T) {
+	defer func(backend string, bRemap bool) {
+		*g_strGitBackend, *g_bRemapLines, g_gitBackend = backend, bRemap, nil
+	}(*g_strGitBackend, *g_bRemapLines)
+	*g_strGitBackend, *g_bRemapLines = "go-git", true
+	if err := setupGitBackend(); err == nil || !strings.Contains(err.Error(), "-remap-lines") {
+		t.Errorf("setupGitBackend() = %v, want -remap-lines error", err)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"runtime"
+	"sync"
+)
+
+var g_nGitJobs = flag.Int("git-jobs", runtime.NumCPU(), "同时执行的 git 命令(各版本的 git ls-tree, 导出源码的 git show)个数, 1 为逐个执行")
+
+// 用最多 -git-jobs 个 goroutine 执行 job(0) 到 job(n-1), 全部完成后返回下标最小的错误
+func RunGitJobs(n int, job func(i int) error) error {
+	workers := *g_nGitJobs
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > n {
+		workers = n
+	}
+	errs := make([]error, n)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				errs[i] = job(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 并发读取各版本的 git ls-tree, 之后按文件比较版本时 GitBlobHash 直接使用缓存. 读取失败的版本在使用时报错
+func PrefetchTrees(coverFiles []*CoverFileInfo) {
+	var commits []string
+	seen := make(map[string]bool)
+	for _, coverFile := range coverFiles {
+		if !seen[coverFile.GitHash] {
+			seen[coverFile.GitHash] = true
+			commits = append(commits, coverFile.GitHash)
+		}
+	}
+	RunGitJobs(len(commits), func(i int) error {
+		GitBlobHash(commits[i], "")
+		return nil
+	})
+}
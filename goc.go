@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// goc(https://github.com/qiniu/goc) 服务中心输入:
+//
+//	goc://host:7777?hash=e24dac6&service=user-api&ts=1723042827
+//
+// 通过 goc server 的 /v1/cover/profile 接口拉取覆盖率. goc 不记录版本信息,
+// 所以 git hash 必须在 URL 中指定, 时间戳不指定时使用当前时间.
+// service, address, coverfile, skipfile 参数原样传给 goc server, 可以重复
+const gocScheme = "goc://"
+
+// 拉取 goc 覆盖率的超时时间
+const gocTimeout = 60 * time.Second
+
+func IsGocInput(s string) bool {
+	return strings.HasPrefix(s, gocScheme)
+}
+
+// 解析 goc:// 输入并从 goc server 拉取覆盖率
+func ParseGocInput(s string) (*CoverFileInfo, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("goc input %s is not valid: %v", s, err)
+	}
+	query := u.Query()
+	gitHash := query.Get("hash")
+	if gitHash == "" {
+		return nil, fmt.Errorf("goc input %s: hash required", s)
+	}
+	timestamp := time.Now().Unix()
+	if strTimestamp := query.Get("ts"); strTimestamp != "" {
+		if timestamp, err = strconv.ParseInt(strTimestamp, 10, 64); err != nil {
+			return nil, fmt.Errorf("goc input %s: timestamp is not valid", s)
+		}
+	}
+
+	data, err := FetchGocProfile(u.Host, query)
+	if err != nil {
+		return nil, err
+	}
+	return &CoverFileInfo{
+		Timestamp: timestamp,
+		GitHash:   gitHash,
+		FileName:  s,
+		Reader:    bytes.NewReader(data),
+	}, nil
+}
+
+// 从 goc server 拉取已注册服务的覆盖率(文本格式 cover profile)
+func FetchGocProfile(host string, query url.Values) ([]byte, error) {
+	params := url.Values{}
+	for _, key := range []string{"service", "address", "coverfile", "skipfile"} {
+		for _, value := range query[key] {
+			params.Add(key, value)
+		}
+	}
+	return gocRequest(http.MethodGet, host, "/v1/cover/profile?"+params.Encode(), nil)
+}
+
+// 列出 goc server 上注册的服务: 服务名 -> 实例地址
+func ListGocServices(host string) (map[string][]string, error) {
+	data, err := gocRequest(http.MethodGet, host, "/v1/cover/list", nil)
+	if err != nil {
+		return nil, err
+	}
+	services := make(map[string][]string)
+	if err := json.Unmarshal(data, &services); err != nil {
+		return nil, fmt.Errorf("goc server %s: bad service list: %v", host, err)
+	}
+	return services, nil
+}
+
+// goc-sync: 拉取 goc server 上注册服务的覆盖率, 与本地文件一起合并, 可选把合并结果推送到指定地址
+func runGocSync(args []string) error {
+	fs := NewSubCommandFlagSet("goc-sync", "[options] [cover.txt.timestamp.hash ...]")
+	strServer := fs.String("server", "", "goc server 地址, 例如 goc-server:7777")
+	strServices := fs.String("service", "", "只拉取这些服务, 逗号分隔, 为空拉取所有注册的服务")
+	strHash := fs.String("hash", "", "拉取的覆盖率对应的 git hash")
+	strHashes := fs.String("hashes", "", "按服务指定 git hash, 例如 user-api=e24dac6,order-api=0b57328, 未指定的服务使用 -hash")
+	strPush := fs.String("push", "", "把合并后的覆盖率文件 POST 到该 URL(为空不推送)")
+	ShareGlobalFlags(fs)
+	fs.Parse(args)
+	if *strServer == "" {
+		fs.Usage()
+		return fmt.Errorf("Error: -server required.")
+	}
+
+	hashes, err := ParseTags(*strHashes)
+	if err != nil {
+		return err
+	}
+	var services []string
+	if *strServices != "" {
+		services = strings.Split(*strServices, ",")
+	} else {
+		registered, err := ListGocServices(*strServer)
+		if err != nil {
+			return err
+		}
+		for service := range registered {
+			services = append(services, service)
+		}
+		sort.Strings(services)
+	}
+	if len(services) == 0 {
+		return fmt.Errorf("no service registered on goc server %s", *strServer)
+	}
+
+	fileInfos, err := ParseCoverFileInfos(fs.Args())
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now().Unix()
+	for _, service := range services {
+		gitHash := hashes[service]
+		if gitHash == "" {
+			gitHash = *strHash
+		}
+		if gitHash == "" {
+			return fmt.Errorf("git hash of service %s required, use -hash or -hashes", service)
+		}
+		query := url.Values{"service": {service}}
+		data, err := FetchGocProfile(*strServer, query)
+		if err != nil {
+			return err
+		}
+		query.Set("hash", gitHash)
+		fileInfos = append(fileInfos, &CoverFileInfo{
+			Timestamp: timestamp,
+			GitHash:   gitHash,
+			FileName:  gocScheme + *strServer + "?" + query.Encode(),
+			Reader:    bytes.NewReader(data),
+			Tags:      map[string]string{"service": service},
+		})
+		fmt.Println("pull ", service, " from ", *strServer, " ok.")
+	}
+
+	if err := Merge(fileInfos); err != nil {
+		return err
+	}
+	fmt.Println("generate ", *g_strOutCoverFile, " and ", *g_strOutHTMLFile, " ok.")
+
+	if *strPush != "" {
+		if err := PushProfile(*strPush, *g_strOutCoverFile); err != nil {
+			return err
+		}
+		fmt.Println("push ", *g_strOutCoverFile, " to ", Redact(*strPush), " ok.")
+	}
+	return nil
+}
+
+// 把覆盖率文件以 text/plain POST 到指定 URL
+func PushProfile(strURL string, fileName string) error {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, strURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	if err := AuthorizeRequest(req, "http"); err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: gocTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to push %s: %v", fileName, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to push %s: %s: %s", fileName, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func gocRequest(method string, host string, path string, body io.Reader) ([]byte, error) {
+	req, err := http.NewRequest(method, "http://"+host+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if err := AuthorizeRequest(req, "goc"); err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: gocTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("goc server %s: %v", host, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("goc server %s: %v", host, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("goc server %s: %s %s: %s", host, method, path, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
@@ -3,61 +3,480 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	_ "embed"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 	"golang.org/x/tools/cover"
 )
 
 var (
-	g_strOutCoverFile = flag.String("outcover", "cover.txt", "输出覆盖率文件")
-	g_strOutHTMLFile  = flag.String("outhtml", "cover.html", "输出覆盖率HTML文件")
+	g_strOutCoverFile   = flag.String("outcover", "cover.txt", "输出覆盖率文件")
+	g_strOutHTMLFile    = flag.String("outhtml", "cover.html", "输出覆盖率HTML文件")
+	g_bMmap             = flag.Bool("mmap", false, "使用 mmap 读取覆盖率文件(适合重新合并数 GB 的合并结果)")
+	g_strTags           = flag.String("tags", "", "本次合并的运行标签, 例如 env=prod,suite=e2e, 写入 Parquet 等导出结果")
+	g_strOutParquet     = flag.String("outparquet", "", "输出块级覆盖率 Parquet 文件(为空不输出)")
+	g_strStdinName      = flag.String("stdin-name", "", "输入为 - 时从标准输入读取, 用该名称(如 cover.txt.1723042827.e24dac6)提供时间戳和 git hash, 内容开头有版本注释时可以不指定")
+	g_bCompress         = flag.Bool("compress", false, "输出压缩的覆盖率文件, 格式见 -compress-format(读取时自动识别 gzip 和 zstd 输入)")
+	g_strInputList      = flag.String("input-list", "", "输入清单文件, 每行一个输入, 可以带时间戳和 git hash 两列: path [timestamp githash]")
+	g_bFunc             = flag.Bool("func", false, "合并后按函数打印覆盖率和总覆盖率(类似 go tool cover -func), 函数取自各版本的源码")
+	g_strFormat         = flag.String("format", "go", "输出覆盖率文件的格式: go(覆盖率文件名带 git hash) 或 sonarqube(SonarQube 通用测试覆盖率 XML, 每个文件取最新版本)")
+	g_strRequireVersion = flag.String("require-version", "", "要求的 gocovmerge 版本: 1.3.0(必须相同) 或 >=1.3.0, 不满足时退出(也可以用 GOCOVMERGE_REQUIRE_VERSION 对所有子命令生效)")
+	g_fSampleFraction   = flag.Float64("sample-fraction", 0, "输入只是线上实例的抽样时的抽样比例(0~1), 用于估计全量覆盖率的置信区间")
 )
 
+// 子命令: 名称 -> 处理函数, 参数为子命令之后的命令行参数
+var g_mapSubCommands = map[string]func(args []string) error{
+	"merge-partial": runMergePartial,
+	"merge-final":   runMergeFinal,
+	"inspect":       runInspect,
+	"import":        runImport,
+	"backfill":      runBackfill,
+	"query":         runQuery,
+	"export":        runExport,
+	"stale":         runStale,
+	"runs":          runRuns,
+	"rebuild":       runRebuild,
+	"goc-sync":      runGocSync,
+	"annotate-diff": runAnnotateDiff,
+	"hook":          runHook,
+	"trends":        runTrends,
+	"version":       runVersion,
+	"self-update":   runSelfUpdate,
+	"status-serve":  runStatusServe,
+	"scenarios":     runScenarios,
+	"override":      runOverride,
+	"clean-sources": runCleanSources,
+	"selftest":      runSelftest,
+}
+
 func main() {
 	// 自定义帮助信息
 	flag.Usage = func() {
-		fmt.Println("Usage: ./bin/gocovmerge [options] [cover.txt.timestamp.hash cover.txt.1723042827.e24dac6 ...]")
+		fmt.Println("Usage: ./bin/gocovmerge [options] [-input-list manifest.txt] [cover.txt.timestamp.hash cover.txt.1723042827.e24dac6 artifacts/ 'runs/**/cover.txt.*.*' covdata.1723042827.e24dac6/ artifacts.tar.gz https://host/cover.txt.1723042827.e24dac6 s3://bucket/runs/ goc://host:7777?hash=e24dac6 ...]")
+		fmt.Println("       ./bin/gocovmerge merge-partial [-o cover.partial] [-format text|bin] [-compress gzip|zstd] [cover.txt.timestamp.hash ...]")
+		fmt.Println("       ./bin/gocovmerge merge-final [options] [cover.partial ...]")
+		fmt.Println("       ./bin/gocovmerge inspect cover.partial [githash file]")
+		fmt.Println("       ./bin/gocovmerge import [-db cover.db] [-tags k=v,...] [cover.txt.timestamp.hash ...]")
+		fmt.Println("       ./bin/gocovmerge backfill [-db cover.db] [-include cover.txt*] [-name-pattern re] [-hash h -timestamp t] dir/ ...")
+		fmt.Println("       ./bin/gocovmerge query [-db cover.db] \"SELECT ...\"")
+		fmt.Println("       ./bin/gocovmerge runs list|show [options]")
+		fmt.Println("       ./bin/gocovmerge rebuild [-db cover.db] [-hash githash] [-tags k=v,...] [-since 7d] [-until 2006-01-02] [options]")
+		fmt.Println("       ./bin/gocovmerge stale [-db cover.db] [-older 30d] [-file name]")
+		fmt.Println("       ./bin/gocovmerge goc-sync -server host:7777 [-service a,b] [-hash githash] [-hashes svc=githash,...] [-push URL] [options] [cover.txt.timestamp.hash ...]")
+		fmt.Println("       ./bin/gocovmerge annotate-diff [-cover cover.txt] base..head")
+		fmt.Println("       ./bin/gocovmerge hook install|run [-type pre-push|pre-commit] [-base ref] [-min 80]")
+		fmt.Println("       ./bin/gocovmerge trends [-db cover.db] [-tags k=v,...] [-goal goal.json] [-o trends.html]")
+		fmt.Println("       ./bin/gocovmerge export [-to clickhouse|bigquery|jsonl] [-table t] [-blocks] [cover.txt.timestamp.hash ...]")
+		fmt.Println("       ./bin/gocovmerge status-serve [-addr :8080] [-file status.json] [-db cover.db -approvers approvers.txt]")
+		fmt.Println("       ./bin/gocovmerge scenarios -log scenarios.txt [-o report.csv] [cover.txt.timestamp.hash ...]")
+		fmt.Println("       ./bin/gocovmerge override approve|list|audit [-db cover.db] [-reason text] [githash]")
+		fmt.Println("       ./bin/gocovmerge clean-sources [-n] [-root go/src]")
+		fmt.Println("       ./bin/gocovmerge selftest [-keep] [-run name]")
+		fmt.Println("       ./bin/gocovmerge version")
+		fmt.Println("       ./bin/gocovmerge self-update [-url https://releases.example.com/gocovmerge] [-version 1.3.0] [-check]")
 		fmt.Println("Options:")
 		flag.PrintDefaults() // 打印默认的参数帮助信息
 	}
 
+	// 子命令
+	if len(os.Args) > 1 {
+		if subCommand, ok := g_mapSubCommands[os.Args[1]]; ok {
+			// self-update 和 version 用于解决版本不满足要求的问题, 不检查
+			if os.Args[1] != "self-update" && os.Args[1] != "version" {
+				if err := CheckRequiredVersion(os.Getenv(requireVersionEnv)); err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+			}
+			if err := subCommand(os.Args[2:]); err != nil {
+				fmt.Println(Redact(err.Error()))
+				printSummaryLine()
+				os.Exit(1)
+			}
+			printSummaryLine()
+			return
+		}
+	}
+
 	flag.Parse()
+	for _, required := range []string{os.Getenv(requireVersionEnv), *g_strRequireVersion} {
+		if err := CheckRequiredVersion(required); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	if err := SetupRepo(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := SetupSourcePaths(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 	coverFiles := flag.Args()
-	if len(coverFiles) == 0 {
+	if len(coverFiles) == 0 && *g_strInputList == "" {
 		fmt.Println("Error: cover.txt.xxx.xxx file required.")
 		flag.Usage()
 		os.Exit(1)
 	}
 
 	if err := run(coverFiles); err != nil {
-		fmt.Println(err)
+		fmt.Println(Redact(err.Error()))
+		printSummaryLine()
 		os.Exit(1)
 	}
 
 	fmt.Println("generate ", *g_strOutCoverFile, " and ", *g_strOutHTMLFile, " ok.")
+	printSummaryLine()
+}
+
+// 合并完成(包括低于 -min-coverage)时, 按 -summary-line 最后打印结果行
+func printSummaryLine() {
+	if *g_bSummaryLine && g_mergeSummary != nil {
+		fmt.Println(g_mergeSummary.Line())
+	}
 }
 
 func run(coverFiles []string) error {
-	mapCoverFiles := make(map[string][]*CoverFileInfo) // githas -> file -> info
-	for _, file := range coverFiles {
-		fileInfo, err := ParseCoverFileInfo(file)
+	// -op subtract 的第一个输入是基线
+	var baselineInfos []*CoverFileInfo
+	if *g_strOp == "subtract" {
+		if len(coverFiles) == 0 {
+			return fmt.Errorf("Error: -op subtract requires a baseline as the first input.")
+		}
+		var err error
+		if baselineInfos, err = ParseCoverFileInfos(coverFiles[:1]); err != nil {
+			return err
+		}
+		coverFiles = coverFiles[1:]
+	}
+	// 读取内容之前先检查展开后的输入, 压缩包, URL 等读入内存后在 CheckInputGuardrails 中再检查
+	inputs, err := ExpandCoverInputs(coverFiles)
+	if err != nil {
+		return err
+	}
+	g_bGuardrails = true
+	if err := CheckExpandedGuardrails(inputs); err != nil {
+		return err
+	}
+	fileInfos, err := ParseCoverInputs(inputs)
+	if err != nil {
+		return err
+	}
+	if *g_strInputList != "" {
+		listInfos, err := ReadInputList(*g_strInputList)
+		if err != nil {
+			return err
+		}
+		fileInfos = append(fileInfos, listInfos...)
+	}
+	if baselineInfos != nil {
+		if len(fileInfos) == 0 {
+			return fmt.Errorf("Error: -op subtract requires inputs after the baseline.")
+		}
+		// 基线和其他输入的版本按 git hash 对应
+		if err := ResolveVersionRefs(append(append([]*CoverFileInfo(nil), baselineInfos...), fileInfos...)); err != nil {
+			return err
+		}
+		if fileInfos, err = PrepareSubtract(baselineInfos, fileInfos); err != nil {
+			return err
+		}
+	}
+	return Merge(fileInfos)
+}
+
+// 完整的合并流程: 按版本合并, 跨版本合并, 输出覆盖率文件和 HTML 报告
+func Merge(fileInfos []*CoverFileInfo) error {
+	if *g_strFormat != "go" && *g_strFormat != "sonarqube" {
+		return fmt.Errorf("unsupported format '%s'", *g_strFormat)
+	}
+	switch {
+	case *g_strOp != "merge" && *g_strOp != "subtract":
+		return fmt.Errorf("unsupported op '%s'", *g_strOp)
+	case *g_strOp == "subtract" && g_baselineByHash == nil:
+		return fmt.Errorf("-op subtract is only supported by the merge command")
+	}
+	if err := checkOverlap(*g_strOverlap); err != nil {
+		return err
+	}
+	if err := checkCompressFormat(*g_strCompressFormat); err != nil {
+		return err
+	}
+	if err := checkCompare(*g_strCompare); err != nil {
+		return err
+	}
+	if err := checkOrphans(*g_strOrphans); err != nil {
+		return err
+	}
+	if err := checkWorktreeSafety(*g_strWorktreeSafety); err != nil {
+		return err
+	}
+	if *g_strOutComment != "" {
+		if err := checkCommentTemplate(*g_strCommentTemplate); err != nil {
+			return err
+		}
+	}
+	if err := ResolveVersionRefs(fileInfos); err != nil {
+		return err
+	}
+	if err := CheckInputGuardrails(fileInfos); err != nil {
+		return err
+	}
+	if *g_strGroups != "" {
+		groups, err := LoadPackageGroups(*g_strGroups)
 		if err != nil {
-			return fmt.Errorf("failed to parse version profiles: %v", err)
+			return err
+		}
+		g_packageGroups = groups
+	}
+	if *g_strLuaCov != "" {
+		scripts, err := LoadLuaScripts(*g_strLuaCov, *g_strLuaPathMap, *g_strLuaRoot)
+		if err != nil {
+			return err
+		}
+		g_luaScripts = scripts
+	}
+	if *g_strSplitByTag != "" {
+		if err := WriteSplitByTag(fileInfos, *g_strSplitByTag, *g_strSplitTagKey); err != nil {
+			return err
+		}
+	}
+	if *g_strHTMLSuites != "" {
+		suites, err := MergeByTag(fileInfos, *g_strHTMLSuites)
+		if err != nil {
+			return err
 		}
-		if _, ok := mapCoverFiles[fileInfo.GitHash]; !ok {
-			mapCoverFiles[fileInfo.GitHash] = make([]*CoverFileInfo, 0)
+		g_htmlSuites = suites
+	}
+	mergedCoverFiles, err := MergeByGitHash(fileInfos)
+	if err != nil {
+		return err
+	}
+	return MergeVersions(mergedCoverFiles)
+}
+
+// 解析所有输入文件名中的版本信息
+// 输入可以用 #k=v,k=v 后缀指定该输入的标签, 例如 e2e/cover.txt.1723042827.e24dac6#suite=e2e,
+// 用 :<权重> 后缀缩放该输入的计数, 例如 load/cover.txt.1723042827.e24dac6:0.1.
+// 目录, 通配符和压缩包展开后的每个文件都带有该标签和权重
+func ParseCoverFileInfos(coverFiles []string) ([]*CoverFileInfo, error) {
+	inputs, err := ExpandCoverInputs(coverFiles)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCoverInputs(inputs)
+}
+
+// 展开后的一个输入(glob 和目录已展开), 带有输入参数上的标签和权重
+type coverInput struct {
+	file   string
+	tags   map[string]string
+	weight float64
+}
+
+// 展开输入参数, 不读取内容, 合并前可以先检查输入的个数和大小
+func ExpandCoverInputs(coverFiles []string) ([]coverInput, error) {
+	var inputs []coverInput
+	for _, input := range coverFiles {
+		input, tags, err := SplitInputTags(input)
+		if err != nil {
+			return nil, err
+		}
+		input, weight, err := SplitInputWeight(input)
+		if err != nil {
+			return nil, err
+		}
+		files, err := ExpandInputs([]string{input})
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			inputs = append(inputs, coverInput{file: file, tags: tags, weight: weight})
+		}
+	}
+	return inputs, nil
+}
+
+// 解析展开后的输入
+func ParseCoverInputs(inputs []coverInput) ([]*CoverFileInfo, error) {
+	if *g_strNamePattern != "" {
+		if _, err := namePattern(); err != nil {
+			return nil, err
+		}
+	}
+	fileInfos := make([]*CoverFileInfo, 0, len(inputs))
+	bStdin := false
+	for _, input := range inputs {
+		// - 表示从标准输入读取, 版本信息来自 -stdin-name 或内容开头的注释
+		if input.file == "-" {
+			if bStdin {
+				return nil, fmt.Errorf("stdin can only be used once as input")
+			}
+			bStdin = true
 		}
+		infos, err := parseCoverInput(input.file)
+		if err != nil {
+			return nil, err
+		}
+		for _, fileInfo := range infos {
+			if input.tags != nil {
+				fileInfo.Tags = MergeTags(fileInfo.Tags, input.tags)
+			}
+			if input.weight != 1 {
+				fileInfo.Weight = input.weight
+			}
+		}
+		fileInfos = append(fileInfos, infos...)
+	}
+	return fileInfos, nil
+}
+
+// 解析一个输入, 压缩包等输入会得到多个 CoverFileInfo
+func parseCoverInput(file string) ([]*CoverFileInfo, error) {
+	if file == "-" {
+		name := *g_strStdinName
+		if name == "" {
+			name = "-"
+		}
+		fileInfo, err := ParseCoverFileInfoFrom(name, os.Stdin)
+		if err != nil && *g_strStdinName == "" {
+			return nil, fmt.Errorf("-stdin-name or a # timestamp:/# githash: header required when reading a profile from stdin")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse version profiles: %v", err)
+		}
+		return []*CoverFileInfo{fileInfo}, nil
+	}
+	if IsGocInput(file) {
+		fileInfo, err := ParseGocInput(file)
+		if err != nil {
+			return nil, err
+		}
+		return []*CoverFileInfo{fileInfo}, nil
+	}
+	if IsHTTPInput(file) {
+		return ParseHTTPInput(file)
+	}
+	if IsObjectURL(file) {
+		return ParseObjectInput(file)
+	}
+	if IsArchive(file) {
+		return ParseArchiveInputs(file)
+	}
+	if fileInfo, ok, err := ReadSidecar(file); err != nil {
+		return nil, err
+	} else if ok {
+		return []*CoverFileInfo{fileInfo}, nil
+	}
+	fileInfo, err := ParseCoverFileInfo(file)
+	if err != nil {
+		// 文件名不带版本时使用文件开头的注释
+		if headerInfo, headerErr := ReadHeaderFileInfo(file); headerErr == nil && headerInfo != nil {
+			return []*CoverFileInfo{headerInfo}, nil
+		}
+		return nil, fmt.Errorf("failed to parse version profiles: %v", err)
+	}
+	return []*CoverFileInfo{fileInfo}, nil
+}
+
+// 读取输入清单: 每行一个输入(与命令行参数相同, 可以带 #k=v 标签), 空行和 # 开头的行忽略.
+// 行中带时间戳和 git hash 两列时使用这两列作为版本信息, 文件名不需要带版本
+func ReadInputList(fileName string) ([]*CoverFileInfo, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var fileInfos []*CoverFileInfo
+	s := bufio.NewScanner(f)
+	for lineNo := 1; s.Scan(); lineNo++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		switch len(fields) {
+		case 1:
+			infos, err := ParseCoverFileInfos(fields)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", fileName, lineNo, err)
+			}
+			fileInfos = append(fileInfos, infos...)
+		case 3:
+			input, tags, err := SplitInputTags(fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", fileName, lineNo, err)
+			}
+			input, weight, err := SplitInputWeight(input)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", fileName, lineNo, err)
+			}
+			timestamp, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: timestamp is not valid", fileName, lineNo)
+			}
+			fileInfos = append(fileInfos, &CoverFileInfo{
+				Timestamp: timestamp,
+				GitHash:   fields[2],
+				FileName:  input,
+				Tags:      tags,
+				Weight:    weight,
+			})
+		default:
+			return nil, fmt.Errorf("%s:%d: expected path or path timestamp githash", fileName, lineNo)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return fileInfos, nil
+}
+
+// 拆分输入末尾的 #k=v,k=v 标签, 没有标签时 tags 为 nil
+func SplitInputTags(input string) (string, map[string]string, error) {
+	i := strings.LastIndex(input, "#")
+	if i < 0 || !strings.Contains(input[i+1:], "=") {
+		return input, nil, nil
+	}
+	tags, err := ParseTags(input[i+1:])
+	if err != nil {
+		return "", nil, fmt.Errorf("input %s: %v", input, err)
+	}
+	return input[:i], tags, nil
+}
+
+// 按 git hash 分组合并覆盖率, 返回按时间排序的每个版本的合并结果
+// 已经带有 Profiles 的输入(例如中间文件)直接参与合并, 否则从 Reader 或 FileName 解析
+func MergeByGitHash(fileInfos []*CoverFileInfo) ([]*CoverFileInfo, error) {
+	fraction, err := ResolveSampleFraction(fileInfos)
+	if err != nil {
+		return nil, err
+	}
+	observe, err := sampleObserver(fraction)
+	if err != nil {
+		return nil, err
+	}
+	return mergeByGitHash(fileInfos, observe)
+}
+
+// observe 不为空时, 合并前对每个输入调用一次(用于抽样估计)
+func mergeByGitHash(fileInfos []*CoverFileInfo, observe func(gitHash string, profiles []*cover.Profile)) ([]*CoverFileInfo, error) {
+	mapCoverFiles := make(map[string][]*CoverFileInfo) // githas -> file -> info
+	for _, fileInfo := range fileInfos {
 		mapCoverFiles[fileInfo.GitHash] = append(mapCoverFiles[fileInfo.GitHash], fileInfo)
 	}
 
@@ -69,17 +488,52 @@ func run(coverFiles []string) error {
 	}
 
 	var mergedCoverFiles []*CoverFileInfo
+	var modes ModeChecker
+	var stmts NumStmtChecker
 	for gitHash, coverFiles := range mapCoverFiles {
 		var merged []*cover.Profile
+		nSkipped := 0
+		duplicates := make(DuplicateChecker)
 		for _, coverFile := range coverFiles {
-			profiles, err := cover.ParseProfiles(coverFile.FileName)
+			if IsSkippedInput(coverFile) {
+				nSkipped++
+				continue
+			}
+			profiles, err := coverFile.ReadProfiles()
 			if err != nil {
-				return fmt.Errorf("failed to parse profiles: %v", err)
+				err = fmt.Errorf("failed to parse profiles %s: %v", coverFile.FileName, err)
+				if !*g_bSkipInvalid {
+					return nil, err
+				}
+				SkipInvalidInput(coverFile, err)
+				nSkipped++
+				continue
+			}
+			if err := modes.Check(coverFile.FileName, profiles); err != nil {
+				return nil, err
+			}
+			stmts.Check(gitHash, coverFile.FileName, profiles)
+			bDuplicate, err := duplicates.Check(coverFile, profiles)
+			if err != nil {
+				return nil, err
+			}
+			if bDuplicate {
+				continue
+			}
+			ApplyWeight(profiles, coverFile.Weight)
+			if observe != nil {
+				observe(gitHash, profiles)
 			}
 			for _, p := range profiles {
-				merged = AddProfile(merged, p)
+				if merged, err = AddProfileChecked(merged, p); err != nil {
+					return nil, fmt.Errorf("%v in %s, use -overlap to resolve overlapping blocks", err, coverFile.FileName)
+				}
 			}
 		}
+		// 该版本的输入都跳过了
+		if nSkipped == len(coverFiles) {
+			continue
+		}
 		fileInfo := &CoverFileInfo{
 			GitHash:   gitHash,
 			Timestamp: coverFiles[0].Timestamp,
@@ -88,114 +542,417 @@ func run(coverFiles []string) error {
 		}
 		mergedCoverFiles = append(mergedCoverFiles, fileInfo)
 	}
+	if err := stmts.Err(); err != nil {
+		return nil, err
+	}
 
 	// 遍历 mergedCoverFiles 并按时间排序
 	sort.Slice(mergedCoverFiles, func(i, j int) bool {
 		return mergedCoverFiles[i].Timestamp < mergedCoverFiles[j].Timestamp
 	})
+	return mergedCoverFiles, nil
+}
+
+// 跨版本合并: 文件内容相同的合并到较早的版本, 不同的按版本分开, 然后输出覆盖率文件和 HTML 报告
+func MergeVersions(mergedCoverFiles []*CoverFileInfo) error {
+	latest := LatestVersion(mergedCoverFiles)
+	mergedByHash := MergeAcrossVersions(mergedCoverFiles)
+	if g_baselineByHash != nil {
+		SubtractBaseline(mergedByHash, g_baselineByHash)
+	}
+	timestamps := make(map[string]int64)
+	for _, coverFile := range mergedCoverFiles {
+		timestamps[coverFile.GitHash] = coverFile.Timestamp
+	}
+	if *g_bRemapLines {
+		RemapLines(mergedByHash, timestamps)
+	}
+	HandleDeletedFiles(mergedByHash, latest.GitHash)
+
+	if *g_strOutParquet != "" {
+		tags, err := ParseTags(*g_strTags)
+		if err != nil {
+			return err
+		}
+		if err := WriteParquet(*g_strOutParquet, mergedByHash, timestamps, FormatTags(tags)); err != nil {
+			return err
+		}
+	}
+
+	if *g_strOutLines != "" {
+		if err := WriteLines(*g_strOutLines, mergedByHash); err != nil {
+			return err
+		}
+	}
+
+	if *g_strOutCSV != "" {
+		if err := WriteCSV(*g_strOutCSV, mergedByHash); err != nil {
+			return err
+		}
+	}
+
+	if *g_strSplitByHash != "" {
+		if err := WriteSplitByHash(*g_strSplitByHash, mergedByHash, timestamps); err != nil {
+			return err
+		}
+	}
 
-	// 根据版本号对比文件内容，相同的合并，不同的分开文件
+	// 导出各版本的源码, 供生成 HTML 报告
+	if root := defaultSourceRoot(); root != "" {
+		if _, err := CleanOrphanSources(root, *g_strOrphans); err != nil {
+			return err
+		}
+	}
+	cleanupSourceRoot, err := PrepareSourceRoot()
+	if err != nil {
+		return err
+	}
+	defer cleanupSourceRoot()
+	delFiles, err := SaveVersionSources(mergedByHash)
+	defer func() { DeleteFiles(delFiles) }()
+	if err != nil {
+		return err
+	}
+	// RenameByHash 会修改文件名, 先取出各文件的最新版本
+	latestProfiles, latestHashes := LatestVersionProfiles(mergedByHash, timestamps)
+	merged := RenameByHash(mergedByHash)
+	if *g_strOutCovData != "" {
+		if err := WriteCoverDataDir(*g_strOutCovData, merged); err != nil {
+			return err
+		}
+	}
+	if err := WriteReport(merged, latestProfiles, latestHashes); err != nil {
+		return err
+	}
+	if *g_strOutRPC != "" {
+		if err := WriteRPCCoverage(*g_strOutRPC, latestProfiles, latestHashes); err != nil {
+			return err
+		}
+	}
+	if *g_strOutTestStubs != "" {
+		if err := WriteTestStubs(*g_strOutTestStubs, latestProfiles, latestHashes); err != nil {
+			return err
+		}
+	}
+	if *g_strOutQuickfix != "" {
+		if err := WriteQuickfix(*g_strOutQuickfix, latestProfiles); err != nil {
+			return err
+		}
+	}
+	if *g_strOutRoutes != "" {
+		if err := WriteRouteCoverage(*g_strOutRoutes, latestProfiles, latestHashes); err != nil {
+			return err
+		}
+	}
+	if *g_strCoveralls != "" || *g_bCoverallsUpload {
+		if err := WriteCoveralls(latestProfiles, latestHashes, latest); err != nil {
+			return err
+		}
+	}
+	if *g_strOutJUnit != "" {
+		if err := WriteJUnit(*g_strOutJUnit, latestProfiles); err != nil {
+			return err
+		}
+	}
+	if *g_strOutTreemap != "" {
+		if err := WriteTreemap(*g_strOutTreemap, latestProfiles); err != nil {
+			return err
+		}
+	}
+	if *g_strOutCombined != "" {
+		if err := WriteCombinedReport(*g_strOutCombined, latestProfiles); err != nil {
+			return err
+		}
+	}
+	if *g_strCodecov != "" || *g_bCodecovUpload {
+		if err := WriteCodecov(latestProfiles, latest); err != nil {
+			return err
+		}
+	}
+
+	// 输出可以是对象存储 URL, 先写到本地再上传
+	outCoverFile, uploadCover, err := LocalOutput(*g_strOutCoverFile)
+	if err != nil {
+		return err
+	}
+	outHTMLFile, uploadHTML, err := LocalOutput(*g_strOutHTMLFile)
+	if err != nil {
+		return err
+	}
+	if *g_strFormat == "sonarqube" {
+		err = WriteSonarQubeFile(outCoverFile, latestProfiles)
+	} else {
+		err = WriteProfileFile(outCoverFile, merged, latest)
+	}
+	if err != nil {
+		return err
+	}
+	if *g_strSplitByPkg != "" {
+		if err := WriteSplitByPackage(*g_strSplitByPkg, merged, latest); err != nil {
+			return err
+		}
+	}
+	PrintSampleSummary(merged)
+	PrintSaturatedCounts()
+	PrintSkippedInputs()
+	if *g_strBadge != "" {
+		if err := WriteBadge(*g_strBadge, merged); err != nil {
+			return err
+		}
+	}
+	if *g_strOutStatus != "" {
+		if err := WriteCoverageStatus(*g_strOutStatus, merged, latest); err != nil {
+			return err
+		}
+	}
+	if g_packageGroups != nil {
+		if err := g_packageGroups.PrintSummary(os.Stdout, merged); err != nil {
+			return err
+		}
+	}
+	if g_luaScripts != nil {
+		PrintLuaSummary(g_luaScripts)
+	}
+	if *g_bFunc {
+		if err := PrintFuncSummary(os.Stdout, merged); err != nil {
+			return err
+		}
+	}
+	if err := GenerateCoverHTML(merged, outHTMLFile); err != nil {
+		return err
+	}
+	if err := uploadCover(); err != nil {
+		return err
+	}
+	if err := uploadHTML(); err != nil {
+		return err
+	}
+	g_mergeSummary = SummarizeMerge(merged, latestProfiles)
+	if err := ApplyGateOverride(g_mergeSummary, latest.GitHash); err != nil {
+		return err
+	}
+	if *g_strOutPolicy != "" {
+		if err := WritePolicyReport(*g_strOutPolicy, g_mergeSummary, latestProfiles); err != nil {
+			return err
+		}
+	}
+	if *g_strOutComment != "" {
+		if err := WriteComment(*g_strOutComment, latest.GitHash, g_mergeSummary, latestProfiles); err != nil {
+			return err
+		}
+	}
+	return g_mergeSummary.Check()
+}
+
+// 根据版本号对比文件内容，相同的合并到较早的版本，不同的分开, 返回 git hash -> 该版本的覆盖率.
+// 每个文件按 blob hash 把内容相同的版本分为一组(-compare gofmt 或 ast 时等价的版本也归入已有的组),
+// 每组合并到组内最早的版本, 与输入顺序无关. -follow-renames 时重命名过的文件按最新版本的路径分组,
+// 组内有最新路径的版本时合并到其中最早的
+func MergeAcrossVersions(mergedCoverFiles []*CoverFileInfo) map[string][]*cover.Profile {
+	type versionGroup struct {
+		gitHash  string // 合并到的版本
+		fileName string // 文件在合并到的版本中的路径
+		blob     string
+		origin   string // 创建组的版本, -compare 的块位置以它为准
+	}
+	groups := make(map[string][]*versionGroup)          // 最新路径 -> 按创建顺序的组
+	byBlob := make(map[string]map[string]*versionGroup) // 最新路径 -> blob hash -> 组
+
+	// 按时间排序, 时间相同时按 git hash, 保证结果确定
+	coverFiles := append([]*CoverFileInfo(nil), mergedCoverFiles...)
+	sort.SliceStable(coverFiles, func(i, j int) bool {
+		if coverFiles[i].Timestamp != coverFiles[j].Timestamp {
+			return coverFiles[i].Timestamp < coverFiles[j].Timestamp
+		}
+		return coverFiles[i].GitHash < coverFiles[j].GitHash
+	})
+	PrefetchTrees(coverFiles)
+	var renamesByHash map[string]map[string]string
+	if *g_bFollowRenames && len(coverFiles) > 1 {
+		renamesByHash = FollowRenames(coverFiles)
+	}
 	mergedByHash := make(map[string][]*cover.Profile)
-	// 双层循环比较 i 和 j (i < j)
-	for i := 0; i < len(mergedCoverFiles); i++ {
-		currentCoverFile := mergedCoverFiles[i]
-		for _, p := range currentCoverFile.Profiles {
-			mergedByHash[currentCoverFile.GitHash] = AddProfile(mergedByHash[currentCoverFile.GitHash], p)
-		}
-		for j := i + 1; j < len(mergedCoverFiles); j++ {
-			nextCoverFile := mergedCoverFiles[j]
-			var newProfiles []*cover.Profile
-			for _, p := range nextCoverFile.Profiles {
-				filePath := fmt.Sprintf("go/src/%s", p.FileName)
-				bSame, _ := CompareVersions(currentCoverFile.GitHash, nextCoverFile.GitHash, filePath)
-				if bSame {
-					mergedByHash[currentCoverFile.GitHash] = AddProfile(mergedByHash[currentCoverFile.GitHash], p)
+	for _, coverFile := range coverFiles {
+		for _, p := range coverFile.Profiles {
+			fileName := p.FileName
+			if newName, ok := renamesByHash[coverFile.GitHash][fileName]; ok {
+				fileName = newName
+			}
+			filePath := RepoPath(p.FileName)
+			if byBlob[fileName] == nil {
+				byBlob[fileName] = make(map[string]*versionGroup)
+			}
+			// 取不到 blob hash(如文件不在该版本中)时单独成组
+			blob, err := GitBlobHash(coverFile.GitHash, filePath)
+			var group *versionGroup
+			if err == nil {
+				group = byBlob[fileName][blob]
+			}
+			if group != nil && group.blob != blob {
+				// 通过 -compare 归入的组, 块位置对应到创建组的版本
+				remapped, ok := RemapEquivalentProfile(group.origin, coverFile.GitHash, filePath, p)
+				if ok {
+					p = remapped
 				} else {
-					newProfiles = append(newProfiles, p)
+					group = nil
 				}
 			}
-			mergedCoverFiles[j] = &CoverFileInfo{
-				GitHash:   nextCoverFile.GitHash,
-				Timestamp: nextCoverFile.Timestamp,
-				FileName:  "",
-				Profiles:  newProfiles,
+			if group == nil && err == nil && *g_strCompare != "bytes" {
+				for _, candidate := range groups[fileName] {
+					if _, err := GitBlobHash(candidate.origin, filePath); err != nil {
+						continue // 重命名前后的版本只按 blob hash 合并
+					}
+					if remapped, ok := RemapEquivalentProfile(candidate.origin, coverFile.GitHash, filePath, p); ok {
+						group, p = candidate, remapped
+						byBlob[fileName][blob] = candidate
+						break
+					}
+				}
 			}
+			if group == nil {
+				group = &versionGroup{gitHash: coverFile.GitHash, fileName: p.FileName, blob: blob, origin: coverFile.GitHash}
+				groups[fileName] = append(groups[fileName], group)
+				if err == nil {
+					byBlob[fileName][blob] = group
+				}
+			}
+			if group.fileName != fileName && p.FileName == fileName && group.blob == blob {
+				// 内容相同的版本有了最新的路径, 已经合并的覆盖率改为合并到这个版本
+				if moved := takeProfile(mergedByHash, group.gitHash, group.fileName); moved != nil {
+					moved.FileName = fileName
+					mergedByHash[coverFile.GitHash] = AddProfile(mergedByHash[coverFile.GitHash], moved)
+				}
+				group.gitHash, group.fileName = coverFile.GitHash, fileName
+			}
+			if p.FileName != group.fileName {
+				renamed := *p
+				renamed.FileName = group.fileName
+				p = &renamed
+			}
+			mergedByHash[group.gitHash] = AddProfile(mergedByHash[group.gitHash], p)
 		}
 	}
+	return mergedByHash
+}
 
-	// 给文件名加上 git hash, 再合并
-	var merged []*cover.Profile
+// 把每个版本的源码导出为 go/src/<file>.<githash>(或 -worktree-safety 的临时目录), 按 -git-jobs 并发执行 git show,
+// 返回要删除的导出文件和新建的目录, 目录在文件之后, 由深到浅(出错时也返回, 以便删除已导出的部分).
+// 模块的工作区中没有 go/src, 删除后不留下未跟踪的目录
+func SaveVersionSources(mergedByHash map[string][]*cover.Profile) ([]string, error) {
+	type saveJob struct {
+		gitHash, fileName string
+	}
+	var jobs []saveJob
 	delFiles := make([]string, 0)
 	for gitHash, profiles := range mergedByHash {
 		for _, p := range profiles {
-			filePath := fmt.Sprintf("go/src/%s", p.FileName)
-			outputPath := fmt.Sprintf("go/src/%s.%s", p.FileName, gitHash)
-			delFiles = append(delFiles, outputPath)
-			err := GitSaveFile(gitHash, filePath, outputPath)
-			if err != nil {
-				return err
-			}
-			p.FileName = fmt.Sprintf("%s.%s", p.FileName, gitHash)
+			jobs = append(jobs, saveJob{gitHash, p.FileName})
+			delFiles = append(delFiles, VersionedSourcePath(p.FileName, gitHash))
+		}
+	}
+	createdDirs := missingDirs(delFiles)
+	err := RunGitJobs(len(jobs), func(i int) error {
+		return saveVersionSource(jobs[i].gitHash, jobs[i].fileName, delFiles[i])
+	})
+	return append(delFiles, createdDirs...), err
+}
 
-			// 合并
-			for _, p := range profiles {
-				merged = AddProfile(merged, p)
+// 导出 files 时要新建的目录, 由深到浅排序
+func missingDirs(files []string) []string {
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, file := range files {
+		for dir := filepath.Dir(file); !seen[dir]; dir = filepath.Dir(dir) {
+			if _, err := os.Stat(dir); err == nil {
+				break
 			}
+			seen[dir] = true
+			dirs = append(dirs, dir)
 		}
 	}
-	defer DeleteFiles(delFiles)
+	sort.Slice(dirs, func(i, j int) bool {
+		if di, dj := strings.Count(dirs[i], string(filepath.Separator)), strings.Count(dirs[j], string(filepath.Separator)); di != dj {
+			return di > dj
+		}
+		return dirs[i] < dirs[j]
+	})
+	return dirs
+}
 
-	outFile, err := os.Create(*g_strOutCoverFile)
-	if err != nil {
-		fmt.Errorf("Error creating outFile: %v", err)
-		return err
+// 导出文件在 gitHash 版本中的源码, 文件在该版本中已删除时取删除前的源码
+func saveVersionSource(gitHash string, fileName string, outputPath string) error {
+	filePath := RepoPath(fileName)
+	err := GitSaveFile(gitHash, filePath, outputPath)
+	if _, errBlob := GitBlobHash(gitHash, filePath); err != nil && errBlob != nil {
+		commit, errLast := lastCommitWithFile(gitHash, filePath)
+		if errLast != nil {
+			return errLast
+		}
+		fmt.Printf("warning: %s does not exist in %s, using the source from %s\n", filePath, gitHash, commit)
+		err = GitSaveFile(commit, filePath, outputPath)
 	}
-	defer outFile.Close()
+	return err
+}
 
-	err = DumpProfiles(merged, outFile)
-	if err != nil {
-		return err
+// 给文件名加上 git hash, 再合并成一份覆盖率
+func RenameByHash(mergedByHash map[string][]*cover.Profile) []*cover.Profile {
+	var merged []*cover.Profile
+	for gitHash, profiles := range mergedByHash {
+		for _, p := range profiles {
+			p.FileName = fmt.Sprintf("%s.%s", p.FileName, gitHash)
+			merged = AddProfile(merged, p)
+		}
 	}
-	return GenerateCoverHTML(*g_strOutCoverFile, *g_strOutHTMLFile)
+	return merged
 }
 
-// 从 cover.txt 生成 HTML 报告
-func GenerateCoverHTML(coverFile string, outputFile string) error {
-	// 获取当前工作目录
-	currDir, err := os.Getwd()
+// 把覆盖率写到文件, 指定 -compress 时按 -compress-format 压缩, 指定 -header 时开头写入 latest 的版本注释
+func WriteProfileFile(fileName string, profiles []*cover.Profile, latest *CoverFileInfo) error {
+	outFile, err := os.Create(fileName)
 	if err != nil {
-		return fmt.Errorf("failed to get current working directory: %w", err)
+		return fmt.Errorf("error creating outFile: %v", err)
 	}
-
-	// 构造命令
-	cmd := exec.Command("go", "tool", "cover", fmt.Sprintf("-html=%s", coverFile), "-o", outputFile)
-
-	// 设置 GOPATH 环境变量（局部）
-	cmd.Env = append(os.Environ(), fmt.Sprintf("GOPATH=%s/go", currDir))
-
-	// 将标准输出和标准错误设置为主进程的输出
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	// 运行命令
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("error executing command: %w", err)
+	defer outFile.Close()
+	var w io.Writer = outFile
+	var cw io.WriteCloser
+	if *g_bCompress {
+		if cw, err = NewCompressWriter(outFile, *g_strCompressFormat); err != nil {
+			return err
+		}
+		w = cw
 	}
-
-	// 处理 HTML 文件结果
-	return InsertAdditionHTML(outputFile)
+	if *g_bHeader && latest != nil && len(profiles) > 0 {
+		if err := WriteProfileHeader(w, latest.Timestamp, latest.GitHash); err != nil {
+			return err
+		}
+	}
+	if cw == nil {
+		return DumpProfiles(profiles, outFile)
+	}
+	if err := DumpProfiles(profiles, cw); err != nil {
+		return err
+	}
+	if err := cw.Close(); err != nil {
+		return err
+	}
+	return outFile.Close()
 }
 
 func AddProfile(profiles []*cover.Profile, p *cover.Profile) []*cover.Profile {
+	profiles, _ = AddProfileChecked(profiles, p)
+	return profiles
+}
+
+// 与 AddProfile 相同, 并返回合并同一文件时的错误(如块重叠)
+func AddProfileChecked(profiles []*cover.Profile, p *cover.Profile) ([]*cover.Profile, error) {
 	i := sort.Search(len(profiles), func(i int) bool { return profiles[i].FileName >= p.FileName })
 	if i < len(profiles) && profiles[i].FileName == p.FileName {
-		MergeProfiles(profiles[i], p)
-	} else {
-		profiles = append(profiles, nil)
-		copy(profiles[i+1:], profiles[i:])
-		profiles[i] = p
+		return profiles, MergeProfiles(profiles[i], p)
 	}
-	return profiles
+	profiles = append(profiles, nil)
+	copy(profiles[i+1:], profiles[i:])
+	profiles[i] = p
+	return profiles, nil
 }
 
 func DumpProfiles(profiles []*cover.Profile, out io.Writer) error {
@@ -239,34 +996,46 @@ func mergeProfileBlock(p *cover.Profile, pb cover.ProfileBlock, startIndex int)
 	}
 
 	i := 0
-	if sortFunc(i) != true {
+	if startIndex < len(p.Blocks) && sortFunc(i) != true {
 		i = sort.Search(len(p.Blocks)-startIndex, sortFunc)
 	}
 
 	i += startIndex
 	if i < len(p.Blocks) && p.Blocks[i].StartLine == pb.StartLine && p.Blocks[i].StartCol == pb.StartCol {
 		if p.Blocks[i].EndLine != pb.EndLine || p.Blocks[i].EndCol != pb.EndCol {
+			if *g_strOverlap != "error" {
+				return resolveOverlap(p, pb, i)
+			}
 			return i, fmt.Errorf("gocovmerge: overlapping merge %v %v %v", p.FileName, p.Blocks[i], pb)
 		}
+		// -overlap split 切分出的段可能没有语句数
+		p.Blocks[i].NumStmt = max(p.Blocks[i].NumStmt, pb.NumStmt)
 		switch p.Mode {
 		case "set":
 			p.Blocks[i].Count |= pb.Count
 		case "count", "atomic":
-			p.Blocks[i].Count += pb.Count
+			p.Blocks[i].Count = AddCount(p.Blocks[i].Count, pb.Count)
 		default:
 			return i, fmt.Errorf("gocovmerge: unsupported covermode '%s'", p.Mode)
 		}
 
 	} else {
+		// 前一个块在 pb 开始之后才结束, 或后一个块在 pb 结束之前开始
 		if i > 0 {
 			pa := p.Blocks[i-1]
-			if pa.EndLine >= pb.EndLine && (pa.EndLine != pb.EndLine || pa.EndCol > pb.EndCol) {
+			if blockStart(pb).less(blockEnd(pa)) {
+				if *g_strOverlap != "error" {
+					return resolveOverlap(p, pb, i)
+				}
 				return i, fmt.Errorf("gocovmerge: overlap before %v %v %v", p.FileName, pa, pb)
 			}
 		}
-		if i < len(p.Blocks)-1 {
-			pa := p.Blocks[i+1]
-			if pa.StartLine <= pb.StartLine && (pa.StartLine != pb.StartLine || pa.StartCol < pb.StartCol) {
+		if i < len(p.Blocks) {
+			pa := p.Blocks[i]
+			if blockStart(pa).less(blockEnd(pb)) {
+				if *g_strOverlap != "error" {
+					return resolveOverlap(p, pb, i)
+				}
 				return i, fmt.Errorf("gocovmerge: overlap after %v %v %v", p.FileName, pa, pb)
 			}
 		}
@@ -283,34 +1052,65 @@ type CoverFileInfo struct {
 	GitHash   string
 	FileName  string
 	Profiles  []*cover.Profile
+	Reader    io.Reader         // 不为空时从 Reader 读取覆盖率数据, FileName 只用于显示和解析版本信息
+	Tags      map[string]string // 该输入的标签(如 suite=e2e), 为空表示没有
+	Weight    float64           // 计数的权重, 0 表示不缩放
+	Size      int64             // 已读入内存的输入数据的大小, 用于 -max-total-size, 0 表示不在内存中或未知
 }
 
-func ParseCoverFileInfo(fileName string) (*CoverFileInfo, error) {
-	// 使用字符串分割
-	parts := strings.Split(fileName, ".")
-	if len(parts) < 2 {
-		return &CoverFileInfo{}, fmt.Errorf("file string is not valid")
+// 根据名称解析版本信息, 名称不带版本时使用内容开头的注释, 覆盖率数据从 r 读取(例如网络连接或压缩包中的文件)
+func ParseCoverFileInfoFrom(name string, r io.Reader) (*CoverFileInfo, error) {
+	br := bufio.NewReaderSize(r, headerPeekSize)
+	fileInfo, err := ParseCoverFileInfo(name)
+	if err != nil {
+		head, _ := br.Peek(headerPeekSize)
+		timestamp, gitHash, ok := ParseProfileHeader(peekProfileHead(head))
+		if !ok {
+			return fileInfo, err
+		}
+		fileInfo = &CoverFileInfo{Timestamp: timestamp, GitHash: gitHash, FileName: name}
+	}
+	fileInfo.Reader = br
+	if lr, ok := r.(interface{ Len() int }); ok {
+		fileInfo.Size = int64(lr.Len())
 	}
+	return fileInfo, nil
+}
 
-	// 倒数第二个是时间戳
-	timestampStr := parts[len(parts)-2]
-	timestamp, err := strconv.ParseInt(timestampStr, 10, 64)
-	if err != nil {
-		return &CoverFileInfo{}, fmt.Errorf("timestamp is not valid")
+// 读取输入的覆盖率数据: 已有 Profiles 直接返回, 否则从 Reader, GOCOVERDIR 目录或文件解析
+func (info *CoverFileInfo) ReadProfiles() ([]*cover.Profile, error) {
+	if info.Profiles != nil {
+		return info.Profiles, nil
+	}
+	if info.Reader != nil {
+		return ParseProfilesFrom(info.Reader)
 	}
-	// 最后一个是git hash
-	gitHash := parts[len(parts)-1]
+	if IsCoverDataDir(info.FileName) {
+		return ParseCoverDataDir(info.FileName)
+	}
+	return ParseProfileFile(info.FileName)
+}
 
+func ParseCoverFileInfo(fileName string) (*CoverFileInfo, error) {
+	timestamp, gitHash, tags, err := ParseVersionFromName(fileName)
+	if err != nil {
+		return &CoverFileInfo{}, err
+	}
 	return &CoverFileInfo{
 		Timestamp: timestamp,
 		GitHash:   gitHash,
 		FileName:  fileName,
+		Tags:      tags,
 	}, nil
 }
 
 // 获取指定版本的文件内容
 func GitGetFileContent(commit, filePath string) (string, error) {
-	cmd := exec.Command("git", "show", fmt.Sprintf("%s:%s", commit, filePath))
+	if g_gitBackend != nil {
+		content, err := g_gitBackend.FileContent(commit, filePath)
+		return string(content), err
+	}
+	cmd := GitCommand("show", fmt.Sprintf("%s:%s", commit, filePath))
 	var out bytes.Buffer
 	cmd.Stdout = &out
 	err := cmd.Run()
@@ -320,26 +1120,86 @@ func GitGetFileContent(commit, filePath string) (string, error) {
 	return out.String(), nil
 }
 
-// 比较两个版本的文件内容
+// 比较两个版本的文件内容: 内容相同时 git 中的 blob hash 相同, 不需要读取文件内容
 func CompareVersions(commit1, commit2, filePath string) (bool, error) {
-	content1, err := GitGetFileContent(commit1, filePath)
+	blob1, err := GitBlobHash(commit1, filePath)
 	if err != nil {
 		return false, fmt.Errorf("获取 %s:%s 版本文件失败: %v", commit1, filePath, err)
 	}
 
-	content2, err := GitGetFileContent(commit2, filePath)
+	blob2, err := GitBlobHash(commit2, filePath)
 	if err != nil {
 		return false, fmt.Errorf("获取 %s:%s 版本文件失败: %v", commit2, filePath, err)
 	}
 
-	return content1 == content2, nil
+	return blob1 == blob2, nil
+}
+
+// 缓存的 git ls-tree 结果, 不同版本的 git ls-tree 可以同时执行
+type commitTree struct {
+	once sync.Once
+	tree map[string]string // 文件路径 -> blob hash
+	err  error
+}
+
+var (
+	g_commitTrees      = make(map[string]*commitTree) // commit -> 该版本的文件
+	g_commitTreesMutex sync.Mutex
+)
+
+// 文件在指定版本中的 blob hash. 每个版本只执行一次 git ls-tree, 结果缓存,
+// 跨版本比较时不再对每对文件执行 git show
+func GitBlobHash(commit, filePath string) (string, error) {
+	g_commitTreesMutex.Lock()
+	t, ok := g_commitTrees[commit]
+	if !ok {
+		t = &commitTree{}
+		g_commitTrees[commit] = t
+	}
+	g_commitTreesMutex.Unlock()
+	t.once.Do(func() { t.tree, t.err = gitLsTree(commit) })
+	if t.err != nil {
+		return "", t.err
+	}
+	blob, ok := t.tree[filePath]
+	if !ok {
+		return "", fmt.Errorf("%s does not exist in %s", filePath, commit)
+	}
+	return blob, nil
+}
+
+// 提交中所有文件的 blob hash: 路径 -> blob hash
+func gitLsTree(commit string) (map[string]string, error) {
+	if g_gitBackend != nil {
+		return g_gitBackend.Tree(commit)
+	}
+	out, err := GitCommand("ls-tree", "-r", "-z", "--full-tree", commit).Output()
+	if err != nil {
+		return nil, fmt.Errorf("git ls-tree %s: %v", commit, err)
+	}
+	tree := make(map[string]string)
+	for _, entry := range strings.Split(string(out), "\x00") {
+		// <mode> SP <type> SP <object> TAB <file>
+		info, name, ok := strings.Cut(entry, "\t")
+		fields := strings.Fields(info)
+		if !ok || len(fields) != 3 || fields[1] != "blob" {
+			continue
+		}
+		tree[name] = fields[2]
+	}
+	return tree, nil
 }
 
 // 检出指定提交中的文件并重命名
 func GitSaveFile(commit string, filePath string, outputPath string) error {
-	// 创建一个临时文件获取 git show 的输出
-	cmd := exec.Command("git", "show", fmt.Sprintf("%s:%s", commit, filePath))
-	output, err := cmd.Output()
+	var output []byte
+	var err error
+	if g_gitBackend != nil {
+		output, err = g_gitBackend.FileContent(commit, filePath)
+	} else {
+		// 创建一个临时文件获取 git show 的输出
+		output, err = GitCommand("show", fmt.Sprintf("%s:%s", commit, filePath)).Output()
+	}
 	if err != nil {
 		return fmt.Errorf("failed to run git show: %w", err)
 	}
@@ -362,107 +1222,98 @@ func GitSaveFile(commit string, filePath string, outputPath string) error {
 func DeleteFiles(filePaths []string) {
 	for _, filePath := range filePaths {
 		err := os.Remove(filePath)
-		if err != nil {
-			// 返回详细错误信息，包括出错的文件路径
-			fmt.Errorf("failed to delete file %s: %w", filePath, err)
-		}
-	}
-}
-
-// 插入 HTML 代码:添加文件列表搜索框，添加行号
-var g_additionHTML = `
-    <style>
-        .line-number {
-            display: inline-block;
-            width: 30px;
-            text-align: right;
-            margin-right: 10px;
-            color: #888;
-        }
-    </style>
-    <script>
-    let optionMap = new Map();
-
-    function initFilter() {
-        var fileSelect = document.getElementById('files');
-        var options = fileSelect.getElementsByTagName('option');
-
-        for (var i = 0; i < options.length; i++) {
-            let value = options[i].value;
-            optionMap.set(value, options[i]);
-        }
-    }
-
-    function filterFiles() {
-        var input = document.getElementById('fileSearch');
-        var filter = input.value.trim().toUpperCase().replace(/_/g, '\_'); // 添加替换下划线的部分
-        var visibleOptions = [];
-
-        optionMap.forEach((option, value) => {
-            const optionText = option.innerText.toUpperCase().replace(/_/g, '\_'); // 对选项文本也做相同处理
-            if (filter === '' || optionText.indexOf(filter) !== -1) {
-                visibleOptions.push(option);
-            } else {
-                option.style.display = 'none';
-            }
-        });
-
-        for (let option of visibleOptions) {
-            option.style.display = '';
-        }
-    }
-
-    function addLineNumbers() {
-      const preElements = document.querySelectorAll('pre');
-      preElements.forEach(pre => {
-          const lines = pre.innerHTML.split('\n');
-          const lineNumberedHtml = lines.map((line, index) => {
-              let num = index + 1;
-              return '<span class="line-number">'+num+'</span>'+line;
-          }).join('\n');
-          pre.innerHTML = lineNumberedHtml;
-          pre.style.whiteSpace = 'pre';
-      });
-    }
-
-    // 在页面加载完成后初始化过滤器
-    window.onload = function () {
-        initFilter();
-        addLineNumbers();
-    };
-    </script>
-
-    <input id="fileSearch" type="text" onkeyup="filterFiles()" placeholder="Search files...">
-`
-
-// 从指定的 HTML 文件中读取内容，插入 HTML 代码，然后覆盖写入文件
+		if err != nil && !os.IsNotExist(err) {
+			// 打印详细错误信息，包括出错的文件路径, 遗留的文件由下次运行的 -orphans 或 clean-sources 处理
+			fmt.Printf("warning: failed to delete file %s: %v\n", filePath, err)
+		}
+	}
+}
+
+// 插入 HTML 代码:添加文件列表搜索框，添加行号. 报告使用的资源都放在 assets/ 中, 不能引用外部 URL
+//
+//go:generate go run assets/checkoffline.go assets
+//go:embed assets/report.html
+var g_additionHTML string
+
+// 从指定的 HTML 文件中读取内容，插入 HTML 代码，然后覆盖写入文件.
+// 解析成 DOM 后插入到文件列表 <select id="files"> 之前, 找不到插入位置时报错, 不会静默地生成缺少搜索框的报告.
+// 同时给源码加上行号(见 NumberReportLines)
 func InsertAdditionHTML(filePath string) error {
 	// 读取原始 HTML 文件
 	htmlContent, err := ioutil.ReadFile(filePath)
 	if err != nil {
 		return fmt.Errorf("error reading file: %v", err)
 	}
-
-	// 将读取的内容转换为字符串
-	htmlString := string(htmlContent)
+	doc, err := html.Parse(bytes.NewReader(htmlContent))
+	if err != nil {
+		return fmt.Errorf("error parsing %s: %v", filePath, err)
+	}
 
 	// 检查搜索框 HTML 是否已经存在
-	existingSearchBoxRe := regexp.MustCompile(`(<input\s+id="fileSearch".*?>)`)
-	if existingSearchBoxRe.MatchString(htmlString) {
+	if FindElement(doc, func(n *html.Node) bool { return n.DataAtom == atom.Input && HTMLAttr(n, "id") == "fileSearch" }) != nil {
 		// 如果存在，则无需进行替换
 		fmt.Println("Search box already exists in the HTML file.")
 		return nil
 	}
 
-	// 使用正则表达式进行替换
-	re := regexp.MustCompile(`(<select id="files">)`)
-	htmlString = re.ReplaceAllString(htmlString, g_additionHTML+`$1`)
+	// 插入位置: 文件列表
+	fileSelect := FindElement(doc, func(n *html.Node) bool { return n.DataAtom == atom.Select && HTMLAttr(n, "id") == "files" })
+	if fileSelect == nil || fileSelect.Parent == nil {
+		return fmt.Errorf("%s: no <select id=\"files\"> to insert the search box before, unsupported go tool cover output", filePath)
+	}
+
+	suiteHTML, err := SuiteToggleHTML(g_htmlSuites)
+	if err != nil {
+		return err
+	}
+	nodes, err := html.ParseFragment(strings.NewReader(g_additionHTML+suiteHTML), fileSelect.Parent)
+	if err != nil {
+		return fmt.Errorf("error parsing report assets: %v", err)
+	}
+	for _, n := range nodes {
+		fileSelect.Parent.InsertBefore(n, fileSelect)
+	}
+	if err := InsertLuaScripts(doc, g_luaScripts); err != nil {
+		return fmt.Errorf("%s: %v", filePath, err)
+	}
+	NumberReportLines(doc)
+
+	var buf bytes.Buffer
+	if err := html.Render(&buf, doc); err != nil {
+		return fmt.Errorf("error rendering %s: %v", filePath, err)
+	}
+	if err := CheckOfflineHTML(buf.String()); err != nil {
+		return fmt.Errorf("%s: %v", filePath, err)
+	}
 
 	// 写回到同一个 HTML 文件
-	err = ioutil.WriteFile(filePath, []byte(htmlString), 0644)
+	err = ioutil.WriteFile(filePath, buf.Bytes(), 0644)
 	if err != nil {
 		return fmt.Errorf("error writing file: %v", err)
 	}
 
 	return nil
 }
+
+// 深度优先查找第一个满足条件的元素
+func FindElement(n *html.Node, match func(n *html.Node) bool) *html.Node {
+	if n.Type == html.ElementNode && match(n) {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := FindElement(c, match); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// 元素的属性值, 没有该属性时为空
+func HTMLAttr(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Namespace == "" && attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
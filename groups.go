@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/tools/cover"
+)
+
+var g_strGroups = flag.String("groups", "", "包分组(如服务边界)配置文件(JSON): {\"billing\": [\"example.com/shop/billing/...\"]}, 合并后打印每组的覆盖率, 并在 CSV, 按行输出, Parquet 和简要报告中带上分组")
+
+// 包分组: 组名 -> 包模式, 模式以 /... 结尾时包括该包和所有子包, 否则按 path.Match 匹配包的导入路径
+type PackageGroups struct {
+	names    []string // 按组名排序, 一个包属于多个组时取第一个
+	patterns map[string][]string
+}
+
+// 由 -groups 加载的分组, 没有指定时为空
+var g_packageGroups *PackageGroups
+
+// 读取分组配置文件
+func LoadPackageGroups(fileName string) (*PackageGroups, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	groups := &PackageGroups{}
+	if err := json.Unmarshal(data, &groups.patterns); err != nil {
+		return nil, fmt.Errorf("bad groups file %s: %v", fileName, err)
+	}
+	for name, patterns := range groups.patterns {
+		if name == "" {
+			return nil, fmt.Errorf("bad groups file %s: empty group name", fileName)
+		}
+		for _, pattern := range patterns {
+			if _, err := path.Match(strings.TrimSuffix(pattern, "/..."), ""); err != nil {
+				return nil, fmt.Errorf("bad groups file %s: group %s: bad pattern %q", fileName, name, pattern)
+			}
+		}
+		groups.names = append(groups.names, name)
+	}
+	sort.Strings(groups.names)
+	return groups, nil
+}
+
+func matchPackagePattern(pattern string, pkg string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "/..."); ok {
+		return pkg == prefix || strings.HasPrefix(pkg, prefix+"/")
+	}
+	bMatch, _ := path.Match(pattern, pkg)
+	return bMatch
+}
+
+// 文件所在的包所属的组, 不属于任何组(或没有配置分组)时为空. 文件名可以带 git hash 后缀
+func (groups *PackageGroups) GroupOf(fileName string) string {
+	if groups == nil {
+		return ""
+	}
+	pkg := path.Dir(fileName)
+	for _, name := range groups.names {
+		for _, pattern := range groups.patterns[name] {
+			if matchPackagePattern(pattern, pkg) {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// 每组的语句覆盖统计: 组名 -> 统计(FileName 为组名), 不属于任何组的文件在空组名下
+func (groups *PackageGroups) Rollup(profiles []*cover.Profile) map[string]*FileCoverage {
+	stats := make(map[string]*FileCoverage)
+	for _, stat := range ComputeFileCoverage(profiles) {
+		name := groups.GroupOf(stat.FileName)
+		if stats[name] == nil {
+			stats[name] = &FileCoverage{FileName: name}
+		}
+		stats[name].Statements += stat.Statements
+		stats[name].Covered += stat.Covered
+	}
+	return stats
+}
+
+// 打印每组的覆盖率, 不属于任何组的文件单独一行. 输入是抽样时覆盖率列为 sampled, 并带上每组的全量估计
+func (groups *PackageGroups) PrintSummary(w io.Writer, profiles []*cover.Profile) error {
+	stats := groups.Rollup(profiles)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	if g_sampleEstimator != nil {
+		fmt.Fprintln(tw, "group\tstatements\tcovered\tsampled\testimated fleet\t95% CI")
+	} else {
+		fmt.Fprintln(tw, "group\tstatements\tcovered\tcoverage")
+	}
+	for _, name := range append(append([]string(nil), groups.names...), "") {
+		stat := stats[name]
+		if stat == nil {
+			if name == "" {
+				continue
+			}
+			stat = &FileCoverage{}
+		}
+		var strEstimate string
+		if g_sampleEstimator != nil {
+			group := name
+			match := func(fileName string) bool { return groups.GroupOf(fileName) == group }
+			strEstimate = "\t" + g_sampleEstimator.estimateColumns(match, *stat)
+		}
+		if name == "" {
+			name = "(ungrouped)"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f%%%s\n", name, stat.Statements, stat.Covered, stat.Percent(), strEstimate)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	g_nMaxFiles       = flag.Int("max-files", 10000, "输入文件数的上限, 超过时在合并前停止(如 glob 误匹配了日志), 0 不限制")
+	g_strMaxTotalSize = flag.String("max-total-size", "10G", "输入总大小的上限, 可以带 K, M, G, T 后缀, 超过时在合并前停止, 0 不限制")
+	g_bYes            = flag.Bool("yes", false, "输入超过 -max-files 或 -max-total-size 时仍然合并, 不询问")
+)
+
+var sizeSuffixMultiple = map[string]int64{"": 1, "K": 1 << 10, "M": 1 << 20, "G": 1 << 30, "T": 1 << 40}
+
+// 解析大小, 如 512M, 10G, 1.5T, 后缀可以带 B 或 iB
+func ParseSize(s string) (int64, error) {
+	str := strings.ToUpper(strings.TrimSpace(s))
+	str = strings.TrimSuffix(strings.TrimSuffix(str, "B"), "I")
+	i := len(str)
+	for i > 0 && (str[i-1] < '0' || str[i-1] > '9') && str[i-1] != '.' {
+		i--
+	}
+	multiple, ok := sizeSuffixMultiple[str[i:]]
+	if !ok {
+		return 0, fmt.Errorf("bad size %q, expected e.g. 512M or 10G", s)
+	}
+	n, err := strconv.ParseFloat(str[:i], 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("bad size %q, expected e.g. 512M or 10G", s)
+	}
+	return int64(n * float64(multiple)), nil
+}
+
+func formatSize(n int64) string {
+	for _, suffix := range []string{"T", "G", "M", "K"} {
+		if n >= sizeSuffixMultiple[suffix] {
+			return fmt.Sprintf("%.1f%s", float64(n)/float64(sizeSuffixMultiple[suffix]), suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+// 合并命令检查输入规模时为 true, 对象存储的前缀列出对象后也检查个数, 避免下载前不知道数量
+var g_bGuardrails bool
+
+// 超过上限时已经确认继续, 之后的检查不再询问
+var g_bGuardrailsConfirmed bool
+
+// 输入的大小: 已读入内存的输入(压缩包中的文件, http(s), 对象存储, goc)为内存中数据的大小,
+// 否则为磁盘上的大小, GOCOVERDIR 目录为其中所有文件的大小, 无法获取时为 0
+func inputSize(fileInfo *CoverFileInfo) int64 {
+	if fileInfo.Size > 0 {
+		return fileInfo.Size
+	}
+	if r, ok := fileInfo.Reader.(interface{ Len() int }); ok {
+		return int64(r.Len())
+	}
+	if fileInfo.Reader != nil || fileInfo.Profiles != nil || fileInfo.FileName == "" {
+		return 0
+	}
+	return diskSize(fileInfo.FileName)
+}
+
+// 文件或目录(其中所有文件)在磁盘上的大小, 不是本地文件(如 URL)时为 0
+func diskSize(fileName string) int64 {
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return 0
+	}
+	if !info.IsDir() {
+		return info.Size()
+	}
+	var size int64
+	filepath.WalkDir(fileName, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// 读取输入前检查展开后的输入名: 文件数和磁盘上的总大小(包括压缩包), 压缩包等读入内存的内容在 CheckInputGuardrails 中再检查
+func CheckExpandedGuardrails(inputs []coverInput) error {
+	var totalSize int64
+	for _, input := range inputs {
+		totalSize += diskSize(input.file)
+	}
+	return checkGuardrailLimits(len(inputs), totalSize)
+}
+
+// 合并前检查所有输入(压缩包, 对象存储前缀等已展开)的文件数和总大小
+func CheckInputGuardrails(fileInfos []*CoverFileInfo) error {
+	var totalSize int64
+	for _, fileInfo := range fileInfos {
+		totalSize += inputSize(fileInfo)
+	}
+	return checkGuardrailLimits(len(fileInfos), totalSize)
+}
+
+// 文件数或总大小超过上限时交互终端上询问是否继续, 否则报错, -yes 或已经确认过时不检查
+func checkGuardrailLimits(nFiles int, totalSize int64) error {
+	if *g_bYes || g_bGuardrailsConfirmed {
+		return nil
+	}
+	maxSize, err := ParseSize(*g_strMaxTotalSize)
+	if err != nil {
+		return fmt.Errorf("-max-total-size: %v", err)
+	}
+	var problems []string
+	if *g_nMaxFiles > 0 && nFiles > *g_nMaxFiles {
+		problems = append(problems, fmt.Sprintf("%d input files exceed -max-files %d", nFiles, *g_nMaxFiles))
+	}
+	if maxSize > 0 && totalSize > maxSize {
+		problems = append(problems, fmt.Sprintf("%s of inputs exceed -max-total-size %s", formatSize(totalSize), *g_strMaxTotalSize))
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	message := strings.Join(problems, ", ")
+	if confirm(message + ", merge anyway? [y/N] ") {
+		g_bGuardrailsConfirmed = true
+		return nil
+	}
+	return fmt.Errorf("%s, check the inputs or use -yes to merge anyway", message)
+}
+
+// 标准输入是终端(不是输入的覆盖率数据)且不在 CI 中(没有 CI 环境变量)时询问, 回答 y 或 yes 时返回 true
+func confirm(prompt string) bool {
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 || os.Getenv("CI") != "" {
+		return false
+	}
+	fmt.Print(prompt)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		fmt.Println()
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// 读入内存的输入(压缩包中的文件, URL 等)按内存中数据的大小计入 -max-total-size
+func TestInputSizeInMemory(t *testing.T) {
+	data := []byte("mode: set\n" + strings.Repeat("example.com/foo/foo.go:3.24,4.11 1 1\n", 100))
+	fileInfo, err := ParseCoverFileInfoFrom("cover.txt.100.0b57328", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := inputSize(fileInfo); size != int64(len(data)) {
+		t.Errorf("inputSize = %d, want %d", size, len(data))
+	}
+	goc := &CoverFileInfo{FileName: "goc://localhost:7777", Reader: bytes.NewReader(data)}
+	if size := inputSize(goc); size != int64(len(data)) {
+		t.Errorf("inputSize of a goc input = %d, want %d", size, len(data))
+	}
+}
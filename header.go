@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var g_bHeader = flag.Bool("header", false, "合并输出的覆盖率文件开头写入最新版本的 # timestamp: 和 # githash: 注释(go tool cover 不能直接读取带注释的文件)")
+
+// 覆盖率开头(mode 行之前)的注释行可以提供版本信息, 文件名不需要带版本:
+//
+//	# timestamp: 1723042827
+//	# githash: e24dac6
+//	mode: set
+const (
+	headerTimestamp = "# timestamp:"
+	headerGitHash   = "# githash:"
+	headerPeekSize  = 4096
+)
+
+// 解析覆盖率开头的注释行, timestamp 和 githash 都有时 ok 为 true
+func ParseProfileHeader(head []byte) (timestamp int64, gitHash string, ok bool) {
+	for len(head) > 0 {
+		line := head
+		if i := bytes.IndexByte(head, '\n'); i >= 0 {
+			line, head = head[:i], head[i+1:]
+		} else {
+			head = nil
+		}
+		s := strings.TrimSpace(string(line))
+		if !strings.HasPrefix(s, "#") {
+			break
+		}
+		switch {
+		case strings.HasPrefix(s, headerTimestamp):
+			timestamp, _ = strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(s, headerTimestamp)), 10, 64)
+		case strings.HasPrefix(s, headerGitHash):
+			gitHash = strings.TrimSpace(strings.TrimPrefix(s, headerGitHash))
+		}
+	}
+	return timestamp, gitHash, timestamp != 0 && gitHash != ""
+}
+
+// 读取覆盖率内容开头的一段用于解析注释行, gzip 或 zstd 压缩的内容先解压
+func peekProfileHead(head []byte) []byte {
+	if IsZstdContent(head) {
+		return peekZstdHead(head, headerPeekSize)
+	}
+	if !IsGzipContent(head) {
+		return head
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(head))
+	if err != nil {
+		return nil
+	}
+	// head 只是压缩数据的开头, 能解压出多少用多少
+	data, _ := io.ReadAll(io.LimitReader(gz, headerPeekSize))
+	return data
+}
+
+// 从文件开头的注释行解析版本信息, 没有注释行时返回 nil
+func ReadHeaderFileInfo(fileName string) (*CoverFileInfo, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	head, _ := bufio.NewReaderSize(f, headerPeekSize).Peek(headerPeekSize)
+	timestamp, gitHash, ok := ParseProfileHeader(peekProfileHead(head))
+	if !ok {
+		return nil, nil
+	}
+	return &CoverFileInfo{Timestamp: timestamp, GitHash: gitHash, FileName: fileName}, nil
+}
+
+// 写出覆盖率开头的版本注释
+func WriteProfileHeader(w io.Writer, timestamp int64, gitHash string) error {
+	_, err := fmt.Fprintf(w, "%s %d\n%s %s\n", headerTimestamp, timestamp, headerGitHash, gitHash)
+	return err
+}
+
+// 时间戳最新的版本, 没有输入时返回 nil
+func LatestVersion(fileInfos []*CoverFileInfo) *CoverFileInfo {
+	var latest *CoverFileInfo
+	for _, fileInfo := range fileInfos {
+		if latest == nil || fileInfo.Timestamp > latest.Timestamp {
+			latest = fileInfo
+		}
+	}
+	return latest
+}
@@ -0,0 +1,631 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/tools/cover"
+)
+
+// 解析时间长度, 在 time.ParseDuration 的基础上支持按天计的 7d
+func ParseAge(s string) (time.Duration, error) {
+	if strDays, ok := strings.CutSuffix(s, "d"); ok {
+		days, err := strconv.ParseFloat(strDays, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(days * float64(24*time.Hour)), nil
+	}
+	return time.ParseDuration(s)
+}
+
+// 解析时间边界: 时长(如 7d, 表示多久之前), 日期(2006-01-02) 或 unix 时间戳, 返回 unix 时间戳
+func ParseTimeBound(s string, now time.Time) (int64, error) {
+	if timestamp, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return timestamp, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t.Unix(), nil
+	}
+	age, err := ParseAge(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected a duration like 7d, a date like 2006-01-02 or a unix timestamp", s)
+	}
+	return now.Add(-age).Unix(), nil
+}
+
+// 历史库中运行的筛选条件, 零值表示不限制, 默认不包含已标记为无效的运行
+type RunFilter struct {
+	GitHash        string
+	Tags           map[string]string
+	Since          int64
+	Until          int64
+	IncludeInvalid bool
+}
+
+// 历史库中的一次运行及其总覆盖率
+type StoredRun struct {
+	ID         int64
+	Source     string
+	GitHash    string
+	Timestamp  int64
+	Mode       string
+	Invalid    bool
+	Tags       map[string]string
+	Statements int
+	Covered    int
+}
+
+// 按条件列出运行, 按时间排序
+func (s *Store) ListRuns(filter RunFilter) ([]*StoredRun, error) {
+	query := `SELECT r.id, r.source, r.git_hash, r.timestamp, r.mode, r.invalid,
+			COALESCE(SUM(b.num_stmt), 0), COALESCE(SUM(CASE WHEN b.count > 0 THEN b.num_stmt ELSE 0 END), 0)
+		FROM runs r
+		LEFT JOIN files f ON f.run_id = r.id
+		LEFT JOIN blocks b ON b.file_id = f.id
+		WHERE 1 = 1`
+	var args []interface{}
+	if !filter.IncludeInvalid {
+		query += " AND r.invalid = 0"
+	}
+	if filter.GitHash != "" {
+		query += " AND r.git_hash = ?"
+		args = append(args, filter.GitHash)
+	}
+	for key, value := range filter.Tags {
+		query += " AND EXISTS (SELECT 1 FROM run_tags t WHERE t.run_id = r.id AND t.key = ? AND t.value = ?)"
+		args = append(args, key, value)
+	}
+	if filter.Since != 0 {
+		query += " AND r.timestamp >= ?"
+		args = append(args, filter.Since)
+	}
+	if filter.Until != 0 {
+		query += " AND r.timestamp <= ?"
+		args = append(args, filter.Until)
+	}
+	query += " GROUP BY r.id ORDER BY r.timestamp, r.id"
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []*StoredRun
+	for rows.Next() {
+		run := &StoredRun{}
+		if err := rows.Scan(&run.ID, &run.Source, &run.GitHash, &run.Timestamp, &run.Mode, &run.Invalid, &run.Statements, &run.Covered); err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, run := range runs {
+		if run.Tags, err = s.RunTags(run.ID); err != nil {
+			return nil, err
+		}
+	}
+	return runs, nil
+}
+
+// 读取运行的标签
+func (s *Store) RunTags(runID int64) (map[string]string, error) {
+	rows, err := s.db.Query("SELECT key, value FROM run_tags WHERE run_id = ?", runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tags := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		tags[key] = value
+	}
+	return tags, rows.Err()
+}
+
+// 读取运行的覆盖率数据, 结果和解析原始文件得到的一致(按文件名排序)
+func (s *Store) RunProfiles(runID int64) ([]*cover.Profile, error) {
+	rows, err := s.db.Query(`SELECT f.file_name, r.mode, b.start_line, b.start_col, b.end_line, b.end_col, b.num_stmt, b.count
+		FROM files f
+		JOIN runs r ON r.id = f.run_id
+		JOIN blocks b ON b.file_id = f.id
+		WHERE f.run_id = ?
+		ORDER BY f.file_name, b.start_line, b.start_col`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	profiles := make([]*cover.Profile, 0)
+	for rows.Next() {
+		var fileName, mode string
+		var b cover.ProfileBlock
+		if err := rows.Scan(&fileName, &mode, &b.StartLine, &b.StartCol, &b.EndLine, &b.EndCol, &b.NumStmt, &b.Count); err != nil {
+			return nil, err
+		}
+		if n := len(profiles); n == 0 || profiles[n-1].FileName != fileName {
+			profiles = append(profiles, &cover.Profile{FileName: fileName, Mode: mode})
+		}
+		p := profiles[len(profiles)-1]
+		p.Blocks = append(p.Blocks, b)
+	}
+	return profiles, rows.Err()
+}
+
+// 一段最后一次被覆盖时间相同的连续行
+type StaleRange struct {
+	FileName    string
+	StartLine   int
+	EndLine     int
+	LastCovered int64 // 最后一次被覆盖的运行时间戳, 0 表示从未被覆盖
+}
+
+// 根据历史库计算每一行最后一次被覆盖的时间, 相邻且时间相同的行合并为一段.
+// 每个文件只看内容与最新版本相同(blob hash 相同, 取不到时 git hash 相同)的运行, 行号才对应同一段代码;
+// 这些运行中从未被覆盖的行也列出
+func (s *Store) LastCoveredRanges() ([]StaleRange, error) {
+	versions, err := s.latestFileVersions()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(`SELECT f.file_name, r.git_hash, b.start_line, b.end_line, MAX(CASE WHEN b.count > 0 THEN r.timestamp ELSE 0 END)
+		FROM blocks b
+		JOIN files f ON f.id = b.file_id
+		JOIN runs r ON r.id = f.run_id
+		WHERE r.invalid = 0
+		GROUP BY f.file_name, r.git_hash, b.start_line, b.start_col, b.end_line, b.end_col`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lastCovered := make(map[string]map[int]int64) // 文件 -> 行 -> 最后覆盖时间
+	for rows.Next() {
+		var fileName, gitHash string
+		var startLine, endLine int
+		var timestamp int64
+		if err := rows.Scan(&fileName, &gitHash, &startLine, &endLine, &timestamp); err != nil {
+			return nil, err
+		}
+		if !versions[fileName][gitHash] {
+			continue
+		}
+		lines := lastCovered[fileName]
+		if lines == nil {
+			lines = make(map[int]int64)
+			lastCovered[fileName] = lines
+		}
+		for line := startLine; line <= endLine; line++ {
+			if last, ok := lines[line]; !ok || timestamp > last {
+				lines[line] = timestamp
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	fileNames := make([]string, 0, len(lastCovered))
+	for fileName := range lastCovered {
+		fileNames = append(fileNames, fileName)
+	}
+	sort.Strings(fileNames)
+	var ranges []StaleRange
+	for _, fileName := range fileNames {
+		lines := lastCovered[fileName]
+		lineNumbers := make([]int, 0, len(lines))
+		for line := range lines {
+			lineNumbers = append(lineNumbers, line)
+		}
+		sort.Ints(lineNumbers)
+		for _, line := range lineNumbers {
+			if n := len(ranges); n > 0 {
+				last := &ranges[n-1]
+				if last.FileName == fileName && last.EndLine == line-1 && last.LastCovered == lines[line] {
+					last.EndLine = line
+					continue
+				}
+			}
+			ranges = append(ranges, StaleRange{FileName: fileName, StartLine: line, EndLine: line, LastCovered: lines[line]})
+		}
+	}
+	return ranges, nil
+}
+
+// 每个文件与最新版本内容相同的 git hash: 文件 -> git hash -> true. 最新版本是包含该文件的最新一次运行的版本
+func (s *Store) latestFileVersions() (map[string]map[string]bool, error) {
+	rows, err := s.db.Query(`SELECT f.file_name, r.git_hash, MAX(r.timestamp)
+		FROM files f
+		JOIN runs r ON r.id = f.run_id
+		WHERE r.invalid = 0
+		GROUP BY f.file_name, r.git_hash
+		ORDER BY f.file_name, MAX(r.timestamp) DESC, r.git_hash`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type fileVersion struct {
+		fileName, gitHash string
+	}
+	var fileVersions []fileVersion
+	for rows.Next() {
+		var v fileVersion
+		var timestamp int64
+		if err := rows.Scan(&v.fileName, &v.gitHash, &timestamp); err != nil {
+			return nil, err
+		}
+		fileVersions = append(fileVersions, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	versions := make(map[string]map[string]bool)
+	latestBlobs := make(map[string]string) // 文件 -> 最新版本的 blob hash, 取不到时为空
+	for _, v := range fileVersions {
+		if versions[v.fileName] == nil {
+			// 按时间倒序, 第一个是最新版本
+			versions[v.fileName] = map[string]bool{v.gitHash: true}
+			latestBlobs[v.fileName], _ = GitBlobHash(v.gitHash, RepoPath(v.fileName))
+			continue
+		}
+		if latestBlob := latestBlobs[v.fileName]; latestBlob != "" {
+			if blob, err := GitBlobHash(v.gitHash, RepoPath(v.fileName)); err == nil && blob == latestBlob {
+				versions[v.fileName][v.gitHash] = true
+			}
+		}
+	}
+	return versions, nil
+}
+
+// stale: 列出每段代码最后一次被覆盖的时间, 最久未被覆盖的排在前面
+func runStale(args []string) error {
+	fs := NewSubCommandFlagSet("stale", "[options]")
+	strDB := fs.String("db", "cover.db", "覆盖率历史库(SQLite)")
+	strOlder := fs.String("older", "", "只列出超过该时长未被覆盖的代码, 例如 30d")
+	strFile := fs.String("file", "", "只列出文件名包含该字符串的文件")
+	fs.Parse(args)
+
+	var older time.Duration
+	if *strOlder != "" {
+		var err error
+		if older, err = ParseAge(*strOlder); err != nil {
+			return err
+		}
+	}
+
+	// 按最新版本的源码判断每个文件的哪些运行可用
+	if err := SetupSourcePaths(); err != nil {
+		return err
+	}
+	store, err := OpenStore(*strDB)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	ranges, err := store.LastCoveredRanges()
+	if err != nil {
+		return err
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		if ranges[i].LastCovered != ranges[j].LastCovered {
+			return ranges[i].LastCovered < ranges[j].LastCovered
+		}
+		if ranges[i].FileName != ranges[j].FileName {
+			return ranges[i].FileName < ranges[j].FileName
+		}
+		return ranges[i].StartLine < ranges[j].StartLine
+	})
+
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "file\tlines\tlast covered\tage")
+	for _, r := range ranges {
+		if *strFile != "" && !strings.Contains(r.FileName, *strFile) {
+			continue
+		}
+		if r.LastCovered == 0 {
+			fmt.Fprintf(w, "%s\t%d-%d\tnever\t-\n", r.FileName, r.StartLine, r.EndLine)
+			continue
+		}
+		lastCovered := time.Unix(r.LastCovered, 0)
+		age := now.Sub(lastCovered)
+		if age < older {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%d-%d\t%s\t%dd\n", r.FileName, r.StartLine, r.EndLine, lastCovered.Format("2006-01-02 15:04"), int(age.Hours()/24))
+	}
+	return w.Flush()
+}
+
+// 解析 runs 和 rebuild 共用的筛选参数
+func parseRunFilter(strHash, strTags, strSince, strUntil string) (RunFilter, error) {
+	filter := RunFilter{GitHash: strHash}
+	var err error
+	if filter.Tags, err = ParseTags(strTags); err != nil {
+		return filter, err
+	}
+	now := time.Now()
+	if strSince != "" {
+		if filter.Since, err = ParseTimeBound(strSince, now); err != nil {
+			return filter, err
+		}
+	}
+	if strUntil != "" {
+		if filter.Until, err = ParseTimeBound(strUntil, now); err != nil {
+			return filter, err
+		}
+	}
+	return filter, nil
+}
+
+func formatPercent(covered, statements int) string {
+	return fmt.Sprintf("%.1f%%", FileCoverage{Statements: statements, Covered: covered}.Percent())
+}
+
+// runs list|show|invalidate|restore|audit: 查看和管理历史库中记录的运行
+func runRuns(args []string) error {
+	subCommands := map[string]func(args []string) error{
+		"list":       runRunsList,
+		"show":       runRunsShow,
+		"invalidate": runRunsInvalidate,
+		"restore":    runRunsRestore,
+		"audit":      runRunsAudit,
+	}
+	if len(args) == 0 || subCommands[args[0]] == nil {
+		fmt.Println("Usage: ./bin/gocovmerge runs list [-db cover.db] [-hash githash] [-tags k=v,...] [-since 7d] [-until 2006-01-02] [-all]")
+		fmt.Println("       ./bin/gocovmerge runs show [-db cover.db] run-id")
+		fmt.Println("       ./bin/gocovmerge runs invalidate|restore [-db cover.db] -reason text run-id ...")
+		fmt.Println("       ./bin/gocovmerge runs audit [-db cover.db] [run-id]")
+		return fmt.Errorf("Error: runs list, show, invalidate, restore or audit required.")
+	}
+	return subCommands[args[0]](args[1:])
+}
+
+func runRunsList(args []string) error {
+	fs := NewSubCommandFlagSet("runs list", "[options]")
+	strDB := fs.String("db", "cover.db", "覆盖率历史库(SQLite)")
+	strHash := fs.String("hash", "", "只列出该 git hash 的运行")
+	strTags := fs.String("tags", "", "只列出带有这些标签的运行, 例如 env=prod,suite=e2e")
+	strSince := fs.String("since", "", "只列出该时间之后的运行: 7d, 2006-01-02 或 unix 时间戳")
+	strUntil := fs.String("until", "", "只列出该时间之前的运行: 7d, 2006-01-02 或 unix 时间戳")
+	bAll := fs.Bool("all", false, "同时列出已标记为无效的运行")
+	fs.Parse(args)
+
+	filter, err := parseRunFilter(*strHash, *strTags, *strSince, *strUntil)
+	if err != nil {
+		return err
+	}
+	filter.IncludeInvalid = *bAll
+	store, err := OpenStore(*strDB)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	runs, err := store.ListRuns(filter)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "id\ttime\tgit hash\ttags\tcoverage\tstatus\tsource")
+	for _, run := range runs {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", run.ID, time.Unix(run.Timestamp, 0).Format("2006-01-02 15:04"),
+			run.GitHash, FormatTags(run.Tags), formatPercent(run.Covered, run.Statements), formatRunStatus(run.Invalid), run.Source)
+	}
+	return w.Flush()
+}
+
+func runRunsShow(args []string) error {
+	fs := NewSubCommandFlagSet("runs show", "[options] run-id")
+	strDB := fs.String("db", "cover.db", "覆盖率历史库(SQLite)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("Error: run id required.")
+	}
+	runID, err := strconv.ParseInt(fs.Arg(0), 10, 64)
+	if err != nil {
+		return fmt.Errorf("run id is not valid")
+	}
+
+	store, err := OpenStore(*strDB)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	var run StoredRun
+	err = store.db.QueryRow("SELECT id, source, git_hash, timestamp, mode, invalid FROM runs WHERE id = ?", runID).
+		Scan(&run.ID, &run.Source, &run.GitHash, &run.Timestamp, &run.Mode, &run.Invalid)
+	if err != nil {
+		return fmt.Errorf("run %d not found: %v", runID, err)
+	}
+	if run.Tags, err = store.RunTags(runID); err != nil {
+		return err
+	}
+	profiles, err := store.RunProfiles(runID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("run:      %d\n", run.ID)
+	fmt.Printf("source:   %s\n", run.Source)
+	fmt.Printf("git hash: %s\n", run.GitHash)
+	fmt.Printf("time:     %s\n", time.Unix(run.Timestamp, 0).Format("2006-01-02 15:04:05"))
+	fmt.Printf("mode:     %s\n", run.Mode)
+	fmt.Printf("tags:     %s\n", FormatTags(run.Tags))
+	fmt.Printf("status:   %s\n", formatRunStatus(run.Invalid))
+	fmt.Println()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "file\tstatements\tcovered\tcoverage")
+	var statements, covered int
+	for _, stat := range ComputeFileCoverage(profiles) {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", stat.FileName, stat.Statements, stat.Covered, formatPercent(stat.Covered, stat.Statements))
+		statements += stat.Statements
+		covered += stat.Covered
+	}
+	fmt.Fprintf(w, "total\t%d\t%d\t%s\n", statements, covered, formatPercent(covered, statements))
+	return w.Flush()
+}
+
+func formatRunStatus(bInvalid bool) string {
+	if bInvalid {
+		return "invalid"
+	}
+	return "valid"
+}
+
+// runs invalidate: 把运行标记为无效, 之后的 rebuild 和 stale 不再使用它, 数据本身保留
+func runRunsInvalidate(args []string) error {
+	return setRunsInvalid("runs invalidate", args, true)
+}
+
+// runs restore: 撤销无效标记
+func runRunsRestore(args []string) error {
+	return setRunsInvalid("runs restore", args, false)
+}
+
+func setRunsInvalid(name string, args []string, bInvalid bool) error {
+	fs := NewSubCommandFlagSet(name, "[options] run-id ...")
+	strDB := fs.String("db", "cover.db", "覆盖率历史库(SQLite)")
+	strReason := fs.String("reason", "", "原因, 记录在审计日志中(必填)")
+	strBy := fs.String("by", os.Getenv("USER"), "操作人, 记录在审计日志中")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("Error: run id required.")
+	}
+	if *strReason == "" {
+		return fmt.Errorf("Error: -reason required.")
+	}
+	if *strBy == "" {
+		*strBy = "unknown"
+	}
+
+	store, err := OpenStore(*strDB)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	for _, arg := range fs.Args() {
+		runID, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return fmt.Errorf("run id %q is not valid", arg)
+		}
+		if err := store.SetRunInvalid(runID, bInvalid, *strReason, *strBy); err != nil {
+			return err
+		}
+		fmt.Println("run ", runID, " is ", formatRunStatus(bInvalid), " now.")
+	}
+	return nil
+}
+
+// runs audit: 查看运行状态修改的审计记录
+func runRunsAudit(args []string) error {
+	fs := NewSubCommandFlagSet("runs audit", "[options] [run-id]")
+	strDB := fs.String("db", "cover.db", "覆盖率历史库(SQLite)")
+	fs.Parse(args)
+
+	store, err := OpenStore(*strDB)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	query := "SELECT run_id, action, reason, actor, at FROM run_audit"
+	var queryArgs []interface{}
+	if fs.NArg() > 0 {
+		runID, err := strconv.ParseInt(fs.Arg(0), 10, 64)
+		if err != nil {
+			return fmt.Errorf("run id is not valid")
+		}
+		query += " WHERE run_id = ?"
+		queryArgs = append(queryArgs, runID)
+	}
+	rows, err := store.db.Query(query+" ORDER BY id", queryArgs...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "time\trun\taction\tby\treason")
+	for rows.Next() {
+		var runID, at int64
+		var action, reason, actor string
+		if err := rows.Scan(&runID, &action, &reason, &actor, &at); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", time.Unix(at, 0).Format("2006-01-02 15:04:05"), runID, action, actor, reason)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// rebuild: 从历史库中按条件选出运行, 重新生成合并后的覆盖率文件和 HTML 报告, 不需要原始文件
+func runRebuild(args []string) error {
+	fs := NewSubCommandFlagSet("rebuild", "[options]")
+	strDB := fs.String("db", "cover.db", "覆盖率历史库(SQLite)")
+	strHash := fs.String("hash", "", "只使用该 git hash 的运行")
+	strTags := fs.String("tags", "", "只使用带有这些标签的运行, 例如 env=staging")
+	strSince := fs.String("since", "", "只使用该时间之后的运行: 7d, 2006-01-02 或 unix 时间戳")
+	strUntil := fs.String("until", "", "只使用该时间之前的运行: 7d, 2006-01-02 或 unix 时间戳")
+	ShareGlobalFlags(fs)
+	fs.Parse(args)
+
+	filter, err := parseRunFilter(*strHash, *strTags, *strSince, *strUntil)
+	if err != nil {
+		return err
+	}
+	store, err := OpenStore(*strDB)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	runs, err := store.ListRuns(filter)
+	if err != nil {
+		return err
+	}
+	if len(runs) == 0 {
+		return fmt.Errorf("no stored runs match the filter")
+	}
+	fileInfos := make([]*CoverFileInfo, 0, len(runs))
+	for _, run := range runs {
+		profiles, err := store.RunProfiles(run.ID)
+		if err != nil {
+			return fmt.Errorf("failed to load run %d: %v", run.ID, err)
+		}
+		fileInfos = append(fileInfos, &CoverFileInfo{
+			Timestamp: run.Timestamp,
+			GitHash:   run.GitHash,
+			FileName:  run.Source,
+			Profiles:  profiles,
+			Tags:      run.Tags,
+		})
+	}
+
+	if err := Merge(fileInfos); err != nil {
+		return err
+	}
+	fmt.Println("rebuild from ", len(runs), " runs, generate ", *g_strOutCoverFile, " and ", *g_strOutHTMLFile, " ok.")
+	return nil
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/cover"
+)
+
+const hookMarker = "# installed by gocovmerge hook install"
+
+// hook install|run: 安装 git 钩子, 推送(或提交)前只测试改动的包, 检查新增代码行的覆盖率
+func runHook(args []string) error {
+	if len(args) == 0 || (args[0] != "install" && args[0] != "run") {
+		fmt.Println("Usage: ./bin/gocovmerge hook install [-type pre-push|pre-commit] [-base ref] [-min 80] [-force]")
+		fmt.Println("       ./bin/gocovmerge hook run [-base ref] [-min 80]")
+		return fmt.Errorf("Error: hook install or hook run required.")
+	}
+	if args[0] == "install" {
+		return runHookInstall(args[1:])
+	}
+	return runHookRun(args[1:])
+}
+
+func runHookInstall(args []string) error {
+	fs := NewSubCommandFlagSet("hook install", "[options]")
+	strType := fs.String("type", "pre-push", "钩子类型: pre-push 或 pre-commit")
+	strBase := fs.String("base", "", "比较的基准, pre-push 默认 @{upstream}, pre-commit 默认 HEAD")
+	fMin := fs.Float64("min", 80, "新增代码行的最低覆盖率(%)")
+	bForce := fs.Bool("force", false, "覆盖已有的(不是 gocovmerge 安装的)钩子")
+	fs.Parse(args)
+	if *strType != "pre-push" && *strType != "pre-commit" {
+		return fmt.Errorf("unsupported hook type '%s'", *strType)
+	}
+	if *strBase == "" {
+		*strBase = "@{upstream}"
+		if *strType == "pre-commit" {
+			*strBase = "HEAD"
+		}
+	}
+
+	out, err := GitCommand("rev-parse", "--git-path", "hooks").Output()
+	if err != nil {
+		return fmt.Errorf("not a git repository")
+	}
+	hookFile := filepath.Join(strings.TrimSpace(string(out)), *strType)
+	if content, err := os.ReadFile(hookFile); err == nil && !bytes.Contains(content, []byte(hookMarker)) && !*bForce {
+		return fmt.Errorf("hook %s already exists, use -force to overwrite", hookFile)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	script := fmt.Sprintf("#!/bin/sh\n%s\nexec %s hook run -base %s -min %s\n", hookMarker,
+		strconv.Quote(exe), shellQuote(*strBase), strconv.FormatFloat(*fMin, 'f', -1, 64))
+	if err := os.MkdirAll(filepath.Dir(hookFile), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(hookFile, []byte(script), 0755); err != nil {
+		return err
+	}
+	fmt.Println("install ", hookFile, " ok.")
+	return nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func runHookRun(args []string) error {
+	fs := NewSubCommandFlagSet("hook run", "[options]")
+	strBase := fs.String("base", "@{upstream}", "比较的基准, 检查基准之后(包括未提交的)新增的代码行")
+	fMin := fs.Float64("min", 80, "新增代码行的最低覆盖率(%)")
+	fs.Parse(args)
+
+	topLevel, err := GitCommand("rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return fmt.Errorf("not a git repository")
+	}
+	if err := os.Chdir(strings.TrimSpace(string(topLevel))); err != nil {
+		return err
+	}
+	mergeBase, err := GitCommand("merge-base", *strBase, "HEAD").Output()
+	if err != nil {
+		return fmt.Errorf("no merge base between %s and HEAD", *strBase)
+	}
+
+	added, err := DiffAddedLines(strings.TrimSpace(string(mergeBase)))
+	if err != nil {
+		return err
+	}
+	if len(added) == 0 {
+		fmt.Println("no changed Go code.")
+		return nil
+	}
+
+	// 改动的包, 只测试这些包
+	dirs := make(map[string]bool)
+	for filePath := range added {
+		dirs[filepath.Dir(filePath)] = true
+	}
+	profiles, err := TestPackagesCached(dirs)
+	if err != nil {
+		return err
+	}
+
+	var covered, total int
+	filePaths := make([]string, 0, len(added))
+	for filePath := range added {
+		filePaths = append(filePaths, filePath)
+	}
+	sort.Strings(filePaths)
+	for _, filePath := range filePaths {
+		p := profiles[filePath]
+		if p == nil {
+			continue
+		}
+		lines := profileLineCounts(p)
+		var uncovered []string
+		for _, line := range added[filePath] {
+			count, ok := lines[line]
+			if !ok {
+				continue
+			}
+			total++
+			if count > 0 {
+				covered++
+			} else {
+				uncovered = append(uncovered, strconv.Itoa(line))
+			}
+		}
+		if len(uncovered) > 0 {
+			fmt.Printf("%s: uncovered lines %s\n", filePath, strings.Join(uncovered, ","))
+		}
+	}
+	if total == 0 {
+		fmt.Println("no new statements.")
+		return nil
+	}
+	percent := float64(covered) * 100 / float64(total)
+	fmt.Printf("patch coverage: %.1f%% (%d/%d lines), required %.1f%%\n", percent, covered, total, *fMin)
+	if percent < *fMin {
+		return fmt.Errorf("patch coverage %.1f%% is below %.1f%%", percent, *fMin)
+	}
+	return nil
+}
+
+// 相对 base 新增的 Go 代码行(包括未提交的改动), 测试文件除外: 文件路径 -> 行号
+func DiffAddedLines(base string) (map[string][]int, error) {
+	out, err := GitCommand("diff", "--no-color", "--no-ext-diff", "-U0", base, "--", "*.go").Output()
+	if err != nil {
+		return nil, fmt.Errorf("git diff %s: %v", base, err)
+	}
+	added := make(map[string][]int)
+	filePath := ""
+	headLine := 0
+	s := bufio.NewScanner(bytes.NewReader(out))
+	s.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for s.Scan() {
+		line := s.Text()
+		switch {
+		case strings.HasPrefix(line, "+++ "):
+			filePath = strings.TrimPrefix(strings.TrimPrefix(line, "+++ "), "b/")
+			if filePath == "/dev/null" || strings.HasSuffix(filePath, "_test.go") {
+				filePath = ""
+			}
+		case strings.HasPrefix(line, "--- "), strings.HasPrefix(line, "diff "), strings.HasPrefix(line, "index "):
+		case strings.HasPrefix(line, "@@"):
+			headLine = parseHunkHeadStart(line)
+		case strings.HasPrefix(line, "+"):
+			if filePath != "" {
+				added[filePath] = append(added[filePath], headLine)
+			}
+			headLine++
+		case strings.HasPrefix(line, "-"), strings.HasPrefix(line, "\\"):
+		default:
+			headLine++
+		}
+	}
+	return added, s.Err()
+}
+
+// 测试改动的包并返回覆盖率: 仓库内的文件路径 -> 覆盖率.
+// 按包和它依赖的仓库内的包的目录下所有文件以及 go.mod/go.sum 的内容缓存每个包的结果, 都没有改动的包不再重新测试
+func TestPackagesCached(dirs map[string]bool) (map[string]*cover.Profile, error) {
+	out, err := GitCommand("rev-parse", "--git-path", "gocovmerge-cache").Output()
+	if err != nil {
+		return nil, err
+	}
+	cacheDir := strings.TrimSpace(string(out))
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return nil, err
+	}
+
+	profiles := make(map[string]*cover.Profile)
+	var uncached []string
+	cacheFiles := make(map[string]string)  // 包的导入路径 -> 缓存文件
+	importPaths := make(map[string]string) // 包目录 -> 导入路径
+	for dir := range dirs {
+		if _, err := os.Stat(dir); err != nil {
+			continue // 包被删除
+		}
+		importPath, err := exec.Command("go", "list", "-f", "{{.ImportPath}}", "./"+filepath.ToSlash(dir)).Output()
+		if err != nil {
+			continue // 没有可编译的 Go 文件
+		}
+		importPaths[dir] = strings.TrimSpace(string(importPath))
+		key, err := packageCacheKey(dir)
+		if err != nil {
+			return nil, err
+		}
+		cacheFile := filepath.Join(cacheDir, key+".out")
+		cacheFiles[importPaths[dir]] = cacheFile
+		if _, err := os.Stat(cacheFile); err != nil {
+			uncached = append(uncached, "./"+filepath.ToSlash(dir))
+		}
+	}
+
+	if len(uncached) > 0 {
+		sort.Strings(uncached)
+		tmpFile, err := os.CreateTemp("", "gocovmerge-hook-*.out")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create temp file: %w", err)
+		}
+		tmpFile.Close()
+		defer os.Remove(tmpFile.Name())
+		cmd := exec.Command("go", append([]string{"test", "-coverprofile=" + tmpFile.Name()}, uncached...)...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("go test failed: %w", err)
+		}
+		tested, err := cover.ParseProfiles(tmpFile.Name())
+		if err != nil {
+			return nil, err
+		}
+		// 按包拆开写入缓存, 没有测试的包写入空文件
+		byPackage := make(map[string][]*cover.Profile)
+		for _, p := range tested {
+			byPackage[path.Dir(p.FileName)] = append(byPackage[path.Dir(p.FileName)], p)
+		}
+		for _, dir := range uncached {
+			importPath := importPaths[filepath.FromSlash(strings.TrimPrefix(dir, "./"))]
+			var buf bytes.Buffer
+			if err := DumpProfiles(byPackage[importPath], &buf); err != nil {
+				return nil, err
+			}
+			if err := os.WriteFile(cacheFiles[importPath], buf.Bytes(), 0644); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for dir, importPath := range importPaths {
+		data, err := os.ReadFile(cacheFiles[importPath])
+		if err != nil {
+			return nil, err
+		}
+		if len(data) == 0 {
+			continue
+		}
+		packageProfiles, err := ParseProfilesBytes(data)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range packageProfiles {
+			profiles[filepath.Join(dir, path.Base(p.FileName))] = p
+		}
+	}
+	return profiles, nil
+}
+
+// 包的缓存 key: 包和它(包括测试)依赖的仓库内的包的目录下所有文件(不递归), 以及 go.mod, go.sum 的内容.
+// 依赖的包改动后覆盖率也可能不同, 不能命中旧的缓存
+func packageCacheKey(dir string) (string, error) {
+	h := sha256.New()
+	files := []string{"go.mod", "go.sum"}
+	for _, depDir := range packageDepDirs(dir) {
+		entries, err := os.ReadDir(depDir)
+		if err != nil {
+			return "", err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				files = append(files, filepath.Join(depDir, entry.Name()))
+			}
+		}
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s %d\n", file, len(data))
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// 包和它(包括测试)依赖的仓库内的包的目录, 相对当前目录, 已排序. 标准库和模块缓存中的依赖不在仓库内;
+// go list 失败时只有包自己的目录
+func packageDepDirs(dir string) []string {
+	out, err := exec.Command("go", "list", "-deps", "-test", "-f", "{{if not .Standard}}{{.Dir}}{{end}}", "./"+filepath.ToSlash(dir)).Output()
+	if err != nil {
+		return []string{dir}
+	}
+	root, err := os.Getwd()
+	if err != nil {
+		return []string{dir}
+	}
+	seen := map[string]bool{filepath.Clean(dir): true}
+	for _, depDir := range strings.Split(string(out), "\n") {
+		if depDir = strings.TrimSpace(depDir); depDir == "" {
+			continue
+		}
+		rel, err := filepath.Rel(root, depDir)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		seen[rel] = true
+	}
+	dirs := make([]string, 0, len(seen))
+	for depDir := range seen {
+		dirs = append(dirs, depDir)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// 每段的行数: 段是浏览器布局的单位, 不在屏幕内的段跳过渲染(content-visibility)
+const htmlChunkLines = 200
+
+// 一行中的一段代码: 文本, 以及所在的着色 span(没有时为空)
+type htmlLineSegment struct {
+	text string
+	span *html.Node
+}
+
+// 给报告中每个文件的源码加上行号并按行分段. 跨行的着色 span 按行拆开,
+// 每段是一个 display: block 的 span, 很大的文件只渲染屏幕内的部分.
+// 覆盖状态不只用颜色表示: 着色 span 加上 covered/uncovered 类, 行号带有该行状态的标记和屏幕阅读器读出的文字
+func NumberReportLines(doc *html.Node) {
+	var pres []*html.Node
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.DataAtom == atom.Pre && strings.Contains(" "+HTMLAttr(n, "class")+" ", " file ") {
+			pres = append(pres, n)
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	for _, pre := range pres {
+		numberPreLines(pre)
+	}
+}
+
+func numberPreLines(pre *html.Node) {
+	lines := [][]htmlLineSegment{nil}
+	addText := func(text string, span *html.Node) {
+		for i, part := range strings.Split(text, "\n") {
+			if i > 0 {
+				lines = append(lines, nil)
+			}
+			if part != "" {
+				last := len(lines) - 1
+				lines[last] = append(lines[last], htmlLineSegment{text: part, span: span})
+			}
+		}
+	}
+	for c := pre.FirstChild; c != nil; c = c.NextSibling {
+		switch c.Type {
+		case html.TextNode:
+			addText(c.Data, nil)
+		case html.ElementNode:
+			addText(htmlText(c), c)
+		}
+	}
+	// 以换行结束时最后是一个空行, 不编号
+	if len(lines) > 1 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+
+	for c := pre.FirstChild; c != nil; c = pre.FirstChild {
+		pre.RemoveChild(c)
+	}
+	width := len(strconv.Itoa(len(lines)))
+	// 行号后面还有一个字符的状态标记
+	style := fmt.Sprintf("--line-number-width: %dch; --chunk-height: %.1fem", width+2, float64(htmlChunkLines)*1.2)
+	if old := strings.TrimSuffix(strings.TrimSpace(HTMLAttr(pre, "style")), ";"); old != "" {
+		style = old + "; " + style
+	}
+	setHTMLAttr(pre, "style", style)
+
+	var chunk *html.Node
+	for i, line := range lines {
+		if i%htmlChunkLines == 0 {
+			chunk = &html.Node{Type: html.ElementNode, Data: "span", DataAtom: atom.Span, Attr: []html.Attribute{{Key: "class", Val: "chunk"}}}
+			pre.AppendChild(chunk)
+		}
+		status := lineCoverStatus(line)
+		number := &html.Node{Type: html.ElementNode, Data: "span", DataAtom: atom.Span, Attr: []html.Attribute{{Key: "class", Val: strings.TrimSpace("line-number " + status.class)}}}
+		number.AppendChild(&html.Node{Type: html.TextNode, Data: strconv.Itoa(i + 1)})
+		if status.label != "" {
+			label := &html.Node{Type: html.ElementNode, Data: "span", DataAtom: atom.Span, Attr: []html.Attribute{{Key: "class", Val: "sr-only"}}}
+			label.AppendChild(&html.Node{Type: html.TextNode, Data: " " + status.label + " "})
+			number.AppendChild(label)
+		}
+		chunk.AppendChild(number)
+		for _, segment := range line {
+			text := &html.Node{Type: html.TextNode, Data: segment.text}
+			if segment.span == nil {
+				chunk.AppendChild(text)
+				continue
+			}
+			span := &html.Node{Type: html.ElementNode, Data: segment.span.Data, DataAtom: segment.span.DataAtom, Attr: append([]html.Attribute(nil), segment.span.Attr...)}
+			if count, ok := spanCoverCount(segment.span); ok {
+				semantic := "covered"
+				if count == 0 {
+					semantic = "uncovered"
+				}
+				setHTMLAttr(span, "class", HTMLAttr(span, "class")+" "+semantic)
+			}
+			span.AppendChild(text)
+			chunk.AppendChild(span)
+		}
+		if i < len(lines)-1 {
+			chunk.AppendChild(&html.Node{Type: html.TextNode, Data: "\n"})
+		}
+	}
+}
+
+// 一行的覆盖状态: 行号的类和读出的文字, 没有语句的行都为空
+type lineStatus struct {
+	class string
+	label string
+}
+
+func lineCoverStatus(line []htmlLineSegment) lineStatus {
+	bCovered, bUncovered := false, false
+	for _, segment := range line {
+		if segment.span == nil || strings.TrimSpace(segment.text) == "" {
+			continue
+		}
+		if count, ok := spanCoverCount(segment.span); ok {
+			bCovered = bCovered || count > 0
+			bUncovered = bUncovered || count == 0
+		}
+	}
+	switch {
+	case bCovered && bUncovered:
+		return lineStatus{class: "line-partial", label: "partially covered"}
+	case bUncovered:
+		return lineStatus{class: "line-uncovered", label: "not covered"}
+	case bCovered:
+		return lineStatus{class: "line-covered", label: "covered"}
+	}
+	return lineStatus{}
+}
+
+// go tool cover 着色 span 的等级 covN(0 为未覆盖, 1-10 按计数), 不是着色 span 时 ok 为 false
+func spanCoverCount(span *html.Node) (int, bool) {
+	for _, class := range strings.Fields(HTMLAttr(span, "class")) {
+		if strings.HasPrefix(class, "cov") {
+			if n, err := strconv.Atoi(strings.TrimPrefix(class, "cov")); err == nil {
+				return n, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// 元素内的全部文本
+func htmlText(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var sb strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		sb.WriteString(htmlText(c))
+	}
+	return sb.String()
+}
+
+func setHTMLAttr(n *html.Node, key string, val string) {
+	for i, attr := range n.Attr {
+		if attr.Namespace == "" && attr.Key == key {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
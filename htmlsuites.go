@@ -0,0 +1,69 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"flag"
+	"html"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/cover"
+)
+
+var g_strHTMLSuites = flag.String("html-suites", "", "在 HTML 报告中按该标签(如 suite)的值添加复选框, 可以只显示部分套件的覆盖情况(为空不添加)")
+
+// 若指定了 -html-suites, 每个标签值合并后的覆盖率, 由 Merge 填充, 生成 HTML 时写入报告
+var g_htmlSuites map[string][]*cover.Profile
+
+// 写入 HTML 的套件覆盖数据: 文件名(带 git hash) -> 套件 -> 块 [startLine, startCol, endLine, endCol, covered]
+type suiteCoverageData struct {
+	Suites []string                       `json:"suites"`
+	Files  map[string]map[string][][5]int `json:"files"`
+}
+
+// 生成套件复选框和重新着色的脚本, 没有套件数据时返回空
+func SuiteToggleHTML(suites map[string][]*cover.Profile) (string, error) {
+	if len(suites) == 0 {
+		return "", nil
+	}
+	data := suiteCoverageData{Files: make(map[string]map[string][][5]int)}
+	for suite, profiles := range suites {
+		data.Suites = append(data.Suites, suite)
+		for _, p := range profiles {
+			if data.Files[p.FileName] == nil {
+				data.Files[p.FileName] = make(map[string][][5]int)
+			}
+			blocks := make([][5]int, 0, len(p.Blocks))
+			for _, b := range p.Blocks {
+				covered := 0
+				if b.Count > 0 {
+					covered = 1
+				}
+				blocks = append(blocks, [5]int{b.StartLine, b.StartCol, b.EndLine, b.EndCol, covered})
+			}
+			data.Files[p.FileName][suite] = blocks
+		}
+	}
+	sort.Strings(data.Suites)
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+
+	var checkboxes strings.Builder
+	for _, suite := range data.Suites {
+		checkboxes.WriteString(`<label><input class="suite-toggle" type="checkbox" value="` + html.EscapeString(suite) +
+			`" checked onchange="applySuites()"> ` + html.EscapeString(suite) + `</label> `)
+	}
+	// 防止数据中的 </script> 提前结束脚本
+	strData := strings.ReplaceAll(string(jsonData), "</", `<\/`)
+	return `<script>var g_suiteCoverage = ` + strData + `;</script>` + g_suiteToggleHTML +
+		`<span id="suites">` + checkboxes.String() + `</span>`, nil
+}
+
+// 按选中的套件重新着色: 全部选中时恢复原始报告, 否则每段代码按覆盖它的最内层块着色,
+// 任一选中的套件覆盖即为覆盖, 选中的套件都没有该块时不着色
+//
+//go:embed assets/suites.html
+var g_suiteToggleHTML string
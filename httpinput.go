@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+var (
+	g_durHTTPTimeout = flag.Duration("http-timeout", 60*time.Second, "下载 http(s) 输入的超时时间")
+	g_nHTTPRetries   = flag.Int("http-retries", 3, "下载 http(s) 输入失败(网络错误或 5xx)时的重试次数")
+)
+
+func IsHTTPInput(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// 下载 http(s) 输入, 版本信息来自 URL 路径的最后一段(如 .../cover.txt.1723042827.e24dac6)
+func ParseHTTPInput(strURL string) ([]*CoverFileInfo, error) {
+	u, err := url.Parse(strURL)
+	if err != nil {
+		return nil, fmt.Errorf("url %s is not valid: %v", strURL, err)
+	}
+	data, err := FetchURL(strURL)
+	if err != nil {
+		return nil, err
+	}
+	return ParseFetchedInput(strURL, u.Path, data)
+}
+
+// 解析已经下载到内存的输入: name(如 URL 的路径)提供版本信息, 压缩包写到临时文件后读取其中的覆盖率文件,
+// FileName 使用 source(如 URL)
+func ParseFetchedInput(source string, name string, data []byte) ([]*CoverFileInfo, error) {
+	if IsArchive(name) {
+		tmpFile, err := os.CreateTemp("", "gocovmerge-*-"+path.Base(name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create temp file: %w", err)
+		}
+		defer os.Remove(tmpFile.Name())
+		_, err = tmpFile.Write(data)
+		if closeErr := tmpFile.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to write temp file: %w", err)
+		}
+		fileInfos, err := ParseArchiveInputs(tmpFile.Name())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", source, err)
+		}
+		for _, fileInfo := range fileInfos {
+			fileInfo.FileName = source + strings.TrimPrefix(fileInfo.FileName, tmpFile.Name())
+		}
+		return fileInfos, nil
+	}
+
+	fileInfo, err := ParseCoverFileInfoFrom(name, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse version profiles %s: %v", source, err)
+	}
+	fileInfo.FileName = source
+	return []*CoverFileInfo{fileInfo}, nil
+}
+
+// 下载 URL 的内容, 网络错误和 5xx 按 -http-retries 重试, 间隔从 1 秒开始翻倍
+func FetchURL(strURL string) ([]byte, error) {
+	client := &http.Client{Timeout: *g_durHTTPTimeout}
+	backoff := time.Second
+	for i := 0; ; i++ {
+		data, bRetry, err := fetchURLOnce(client, strURL)
+		if err == nil {
+			return data, nil
+		}
+		if !bRetry || i >= *g_nHTTPRetries {
+			return nil, err
+		}
+		// URL 中可能带有预签名的签名或 token
+		fmt.Println(Redact(fmt.Sprint("fetch ", strURL, " failed: ", err, ", retry in ", backoff)))
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func fetchURLOnce(client *http.Client, strURL string) (data []byte, bRetry bool, err error) {
+	req, err := http.NewRequest(http.MethodGet, strURL, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := AuthorizeRequest(req, "http"); err != nil {
+		return nil, false, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to fetch %s: %v", strURL, err)
+	}
+	defer resp.Body.Close()
+	data, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to fetch %s: %v", strURL, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode >= 500, fmt.Errorf("failed to fetch %s: %s", strURL, resp.Status)
+	}
+	return data, false, nil
+}
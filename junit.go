@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"os"
+
+	"golang.org/x/tools/cover"
+)
+
+var (
+	g_strOutJUnit = flag.String("outjunit", "", "输出 JUnit XML, 每个包是一个测试用例, 覆盖率低于 -junit-min 时失败(为空不输出)")
+	g_fJUnitMin   = flag.Float64("junit-min", 0, "JUnit XML 中每个包的最低覆盖率(%), 为 0 时使用 -min-coverage")
+)
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// 写出 JUnit XML: 测试套件 coverage, 每个包一个用例(classname 为 -groups 的分组), profiles 为每个文件的最新版本
+func WriteJUnit(fileName string, profiles []*cover.Profile) error {
+	stats, err := ComputePackageCoverage(profiles)
+	if err != nil {
+		return err
+	}
+	fMin := *g_fJUnitMin
+	if fMin == 0 {
+		fMin = *g_fMinCoverage
+	}
+	suite := junitTestSuite{Name: "coverage", Tests: len(stats), Time: "0"}
+	for _, stat := range stats {
+		testCase := junitTestCase{
+			Name:      stat.ImportPath,
+			ClassName: "coverage",
+			Time:      "0",
+			SystemOut: fmt.Sprintf("%.1f%% of statements covered (%d/%d) in %d files", stat.Percent, stat.Covered, stat.Statements, stat.Files),
+		}
+		if stat.Group != "" {
+			testCase.ClassName = "coverage." + stat.Group
+		}
+		if stat.Statements > 0 && stat.Percent < fMin {
+			message := fmt.Sprintf("coverage %.1f%% is below %.1f%%", stat.Percent, fMin)
+			testCase.Failure = &junitFailure{Message: message, Type: "coverage", Text: message}
+			suite.Failures++
+		}
+		suite.Cases = append(suite.Cases, testCase)
+	}
+
+	outFile, upload, err := LocalOutput(fileName)
+	if err != nil {
+		return err
+	}
+	data, err := xml.MarshalIndent(junitTestSuites{Suites: []junitTestSuite{suite}}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(outFile, append([]byte(xml.Header), append(data, '\n')...), 0644); err != nil {
+		return err
+	}
+	return upload()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/cover"
+)
+
+// 解析 LCOV tracefile(geninfo/lcov 输出的 .info), 每个 DA:<line>,<hits> 与 Cobertura 一样转换为覆盖整行的块,
+// mode 为 count. 同一文件的多条记录(如多个测试名 TN)命中次数相加, 文件名保持 SF: 的原样
+func ParseLCOV(r io.Reader) ([]*cover.Profile, error) {
+	files := make(map[string]map[int]int) // 文件 -> 行号 -> 命中次数
+	var lines map[int]int
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for lineNo := 1; s.Scan(); lineNo++ {
+		line := strings.TrimSpace(s.Text())
+		switch {
+		case strings.HasPrefix(line, "SF:"):
+			fileName := strings.TrimPrefix(line, "SF:")
+			if files[fileName] == nil {
+				files[fileName] = make(map[int]int)
+			}
+			lines = files[fileName]
+		case strings.HasPrefix(line, "DA:"):
+			if lines == nil {
+				return nil, fmt.Errorf("invalid lcov line %d: DA outside of a SF record", lineNo)
+			}
+			fields := strings.Split(strings.TrimPrefix(line, "DA:"), ",")
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("invalid lcov line %d: %q", lineNo, line)
+			}
+			number, err := strconv.Atoi(fields[0])
+			if err != nil || number <= 0 {
+				return nil, fmt.Errorf("invalid lcov line %d: bad line number %q", lineNo, fields[0])
+			}
+			// 部分工具对非常大的计数输出浮点数, 如 1.5e+10
+			hits, err := strconv.ParseFloat(fields[1], 64)
+			if err != nil || hits < 0 {
+				return nil, fmt.Errorf("invalid lcov line %d: bad hit count %q", lineNo, fields[1])
+			}
+			lines[number] = AddCount(lines[number], floatCount(hits))
+		case line == "end_of_record":
+			lines = nil
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	profiles := make([]*cover.Profile, 0, len(files))
+	for fileName, lines := range files {
+		p := &cover.Profile{FileName: fileName, Mode: "count"}
+		for number, hits := range lines {
+			p.Blocks = append(p.Blocks, cover.ProfileBlock{
+				StartLine: number,
+				StartCol:  1,
+				EndLine:   number + 1,
+				EndCol:    1,
+				NumStmt:   1,
+				Count:     hits,
+			})
+		}
+		sort.Slice(p.Blocks, func(i, j int) bool { return p.Blocks[i].StartLine < p.Blocks[j].StartLine })
+		profiles = append(profiles, p)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].FileName < profiles[j].FileName })
+	return profiles, nil
+}
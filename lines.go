@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/cover"
+)
+
+var g_strOutLines = flag.String("outlines", "", "输出按行的覆盖率, .jsonl 每行一个 JSON, .json 输出一个数组(为空不输出)")
+
+// 按行的覆盖率, 由块展开: 块覆盖的每一行都算, count 为覆盖该行的块中的最大计数
+type LineRow struct {
+	FileName string `json:"file"`
+	GitHash  string `json:"git_hash"`
+	Line     int    `json:"line"`
+	Covered  bool   `json:"covered"`
+	Count    int    `json:"count"`
+	Group    string `json:"group,omitempty"`
+}
+
+// 把覆盖率展开成行, 按文件和行号排序
+func ProfileLines(p *cover.Profile, gitHash string) []LineRow {
+	counts := make(map[int]int)
+	for _, b := range p.Blocks {
+		for line := b.StartLine; line <= b.EndLine; line++ {
+			if count, ok := counts[line]; !ok || b.Count > count {
+				counts[line] = b.Count
+			}
+		}
+	}
+	rows := make([]LineRow, 0, len(counts))
+	group := g_packageGroups.GroupOf(p.FileName)
+	for line, count := range counts {
+		rows = append(rows, LineRow{FileName: p.FileName, GitHash: gitHash, Line: line, Covered: count > 0, Count: count, Group: group})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Line < rows[j].Line })
+	return rows
+}
+
+// 写出每个版本合并后的按行覆盖率, 文件名不带 git hash 后缀, 版本信息在 git_hash 字段
+func WriteLines(fileName string, mergedByHash map[string][]*cover.Profile) error {
+	outFile, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("error creating outFile: %v", err)
+	}
+	defer outFile.Close()
+	w := bufio.NewWriter(outFile)
+	enc := json.NewEncoder(w)
+	bArray := strings.HasSuffix(fileName, ".json")
+
+	gitHashes := make([]string, 0, len(mergedByHash))
+	for gitHash := range mergedByHash {
+		gitHashes = append(gitHashes, gitHash)
+	}
+	sort.Strings(gitHashes)
+
+	if bArray {
+		w.WriteString("[")
+	}
+	bFirst := true
+	for _, gitHash := range gitHashes {
+		for _, p := range mergedByHash[gitHash] {
+			for _, row := range ProfileLines(p, gitHash) {
+				if bArray && !bFirst {
+					w.WriteString(",")
+				}
+				bFirst = false
+				if err := enc.Encode(row); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if bArray {
+		w.WriteString("]\n")
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return outFile.Close()
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/tools/cover"
+)
+
+var (
+	g_strLuaCov     = flag.String("luacov", "", "内嵌 Lua 脚本的 LuaCov 统计文件(luacov.stats.out), 多个用逗号分隔, 命中次数相加, 在 HTML 报告中单独列出并计入汇总")
+	g_strLuaPathMap = flag.String("lua-path-map", "", "LuaCov 中脚本路径的前缀替换, 格式 from=to,..., 如 /srv/game/=scripts/, 替换后为相对 -lua-root 的路径")
+	g_strLuaRoot    = flag.String("lua-root", ".", "Lua 脚本源码所在的目录, 用于判断可执行的行和在报告中显示源码")
+)
+
+// 合并后的一个 Lua 脚本
+type LuaScript struct {
+	Path   string      // 映射后的路径
+	Hits   map[int]int // 行号 -> 命中次数
+	Source []string    // 源码的每一行, 找不到源码时为空
+}
+
+// 由 -luacov 加载的脚本, 按路径排序, 没有指定时为空
+var g_luaScripts []*LuaScript
+
+// 解析 LuaCov 统计文件: 每个脚本两行, "<行数>:<脚本名>" 和空格分隔的每行命中次数
+func ParseLuaCovStats(r io.Reader) (map[string]map[int]int, error) {
+	scripts := make(map[string]map[int]int)
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for lineNo := 1; s.Scan(); lineNo++ {
+		header := strings.TrimSpace(s.Text())
+		if header == "" {
+			continue
+		}
+		strMax, name, ok := strings.Cut(header, ":")
+		maxLine, err := strconv.Atoi(strMax)
+		if !ok || err != nil || maxLine < 0 || name == "" {
+			return nil, fmt.Errorf("invalid luacov stats line %d: %q", lineNo, header)
+		}
+		if !s.Scan() {
+			return nil, fmt.Errorf("invalid luacov stats: no hit counts for %s", name)
+		}
+		lineNo++
+		fields := strings.Fields(s.Text())
+		if len(fields) > maxLine {
+			return nil, fmt.Errorf("invalid luacov stats line %d: %d hit counts for %d lines", lineNo, len(fields), maxLine)
+		}
+		hits := scripts[name]
+		if hits == nil {
+			hits = make(map[int]int)
+			scripts[name] = hits
+		}
+		for i, field := range fields {
+			n, err := strconv.Atoi(field)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid luacov stats line %d: bad hit count %q", lineNo, field)
+			}
+			hits[i+1] = AddCount(hits[i+1], n)
+		}
+	}
+	return scripts, s.Err()
+}
+
+// 把 LuaCov 中的脚本名映射为报告中的路径: 去掉 chunk 名的 @ 和开头的 ./, 再按 -lua-path-map 替换前缀(取最长的匹配)
+func mapLuaPath(name string, pathMap [][2]string) string {
+	name = strings.TrimPrefix(strings.TrimPrefix(filepath.ToSlash(name), "@"), "./")
+	best := -1
+	for i, m := range pathMap {
+		if strings.HasPrefix(name, m[0]) && (best < 0 || len(m[0]) > len(pathMap[best][0])) {
+			best = i
+		}
+	}
+	if best >= 0 {
+		name = pathMap[best][1] + strings.TrimPrefix(name, pathMap[best][0])
+	}
+	return name
+}
+
+func parseLuaPathMap(s string) ([][2]string, error) {
+	var pathMap [][2]string
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(item, "=")
+		if !ok || from == "" {
+			return nil, fmt.Errorf("lua path map %q is not in from=to form", item)
+		}
+		pathMap = append(pathMap, [2]string{filepath.ToSlash(from), filepath.ToSlash(to)})
+	}
+	return pathMap, nil
+}
+
+// 读取 -luacov 指定的统计文件, 映射路径后同一脚本的命中次数相加, 并读取源码
+func LoadLuaScripts(files string, strPathMap string, root string) ([]*LuaScript, error) {
+	pathMap, err := parseLuaPathMap(strPathMap)
+	if err != nil {
+		return nil, err
+	}
+	merged := make(map[string]*LuaScript)
+	for _, fileName := range strings.Split(files, ",") {
+		fileName = strings.TrimSpace(fileName)
+		if fileName == "" {
+			continue
+		}
+		f, err := os.Open(fileName)
+		if err != nil {
+			return nil, err
+		}
+		stats, err := ParseLuaCovStats(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", fileName, err)
+		}
+		for name, hits := range stats {
+			scriptPath := mapLuaPath(name, pathMap)
+			script := merged[scriptPath]
+			if script == nil {
+				script = &LuaScript{Path: scriptPath, Hits: make(map[int]int)}
+				merged[scriptPath] = script
+			}
+			for line, n := range hits {
+				script.Hits[line] = AddCount(script.Hits[line], n)
+			}
+		}
+	}
+	scripts := make([]*LuaScript, 0, len(merged))
+	for _, script := range merged {
+		data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(script.Path)))
+		if err != nil {
+			fmt.Println("warning: lua source", script.Path, "not found, every line in the stats counts as executable:", err)
+		} else {
+			script.Source = strings.Split(strings.TrimSuffix(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n"), "\n")
+		}
+		scripts = append(scripts, script)
+	}
+	sort.Slice(scripts, func(i, j int) bool { return scripts[i].Path < scripts[j].Path })
+	return scripts, nil
+}
+
+// LuaCov 的统计不区分不可执行的行(命中次数都是 0), 有源码时跳过空行, 注释和只有 end/else 等关键字或括号的行
+func isLuaExecutableLine(line string) bool {
+	line = strings.TrimSpace(line)
+	if i := strings.Index(line, "--"); i >= 0 {
+		line = strings.TrimSpace(line[:i])
+	}
+	switch strings.TrimRight(line, ",;") {
+	case "", "end", "else", "do", "then", "repeat", "}", ")", "]", "{", "(", "end)", "})", "end}":
+		return false
+	}
+	return true
+}
+
+// 可执行的行, 按行号排序
+func (script *LuaScript) Lines() []int {
+	var lines []int
+	if script.Source == nil {
+		for line := range script.Hits {
+			lines = append(lines, line)
+		}
+		sort.Ints(lines)
+		return lines
+	}
+	bBlockComment := false
+	for i, text := range script.Source {
+		trimmed := strings.TrimSpace(text)
+		// --[[ ... ]] 块注释, 简单处理: 块注释的开始和结束各占一行
+		if bBlockComment {
+			if strings.Contains(trimmed, "]]") {
+				bBlockComment = false
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "--[[") || strings.HasPrefix(trimmed, "--[=[") {
+			bBlockComment = !strings.Contains(trimmed, "]]")
+			continue
+		}
+		// 命中过的行总是可执行的
+		if script.Hits[i+1] > 0 || isLuaExecutableLine(text) {
+			lines = append(lines, i+1)
+		}
+	}
+	return lines
+}
+
+// 转换为 cover.Profile, 与 Cobertura 一样每个可执行的行是一个块, mode 为 count
+func (script *LuaScript) Profile() *cover.Profile {
+	p := &cover.Profile{FileName: script.Path, Mode: "count"}
+	for _, line := range script.Lines() {
+		p.Blocks = append(p.Blocks, cover.ProfileBlock{StartLine: line, StartCol: 1, EndLine: line + 1, EndCol: 1, NumStmt: 1, Count: script.Hits[line]})
+	}
+	return p
+}
+
+func LuaProfiles(scripts []*LuaScript) []*cover.Profile {
+	profiles := make([]*cover.Profile, 0, len(scripts))
+	for _, script := range scripts {
+		profiles = append(profiles, script.Profile())
+	}
+	return profiles
+}
+
+// Lua 脚本的总行数和已覆盖的行数
+func LuaTotals(scripts []*LuaScript) FileCoverage {
+	var total FileCoverage
+	for _, stat := range ComputeFileCoverage(LuaProfiles(scripts)) {
+		total.Statements += stat.Statements
+		total.Covered += stat.Covered
+	}
+	return total
+}
+
+// 在 HTML 报告的文件列表中加上 "Lua scripts" 分组, 每个有源码的脚本一个 <pre class="file">, 着色方式与 Go 文件相同.
+// 找不到源码的脚本只计入汇总
+func InsertLuaScripts(doc *html.Node, scripts []*LuaScript) error {
+	if len(scripts) == 0 {
+		return nil
+	}
+	fileSelect := FindElement(doc, func(n *html.Node) bool { return n.DataAtom == atom.Select && HTMLAttr(n, "id") == "files" })
+	var lastPre *html.Node
+	count := 0
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.DataAtom == atom.Pre && strings.Contains(" "+HTMLAttr(n, "class")+" ", " file ") {
+			lastPre = n
+			count++
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	if fileSelect == nil || lastPre == nil {
+		return fmt.Errorf("no file list in the report to add lua scripts to, unsupported go tool cover output")
+	}
+
+	var group *html.Node
+	insertAfter := lastPre
+	for _, script := range scripts {
+		if script.Source == nil {
+			continue
+		}
+		if group == nil {
+			group = &html.Node{Type: html.ElementNode, Data: "optgroup", DataAtom: atom.Optgroup, Attr: []html.Attribute{{Key: "label", Val: "Lua scripts"}}}
+			fileSelect.AppendChild(group)
+		}
+		id := fmt.Sprintf("file%d", count)
+		count++
+		p := script.Profile()
+		stat := ComputeFileCoverage([]*cover.Profile{p})[0]
+		option := &html.Node{Type: html.ElementNode, Data: "option", DataAtom: atom.Option, Attr: []html.Attribute{{Key: "value", Val: id}}}
+		option.AppendChild(&html.Node{Type: html.TextNode, Data: fmt.Sprintf("%s (%.1f%%)", script.Path, stat.Percent())})
+		group.AppendChild(option)
+
+		executable := make(map[int]bool)
+		for _, line := range script.Lines() {
+			executable[line] = true
+		}
+		pre := &html.Node{Type: html.ElementNode, Data: "pre", DataAtom: atom.Pre, Attr: []html.Attribute{{Key: "class", Val: "file"}, {Key: "id", Val: id}, {Key: "style", Val: "display: none"}}}
+		for i, text := range script.Source {
+			if i > 0 {
+				pre.AppendChild(&html.Node{Type: html.TextNode, Data: "\n"})
+			}
+			if !executable[i+1] || text == "" {
+				pre.AppendChild(&html.Node{Type: html.TextNode, Data: text})
+				continue
+			}
+			hits := script.Hits[i+1]
+			class := "cov0"
+			if hits > 0 {
+				class = "cov8"
+			}
+			span := &html.Node{Type: html.ElementNode, Data: "span", DataAtom: atom.Span, Attr: []html.Attribute{{Key: "class", Val: class}, {Key: "title", Val: strconv.Itoa(hits)}}}
+			span.AppendChild(&html.Node{Type: html.TextNode, Data: text})
+			pre.AppendChild(span)
+		}
+		insertAfter.Parent.InsertBefore(pre, insertAfter.NextSibling)
+		insertAfter = pre
+	}
+	return nil
+}
+
+// 打印 Lua 脚本的汇总
+func PrintLuaSummary(scripts []*LuaScript) {
+	total := LuaTotals(scripts)
+	fmt.Printf("lua: %.1f%% of lines covered (%d/%d) in %d scripts\n", total.Percent(), total.Covered, total.Statements, len(scripts))
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+)
+
+// 不支持 mmap 的平台直接读入整个文件
+func MmapFile(fileName string) (data []byte, unmap func() error, err error) {
+	data, err = os.ReadFile(fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// 工作区中的 Go 模块: 覆盖率中以 Path 开头的文件在 git 仓库的 RelDir 目录下
+type goModule struct {
+	Path   string
+	Dir    string // 磁盘上的目录, 没有工作区时为空
+	RelDir string // 相对仓库根目录的目录, 根目录为空
+}
+
+// go list -m 找到的模块, 按模块名从长到短排序, 以便嵌套的模块优先匹配
+var g_goModules []goModule
+
+// 仓库根目录的 go.work, 没有时为 off. 执行 go 命令时作为 GOWORK, 不受外部 GOWORK 环境变量影响
+var g_strGoWork = "off"
+
+// 用 go list -m 在工作区中查找主模块, 根目录有 go.work 时为其中所有的模块. 没有 go 命令或不是模块时没有模块,
+// 文件按 go/src/<file> 查找; 没有工作区(裸仓库)时读取最新提交根目录的 go.work 或 go.mod
+func LoadGoModules() {
+	g_goModules, g_strGoWork = nil, "off"
+	dir := WorkTreeDir()
+	if dir == "" {
+		g_goModules = gitGoModules("HEAD")
+		sort.SliceStable(g_goModules, func(i, j int) bool { return len(g_goModules[i].Path) > len(g_goModules[j].Path) })
+		return
+	}
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return
+	}
+	if _, err := os.Stat(filepath.Join(root, "go.work")); err == nil {
+		g_strGoWork = filepath.Join(root, "go.work")
+	}
+	cmd := exec.Command("go", "list", "-m", "-json")
+	cmd.Dir = root
+	cmd.Env = moduleEnv()
+	out, err := cmd.Output()
+	if err != nil {
+		return
+	}
+	d := json.NewDecoder(strings.NewReader(string(out)))
+	for {
+		var m goModule
+		if err := d.Decode(&m); err != nil {
+			if !errors.Is(err, io.EOF) {
+				g_goModules = nil
+			}
+			break
+		}
+		rel, err := filepath.Rel(root, m.Dir)
+		if m.Path == "" || err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue // 不在仓库中的模块
+		}
+		if rel == "." {
+			rel = ""
+		}
+		m.RelDir = filepath.ToSlash(rel)
+		g_goModules = append(g_goModules, m)
+	}
+	sort.SliceStable(g_goModules, func(i, j int) bool { return len(g_goModules[i].Path) > len(g_goModules[j].Path) })
+}
+
+// 在模块中执行 go 命令的环境变量: 使用 g_strGoWork, 并去掉 GOFLAGS 中 go.work 不允许的 -mod(如 -mod=mod)
+func moduleEnv() []string {
+	env := append([]string(nil), os.Environ()...)
+	for i, kv := range env {
+		if flags, ok := strings.CutPrefix(kv, "GOFLAGS="); ok {
+			var kept []string
+			for _, f := range strings.Fields(flags) {
+				if !strings.HasPrefix(f, "-mod=") {
+					kept = append(kept, f)
+				}
+			}
+			env[i] = "GOFLAGS=" + strings.Join(kept, " ")
+		}
+	}
+	return append(env, "GO111MODULE=on", "GOWORK="+g_strGoWork)
+}
+
+// 从提交中读取模块: 根目录的 go.work 中 use 的每个目录的 go.mod, 没有 go.work 时为根目录的 go.mod
+func gitGoModules(commit string) []goModule {
+	dirs := []string{"."}
+	if content, err := GitGetFileContent(commit, "go.work"); err == nil {
+		dirs = goWorkUses(content)
+	}
+	var modules []goModule
+	for _, dir := range dirs {
+		content, err := GitGetFileContent(commit, path.Join(dir, "go.mod"))
+		if err != nil {
+			continue
+		}
+		if module := moduleOf(content); module != "" {
+			rel := path.Clean(dir)
+			if rel == "." {
+				rel = ""
+			}
+			modules = append(modules, goModule{Path: module, RelDir: rel})
+		}
+	}
+	return modules
+}
+
+// go.work 中 use 的目录, 支持 use ./a 和 use ( ./a ./b ) 两种写法
+func goWorkUses(goWork string) []string {
+	var dirs []string
+	bBlock := false
+	for _, line := range strings.Split(goWork, "\n") {
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+		case bBlock && fields[0] == ")":
+			bBlock = false
+		case bBlock:
+			dirs = append(dirs, strings.Trim(fields[0], `"`))
+		case fields[0] == "use" && len(fields) >= 2 && fields[1] == "(":
+			bBlock = true
+		case fields[0] == "use" && len(fields) >= 2:
+			dirs = append(dirs, strings.Trim(fields[1], `"`))
+		}
+	}
+	return dirs
+}
+
+// 文件所在的模块和文件在模块中的路径, 不属于任何模块时返回 nil
+func moduleOfFile(fileName string) (*goModule, string) {
+	for i, m := range g_goModules {
+		if rest, ok := strings.CutPrefix(fileName, m.Path+"/"); ok {
+			return &g_goModules[i], rest
+		}
+	}
+	return nil, ""
+}
+
+// 模块中的文件在仓库中的路径
+func moduleRepoPath(fileName string) (string, bool) {
+	m, rest := moduleOfFile(fileName)
+	if m == nil {
+		return "", false
+	}
+	return path.Join(m.RelDir, rest), true
+}
+
+// moduleRepoPath 的反向, 根目录的模块放在最后, 使 go/src 下的文件仍然按 GOPATH 的方式对应
+func moduleFileName(repoPath string) (string, bool) {
+	var root *goModule
+	for i, m := range g_goModules {
+		if m.RelDir == "" {
+			if root == nil {
+				root = &g_goModules[i]
+			}
+			continue
+		}
+		if rest, ok := strings.CutPrefix(repoPath, m.RelDir+"/"); ok {
+			return m.Path + "/" + rest, true
+		}
+	}
+	if root != nil && !strings.HasPrefix(repoPath, "go/src/") {
+		return root.Path + "/" + repoPath, true
+	}
+	return "", false
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var g_strNamePattern = flag.String("name-pattern", "", "从输入路径中提取版本信息的正则表达式, 命名分组 timestamp 和 hash 必填, 其他命名分组作为标签, "+
+	`例如 runs/(?P<hash>[0-9a-f]+)/(?P<timestamp>\d+)/(?P<suite>\w+)\.out$(为空时使用 name.timestamp.hash)`)
+
+// 带版本信息的覆盖率文件名: <name>.<timestamp>.<githash>, 可以带 .gz 或 .zst 后缀. githash 也可以是不含 . 和 / 的分支或 tag
+var g_reCoverFileName = regexp.MustCompile(`\.[0-9]+\.[0-9A-Za-z][0-9A-Za-z_-]*(\.gz|\.zst)?$`)
+
+// 编译后的 -name-pattern, 第一次使用时编译
+var g_reNamePattern *regexp.Regexp
+
+func namePattern() (*regexp.Regexp, error) {
+	if g_reNamePattern == nil {
+		re, err := regexp.Compile(*g_strNamePattern)
+		if err != nil {
+			return nil, fmt.Errorf("bad -name-pattern: %v", err)
+		}
+		names := strings.Join(re.SubexpNames(), ",")
+		if !strings.Contains(","+names+",", ",timestamp,") || !strings.Contains(","+names+",", ",hash,") {
+			return nil, fmt.Errorf("bad -name-pattern: named groups timestamp and hash required")
+		}
+		g_reNamePattern = re
+	}
+	return g_reNamePattern, nil
+}
+
+// 判断输入的名称是否带有版本信息, 指定了 -name-pattern 时按它匹配完整路径
+func IsCoverFileName(name string) bool {
+	if *g_strNamePattern != "" {
+		re, err := namePattern()
+		return err == nil && re.MatchString(filepath.ToSlash(filepath.Clean(name)))
+	}
+	return g_reCoverFileName.MatchString(path.Base(name))
+}
+
+// 从输入名称中解析时间戳和 git hash. 默认倒数第二段是时间戳, 最后一段是 git hash;
+// 指定了 -name-pattern 时按命名分组提取, 其他命名分组作为输入的标签
+func ParseVersionFromName(fileName string) (timestamp int64, gitHash string, tags map[string]string, err error) {
+	// 去掉目录输入(GOCOVERDIR)末尾的 / 和压缩文件的 .gz, .zst 后缀
+	name := strings.TrimSuffix(strings.TrimSuffix(filepath.Clean(fileName), ".gz"), ".zst")
+	var strTimestamp string
+	if *g_strNamePattern == "" {
+		// 使用字符串分割
+		parts := strings.Split(name, ".")
+		if len(parts) < 2 {
+			return 0, "", nil, fmt.Errorf("file string is not valid")
+		}
+		// 倒数第二个是时间戳, 最后一个是git hash
+		strTimestamp, gitHash = parts[len(parts)-2], parts[len(parts)-1]
+	} else {
+		re, err := namePattern()
+		if err != nil {
+			return 0, "", nil, err
+		}
+		match := re.FindStringSubmatch(filepath.ToSlash(name))
+		if match == nil {
+			return 0, "", nil, fmt.Errorf("%s does not match -name-pattern", fileName)
+		}
+		for i, group := range re.SubexpNames() {
+			switch group {
+			case "":
+			case "timestamp":
+				strTimestamp = match[i]
+			case "hash":
+				gitHash = match[i]
+			default:
+				if tags == nil {
+					tags = make(map[string]string)
+				}
+				tags[group] = match[i]
+			}
+		}
+	}
+
+	timestamp, err = strconv.ParseInt(strTimestamp, 10, 64)
+	if err != nil {
+		return 0, "", nil, fmt.Errorf("timestamp is not valid")
+	}
+	return timestamp, gitHash, tags, nil
+}
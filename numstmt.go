@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/cover"
+)
+
+// 同一版本同一位置的块在各输入中的语句数必须一致, 否则合并结果的语句数取决于合并顺序.
+// 通常是同一个 git hash 下混入了不同源码(如未提交的修改)编译的覆盖率
+type NumStmtChecker struct {
+	blocks   map[string]map[[4]int]numStmtSource // git hash 和文件 -> 块的位置 -> 第一次出现的语句数
+	problems map[string]string                   // 块 -> 诊断信息, 每个块只报告一次
+}
+
+type numStmtSource struct {
+	numStmt  int
+	fileName string
+}
+
+func (c *NumStmtChecker) Check(gitHash string, fileName string, profiles []*cover.Profile) {
+	if c.blocks == nil {
+		c.blocks = make(map[string]map[[4]int]numStmtSource)
+		c.problems = make(map[string]string)
+	}
+	for _, p := range profiles {
+		key := gitHash + " " + p.FileName
+		blocks := c.blocks[key]
+		if blocks == nil {
+			blocks = make(map[[4]int]numStmtSource)
+			c.blocks[key] = blocks
+		}
+		for _, b := range p.Blocks {
+			pos := [4]int{b.StartLine, b.StartCol, b.EndLine, b.EndCol}
+			first, ok := blocks[pos]
+			if !ok {
+				blocks[pos] = numStmtSource{numStmt: b.NumStmt, fileName: fileName}
+				continue
+			}
+			if first.numStmt != b.NumStmt {
+				block := fmt.Sprintf("%s:%d.%d,%d.%d (%s)", p.FileName, b.StartLine, b.StartCol, b.EndLine, b.EndCol, gitHash)
+				if _, ok := c.problems[block]; !ok {
+					c.problems[block] = fmt.Sprintf("%s: %d statements in %s, %d in %s", block, first.numStmt, first.fileName, b.NumStmt, fileName)
+				}
+			}
+		}
+	}
+}
+
+// 有不一致的块时返回列出这些块和输入的错误, 最多列出 10 个
+func (c *NumStmtChecker) Err() error {
+	if len(c.problems) == 0 {
+		return nil
+	}
+	lines := make([]string, 0, len(c.problems))
+	for _, line := range c.problems {
+		lines = append(lines, line)
+	}
+	sort.Strings(lines)
+	if len(lines) > 10 {
+		lines = append(lines[:10], fmt.Sprintf("and %d more", len(c.problems)-10))
+	}
+	return fmt.Errorf("inconsistent NumStmt for %d blocks, the inputs were built from different sources:\n  %s", len(c.problems), strings.Join(lines, "\n  "))
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// 对象存储(s3://, gs://, oss://)通过各自的命令行工具访问, 认证使用工具自己的配置或 s3/gs/oss 的认证信息(见 Credential):
+//
+//	s3://  aws
+//	gs://  gsutil
+//	oss:// ossutil
+//
+// 以 / 结尾的 URL 表示前缀, 读取其下所有文件名带版本信息的覆盖率文件
+func IsObjectURL(s string) bool {
+	return strings.HasPrefix(s, "s3://") || strings.HasPrefix(s, "gs://") || strings.HasPrefix(s, "oss://")
+}
+
+// 解析对象存储输入
+func ParseObjectInput(strURL string) ([]*CoverFileInfo, error) {
+	objects := []string{strURL}
+	if strings.HasSuffix(strURL, "/") {
+		var err error
+		if objects, err = ListObjects(strURL); err != nil {
+			return nil, err
+		}
+		if len(objects) == 0 {
+			return nil, fmt.Errorf("no cover.txt.xxx.xxx file found in %s", strURL)
+		}
+		// 下载之前检查对象个数
+		if g_bGuardrails {
+			if err := checkGuardrailLimits(len(objects), 0); err != nil {
+				return nil, fmt.Errorf("%s: %v", strURL, err)
+			}
+		}
+	}
+
+	var fileInfos []*CoverFileInfo
+	for _, object := range objects {
+		data, err := ReadObject(object)
+		if err != nil {
+			return nil, err
+		}
+		infos, err := ParseFetchedInput(object, object, data)
+		if err != nil {
+			return nil, err
+		}
+		fileInfos = append(fileInfos, infos...)
+	}
+	return fileInfos, nil
+}
+
+// 读取对象内容
+func ReadObject(strURL string) ([]byte, error) {
+	var cmd *exec.Cmd
+	var err error
+	switch {
+	case strings.HasPrefix(strURL, "s3://"):
+		cmd, err = ObjectCommand("s3", "aws", "s3", "cp", strURL, "-")
+	case strings.HasPrefix(strURL, "gs://"):
+		cmd, err = ObjectCommand("gs", "gsutil", "cat", strURL)
+	default:
+		cmd, err = ObjectCommand("oss", "ossutil", "cat", strURL)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return runObjectCommand(cmd, strURL)
+}
+
+// 列出前缀下文件名带版本信息的对象, 返回完整 URL
+func ListObjects(prefix string) ([]string, error) {
+	var cmd *exec.Cmd
+	var err error
+	switch {
+	case strings.HasPrefix(prefix, "s3://"):
+		cmd, err = ObjectCommand("s3", "aws", "s3", "ls", "--recursive", prefix)
+	case strings.HasPrefix(prefix, "gs://"):
+		cmd, err = ObjectCommand("gs", "gsutil", "ls", prefix+"**")
+	default:
+		cmd, err = ObjectCommand("oss", "ossutil", "ls", "-s", prefix)
+	}
+	if err != nil {
+		return nil, err
+	}
+	out, err := runObjectCommand(cmd, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []string
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		var object string
+		if strings.HasPrefix(prefix, "s3://") {
+			// aws s3 ls 输出: 日期 时间 大小 key, key 相对于 bucket
+			fields := strings.Fields(line)
+			if len(fields) < 4 {
+				continue
+			}
+			bucket := strings.SplitN(strings.TrimPrefix(prefix, "s3://"), "/", 2)[0]
+			object = "s3://" + bucket + "/" + strings.Join(fields[3:], " ")
+		} else if strings.Contains(line, "://") {
+			object = line
+		} else {
+			continue
+		}
+		if IsCoverFileName(object) || IsArchive(object) {
+			objects = append(objects, object)
+		}
+	}
+	return objects, nil
+}
+
+// 上传本地文件到对象存储
+func WriteObject(localFile string, strURL string) error {
+	var cmd *exec.Cmd
+	var err error
+	switch {
+	case strings.HasPrefix(strURL, "s3://"):
+		cmd, err = ObjectCommand("s3", "aws", "s3", "cp", localFile, strURL)
+	case strings.HasPrefix(strURL, "gs://"):
+		cmd, err = ObjectCommand("gs", "gsutil", "cp", localFile, strURL)
+	default:
+		cmd, err = ObjectCommand("oss", "ossutil", "cp", "-f", localFile, strURL)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = runObjectCommand(cmd, strURL)
+	return err
+}
+
+// 输出为对象存储 URL 时先写到临时文件, 调用返回的 upload 上传并删除临时文件;
+// 本地输出原样返回, upload 不做任何事
+func LocalOutput(name string) (localFile string, upload func() error, err error) {
+	if !IsObjectURL(name) {
+		return name, func() error { return nil }, nil
+	}
+	dir, err := os.MkdirTemp("", "gocovmerge-out-*")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	localFile = filepath.Join(dir, path.Base(name))
+	upload = func() error {
+		defer os.RemoveAll(dir)
+		return WriteObject(localFile, name)
+	}
+	return localFile, upload, nil
+}
+
+func runObjectCommand(cmd *exec.Cmd, strURL string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %v: %s", filepath.Base(cmd.Path), strURL, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// 报告需要在隔离环境中离线查看, 不能引用外部的脚本, 样式, 图片等.
+// 规则与 assets/checkoffline.go(go generate 时检查 assets/)一致, 修改时两边都要改
+var g_reExternalRef = regexp.MustCompile(`(?i)(\b(?:src|href|action|poster|srcset|data)\s*=\s*["']\s*(?:https?:)?//|url\(\s*["']?\s*(?:https?:)?//|@import\s+(?:url\()?\s*["']?\s*(?:https?:)?//|\bfetch\(\s*["'](?:https?:)?//)`)
+
+// 检查 HTML 中是否有外部引用, 源码中的 URL 已经转义, 不会被当作引用
+func CheckOfflineHTML(html string) error {
+	loc := g_reExternalRef.FindStringIndex(html)
+	if loc == nil {
+		return nil
+	}
+	end := loc[1] + 60
+	if end > len(html) {
+		end = len(html)
+	}
+	return fmt.Errorf("report references an external URL: %q", html[loc[0]:end])
+}
+
+func init() {
+	if err := CheckOfflineHTML(g_additionHTML + g_suiteToggleHTML + g_briefCSS + g_trendsCSS + g_treemapHTML + g_combinedCSS); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var g_strOrphans = flag.String("orphans", "report", "合并开始前对以前中断的运行遗留的 go/src/<file>.<githash> 源码的处理: report 打印警告, remove 删除, ignore 不检查")
+
+// GitSaveFile 导出的源码: <file>.go.<githash>
+var g_reExtractedSource = regexp.MustCompile(`\.go\.[0-9a-f]{7,40}$`)
+
+func checkOrphans(action string) error {
+	switch action {
+	case "report", "remove", "ignore":
+		return nil
+	}
+	return fmt.Errorf("unsupported orphans '%s', expected report, remove or ignore", action)
+}
+
+// 查找 root 下遗留的导出源码: 文件名为 <file>.go.<githash>, git hash 是仓库中的提交, 且文件不受 git 管理
+func FindOrphanSources(root string) ([]string, error) {
+	var candidates []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.Type().IsRegular() && g_reExtractedSource.MatchString(d.Name()) {
+			candidates = append(candidates, path)
+		}
+		return nil
+	})
+	if err != nil || len(candidates) == 0 {
+		return nil, err
+	}
+	names, err := trackedFiles(root)
+	if err != nil {
+		return nil, err
+	}
+	tracked := make(map[string]bool)
+	for _, name := range names {
+		tracked[filepath.Join(WorkTreeDir(), filepath.FromSlash(name))] = true
+	}
+	bCommits := make(map[string]bool) // git hash -> 是否为提交
+	var orphans []string
+	for _, path := range candidates {
+		if tracked[filepath.Clean(path)] {
+			continue
+		}
+		gitHash := path[strings.LastIndex(path, ".")+1:]
+		bCommit, ok := bCommits[gitHash]
+		if !ok {
+			_, err := resolveRef(gitHash)
+			bCommit = err == nil
+			bCommits[gitHash] = bCommit
+		}
+		if bCommit {
+			orphans = append(orphans, path)
+		}
+	}
+	sort.Strings(orphans)
+	return orphans, nil
+}
+
+// root 下已跟踪的文件, 路径相对工作区根目录
+func trackedFiles(root string) ([]string, error) {
+	if g_gitBackend != nil {
+		return g_gitBackend.TrackedFiles()
+	}
+	out, err := GitCommand("ls-files", "-z", "--full-name", "--", root).Output()
+	if err != nil {
+		return nil, fmt.Errorf("git ls-files %s: %v", root, err)
+	}
+	return strings.Split(string(out), "\x00"), nil
+}
+
+// 按 action 处理 root 下遗留的导出源码, 返回找到的文件
+func CleanOrphanSources(root string, action string) ([]string, error) {
+	if action == "ignore" {
+		return nil, nil
+	}
+	orphans, err := FindOrphanSources(root)
+	if err != nil || len(orphans) == 0 {
+		return orphans, err
+	}
+	if action == "remove" {
+		DeleteFiles(orphans)
+		fmt.Printf("removed %d leftover extracted sources under %s\n", len(orphans), root)
+		return orphans, nil
+	}
+	fmt.Printf("warning: %d leftover extracted sources under %s from interrupted runs, do not commit them (remove with -orphans remove or clean-sources):\n", len(orphans), root)
+	for _, path := range orphans {
+		fmt.Println("  " + path)
+	}
+	return orphans, nil
+}
+
+// clean-sources: 删除(或用 -n 列出)遗留的导出源码, 可以定期执行
+func runCleanSources(args []string) error {
+	fs := NewSubCommandFlagSet("clean-sources", "[-n] [-root go/src]")
+	bDryRun := fs.Bool("n", false, "只列出, 不删除")
+	strRoot := fs.String("root", "go/src", "源码目录")
+	fs.Parse(args)
+	action := "remove"
+	if *bDryRun {
+		action = "report"
+	}
+	orphans, err := CleanOrphanSources(*strRoot, action)
+	if err != nil {
+		return err
+	}
+	if len(orphans) == 0 {
+		fmt.Println("no leftover extracted sources under", *strRoot)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+
+	"golang.org/x/tools/cover"
+)
+
+// 重叠的块(如插桩过期或从其他格式转换的覆盖率)的处理方式
+var g_strOverlap = flag.String("overlap", "error", "块重叠时的处理方式: error 报错, first 保留先合并的块, "+
+	"max 保留先合并的块并取最大计数, sum 保留先合并的块并累加计数, split 按块的边界切分, 每段的计数为覆盖它的块的合并计数")
+
+func checkOverlap(strategy string) error {
+	switch strategy {
+	case "error", "first", "max", "sum", "split":
+		return nil
+	}
+	return fmt.Errorf("unsupported overlap '%s', expected error, max, sum, first or split", strategy)
+}
+
+type blockPoint struct {
+	line, col int
+}
+
+func (a blockPoint) less(b blockPoint) bool {
+	return a.line < b.line || (a.line == b.line && a.col < b.col)
+}
+
+func blockStart(b cover.ProfileBlock) blockPoint { return blockPoint{b.StartLine, b.StartCol} }
+func blockEnd(b cover.ProfileBlock) blockPoint   { return blockPoint{b.EndLine, b.EndCol} }
+
+// 按合并模式合并计数
+func mergeCount(mode string, a, b int) int {
+	if mode == "set" {
+		return a | b
+	}
+	return AddCount(a, b)
+}
+
+// 按 -overlap 把与已有块重叠的 pb 合并到 p 中, i 为 pb 按起始位置应插入的位置.
+// 返回后续块可以开始查找的位置
+func resolveOverlap(p *cover.Profile, pb cover.ProfileBlock, i int) (int, error) {
+	// 与 pb 重叠的已有块 [lo, hi)
+	lo := i
+	for lo > 0 && blockStart(pb).less(blockEnd(p.Blocks[lo-1])) {
+		lo--
+	}
+	hi := i
+	for hi < len(p.Blocks) && blockStart(p.Blocks[hi]).less(blockEnd(pb)) {
+		hi++
+	}
+	switch *g_strOverlap {
+	case "first":
+	case "max":
+		for j := lo; j < hi; j++ {
+			p.Blocks[j].Count = max(p.Blocks[j].Count, pb.Count)
+		}
+	case "sum":
+		for j := lo; j < hi; j++ {
+			p.Blocks[j].Count = mergeCount(p.Mode, p.Blocks[j].Count, pb.Count)
+		}
+	case "split":
+		segments := splitBlocks(p.Mode, append(append([]cover.ProfileBlock(nil), p.Blocks[lo:hi]...), pb))
+		p.Blocks = append(p.Blocks[:lo], append(segments, p.Blocks[hi:]...)...)
+	default:
+		return i, fmt.Errorf("gocovmerge: overlap in %v: %v", p.FileName, pb)
+	}
+	return lo, nil
+}
+
+// 把互相重叠的块按所有块的边界切分成不重叠的段, 每段的计数为覆盖它的块的合并计数.
+// 块的语句数记在它起始的段上, 多个块起始于同一段时取最大值, 其他段的语句数为 0
+func splitBlocks(mode string, blocks []cover.ProfileBlock) []cover.ProfileBlock {
+	var points []blockPoint
+	for _, b := range blocks {
+		points = append(points, blockStart(b), blockEnd(b))
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].less(points[j]) })
+	var segments []cover.ProfileBlock
+	for k := 0; k+1 < len(points); k++ {
+		from, to := points[k], points[k+1]
+		if !from.less(to) {
+			continue
+		}
+		segment := cover.ProfileBlock{StartLine: from.line, StartCol: from.col, EndLine: to.line, EndCol: to.col}
+		bCovered := false
+		for _, b := range blocks {
+			if from.less(blockStart(b)) || blockEnd(b).less(to) {
+				continue
+			}
+			if bCovered {
+				segment.Count = mergeCount(mode, segment.Count, b.Count)
+			} else {
+				segment.Count, bCovered = b.Count, true
+			}
+			if blockStart(b) == from {
+				segment.NumStmt = max(segment.NumStmt, b.NumStmt)
+			}
+		}
+		if bCovered {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.org/x/tools/cover"
+)
+
+// 先合并 a(1.1-5.1, 3 条语句, 计数 2), 再合并与它重叠的 b(3.1-8.1, 2 条语句, 计数 5)
+func TestResolveOverlap(t *testing.T) {
+	defer func(strategy string) { *g_strOverlap = strategy }(*g_strOverlap)
+	a := cover.ProfileBlock{StartLine: 1, StartCol: 1, EndLine: 5, EndCol: 1, NumStmt: 3, Count: 2}
+	b := cover.ProfileBlock{StartLine: 3, StartCol: 1, EndLine: 8, EndCol: 1, NumStmt: 2, Count: 5}
+	withCount := func(b cover.ProfileBlock, count int) cover.ProfileBlock {
+		b.Count = count
+		return b
+	}
+	for _, tc := range []struct {
+		strategy string
+		want     []cover.ProfileBlock
+	}{
+		{"first", []cover.ProfileBlock{a}},
+		{"max", []cover.ProfileBlock{withCount(a, 5)}},
+		{"sum", []cover.ProfileBlock{withCount(a, 7)}},
+		// 语句数记在块起始的段上
+		{"split", []cover.ProfileBlock{
+			{StartLine: 1, StartCol: 1, EndLine: 3, EndCol: 1, NumStmt: 3, Count: 2},
+			{StartLine: 3, StartCol: 1, EndLine: 5, EndCol: 1, NumStmt: 2, Count: 7},
+			{StartLine: 5, StartCol: 1, EndLine: 8, EndCol: 1, NumStmt: 0, Count: 5},
+		}},
+	} {
+		*g_strOverlap = tc.strategy
+		into := &cover.Profile{FileName: "example.com/foo/foo.go", Mode: "count", Blocks: []cover.ProfileBlock{a}}
+		merge := &cover.Profile{FileName: "example.com/foo/foo.go", Mode: "count", Blocks: []cover.ProfileBlock{b}}
+		if err := MergeProfiles(into, merge); err != nil {
+			t.Fatalf("%s: %v", tc.strategy, err)
+		}
+		if !reflect.DeepEqual(into.Blocks, tc.want) {
+			t.Errorf("%s:\n got %+v\nwant %+v", tc.strategy, into.Blocks, tc.want)
+		}
+	}
+
+	*g_strOverlap = "error"
+	into := &cover.Profile{FileName: "example.com/foo/foo.go", Mode: "count", Blocks: []cover.ProfileBlock{a}}
+	merge := &cover.Profile{FileName: "example.com/foo/foo.go", Mode: "count", Blocks: []cover.ProfileBlock{b}}
+	if err := MergeProfiles(into, merge); err == nil {
+		t.Error("error: no error for overlapping blocks")
+	}
+}
+
+// 多个块起始于同一段时语句数取最大值, set 模式下计数按位或
+func TestSplitBlocksSameStart(t *testing.T) {
+	blocks := []cover.ProfileBlock{
+		{StartLine: 1, StartCol: 1, EndLine: 5, EndCol: 1, NumStmt: 3, Count: 0},
+		{StartLine: 1, StartCol: 1, EndLine: 3, EndCol: 1, NumStmt: 2, Count: 1},
+	}
+	want := []cover.ProfileBlock{
+		{StartLine: 1, StartCol: 1, EndLine: 3, EndCol: 1, NumStmt: 3, Count: 1},
+		{StartLine: 3, StartCol: 1, EndLine: 5, EndCol: 1, NumStmt: 0, Count: 0},
+	}
+	if got := splitBlocks("set", blocks); !reflect.DeepEqual(got, want) {
+		t.Errorf("splitBlocks:\n got %+v\nwant %+v", got, want)
+	}
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var g_strGateOverrides = flag.String("gate-overrides", "", "门禁豁免所在的历史库(SQLite), -min-coverage 不通过时使用一次最新版本 git hash 的未使用豁免, 并记录在审计日志中(为空不使用)")
+
+// 对一个 git hash 的一次性门禁豁免
+type GateOverride struct {
+	ID         int64  `json:"id"`
+	GitHash    string `json:"git_hash"`
+	Reason     string `json:"reason"`
+	ApprovedBy string `json:"approved_by"`
+	ApprovedAt int64  `json:"approved_at"`
+	UsedBy     string `json:"used_by,omitempty"`
+	UsedAt     int64  `json:"used_at,omitempty"` // 0 表示还没有使用
+}
+
+// 审批一个 git hash 的门禁豁免, 返回豁免的 id
+func (s *Store) ApproveOverride(gitHash string, reason string, actor string) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	now := time.Now().Unix()
+	res, err := tx.Exec("INSERT INTO gate_overrides (git_hash, reason, approved_by, approved_at) VALUES (?, ?, ?, ?)", gitHash, reason, actor, now)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("INSERT INTO gate_audit (override_id, git_hash, action, reason, actor, at) VALUES (?, ?, 'approve', ?, ?, ?)",
+		id, gitHash, reason, actor, now); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// 使用 git hash 最早审批的一个未使用的豁免(长短 hash 互为前缀即可), 没有时返回 nil.
+// detail 为门禁不通过的原因, 记录在审计日志中
+func (s *Store) UseOverride(gitHash string, actor string, detail string) (*GateOverride, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	var o GateOverride
+	err = tx.QueryRow(`SELECT id, git_hash, reason, approved_by, approved_at FROM gate_overrides
+		WHERE used_at = 0 AND (? LIKE git_hash || '%' OR git_hash LIKE ? || '%') ORDER BY id LIMIT 1`, gitHash, gitHash).
+		Scan(&o.ID, &o.GitHash, &o.Reason, &o.ApprovedBy, &o.ApprovedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	o.UsedBy, o.UsedAt = actor, time.Now().Unix()
+	if _, err := tx.Exec("UPDATE gate_overrides SET used_by = ?, used_at = ? WHERE id = ?", o.UsedBy, o.UsedAt, o.ID); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("INSERT INTO gate_audit (override_id, git_hash, action, reason, actor, at) VALUES (?, ?, 'use', ?, ?, ?)",
+		o.ID, gitHash, detail, actor, o.UsedAt); err != nil {
+		return nil, err
+	}
+	return &o, tx.Commit()
+}
+
+// 列出门禁豁免, 最新的在前
+func (s *Store) ListOverrides() ([]GateOverride, error) {
+	rows, err := s.db.Query("SELECT id, git_hash, reason, approved_by, approved_at, used_by, used_at FROM gate_overrides ORDER BY id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	overrides := []GateOverride{}
+	for rows.Next() {
+		var o GateOverride
+		if err := rows.Scan(&o.ID, &o.GitHash, &o.Reason, &o.ApprovedBy, &o.ApprovedAt, &o.UsedBy, &o.UsedAt); err != nil {
+			return nil, err
+		}
+		overrides = append(overrides, o)
+	}
+	return overrides, rows.Err()
+}
+
+// 门禁不通过时按 -gate-overrides 使用豁免, 使用后门禁结果为 override
+func ApplyGateOverride(summary *MergeSummary, gitHash string) error {
+	if *g_strGateOverrides == "" || summary.Threshold != "fail" {
+		return nil
+	}
+	store, err := OpenStore(*g_strGateOverrides)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	actor := os.Getenv("USER")
+	if actor == "" {
+		actor = "unknown"
+	}
+	override, err := store.UseOverride(gitHash, actor, summary.Check().Error())
+	if err != nil || override == nil {
+		return err
+	}
+	summary.Threshold = "override"
+	summary.Override = fmt.Sprintf("override %d approved by %s: %s", override.ID, override.ApprovedBy, override.Reason)
+	fmt.Println("warning: coverage gate failed for", gitHash, "and was bypassed by", summary.Override)
+	return nil
+}
+
+func checkOverrideRequest(gitHash string, reason string) error {
+	if len(gitHash) < 7 || strings.Trim(strings.ToLower(gitHash), "0123456789abcdef") != "" {
+		return fmt.Errorf("git hash %q is not valid, at least 7 hex digits required", gitHash)
+	}
+	if strings.TrimSpace(reason) == "" {
+		return fmt.Errorf("reason required")
+	}
+	return nil
+}
+
+// override approve|list|audit: 管理门禁豁免
+func runOverride(args []string) error {
+	subCommands := map[string]func(args []string) error{
+		"approve": runOverrideApprove,
+		"list":    runOverrideList,
+		"audit":   runOverrideAudit,
+	}
+	if len(args) == 0 || subCommands[args[0]] == nil {
+		fmt.Println("Usage: ./bin/gocovmerge override approve [-db cover.db] -reason text [-by name] githash")
+		fmt.Println("       ./bin/gocovmerge override list|audit [-db cover.db]")
+		return fmt.Errorf("Error: override approve, list or audit required.")
+	}
+	return subCommands[args[0]](args[1:])
+}
+
+func runOverrideApprove(args []string) error {
+	fs := NewSubCommandFlagSet("override approve", "[options] githash")
+	strDB := fs.String("db", "cover.db", "覆盖率历史库(SQLite)")
+	strReason := fs.String("reason", "", "原因, 记录在审计日志中(必填)")
+	strBy := fs.String("by", os.Getenv("USER"), "审批人, 记录在审计日志中")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("Error: git hash required.")
+	}
+	if *strReason == "" {
+		return fmt.Errorf("Error: -reason required.")
+	}
+	if err := checkOverrideRequest(fs.Arg(0), *strReason); err != nil {
+		return err
+	}
+	if *strBy == "" {
+		*strBy = "unknown"
+	}
+	store, err := OpenStore(*strDB)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	id, err := store.ApproveOverride(fs.Arg(0), *strReason, *strBy)
+	if err != nil {
+		return err
+	}
+	fmt.Println("override", id, "for", fs.Arg(0), "approved")
+	return nil
+}
+
+func runOverrideList(args []string) error {
+	fs := NewSubCommandFlagSet("override list", "[options]")
+	strDB := fs.String("db", "cover.db", "覆盖率历史库(SQLite)")
+	fs.Parse(args)
+	store, err := OpenStore(*strDB)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	overrides, err := store.ListOverrides()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "id\tgit hash\tapproved\tby\tused\treason")
+	for _, o := range overrides {
+		used := "-"
+		if o.UsedAt != 0 {
+			used = time.Unix(o.UsedAt, 0).Format("2006-01-02 15:04") + " by " + o.UsedBy
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", o.ID, o.GitHash, time.Unix(o.ApprovedAt, 0).Format("2006-01-02 15:04"), o.ApprovedBy, used, o.Reason)
+	}
+	return w.Flush()
+}
+
+// override audit: 查看审批和使用豁免的审计记录
+func runOverrideAudit(args []string) error {
+	fs := NewSubCommandFlagSet("override audit", "[options]")
+	strDB := fs.String("db", "cover.db", "覆盖率历史库(SQLite)")
+	fs.Parse(args)
+	store, err := OpenStore(*strDB)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	rows, err := store.db.Query("SELECT override_id, git_hash, action, reason, actor, at FROM gate_audit ORDER BY id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "time\toverride\tgit hash\taction\tby\treason")
+	for rows.Next() {
+		var id, at int64
+		var gitHash, action, reason, actor string
+		if err := rows.Scan(&id, &gitHash, &action, &reason, &actor, &at); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\n", time.Unix(at, 0).Format("2006-01-02 15:04:05"), id, gitHash, action, actor, reason)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// 读取审批人文件: 每行 "<名字> <token 的 sha256 十六进制>", 空行和 # 开头的行忽略, 返回 token 的 sha256 -> 名字
+func ReadApprovers(fileName string) (map[string]string, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	approvers := make(map[string]string)
+	s := bufio.NewScanner(f)
+	for lineNo := 1; s.Scan(); lineNo++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || len(fields[1]) != sha256.Size*2 {
+			return nil, fmt.Errorf("%s:%d: expected \"<name> <sha256 of token>\"", fileName, lineNo)
+		}
+		approvers[strings.ToLower(fields[1])] = fields[0]
+	}
+	return approvers, s.Err()
+}
+
+// 按 Authorization: Bearer <token> 找到审批人, 不是审批人时返回空
+func approverOf(r *http.Request, approvers map[string]string) string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	hash := hex.EncodeToString(sum[:])
+	for approverHash, name := range approvers {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(approverHash)) == 1 {
+			return name
+		}
+	}
+	return ""
+}
+
+// status-serve -db: GET /api/overrides 列出豁免, POST /api/overrides {"git_hash": "...", "reason": "..."} 由审批人审批,
+// /overrides 为查看和审批的页面
+func overrideHandlers(mux *http.ServeMux, dbPath string, approvers map[string]string) {
+	mux.HandleFunc("/api/overrides", func(w http.ResponseWriter, r *http.Request) {
+		store, err := OpenStore(dbPath)
+		if err != nil {
+			http.Error(w, "store not available", http.StatusServiceUnavailable)
+			return
+		}
+		defer store.Close()
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			overrides, err := store.ListOverrides()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(overrides)
+		case http.MethodPost:
+			approver := approverOf(r, approvers)
+			if approver == "" {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "approver token required", http.StatusUnauthorized)
+				return
+			}
+			var req struct {
+				GitHash string `json:"git_hash"`
+				Reason  string `json:"reason"`
+			}
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req); err != nil {
+				http.Error(w, "bad request: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := checkOverrideRequest(req.GitHash, req.Reason); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			id, err := store.ApproveOverride(req.GitHash, req.Reason, approver)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			fmt.Println("override", id, "for", req.GitHash, "approved by", approver)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(GateOverride{ID: id, GitHash: req.GitHash, Reason: req.Reason, ApprovedBy: approver, ApprovedAt: time.Now().Unix()})
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/overrides", func(w http.ResponseWriter, r *http.Request) {
+		store, err := OpenStore(dbPath)
+		if err != nil {
+			http.Error(w, "store not available", http.StatusServiceUnavailable)
+			return
+		}
+		defer store.Close()
+		overrides, err := store.ListOverrides()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(overridesHTML(overrides)))
+	})
+}
+
+// 查看和审批豁免的页面, 审批通过 fetch 调用 POST /api/overrides
+func overridesHTML(overrides []GateOverride) string {
+	var sb strings.Builder
+	sb.WriteString("<!DOCTYPE html>\n<html lang=\"en\">\n<head>\n<meta charset=\"utf-8\">\n<title>Coverage gate overrides</title>\n<style>\n")
+	sb.WriteString(g_policyCSS)
+	sb.WriteString("</style>\n</head>\n<body>\n<h1>Coverage gate overrides</h1>\n")
+	sb.WriteString("<form id=\"approve\">\n<p><label>Git hash <input name=\"git_hash\" required minlength=\"7\"></label>\n" +
+		"<label>Reason <input name=\"reason\" required size=\"50\"></label>\n" +
+		"<label>Approver token <input name=\"token\" type=\"password\" required></label>\n" +
+		"<button type=\"submit\">Approve one-time override</button> <span id=\"result\"></span></p>\n</form>\n")
+	sb.WriteString("<table>\n<tr><th class=\"num\">Id</th><th>Git hash</th><th>Approved</th><th>By</th><th>Used</th><th>Reason</th></tr>\n")
+	for _, o := range overrides {
+		used := "not used"
+		if o.UsedAt != 0 {
+			used = time.Unix(o.UsedAt, 0).UTC().Format(time.RFC3339) + " by " + o.UsedBy
+		}
+		fmt.Fprintf(&sb, "<tr><td class=\"num\">%d</td><td><code>%s</code></td><td>%s</td><td>%s</td><td>%s</td><td>%s</td></tr>\n",
+			o.ID, html.EscapeString(o.GitHash), time.Unix(o.ApprovedAt, 0).UTC().Format(time.RFC3339), html.EscapeString(o.ApprovedBy),
+			html.EscapeString(used), html.EscapeString(o.Reason))
+	}
+	sb.WriteString(`</table>
+<script>
+document.getElementById("approve").addEventListener("submit", function (e) {
+    e.preventDefault();
+    var form = e.target;
+    fetch("/api/overrides", {
+        method: "POST",
+        headers: {"Authorization": "Bearer " + form.token.value, "Content-Type": "application/json"},
+        body: JSON.stringify({git_hash: form.git_hash.value, reason: form.reason.value})
+    }).then(function (resp) {
+        if (resp.ok) {
+            location.reload();
+        } else {
+            resp.text().then(function (text) { document.getElementById("result").textContent = text; });
+        }
+    });
+});
+</script>
+</body>
+</html>
+`)
+	return sb.String()
+}
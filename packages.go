@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/tools/cover"
+)
+
+// 一个包的覆盖率统计
+type PackageCoverage struct {
+	ImportPath string  `json:"package"`
+	Name       string  `json:"name"`
+	Group      string  `json:"group,omitempty"`
+	Files      int     `json:"files"`
+	Statements int     `json:"statements"`
+	Covered    int     `json:"covered"`
+	Percent    float64 `json:"percent"`
+}
+
+// 把文件的覆盖率汇总到包, 按导入路径排序. 包的导入路径和包名由 go list 在 go/src 中解析,
+// 解析不到的包(已删除或不能编译)导入路径取文件所在目录, 包名为空
+func ComputePackageCoverage(profiles []*cover.Profile) ([]PackageCoverage, error) {
+	byDir := make(map[string]*PackageCoverage)
+	for _, stat := range ComputeFileCoverage(profiles) {
+		dir := path.Dir(stat.FileName)
+		pkg := byDir[dir]
+		if pkg == nil {
+			pkg = &PackageCoverage{ImportPath: dir}
+			byDir[dir] = pkg
+		}
+		pkg.Files++
+		pkg.Statements += stat.Statements
+		pkg.Covered += stat.Covered
+	}
+	dirs := make([]string, 0, len(byDir))
+	for dir := range byDir {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	listed, err := listPackages(dirs)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]PackageCoverage, 0, len(dirs))
+	for _, dir := range dirs {
+		pkg := byDir[dir]
+		if info, ok := listed[dir]; ok {
+			pkg.ImportPath, pkg.Name = info[0], info[1]
+		}
+		pkg.Group = g_packageGroups.GroupOf(dir + "/")
+		pkg.Percent = FileCoverage{Statements: pkg.Statements, Covered: pkg.Covered}.Percent()
+		stats = append(stats, *pkg)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].ImportPath < stats[j].ImportPath })
+	return stats, nil
+}
+
+// 用 go list 解析目录(覆盖率中的文件所在目录)对应的包: 目录 -> [导入路径, 包名].
+// go list -m 找到的模块(包括 go.work 中的模块)中的目录在模块中查找, 其他目录在工作区的 GOPATH=go 中查找, 没有工作区(裸仓库)时不解析
+func listPackages(dirs []string) (map[string][2]string, error) {
+	listed := make(map[string][2]string)
+	if len(dirs) == 0 || WorkTreeDir() == "" {
+		return listed, nil
+	}
+	currDir, err := filepath.Abs(WorkTreeDir())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current working directory: %w", err)
+	}
+	byModule := make(map[string][]string) // 模块目录 -> 其中的目录
+	var gopathDirs []string
+	for _, dir := range dirs {
+		if m, _ := moduleOfFile(dir + "/"); m != nil && m.Dir != "" {
+			byModule[m.Dir] = append(byModule[m.Dir], dir)
+		} else {
+			gopathDirs = append(gopathDirs, dir)
+		}
+	}
+	for moduleDir, moduleDirs := range byModule {
+		if err := goListPackages(listed, moduleDir, moduleDirs, moduleEnv()); err != nil {
+			return nil, err
+		}
+	}
+	if len(gopathDirs) > 0 {
+		if err := goListPackages(listed, "", gopathDirs, append(os.Environ(), fmt.Sprintf("GOPATH=%s/go", currDir), "GO111MODULE=off")); err != nil {
+			return nil, err
+		}
+	}
+	return listed, nil
+}
+
+// 在 dir 中以环境变量 env 执行 go list, 结果加入 listed
+func goListPackages(listed map[string][2]string, dir string, pkgs []string, env []string) error {
+	cmd := exec.Command("go", append([]string{"list", "-e", "-f", "{{.ImportPath}}\t{{.Name}}\t{{if .Error}}error{{end}}"}, pkgs...)...)
+	cmd.Dir = dir
+	cmd.Env = env
+	out, err := cmd.Output()
+	if err != nil {
+		// 没有 go 命令等, 不影响统计
+		return nil
+	}
+	s := bufio.NewScanner(strings.NewReader(string(out)))
+	for s.Scan() {
+		fields := strings.Split(s.Text(), "\t")
+		if len(fields) != 3 || fields[1] == "" || fields[2] != "" {
+			continue
+		}
+		listed[fields[0]] = [2]string{fields[0], fields[1]}
+	}
+	return s.Err()
+}
+
+// 按列对齐打印包的覆盖率
+func PrintPackageCoverage(w io.Writer, stats []PackageCoverage) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "package\tname\tfiles\tstatements\tcovered\tcoverage")
+	for _, stat := range stats {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%.1f%%\n", stat.ImportPath, stat.Name, stat.Files, stat.Statements, stat.Covered, stat.Percent)
+	}
+	return tw.Flush()
+}
+
+// 按文件扩展名写出包的覆盖率: .csv, .json, 其他为对齐的文本
+func WritePackageCoverage(fileName string, stats []PackageCoverage) error {
+	outFile, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("error creating outFile: %v", err)
+	}
+	defer outFile.Close()
+	switch path.Ext(fileName) {
+	case ".csv":
+		w := csv.NewWriter(outFile)
+		w.Write([]string{"package", "name", "group", "files", "statements", "covered", "percent"})
+		for _, stat := range stats {
+			w.Write([]string{stat.ImportPath, stat.Name, stat.Group, strconv.Itoa(stat.Files), strconv.Itoa(stat.Statements),
+				strconv.Itoa(stat.Covered), strconv.FormatFloat(stat.Percent, 'f', 1, 64)})
+		}
+		w.Flush()
+		err = w.Error()
+	case ".json":
+		enc := json.NewEncoder(outFile)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(stats)
+	default:
+		err = PrintPackageCoverage(outFile, stats)
+	}
+	if err != nil {
+		return err
+	}
+	return outFile.Close()
+}
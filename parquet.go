@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/parquet-go/parquet-go"
+	"golang.org/x/tools/cover"
+)
+
+// Parquet 中每个块一行
+type ParquetBlockRow struct {
+	FileName  string `parquet:"file_name,dict"`
+	StartLine int32  `parquet:"start_line"`
+	StartCol  int32  `parquet:"start_col"`
+	EndLine   int32  `parquet:"end_line"`
+	EndCol    int32  `parquet:"end_col"`
+	NumStmt   int32  `parquet:"num_stmt"`
+	Count     int64  `parquet:"count"`
+	GitHash   string `parquet:"git_hash,dict"`
+	Timestamp int64  `parquet:"timestamp"`
+	Tags      string `parquet:"tags,dict"`
+	Group     string `parquet:"group,dict"`
+}
+
+// 把每个版本合并后的块写成 Parquet, 文件名不带 git hash 后缀, 版本信息在 git_hash 列
+func WriteParquet(fileName string, mergedByHash map[string][]*cover.Profile, timestamps map[string]int64, strTags string) error {
+	gitHashes := make([]string, 0, len(mergedByHash))
+	for gitHash := range mergedByHash {
+		gitHashes = append(gitHashes, gitHash)
+	}
+	sort.Slice(gitHashes, func(i, j int) bool {
+		return timestamps[gitHashes[i]] < timestamps[gitHashes[j]]
+	})
+
+	outFile, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("error creating parquet file: %v", err)
+	}
+	defer outFile.Close()
+
+	w := parquet.NewGenericWriter[ParquetBlockRow](outFile)
+	for _, gitHash := range gitHashes {
+		for _, p := range mergedByHash[gitHash] {
+			rows := make([]ParquetBlockRow, 0, len(p.Blocks))
+			group := g_packageGroups.GroupOf(p.FileName)
+			for _, b := range p.Blocks {
+				rows = append(rows, ParquetBlockRow{
+					FileName:  p.FileName,
+					StartLine: int32(b.StartLine),
+					StartCol:  int32(b.StartCol),
+					EndLine:   int32(b.EndLine),
+					EndCol:    int32(b.EndCol),
+					NumStmt:   int32(b.NumStmt),
+					Count:     int64(b.Count),
+					GitHash:   gitHash,
+					Timestamp: timestamps[gitHash],
+					Tags:      strTags,
+					Group:     group,
+				})
+			}
+			if _, err := w.Write(rows); err != nil {
+				return fmt.Errorf("failed to write parquet rows: %v", err)
+			}
+		}
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to write parquet file: %v", err)
+	}
+	return outFile.Close()
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 中间文件格式:
+//
+//	# gocovmerge partial v1
+//	# version: <githash> <timestamp> [<tags>]
+//	mode: set
+//	encoding/base64/base64.go:34.44,37.40 3 1
+//	# version: ...
+//
+// 每个版本和标签一段, 段内是标准的 cover profile, 保留 git hash, 时间戳和输入的标签(URL 查询串编码, 如 suite=e2e&env=prod)
+// 供 merge-final 使用
+const (
+	partialHeader        = "# gocovmerge partial v1"
+	partialVersionPrefix = "# version: "
+)
+
+// 创建子命令的参数集
+func NewSubCommandFlagSet(name string, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Println("Usage: ./bin/gocovmerge " + name + " " + usage)
+		fmt.Println("Options:")
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// 让子命令同时接受主命令的参数(如 -outcover, -outhtml), 子命令自己定义的同名参数优先,
+// 需要在子命令定义完自己的参数之后调用
+func ShareGlobalFlags(fs *flag.FlagSet) {
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		if fs.Lookup(f.Name) == nil {
+			fs.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+}
+
+// merge-partial: 把一部分输入按版本合并成中间文件, 供之后 merge-final 汇总
+func runMergePartial(args []string) error {
+	fs := NewSubCommandFlagSet("merge-partial", "[options] [cover.txt.timestamp.hash ...]")
+	strOutFile := fs.String("o", "cover.partial", "输出中间文件")
+	strFormat := fs.String("format", "text", "中间文件格式: text 或 bin(带索引的二进制格式, 重复合并时更快)")
+	strCompress := fs.String("compress", "", "压缩 text 格式的中间文件: gzip 或 zstd(为空不压缩), merge-final 读取时自动识别")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("Error: cover.txt.xxx.xxx file required.")
+	}
+	if *strCompress != "" {
+		if err := checkCompressFormat(*strCompress); err != nil {
+			return err
+		}
+		if *strFormat != "text" {
+			return fmt.Errorf("-compress is only supported by the text format, the bin format needs its index")
+		}
+	}
+
+	fileInfos, err := ParseCoverFileInfos(fs.Args())
+	if err != nil {
+		return err
+	}
+	mergedCoverFiles, err := mergePartialVersions(fileInfos)
+	if err != nil {
+		return err
+	}
+
+	outFile, err := os.Create(*strOutFile)
+	if err != nil {
+		return fmt.Errorf("error creating outFile: %v", err)
+	}
+	defer outFile.Close()
+	var out io.Writer = outFile
+	var cw io.WriteCloser
+	if *strCompress != "" {
+		if cw, err = NewCompressWriter(outFile, *strCompress); err != nil {
+			return err
+		}
+		out = cw
+	}
+
+	switch *strFormat {
+	case "text":
+		err = WritePartial(mergedCoverFiles, out)
+	case "bin":
+		err = WriteBinaryPartial(mergedCoverFiles, out)
+	default:
+		err = fmt.Errorf("unsupported partial format '%s'", *strFormat)
+	}
+	if err == nil && cw != nil {
+		err = cw.Close()
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("generate ", *strOutFile, " ok.")
+	return nil
+}
+
+// 按 git hash 和输入的标签分组合并, 标签不同的输入分开记录, merge-final 之后按标签的功能(-split-by-tag, -html-suites 等)
+// 与直接合并的结果相同
+func mergePartialVersions(fileInfos []*CoverFileInfo) ([]*CoverFileInfo, error) {
+	var keys []string
+	byTags := make(map[string][]*CoverFileInfo)
+	for _, fileInfo := range fileInfos {
+		key := encodePartialTags(fileInfo.Tags)
+		if _, ok := byTags[key]; !ok {
+			keys = append(keys, key)
+		}
+		byTags[key] = append(byTags[key], fileInfo)
+	}
+	var merged []*CoverFileInfo
+	for _, key := range keys {
+		coverFiles, err := MergeByGitHash(byTags[key])
+		if err != nil {
+			return nil, err
+		}
+		for _, coverFile := range coverFiles {
+			coverFile.Tags = byTags[key][0].Tags
+		}
+		merged = append(merged, coverFiles...)
+	}
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].Timestamp < merged[j].Timestamp })
+	return merged, nil
+}
+
+// 标签编码为按 key 排序的 URL 查询串, 值中可以有空格, 逗号等
+func encodePartialTags(tags map[string]string) string {
+	values := make(url.Values, len(tags))
+	for key, value := range tags {
+		values.Set(key, value)
+	}
+	return values.Encode()
+}
+
+func decodePartialTags(s string) (map[string]string, error) {
+	values, err := url.ParseQuery(s)
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[string]string, len(values))
+	for key := range values {
+		tags[key] = values.Get(key)
+	}
+	return tags, nil
+}
+
+// merge-final: 汇总多个中间文件, 走完整的跨版本合并并输出覆盖率文件和 HTML 报告
+func runMergeFinal(args []string) error {
+	fs := NewSubCommandFlagSet("merge-final", "[options] [cover.partial ...]")
+	ShareGlobalFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("Error: cover.partial file required.")
+	}
+
+	var fileInfos []*CoverFileInfo
+	for _, file := range fs.Args() {
+		var partialInfos []*CoverFileInfo
+		var err error
+		if IsBinaryPartial(file) {
+			partialInfos, err = ReadBinaryPartial(file)
+		} else {
+			partialInfos, err = ReadPartial(file)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read partial file %s: %v", file, err)
+		}
+		fileInfos = append(fileInfos, partialInfos...)
+	}
+	if err := Merge(fileInfos); err != nil {
+		return err
+	}
+	fmt.Println("generate ", *g_strOutCoverFile, " and ", *g_strOutHTMLFile, " ok.")
+	return nil
+}
+
+// 写出中间文件
+func WritePartial(coverFiles []*CoverFileInfo, out io.Writer) error {
+	w := bufio.NewWriter(out)
+	if _, err := fmt.Fprintln(w, partialHeader); err != nil {
+		return err
+	}
+	for _, coverFile := range coverFiles {
+		line := fmt.Sprintf("%s%s %d", partialVersionPrefix, coverFile.GitHash, coverFile.Timestamp)
+		if tags := encodePartialTags(coverFile.Tags); tags != "" {
+			line += " " + tags
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+		if err := DumpProfiles(coverFile.Profiles, w); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// 读取中间文件(可以是 gzip 或 zstd 压缩的), 每个版本一个 CoverFileInfo
+func ReadPartial(fileName string) ([]*CoverFileInfo, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, closeReader, err := NewDecompressReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer closeReader()
+
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	if !s.Scan() || s.Text() != partialHeader {
+		return nil, fmt.Errorf("not a gocovmerge partial file")
+	}
+
+	var coverFiles []*CoverFileInfo
+	var body strings.Builder
+	flush := func() error {
+		if len(coverFiles) == 0 {
+			return nil
+		}
+		profiles, err := ParseProfilesBytes([]byte(body.String()))
+		if err != nil {
+			return err
+		}
+		coverFiles[len(coverFiles)-1].Profiles = profiles
+		body.Reset()
+		return nil
+	}
+	for s.Scan() {
+		line := s.Text()
+		if !strings.HasPrefix(line, partialVersionPrefix) {
+			if len(coverFiles) == 0 {
+				return nil, fmt.Errorf("profile data before version line")
+			}
+			body.WriteString(line)
+			body.WriteByte('\n')
+			continue
+		}
+		if err := flush(); err != nil {
+			return nil, err
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, partialVersionPrefix))
+		if len(fields) != 2 && len(fields) != 3 {
+			return nil, fmt.Errorf("bad version line: %v", line)
+		}
+		timestamp, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("timestamp is not valid")
+		}
+		var tags map[string]string
+		if len(fields) == 3 {
+			if tags, err = decodePartialTags(fields[2]); err != nil {
+				return nil, fmt.Errorf("bad tags in version line: %v", line)
+			}
+		}
+		coverFiles = append(coverFiles, &CoverFileInfo{
+			Timestamp: timestamp,
+			GitHash:   fields[0],
+			FileName:  fileName,
+			Tags:      tags,
+		})
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return coverFiles, nil
+}
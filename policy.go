@@ -0,0 +1,151 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/tools/cover"
+)
+
+var g_strOutPolicy = flag.String("outpolicy", "", "输出门禁规则的评估结果: 每条规则的对象, 阈值, 实测值和是否通过, .json 结尾时输出 JSON, 否则输出 HTML(为空不输出)")
+
+//go:embed assets/policy.css
+var g_policyCSS string
+
+// 一条规则对一个对象的评估结果
+type PolicyRule struct {
+	Rule      string  `json:"rule"`   // 规则对应的参数, 如 min-coverage
+	Target    string  `json:"target"` // total 或包的导入路径
+	Threshold float64 `json:"threshold"`
+	Measured  float64 `json:"measured"`
+	Passed    bool    `json:"passed"`
+	Blocking  bool    `json:"blocking"` // 不通过时是否以错误退出, 否则只在报告(如 JUnit XML)中失败
+	Message   string  `json:"message"`
+}
+
+type PolicyReport struct {
+	GeneratedAt string       `json:"generated_at"`
+	Passed      bool         `json:"passed"`             // 所有 blocking 的规则都通过, 或使用了门禁豁免
+	Override    string       `json:"override,omitempty"` // 使用的门禁豁免
+	Rules       []PolicyRule `json:"rules"`
+}
+
+func newPolicyRule(rule, target string, threshold, measured float64, bBlocking bool) PolicyRule {
+	r := PolicyRule{Rule: rule, Target: target, Threshold: threshold, Measured: roundPercent(measured), Passed: measured >= threshold, Blocking: bBlocking}
+	if r.Passed {
+		r.Message = fmt.Sprintf("coverage %.1f%% meets %.1f%%", measured, threshold)
+	} else {
+		r.Message = fmt.Sprintf("coverage %.1f%% is below %.1f%%", measured, threshold)
+	}
+	return r
+}
+
+// 评估这次合并用到的规则: -min-coverage 的总覆盖率, 以及 -outjunit 时每个包的 -junit-min.
+// profiles 为每个文件的最新版本
+func EvaluatePolicies(summary *MergeSummary, profiles []*cover.Profile) (*PolicyReport, error) {
+	report := &PolicyReport{GeneratedAt: time.Now().UTC().Format(time.RFC3339), Passed: true, Rules: []PolicyRule{}}
+	if *g_fMinCoverage > 0 {
+		report.Rules = append(report.Rules, newPolicyRule("min-coverage", "total", *g_fMinCoverage, summary.Total, true))
+	}
+	fJUnitMin := *g_fJUnitMin
+	if fJUnitMin == 0 {
+		fJUnitMin = *g_fMinCoverage
+	}
+	if *g_strOutJUnit != "" && fJUnitMin > 0 {
+		stats, err := ComputePackageCoverage(profiles)
+		if err != nil {
+			return nil, err
+		}
+		for _, stat := range stats {
+			// 与 JUnit XML 一样, 没有语句的包不检查
+			if stat.Statements > 0 {
+				report.Rules = append(report.Rules, newPolicyRule("junit-min", stat.ImportPath, fJUnitMin, stat.Percent, false))
+			}
+		}
+	}
+	for _, rule := range report.Rules {
+		if rule.Blocking && !rule.Passed {
+			report.Passed = false
+		}
+	}
+	if summary.Override != "" {
+		report.Passed, report.Override = true, summary.Override
+	}
+	return report, nil
+}
+
+// 输出 -outpolicy
+func WritePolicyReport(fileName string, summary *MergeSummary, profiles []*cover.Profile) error {
+	report, err := EvaluatePolicies(summary, profiles)
+	if err != nil {
+		return err
+	}
+	outFile, upload, err := LocalOutput(fileName)
+	if err != nil {
+		return err
+	}
+	var data []byte
+	if strings.HasSuffix(fileName, ".json") {
+		data, err = json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		data = append(data, '\n')
+	} else {
+		content := policyHTML(report)
+		if err := CheckOfflineHTML(content); err != nil {
+			return err
+		}
+		data = []byte(content)
+	}
+	if err := os.WriteFile(outFile, data, 0644); err != nil {
+		return err
+	}
+	return upload()
+}
+
+func policyHTML(report *PolicyReport) string {
+	var sb strings.Builder
+	sb.WriteString("<!DOCTYPE html>\n<html lang=\"en\">\n<head>\n<meta charset=\"utf-8\">\n<title>Coverage policy</title>\n<style>\n")
+	sb.WriteString(g_policyCSS)
+	sb.WriteString("</style>\n</head>\n<body>\n<h1>Coverage policy</h1>\n")
+	failed, blocking := 0, 0
+	for _, rule := range report.Rules {
+		if !rule.Passed {
+			failed++
+			if rule.Blocking {
+				blocking++
+			}
+		}
+	}
+	result, class := "passed", "pass"
+	if !report.Passed {
+		result, class = "failed", "fail"
+	}
+	fmt.Fprintf(&sb, "<p class=\"summary\">Gate <strong class=\"%s\">%s</strong>: %d of %d rules failed, %d of them blocking. Generated at %s.</p>\n",
+		class, result, failed, len(report.Rules), blocking, html.EscapeString(report.GeneratedAt))
+	if report.Override != "" {
+		fmt.Fprintf(&sb, "<p class=\"summary\">The gate was bypassed by %s.</p>\n", html.EscapeString(report.Override))
+	}
+	sb.WriteString("<table>\n<tr><th>Rule</th><th>Target</th><th class=\"num\">Threshold</th><th class=\"num\">Measured</th><th>Result</th><th>Blocking</th><th>Message</th></tr>\n")
+	for _, rule := range report.Rules {
+		result, class := "pass", "pass"
+		if !rule.Passed {
+			result, class = "fail", "fail"
+		}
+		bBlocking := "no"
+		if rule.Blocking {
+			bBlocking = "yes"
+		}
+		fmt.Fprintf(&sb, "<tr class=\"%s\"><td><code>-%s</code></td><td><code>%s</code></td><td class=\"num\">%.1f%%</td><td class=\"num\">%.1f%%</td><td class=\"%s\">%s</td><td>%s</td><td>%s</td></tr>\n",
+			class, html.EscapeString(rule.Rule), html.EscapeString(rule.Target), rule.Threshold, rule.Measured, class, result, bBlocking, html.EscapeString(rule.Message))
+	}
+	sb.WriteString("</table>\n</body>\n</html>\n")
+	return sb.String()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"golang.org/x/tools/cover"
+)
+
+var g_strOutQuickfix = flag.String("outquickfix", "", "以 file:line:col: message 格式输出每个文件最新版本中未覆盖的代码, 可以作为 Vim quickfix 列表(vim -q)跳转, - 输出到标准输出(为空不输出)")
+
+// 输出 -outquickfix, profiles 为每个文件的最新版本, 路径为仓库中的路径 go/src/<文件名>.
+// 首尾相接的未覆盖块合并为一项
+func WriteQuickfix(fileName string, profiles []*cover.Profile) error {
+	if fileName == "-" {
+		return DumpQuickfix(profiles, os.Stdout)
+	}
+	outFile, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("error creating outFile: %v", err)
+	}
+	defer outFile.Close()
+	if err := DumpQuickfix(profiles, outFile); err != nil {
+		return err
+	}
+	if err := outFile.Close(); err != nil {
+		return err
+	}
+	fmt.Println("generate ", fileName, " ok.")
+	return nil
+}
+
+func DumpQuickfix(profiles []*cover.Profile, out io.Writer) error {
+	w := bufio.NewWriter(out)
+	sorted := append([]*cover.Profile(nil), profiles...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].FileName < sorted[j].FileName })
+	for _, p := range sorted {
+		var pending *cover.ProfileBlock // 正在合并的未覆盖块
+		flush := func() {
+			if pending == nil {
+				return
+			}
+			msg := fmt.Sprintf("uncovered: %d statements", pending.NumStmt)
+			if pending.NumStmt == 1 {
+				msg = "uncovered: 1 statement"
+			}
+			if pending.EndLine > pending.StartLine {
+				msg += fmt.Sprintf(" (lines %d-%d)", pending.StartLine, pending.EndLine)
+			}
+			fmt.Fprintf(w, "%s:%d:%d: %s\n", RepoPath(p.FileName), pending.StartLine, pending.StartCol, msg)
+			pending = nil
+		}
+		for _, b := range p.Blocks {
+			if b.Count > 0 || b.NumStmt == 0 {
+				if b.Count > 0 {
+					flush()
+				}
+				continue
+			}
+			if pending != nil && pending.EndLine == b.StartLine && pending.EndCol == b.StartCol {
+				pending.EndLine, pending.EndCol = b.EndLine, b.EndCol
+				pending.NumStmt += b.NumStmt
+				continue
+			}
+			flush()
+			block := b
+			pending = &block
+		}
+		flush()
+	}
+	return w.Flush()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// 版本标识为 git hash 时原样使用, 否则(分支, tag 如 v1.2.3)是 git 引用, 解析为提交的 hash
+var g_reGitHash = regexp.MustCompile(`^[0-9a-f]{4,40}$`)
+
+// 把输入的版本统一为提交的短 hash, 同一提交的引用, 短 hash 和完整 hash 因此是同一个版本.
+// 每个提交使用输入中最短的 hash, 只有引用时使用 7 位; 仓库中没有的 hash(如浅克隆)按原样使用.
+// 引用在合并时解析: tag 总是对应构建时的提交, 分支对应它当前指向的提交
+func ResolveVersionRefs(fileInfos []*CoverFileInfo) error {
+	var versions []string                  // 按输入顺序
+	fullHashes := make(map[string]string)  // 版本 -> 完整 hash, 不能解析的 hash 为空
+	shortHashes := make(map[string]string) // 完整 hash -> 输入中最短的 hash
+	for _, fileInfo := range fileInfos {
+		version := fileInfo.GitHash
+		if _, ok := fullHashes[version]; ok || version == "" {
+			continue
+		}
+		versions = append(versions, version)
+		bHash := g_reGitHash.MatchString(version)
+		full, err := resolveRef(version)
+		if err != nil {
+			if !bHash {
+				return fmt.Errorf("%v in %s", err, fileInfo.FileName)
+			}
+			full = ""
+		}
+		fullHashes[version] = full
+		if short, ok := shortHashes[full]; bHash && full != "" && (!ok || len(version) < len(short)) {
+			shortHashes[full] = version
+		}
+	}
+	renamed := make(map[string]string) // 版本 -> 统一后的短 hash
+	for _, version := range versions {
+		full := fullHashes[version]
+		if full == "" {
+			continue
+		}
+		gitHash, ok := shortHashes[full]
+		if !ok {
+			gitHash = full[:7]
+			shortHashes[full] = gitHash
+		}
+		if gitHash != version {
+			renamed[version] = gitHash
+			fmt.Printf("version %s is commit %s\n", version, gitHash)
+		}
+	}
+	for _, fileInfo := range fileInfos {
+		if gitHash, ok := renamed[fileInfo.GitHash]; ok {
+			fileInfo.GitHash = gitHash
+		}
+	}
+	return nil
+}
+
+// 解析引用为完整的 commit hash
+func resolveRef(ref string) (string, error) {
+	if g_gitBackend != nil {
+		return g_gitBackend.Resolve(ref)
+	}
+	return GitRevParse(ref)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/cover"
+)
+
+var g_bRemapLines = flag.Bool("remap-lines", false, "按 git diff 把旧版本中没有改动的区域的块平移到文件的最新版本, 合并到最新版本的覆盖率中, 只有改动区域的块留在旧版本")
+
+// git diff -U0 的一个 hunk: 旧版本从 oldStart 行开始的 oldLines 行改为新版本从 newStart 行开始的 newLines 行
+type lineHunk struct {
+	oldStart, oldLines int
+	newStart, newLines int
+}
+
+// 解析 @@ -a,b +c,d @@, 省略行数时为 1
+func parseHunkHeader(line string) (lineHunk, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 || !strings.HasPrefix(fields[1], "-") || !strings.HasPrefix(fields[2], "+") {
+		return lineHunk{}, false
+	}
+	parse := func(s string) (int, int, bool) {
+		start, count, bCount := strings.Cut(s[1:], ",")
+		n, err := strconv.Atoi(start)
+		if err != nil {
+			return 0, 0, false
+		}
+		if !bCount {
+			return n, 1, true
+		}
+		c, err := strconv.Atoi(count)
+		return n, c, err == nil
+	}
+	var h lineHunk
+	var ok1, ok2 bool
+	h.oldStart, h.oldLines, ok1 = parse(fields[1])
+	h.newStart, h.newLines, ok2 = parse(fields[2])
+	return h, ok1 && ok2
+}
+
+// 文件在两个版本之间的 hunk, 按行号排序
+func DiffHunks(oldHash string, newHash string, filePath string) ([]lineHunk, error) {
+	out, err := GitCommand("diff", "--no-color", "--no-ext-diff", "-U0", oldHash, newHash, "--", filePath).Output()
+	if err != nil {
+		return nil, fmt.Errorf("git diff %s %s -- %s: %v", oldHash, newHash, filePath, err)
+	}
+	var hunks []lineHunk
+	s := bufio.NewScanner(bytes.NewReader(out))
+	s.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for s.Scan() {
+		if !strings.HasPrefix(s.Text(), "@@") {
+			continue
+		}
+		if h, ok := parseHunkHeader(s.Text()); ok {
+			hunks = append(hunks, h)
+		}
+	}
+	return hunks, s.Err()
+}
+
+// 把旧版本 [start, end] 行的区域平移到新版本, 区域内有改动时返回 false
+func remapLineRange(hunks []lineHunk, start int, end int) (int, bool) {
+	shift := 0
+	for _, h := range hunks {
+		if h.oldLines == 0 {
+			// 在 oldStart 行之后插入
+			if h.oldStart < start {
+				shift += h.newLines
+			} else if h.oldStart < end {
+				return 0, false
+			}
+			continue
+		}
+		last := h.oldStart + h.oldLines - 1
+		if last < start {
+			shift += h.newLines - h.oldLines
+		} else if h.oldStart <= end {
+			return 0, false
+		}
+	}
+	return shift, true
+}
+
+// -remap-lines: 每个文件以时间最新的版本为准, 把其他版本中没有改动的区域的块按 git diff 平移后合并到最新版本相同位置的块.
+// 改动区域的块, 以及平移后在最新版本中找不到相同块的(如插桩方式不同), 仍然留在原来的版本, 块全部合并后旧版本不再单独列出
+func RemapLines(mergedByHash map[string][]*cover.Profile, timestamps map[string]int64) {
+	versions := make(map[string][]string) // 文件名 -> 有该文件的版本
+	for gitHash, profiles := range mergedByHash {
+		for _, p := range profiles {
+			versions[p.FileName] = append(versions[p.FileName], gitHash)
+		}
+	}
+	fileNames := make([]string, 0, len(versions))
+	for fileName := range versions {
+		fileNames = append(fileNames, fileName)
+	}
+	sort.Strings(fileNames)
+
+	findProfile := func(gitHash, fileName string) *cover.Profile {
+		profiles := mergedByHash[gitHash]
+		i := sort.Search(len(profiles), func(i int) bool { return profiles[i].FileName >= fileName })
+		if i < len(profiles) && profiles[i].FileName == fileName {
+			return profiles[i]
+		}
+		return nil
+	}
+	nRemapped, nVersions := 0, 0
+	for _, fileName := range fileNames {
+		hashes := versions[fileName]
+		if len(hashes) < 2 {
+			continue
+		}
+		sort.Slice(hashes, func(i, j int) bool {
+			if timestamps[hashes[i]] != timestamps[hashes[j]] {
+				return timestamps[hashes[i]] < timestamps[hashes[j]]
+			}
+			return hashes[i] < hashes[j]
+		})
+		latestHash := hashes[len(hashes)-1]
+		target := findProfile(latestHash, fileName)
+		targetBlocks := make(map[blockPoint]int) // 块的起始位置 -> 下标
+		for i, b := range target.Blocks {
+			targetBlocks[blockStart(b)] = i
+		}
+		filePath := RepoPath(fileName)
+		for _, gitHash := range hashes[:len(hashes)-1] {
+			hunks, err := DiffHunks(gitHash, latestHash, filePath)
+			if err != nil {
+				fmt.Println("warning: remap lines:", err)
+				continue
+			}
+			p := findProfile(gitHash, fileName)
+			var rest []cover.ProfileBlock
+			for _, b := range p.Blocks {
+				shift, ok := remapLineRange(hunks, b.StartLine, b.EndLine)
+				if ok {
+					if i, found := targetBlocks[blockPoint{b.StartLine + shift, b.StartCol}]; found {
+						tb := &target.Blocks[i]
+						if tb.EndLine == b.EndLine+shift && tb.EndCol == b.EndCol && tb.NumStmt == b.NumStmt {
+							tb.Count = mergeCount(target.Mode, tb.Count, b.Count)
+							nRemapped++
+							continue
+						}
+					}
+				}
+				rest = append(rest, b)
+			}
+			p.Blocks = rest
+			if len(rest) == 0 {
+				nVersions++
+				removeProfile(mergedByHash, gitHash, fileName)
+			}
+		}
+	}
+	if nRemapped > 0 {
+		fmt.Printf("remap lines: %d blocks merged into the latest versions, %d older file versions no longer listed\n", nRemapped, nVersions)
+	}
+}
+
+func removeProfile(mergedByHash map[string][]*cover.Profile, gitHash string, fileName string) {
+	profiles := mergedByHash[gitHash][:0]
+	for _, p := range mergedByHash[gitHash] {
+		if p.FileName != fileName {
+			profiles = append(profiles, p)
+		}
+	}
+	if len(profiles) == 0 {
+		delete(mergedByHash, gitHash)
+		return
+	}
+	mergedByHash[gitHash] = profiles
+}
@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+func TestParseHunkHeader(t *testing.T) {
+	for _, tc := range []struct {
+		line string
+		want lineHunk
+		ok   bool
+	}{
+		{"@@ -3,0 +4,2 @@ func foo() {", lineHunk{3, 0, 4, 2}, true},
+		{"@@ -10,3 +9,0 @@", lineHunk{10, 3, 9, 0}, true},
+		{"@@ -5 +5 @@", lineHunk{5, 1, 5, 1}, true},
+		{"@@ -5 +6,2 @@", lineHunk{5, 1, 6, 2}, true},
+		{"@@ -3,0 +4 @@", lineHunk{3, 0, 4, 1}, true},
+		{"@@ -x +5 @@", lineHunk{}, false},
+		{"@@ +5 -5 @@", lineHunk{}, false},
+	} {
+		got, ok := parseHunkHeader(tc.line)
+		if ok != tc.ok || ok && got != tc.want {
+			t.Errorf("parseHunkHeader(%q) = %+v, %v, want %+v, %v", tc.line, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+// 旧版本第 10 到 12 行的块在各种 hunk 下的平移
+func TestRemapLineRange(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		hunks []lineHunk
+		shift int
+		ok    bool
+	}{
+		{"no change", nil, 0, true},
+		{"insert at file start", []lineHunk{{0, 0, 1, 3}}, 3, true},
+		{"insert before", []lineHunk{{9, 0, 10, 2}}, 2, true},
+		{"insert after first line", []lineHunk{{10, 0, 11, 1}}, 0, false},
+		{"insert before last line", []lineHunk{{11, 0, 12, 1}}, 0, false},
+		{"insert right after", []lineHunk{{12, 0, 13, 4}}, 0, true},
+		{"insert after", []lineHunk{{20, 0, 21, 1}}, 0, true},
+		{"delete before", []lineHunk{{3, 2, 2, 0}}, -2, true},
+		{"delete overlapping start", []lineHunk{{9, 2, 8, 0}}, 0, false},
+		{"delete inside", []lineHunk{{11, 1, 10, 0}}, 0, false},
+		{"delete right after", []lineHunk{{13, 1, 12, 0}}, 0, true},
+		{"replace before with more lines", []lineHunk{{5, 1, 5, 3}}, 2, true},
+		{"replace one line before, counts omitted", []lineHunk{{5, 1, 5, 1}}, 0, true},
+		{"replace one line inside, counts omitted", []lineHunk{{12, 1, 12, 1}}, 0, false},
+		{"insert and delete before", []lineHunk{{2, 0, 3, 1}, {5, 2, 5, 0}}, -1, true},
+		{"change before and after", []lineHunk{{1, 1, 1, 2}, {30, 0, 32, 5}}, 1, true},
+	} {
+		shift, ok := remapLineRange(tc.hunks, 10, 12)
+		if ok != tc.ok || ok && shift != tc.shift {
+			t.Errorf("%s: remapLineRange = %d, %v, want %d, %v", tc.name, shift, ok, tc.shift, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+var (
+	g_strRepo     = flag.String("repo", "", "git 仓库的根目录(含 go/src), 默认为当前目录, 用于在 CI 工作区的其他目录中执行")
+	g_strGitDir   = flag.String("git-dir", "", "git 目录, 同 git --git-dir, 可以是裸仓库(如镜像), 此时源码导出到临时目录")
+	g_strWorkTree = flag.String("work-tree", "", "工作区目录, 同 git --work-tree, 默认为 -repo 或当前目录")
+)
+
+// 解析 -repo, -git-dir, -work-tree 为绝对路径(之后的 git 命令和导出源码不受当前目录影响), 并设置导出源码的目录.
+// 合并前调用一次
+func SetupRepo() error {
+	for _, dir := range []*string{g_strRepo, g_strGitDir, g_strWorkTree} {
+		if *dir == "" {
+			continue
+		}
+		abs, err := filepath.Abs(*dir)
+		if err != nil {
+			return err
+		}
+		if info, err := os.Stat(abs); err != nil || !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", *dir)
+		}
+		*dir = abs
+	}
+	if err := setupGitBackend(); err != nil {
+		return err
+	}
+	if g_gitBackend == nil && (*g_strGitDir != "" || *g_strRepo != "") {
+		if err := GitCommand("rev-parse", "--git-dir").Run(); err != nil {
+			return fmt.Errorf("no git repository at %s", firstNonEmpty(*g_strGitDir, *g_strRepo))
+		}
+	}
+	g_strSourceRoot = defaultSourceRoot()
+	return nil
+}
+
+// git 命令的全局参数
+func gitRepoArgs() []string {
+	var args []string
+	if *g_strRepo != "" {
+		args = append(args, "-C", *g_strRepo)
+	}
+	if *g_strGitDir != "" {
+		args = append(args, "--git-dir="+*g_strGitDir)
+	}
+	if *g_strWorkTree != "" {
+		args = append(args, "--work-tree="+*g_strWorkTree)
+	}
+	return args
+}
+
+// 工作区目录, 只指定 -git-dir(裸仓库)时没有工作区, 返回空
+func WorkTreeDir() string {
+	switch {
+	case *g_strWorkTree != "":
+		return *g_strWorkTree
+	case *g_strGitDir != "" && *g_strRepo == "":
+		return ""
+	case *g_strRepo != "":
+		return *g_strRepo
+	}
+	return "."
+}
+
+// 工作区中导出源码的目录 go/src, 没有工作区时为空
+func defaultSourceRoot() string {
+	dir := WorkTreeDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "go", "src")
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
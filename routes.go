@@ -0,0 +1,343 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/tools/cover"
+)
+
+var g_strOutRoutes = flag.String("outroutes", "", "输出 HTTP 路由覆盖率表: 源码中注册的每个路由(net/http, gin, echo)的处理函数是否被覆盖, "+
+	"- 输出到标准输出, .csv 或 .json 结尾时输出 CSV 或 JSON(为空不输出)")
+
+// 一个 HTTP 路由的覆盖情况
+type RouteCoverage struct {
+	Method     string   `json:"method"` // GET 等, 不限方法时为 ANY
+	Path       string   `json:"path"`   // 加上了 Group 的前缀
+	Framework  string   `json:"framework"`
+	Position   string   `json:"position"` // 注册路由的位置, 文件:行号
+	Handlers   []string `json:"handlers"` // 处理函数: 包的导入路径.函数名, 匿名函数为 文件:行号
+	Statements int      `json:"statements"`
+	Covered    int      `json:"covered"`
+	Percent    float64  `json:"percent"`
+	Status     string   `json:"status"` // covered(处理函数执行过), uncovered 或 unresolved(找不到处理函数的覆盖率)
+}
+
+const (
+	importGin  = "github.com/gin-gonic/gin"
+	importEcho = "github.com/labstack/echo"
+)
+
+// gin 和 echo 注册路由的方法, 第一个参数是路径
+var g_routeMethods = map[string]string{
+	"GET": "GET", "POST": "POST", "PUT": "PUT", "DELETE": "DELETE", "PATCH": "PATCH",
+	"HEAD": "HEAD", "OPTIONS": "OPTIONS", "CONNECT": "CONNECT", "TRACE": "TRACE", "Any": "ANY",
+}
+
+// 一个文件中注册的路由
+type routeFile struct {
+	pkg     string
+	file    string
+	blocks  []cover.ProfileBlock
+	fset    *token.FileSet
+	imports map[string]string // 包名 -> 导入路径
+	stats   rpcFuncStats
+	methods map[string]map[string]bool
+}
+
+// 找出每个文件最新版本中注册的路由并计算处理函数的覆盖率, 源码为导出的 go/src/<文件>.<git hash>
+func ComputeRouteCoverage(profiles []*cover.Profile, latestHashes map[string]string) []RouteCoverage {
+	stats, methods, _ := collectRPC(profiles, latestHashes)
+	var routes []RouteCoverage
+	for _, p := range profiles {
+		fileName := VersionedSourcePath(p.FileName, latestHashes[p.FileName])
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, fileName, nil, parser.SkipObjectResolution)
+		if err != nil {
+			continue
+		}
+		rf := &routeFile{pkg: path.Dir(p.FileName), file: p.FileName, blocks: p.Blocks, fset: fset, imports: make(map[string]string), stats: stats, methods: methods}
+		for _, spec := range file.Imports {
+			importPath, _ := strconv.Unquote(spec.Path.Value)
+			name := path.Base(importPath)
+			if strings.HasPrefix(importPath, importEcho) {
+				name = "echo"
+			}
+			if spec.Name != nil {
+				name = spec.Name.Name
+			}
+			rf.imports[name] = importPath
+		}
+		routes = append(routes, rf.routes(file)...)
+	}
+	sort.SliceStable(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+	return routes
+}
+
+func (rf *routeFile) importsPrefix(prefix string) bool {
+	for _, importPath := range rf.imports {
+		if strings.HasPrefix(importPath, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// 按调用的形式识别路由:
+// net/http 的 Handle/HandleFunc(pattern, handler), gin 的 GET(path, handlers...) 和 Handle(method, path, handlers...),
+// echo 的 GET(path, handler, middleware...) 和 Add(method, path, handler, middleware...).
+// Group(prefix) 赋值给变量时, 之后该变量注册的路由加上前缀
+func (rf *routeFile) routes(file *ast.File) []RouteCoverage {
+	bHTTP, bGin, bEcho := rf.importsPrefix("net/http"), rf.importsPrefix(importGin), rf.importsPrefix(importEcho)
+	if !bHTTP && !bGin && !bEcho {
+		return nil
+	}
+	prefixes := make(map[string]string) // 变量名 -> Group 的前缀
+	var routes []RouteCoverage
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.AssignStmt:
+			if len(n.Lhs) != 1 || len(n.Rhs) != 1 {
+				return true
+			}
+			lhs, ok := n.Lhs[0].(*ast.Ident)
+			call, ok2 := n.Rhs[0].(*ast.CallExpr)
+			if !ok || !ok2 || len(call.Args) == 0 {
+				return true
+			}
+			if sel, ok := call.Fun.(*ast.SelectorExpr); ok && sel.Sel.Name == "Group" && (bGin || bEcho) {
+				if prefix, ok := stringLit(call.Args[0]); ok {
+					prefixes[lhs.Name] = prefixes[exprName(sel.X)] + prefix
+				}
+			}
+		case *ast.CallExpr:
+			sel, ok := n.Fun.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			route := RouteCoverage{Position: fmt.Sprintf("%s:%d", rf.file, rf.fset.Position(n.Pos()).Line)}
+			var handler ast.Expr
+			prefix := prefixes[exprName(sel.X)]
+			switch name := sel.Sel.Name; {
+			case (name == "Handle" || name == "HandleFunc") && len(n.Args) == 2 && bHTTP:
+				pattern, ok := stringLit(n.Args[0])
+				if !ok {
+					return true
+				}
+				// Go 1.22 的模式可以带方法, 如 "GET /items/{id}"
+				route.Method, route.Path = "ANY", pattern
+				if method, rest, ok := strings.Cut(pattern, " "); ok && method == strings.ToUpper(method) {
+					route.Method, route.Path = method, strings.TrimSpace(rest)
+				}
+				route.Framework, handler = "net/http", n.Args[1]
+			case g_routeMethods[name] != "" && len(n.Args) >= 2 && (bGin || bEcho):
+				p, ok := stringLit(n.Args[0])
+				if !ok {
+					return true
+				}
+				route.Method, route.Path = g_routeMethods[name], prefix+p
+				route.Framework, handler = rf.framework(bGin, bEcho, n.Args[1:])
+			case (name == "Handle" && bGin || name == "Add" && bEcho) && len(n.Args) >= 3:
+				method, ok := stringLit(n.Args[0])
+				p, ok2 := stringLit(n.Args[1])
+				if !ok || !ok2 {
+					return true
+				}
+				route.Method, route.Path = method, prefix+p
+				route.Framework, handler = rf.framework(name == "Handle", name == "Add", n.Args[2:])
+			default:
+				return true
+			}
+			rf.setHandler(&route, handler)
+			routes = append(routes, route)
+		}
+		return true
+	})
+	return routes
+}
+
+// gin 的最后一个参数是处理函数(前面是中间件), echo 的第一个参数是处理函数(后面是中间件)
+func (rf *routeFile) framework(bGin, bEcho bool, handlers []ast.Expr) (string, ast.Expr) {
+	if bEcho && !bGin {
+		return "echo", handlers[0]
+	}
+	return "gin", handlers[len(handlers)-1]
+}
+
+// 找到处理函数并统计覆盖率
+func (rf *routeFile) setHandler(route *RouteCoverage, handler ast.Expr) {
+	route.Status = "unresolved"
+	if lit, ok := handler.(*ast.FuncLit); ok {
+		start, end := rf.fset.Position(lit.Pos()), rf.fset.Position(lit.End())
+		r := funcRange{start: start, end: end}
+		route.Handlers = []string{fmt.Sprintf("%s:%d", rf.file, start.Line)}
+		bFound := false
+		for _, b := range rf.blocks {
+			if r.contains(b.StartLine, b.StartCol) {
+				bFound = true
+				route.Statements += b.NumStmt
+				if b.Count > 0 {
+					route.Covered += b.NumStmt
+				}
+			}
+		}
+		if bFound {
+			route.Status = "uncovered"
+		}
+	} else {
+		for _, name := range rf.resolve(handler) {
+			route.Handlers = append(route.Handlers, name)
+			if stat := rf.stats[name]; stat != nil {
+				route.Statements += stat.Statements
+				route.Covered += stat.Covered
+				route.Status = "uncovered"
+			}
+		}
+	}
+	if route.Covered > 0 {
+		route.Status = "covered"
+	}
+	route.Percent = roundPercent(FileCoverage{Statements: route.Statements, Covered: route.Covered}.Percent())
+}
+
+// 处理函数的名称(包的导入路径.函数名), 方法值按方法名在同一个包中查找, 可能有多个
+func (rf *routeFile) resolve(handler ast.Expr) []string {
+	switch h := handler.(type) {
+	case *ast.Ident:
+		return []string{rf.pkg + "." + h.Name}
+	case *ast.CallExpr:
+		// http.HandlerFunc(f), gin.WrapF(f), echo.WrapHandler(h), http.StripPrefix(prefix, h)
+		name := exprName(h.Fun)
+		name = name[strings.LastIndex(name, ".")+1:]
+		switch {
+		case (name == "HandlerFunc" || name == "WrapF" || name == "WrapH" || name == "WrapHandler") && len(h.Args) == 1:
+			return rf.resolve(h.Args[0])
+		case name == "StripPrefix" && len(h.Args) == 2:
+			return rf.resolve(h.Args[1])
+		}
+	case *ast.UnaryExpr:
+		if h.Op == token.AND {
+			return rf.resolve(h.X)
+		}
+	case *ast.CompositeLit:
+		// 实现了 http.Handler 的类型
+		if id, ok := h.Type.(*ast.Ident); ok {
+			return rf.methodNames(rf.pkg, id.Name, "ServeHTTP")
+		}
+	case *ast.SelectorExpr:
+		if x, ok := h.X.(*ast.Ident); ok && rf.imports[x.Name] != "" {
+			return []string{rf.imports[x.Name] + "." + h.Sel.Name}
+		}
+		var names []string
+		for key, set := range rf.methods {
+			typePkg, typeName := key[:strings.LastIndex(key, ".")], key[strings.LastIndex(key, ".")+1:]
+			if typePkg == rf.pkg && set[h.Sel.Name] {
+				names = append(names, rf.methodNames(typePkg, typeName, h.Sel.Name)...)
+			}
+		}
+		sort.Strings(names)
+		return names
+	}
+	return nil
+}
+
+// 有覆盖率的方法名, 指针接收者或值接收者
+func (rf *routeFile) methodNames(pkg, typeName, method string) []string {
+	var names []string
+	for _, name := range []string{"*" + typeName + "." + method, typeName + "." + method} {
+		if rf.stats[pkg+"."+name] != nil {
+			names = append(names, pkg+"."+name)
+		}
+	}
+	return names
+}
+
+func stringLit(expr ast.Expr) (string, bool) {
+	lit, ok := expr.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return "", false
+	}
+	s, err := strconv.Unquote(lit.Value)
+	return s, err == nil
+}
+
+// 标识符和选择表达式的文本, 如 r, s.router
+func exprName(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		return e.Name
+	case *ast.SelectorExpr:
+		return exprName(e.X) + "." + e.Sel.Name
+	}
+	return ""
+}
+
+// 输出 -outroutes, profiles 为每个文件的最新版本
+func WriteRouteCoverage(fileName string, profiles []*cover.Profile, latestHashes map[string]string) error {
+	routes := ComputeRouteCoverage(profiles, latestHashes)
+	if fileName == "-" {
+		return PrintRouteCoverage(os.Stdout, routes)
+	}
+	outFile, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("error creating outFile: %v", err)
+	}
+	defer outFile.Close()
+	switch path.Ext(fileName) {
+	case ".csv":
+		w := csv.NewWriter(outFile)
+		w.Write([]string{"method", "path", "framework", "position", "handlers", "statements", "covered", "percent", "status"})
+		for _, route := range routes {
+			w.Write([]string{route.Method, route.Path, route.Framework, route.Position, strings.Join(route.Handlers, " "),
+				strconv.Itoa(route.Statements), strconv.Itoa(route.Covered), strconv.FormatFloat(route.Percent, 'f', 1, 64), route.Status})
+		}
+		w.Flush()
+		err = w.Error()
+	case ".json":
+		enc := json.NewEncoder(outFile)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(routes)
+	default:
+		err = PrintRouteCoverage(outFile, routes)
+	}
+	if err != nil {
+		return err
+	}
+	return outFile.Close()
+}
+
+// 打印路由覆盖率表, 最后一行是覆盖的路由数
+func PrintRouteCoverage(w io.Writer, routes []RouteCoverage) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "route\tstatus\tcoverage\thandler\tregistered at")
+	covered := 0
+	for _, route := range routes {
+		if route.Status == "covered" {
+			covered++
+		}
+		handler := strings.Join(route.Handlers, ", ")
+		if handler == "" {
+			handler = "-"
+		}
+		fmt.Fprintf(tw, "%s %s\t%s\t%.1f%%\t%s\t%s\n", route.Method, route.Path, route.Status, route.Percent, handler, route.Position)
+	}
+	fmt.Fprintf(tw, "total:\t%d/%d covered\t%.1f%%\t\t\n", covered, len(routes), percentOf(covered, len(routes)))
+	return tw.Flush()
+}
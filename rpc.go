@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/tools/cover"
+)
+
+var (
+	g_strOutRPC = flag.String("outrpc", "", "输出 RPC 接口覆盖率表: 每个 RPC 方法的处理函数是否被覆盖, - 输出到标准输出, .csv 或 .json 结尾时输出 CSV 或 JSON(为空不输出)")
+	g_strRPCMap = flag.String("rpc-map", "", "RPC 方法到处理函数的映射(JSON): {\"shop.Billing/Charge\": \"example.com/shop/billing.*Server.Charge\"}, "+
+		"补充或覆盖从生成的 gRPC 服务接口(XxxServer)自动找到的实现")
+)
+
+// 一个 RPC 方法的覆盖情况
+type RPCCoverage struct {
+	Method     string   `json:"method"`   // 服务全名/方法名, 如 shop.Billing/Charge
+	Handlers   []string `json:"handlers"` // 处理函数: 包的导入路径.函数名, 如 example.com/shop/billing.*Server.Charge
+	Statements int      `json:"statements"`
+	Covered    int      `json:"covered"`
+	Percent    float64  `json:"percent"`
+	Status     string   `json:"status"` // covered(处理函数执行过), uncovered 或 no handler
+}
+
+// 函数的语句统计, 键为 包的导入路径.函数名
+type rpcFuncStats map[string]*FileCoverage
+
+// 生成的 gRPC 服务接口
+type rpcService struct {
+	pkg     string
+	name    string // 服务全名, 如 shop.Billing
+	iface   string // 接口名, 如 BillingServer
+	methods []string
+}
+
+// 统计每个文件最新版本中每个函数的语句, 同时收集每个类型的方法名和生成的服务接口.
+// latestHashes 为每个文件取的版本, 源码为导出的 go/src/<文件>.<git hash>
+func collectRPC(profiles []*cover.Profile, latestHashes map[string]string) (rpcFuncStats, map[string]map[string]bool, []rpcService) {
+	stats := make(rpcFuncStats)
+	methods := make(map[string]map[string]bool) // 包的导入路径.类型名 -> 方法名
+	var services []rpcService
+	for _, p := range profiles {
+		pkg := path.Dir(p.FileName)
+		versioned := &cover.Profile{FileName: p.FileName + "." + latestHashes[p.FileName], Mode: p.Mode, Blocks: p.Blocks}
+		funcs, _ := profileFuncs(versioned)
+		for _, f := range funcs {
+			if f.lit {
+				continue
+			}
+			stat := stats[pkg+"."+f.name]
+			if stat == nil {
+				stat = &FileCoverage{FileName: pkg + "." + f.name}
+				stats[stat.FileName] = stat
+			}
+			for _, b := range f.blocks {
+				stat.Statements += b.NumStmt
+				if b.Count > 0 {
+					stat.Covered += b.NumStmt
+				}
+			}
+			if recv, method, ok := strings.Cut(strings.TrimPrefix(f.name, "*"), "."); ok {
+				key := pkg + "." + recv
+				if methods[key] == nil {
+					methods[key] = make(map[string]bool)
+				}
+				methods[key][method] = true
+			}
+		}
+		services = append(services, findRPCServices(filepath.Join(g_strSourceRoot, versioned.FileName), pkg)...)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].name < services[j].name })
+	return stats, methods, services
+}
+
+// 在 protoc-gen-go-grpc 生成的文件中查找服务接口: 带有 mustEmbedUnimplementedXxxServer 方法的 XxxServer 接口,
+// 服务全名取自 Xxx_ServiceDesc 的 ServiceName
+func findRPCServices(fileName string, pkg string) []rpcService {
+	data, err := os.ReadFile(fileName)
+	if err != nil || !bytes.Contains(data, []byte("mustEmbedUnimplemented")) {
+		return nil
+	}
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, fileName, data, parser.SkipObjectResolution)
+	if err != nil {
+		return nil
+	}
+	serviceNames := make(map[string]string) // Xxx -> 服务全名
+	var services []rpcService
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.ValueSpec:
+			for i, name := range n.Names {
+				base, ok := strings.CutSuffix(name.Name, "_ServiceDesc")
+				if !ok || i >= len(n.Values) {
+					continue
+				}
+				if lit, ok := n.Values[i].(*ast.CompositeLit); ok {
+					for _, elt := range lit.Elts {
+						kv, ok := elt.(*ast.KeyValueExpr)
+						if !ok {
+							continue
+						}
+						if key, ok := kv.Key.(*ast.Ident); !ok || key.Name != "ServiceName" {
+							continue
+						}
+						if value, ok := kv.Value.(*ast.BasicLit); ok {
+							serviceNames[base], _ = strconv.Unquote(value.Value)
+						}
+					}
+				}
+			}
+		case *ast.TypeSpec:
+			iface, ok := n.Type.(*ast.InterfaceType)
+			if !ok || !strings.HasSuffix(n.Name.Name, "Server") || strings.HasPrefix(n.Name.Name, "Unsafe") {
+				return false
+			}
+			service := rpcService{pkg: pkg, iface: n.Name.Name}
+			bGenerated := false
+			for _, field := range iface.Methods.List {
+				if _, ok := field.Type.(*ast.FuncType); !ok {
+					continue
+				}
+				for _, name := range field.Names {
+					if name.Name == "mustEmbedUnimplemented"+n.Name.Name {
+						bGenerated = true
+					} else {
+						service.methods = append(service.methods, name.Name)
+					}
+				}
+			}
+			if bGenerated {
+				services = append(services, service)
+			}
+			return false
+		}
+		return true
+	})
+	for i := range services {
+		base := strings.TrimSuffix(services[i].iface, "Server")
+		services[i].name = serviceNames[base]
+		if services[i].name == "" {
+			services[i].name = base
+		}
+	}
+	return services
+}
+
+// 实现了服务接口所有方法的类型(不包括生成的 UnimplementedXxxServer), 返回 包的导入路径.类型名
+func rpcImplementations(service rpcService, methods map[string]map[string]bool) []string {
+	var impls []string
+	for key, set := range methods {
+		typeName := key[strings.LastIndex(key, ".")+1:]
+		if strings.HasPrefix(typeName, "Unimplemented") || strings.HasPrefix(typeName, "unimplemented") {
+			continue
+		}
+		bAll := len(service.methods) > 0
+		for _, method := range service.methods {
+			bAll = bAll && set[method]
+		}
+		if bAll {
+			impls = append(impls, key)
+		}
+	}
+	sort.Strings(impls)
+	return impls
+}
+
+// 把 -rpc-map 中的处理函数统一为 包的导入路径.函数名, 接受 (*T).M 的写法
+func normalizeRPCHandler(handler string) string {
+	slash := strings.LastIndex(handler, "/")
+	dot := strings.Index(handler[slash+1:], ".")
+	if dot < 0 {
+		return handler
+	}
+	pkg, name := handler[:slash+1+dot], handler[slash+1+dot+1:]
+	if recv, method, ok := strings.Cut(name, ")."); ok && strings.HasPrefix(recv, "(") {
+		name = strings.TrimPrefix(recv, "(") + "." + method
+	}
+	return pkg + "." + name
+}
+
+// 计算每个 RPC 方法的覆盖率
+func ComputeRPCCoverage(profiles []*cover.Profile, latestHashes map[string]string, rpcMap map[string]string) []RPCCoverage {
+	stats, methods, services := collectRPC(profiles, latestHashes)
+	handlers := make(map[string][]string) // RPC 方法 -> 处理函数
+	for _, service := range services {
+		impls := rpcImplementations(service, methods)
+		for _, method := range service.methods {
+			rpc := service.name + "/" + method
+			handlers[rpc] = []string{}
+			for _, impl := range impls {
+				pkg, typeName := impl[:strings.LastIndex(impl, ".")], impl[strings.LastIndex(impl, ".")+1:]
+				for _, name := range []string{"*" + typeName + "." + method, typeName + "." + method} {
+					if stats[pkg+"."+name] != nil {
+						handlers[rpc] = append(handlers[rpc], pkg+"."+name)
+					}
+				}
+			}
+		}
+	}
+	for rpc, handler := range rpcMap {
+		handlers[strings.TrimPrefix(rpc, "/")] = []string{normalizeRPCHandler(handler)}
+	}
+
+	result := make([]RPCCoverage, 0, len(handlers))
+	for rpc, names := range handlers {
+		row := RPCCoverage{Method: rpc, Handlers: names, Status: "no handler"}
+		for _, name := range names {
+			if stat := stats[name]; stat != nil {
+				row.Statements += stat.Statements
+				row.Covered += stat.Covered
+				row.Status = "uncovered"
+			}
+		}
+		if row.Covered > 0 {
+			row.Status = "covered"
+		}
+		row.Percent = roundPercent(FileCoverage{Statements: row.Statements, Covered: row.Covered}.Percent())
+		result = append(result, row)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Method < result[j].Method })
+	return result
+}
+
+func readRPCMap(fileName string) (map[string]string, error) {
+	if fileName == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var rpcMap map[string]string
+	if err := json.Unmarshal(data, &rpcMap); err != nil {
+		return nil, fmt.Errorf("bad rpc map %s: %v", fileName, err)
+	}
+	return rpcMap, nil
+}
+
+// 输出 -outrpc, profiles 为每个文件的最新版本
+func WriteRPCCoverage(fileName string, profiles []*cover.Profile, latestHashes map[string]string) error {
+	rpcMap, err := readRPCMap(*g_strRPCMap)
+	if err != nil {
+		return err
+	}
+	rows := ComputeRPCCoverage(profiles, latestHashes, rpcMap)
+	if fileName == "-" {
+		return PrintRPCCoverage(os.Stdout, rows)
+	}
+	outFile, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("error creating outFile: %v", err)
+	}
+	defer outFile.Close()
+	switch path.Ext(fileName) {
+	case ".csv":
+		w := csv.NewWriter(outFile)
+		w.Write([]string{"method", "handlers", "statements", "covered", "percent", "status"})
+		for _, row := range rows {
+			w.Write([]string{row.Method, strings.Join(row.Handlers, " "), strconv.Itoa(row.Statements), strconv.Itoa(row.Covered),
+				strconv.FormatFloat(row.Percent, 'f', 1, 64), row.Status})
+		}
+		w.Flush()
+		err = w.Error()
+	case ".json":
+		enc := json.NewEncoder(outFile)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(rows)
+	default:
+		err = PrintRPCCoverage(outFile, rows)
+	}
+	if err != nil {
+		return err
+	}
+	return outFile.Close()
+}
+
+// 打印 RPC 接口覆盖率表, 最后一行是覆盖的方法数
+func PrintRPCCoverage(w io.Writer, rows []RPCCoverage) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "method\tstatus\tcoverage\thandler")
+	covered := 0
+	for _, row := range rows {
+		if row.Status == "covered" {
+			covered++
+		}
+		handler := strings.Join(row.Handlers, ", ")
+		if handler == "" {
+			handler = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%.1f%%\t%s\n", row.Method, row.Status, row.Percent, handler)
+	}
+	fmt.Fprintf(tw, "total:\t%d/%d covered\t%.1f%%\t\n", covered, len(rows), percentOf(covered, len(rows)))
+	return tw.Flush()
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	"golang.org/x/tools/cover"
+)
+
+// 只合并了部分线上实例时, 根据每个块被多少个实例覆盖估计全量实例的覆盖率区间.
+// 使用不放回抽样下的 Chao2 估计(Chao & Lin 2012):
+//
+//	f0 = Q1² / (2·Q2·n/(n-1) + Q1·q/(1-q))
+//
+// 其中 n 为实例数, q 为抽样比例, Q1/Q2 为恰好被 1/2 个实例覆盖的块数, f0 为样本中未出现但全量中被覆盖的块数.
+// 区间使用 Chao 的对数正态 95% 置信区间, 下界不会低于样本中已观察到的覆盖率.
+type SampleEstimator struct {
+	Fraction   float64
+	nInstances int
+	incidence  map[sampleBlockKey]int // 块 -> 覆盖该块的实例数
+	numStmt    map[sampleBlockKey]int
+}
+
+type sampleBlockKey struct {
+	gitHash   string
+	fileName  string
+	startLine int
+	startCol  int
+}
+
+// 若指定了抽样比例, 记录实例覆盖情况的估计器
+var g_sampleEstimator *SampleEstimator
+
+// 输入标签中的抽样比例, 例如由 -name-pattern 或元数据文件给出, 这样不同来源的输入不必共用一个命令行参数
+const sampleFractionTag = "sample_fraction"
+
+// 本次合并的抽样比例: -sample-fraction 优先, 否则取输入的 sample_fraction 标签, 带有该标签的输入必须一致.
+// 都没有时返回 0, 表示输入不是抽样
+func ResolveSampleFraction(fileInfos []*CoverFileInfo) (float64, error) {
+	if *g_fSampleFraction != 0 {
+		return checkSampleFraction(*g_fSampleFraction, "-sample-fraction")
+	}
+	var fraction float64
+	var from string
+	for _, fileInfo := range fileInfos {
+		value, ok := fileInfo.Tags[sampleFractionTag]
+		if !ok {
+			continue
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, fmt.Errorf("bad %s tag %q in %s", sampleFractionTag, value, fileInfo.FileName)
+		}
+		if _, err := checkSampleFraction(f, fileInfo.FileName); err != nil {
+			return 0, err
+		}
+		if from != "" && f != fraction {
+			return 0, fmt.Errorf("inputs have different sample fractions: %v in %s, %v in %s", fraction, from, f, fileInfo.FileName)
+		}
+		fraction, from = f, fileInfo.FileName
+	}
+	return fraction, nil
+}
+
+func checkSampleFraction(fraction float64, from string) (float64, error) {
+	if fraction <= 0 || fraction > 1 {
+		return 0, fmt.Errorf("sample fraction must be in (0, 1], got %v in %s", fraction, from)
+	}
+	return fraction, nil
+}
+
+// 返回记录每个实例(一个输入文件)覆盖情况的函数, fraction 为 0 时返回 nil, 不做估计.
+// 必须在合并之前调用, 合并会修改 profiles 中的计数
+func sampleObserver(fraction float64) (func(gitHash string, profiles []*cover.Profile), error) {
+	if fraction <= 0 {
+		return nil, nil
+	}
+	if g_sampleEstimator == nil {
+		g_sampleEstimator = NewSampleEstimator(fraction)
+	} else if g_sampleEstimator.Fraction != fraction {
+		return nil, fmt.Errorf("inputs have different sample fractions: %v and %v", g_sampleEstimator.Fraction, fraction)
+	}
+	return g_sampleEstimator.Observe, nil
+}
+
+func NewSampleEstimator(fraction float64) *SampleEstimator {
+	return &SampleEstimator{
+		Fraction:  fraction,
+		incidence: make(map[sampleBlockKey]int),
+		numStmt:   make(map[sampleBlockKey]int),
+	}
+}
+
+func (e *SampleEstimator) Observe(gitHash string, profiles []*cover.Profile) {
+	e.nInstances++
+	for _, p := range profiles {
+		for _, b := range p.Blocks {
+			if b.Count <= 0 {
+				continue
+			}
+			key := sampleBlockKey{gitHash: gitHash, fileName: p.FileName, startLine: b.StartLine, startCol: b.StartCol}
+			e.incidence[key]++
+			e.numStmt[key] = b.NumStmt
+		}
+	}
+}
+
+// 估计全量实例中被覆盖的语句数及其 95% 置信区间, 结果不超过 total.
+// 实例数不足 2 时无法估计, ok 为 false
+func (e *SampleEstimator) Estimate(covered int, total int) (estimate, low, high float64, ok bool) {
+	return e.EstimateWhere(nil, covered, total)
+}
+
+// 同 Estimate, 只统计 match 返回 true 的文件(输入中的文件名, 不带 git hash 后缀)中的块, 用于每个包或每组的估计.
+// covered 和 total 是这些文件的观察值, match 为空时统计所有文件
+func (e *SampleEstimator) EstimateWhere(match func(fileName string) bool, covered int, total int) (estimate, low, high float64, ok bool) {
+	if e.nInstances < 2 {
+		return 0, 0, 0, false
+	}
+	var q1, q2, q1Stmt float64
+	for key, n := range e.incidence {
+		if match != nil && !match(key.fileName) {
+			continue
+		}
+		switch n {
+		case 1:
+			q1++
+			q1Stmt += float64(e.numStmt[key])
+		case 2:
+			q2++
+		}
+	}
+	obs := float64(covered)
+	if q1 == 0 || e.Fraction >= 1 {
+		return obs, obs, obs, true
+	}
+
+	n := float64(e.nInstances)
+	r := (n - 1) / n
+	var f0 float64
+	if q2 > 0 {
+		f0 = q1 * q1 / (2*q2/r + q1*e.Fraction/(1-e.Fraction))
+	} else {
+		f0 = q1 * (q1 - 1) / (2/r + q1*e.Fraction/(1-e.Fraction))
+	}
+
+	// Chao1 方差近似, Q2 为 0 时使用偏差修正形式
+	var variance float64
+	if q2 > 0 {
+		ratio := q1 / q2
+		variance = q2 * (r/2*math.Pow(ratio, 2) + r*r*math.Pow(ratio, 3) + r*r/4*math.Pow(ratio, 4))
+	} else {
+		variance = r*q1*(q1-1)/2 + r*r*q1*math.Pow(2*q1-1, 2)/4 - r*r*math.Pow(q1, 4)/(4*f0)
+	}
+
+	// 由块数换算到语句数, 未观察到的块按只被一个实例覆盖的块的平均语句数计
+	stmtPerBlock := q1Stmt / q1
+	clamp := func(v float64) float64 {
+		return math.Min(obs+v*stmtPerBlock, float64(total))
+	}
+	// 方差不可用(样本太少)时区间退化为 [观察值, 估计值]
+	if f0 <= 0 || variance <= 0 {
+		return clamp(f0), obs, clamp(f0), true
+	}
+	c := math.Exp(1.96 * math.Sqrt(math.Log(1+variance/(f0*f0))))
+	return clamp(f0), clamp(f0 / c), clamp(f0 * c), true
+}
+
+// 全量估计的列: 估计的覆盖率和 95% 置信区间, 无法估计时为 -
+func (e *SampleEstimator) estimateColumns(match func(fileName string) bool, stat FileCoverage) string {
+	estimate, low, high, ok := e.EstimateWhere(match, stat.Covered, stat.Statements)
+	if !ok || stat.Statements == 0 {
+		return "-\t-"
+	}
+	percent := func(v float64) float64 { return v * 100 / float64(stat.Statements) }
+	return fmt.Sprintf("%.1f%%\t[%.1f%%, %.1f%%]", percent(estimate), percent(low), percent(high))
+}
+
+// 打印每个包和总的抽样覆盖率及全量估计, 没有抽样时不输出.
+// 其他输出(-func, 报告, HTML 等)中的每个文件的覆盖率都是抽样的观察值, 最后一行提示这一点
+func PrintSampleSummary(profiles []*cover.Profile) {
+	if g_sampleEstimator == nil {
+		return
+	}
+	var all FileCoverage
+	packages := make(map[string]*FileCoverage)
+	for _, stat := range ComputeFileCoverage(profiles) {
+		pkg := path.Dir(stat.FileName)
+		if packages[pkg] == nil {
+			packages[pkg] = &FileCoverage{FileName: pkg}
+		}
+		packages[pkg].Statements += stat.Statements
+		packages[pkg].Covered += stat.Covered
+		all.Statements += stat.Statements
+		all.Covered += stat.Covered
+	}
+	if all.Statements == 0 {
+		return
+	}
+	var pkgs []string
+	for pkg := range packages {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Strings(pkgs)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "package\tstatements\tsampled\testimated fleet\t95% CI")
+	for _, pkg := range pkgs {
+		stat := packages[pkg]
+		match := func(fileName string) bool { return path.Dir(fileName) == pkg }
+		fmt.Fprintf(tw, "%s\t%d\t%.1f%%\t%s\n", pkg, stat.Statements, stat.Percent(), g_sampleEstimator.estimateColumns(match, *stat))
+	}
+	fmt.Fprintf(tw, "total\t%d\t%.1f%%\t%s\n", all.Statements, all.Percent(), g_sampleEstimator.estimateColumns(nil, all))
+	tw.Flush()
+	fmt.Printf("sample fraction %.4g%%, %d instances; per-file and per-function coverage elsewhere in the output is sampled, not estimated\n",
+		g_sampleEstimator.Fraction*100, g_sampleEstimator.nInstances)
+}
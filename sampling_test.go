@@ -0,0 +1,116 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"golang.org/x/tools/cover"
+)
+
+// 每个实例覆盖的块(按行), 每个块一条语句
+func instanceProfile(fileName string, lines []int) *cover.Profile {
+	p := &cover.Profile{FileName: fileName, Mode: "set"}
+	for _, line := range lines {
+		p.Blocks = append(p.Blocks, cover.ProfileBlock{StartLine: line, StartCol: 1, EndLine: line, EndCol: 10, NumStmt: 1, Count: 1})
+	}
+	return p
+}
+
+func observeInstances(e *SampleEstimator, fileName string, instances [][]int) {
+	for _, lines := range instances {
+		e.Observe("0b57328", []*cover.Profile{instanceProfile(fileName, lines)})
+	}
+}
+
+func TestSampleEstimatorChao2(t *testing.T) {
+	// 3 个实例: 块 1, 2 各被 1 个实例覆盖(Q1=2), 块 3 被 2 个实例覆盖(Q2=1).
+	// q=0.5, (n-1)/n=2/3: f0 = 2²/(2·1·3/2 + 2·0.5/0.5) = 0.8
+	e := NewSampleEstimator(0.5)
+	observeInstances(e, "example.com/foo/foo.go", [][]int{{1, 3}, {2, 3}, {}})
+	estimate, low, high, ok := e.Estimate(3, 10)
+	if !ok {
+		t.Fatal("estimate not ok with 3 instances")
+	}
+	if math.Abs(estimate-3.8) > 1e-9 {
+		t.Errorf("estimate = %v, want 3.8", estimate)
+	}
+	if low < 3 || low > estimate || high < estimate || high > 10 {
+		t.Errorf("CI [%v, %v] does not contain estimate %v within [3, 10]", low, high, estimate)
+	}
+
+	// 估计不超过总语句数
+	if estimate, _, high, _ := e.Estimate(3, 3); estimate != 3 || high != 3 {
+		t.Errorf("estimate, high = %v, %v, want clamped to 3", estimate, high)
+	}
+}
+
+func TestSampleEstimatorDegenerate(t *testing.T) {
+	e := NewSampleEstimator(0.5)
+	observeInstances(e, "example.com/foo/foo.go", [][]int{{1, 2}})
+	if _, _, _, ok := e.Estimate(2, 10); ok {
+		t.Error("estimate ok with 1 instance")
+	}
+
+	// 没有只被一个实例覆盖的块时, 估计就是观察值
+	e = NewSampleEstimator(0.5)
+	observeInstances(e, "example.com/foo/foo.go", [][]int{{1, 2}, {1, 2}})
+	if estimate, low, high, ok := e.Estimate(2, 10); !ok || estimate != 2 || low != 2 || high != 2 {
+		t.Errorf("Estimate = %v, %v, %v, %v, want 2, 2, 2, true", estimate, low, high, ok)
+	}
+
+	// 全量时不需要估计
+	e = NewSampleEstimator(1)
+	observeInstances(e, "example.com/foo/foo.go", [][]int{{1}, {2}})
+	if estimate, _, _, _ := e.Estimate(2, 10); estimate != 2 {
+		t.Errorf("estimate with fraction 1 = %v, want 2", estimate)
+	}
+}
+
+func TestSampleEstimatorWhere(t *testing.T) {
+	// 每个实例同时覆盖两个包
+	e := NewSampleEstimator(0.5)
+	for _, lines := range [][]int{{1, 3}, {2, 3}, {}} {
+		e.Observe("0b57328", []*cover.Profile{instanceProfile("example.com/foo/foo.go", lines), instanceProfile("example.com/bar/bar.go", []int{1})})
+	}
+	inFoo := func(fileName string) bool { return fileName == "example.com/foo/foo.go" }
+	if estimate, _, _, _ := e.EstimateWhere(inFoo, 3, 10); math.Abs(estimate-3.8) > 1e-9 {
+		t.Errorf("foo estimate = %v, want 3.8", estimate)
+	}
+	// bar 的块都被所有实例覆盖, 没有未观察到的覆盖
+	inBar := func(fileName string) bool { return fileName == "example.com/bar/bar.go" }
+	if estimate, low, high, _ := e.EstimateWhere(inBar, 1, 5); estimate != 1 || low != 1 || high != 1 {
+		t.Errorf("bar estimate = %v [%v, %v], want 1 [1, 1]", estimate, low, high)
+	}
+}
+
+func TestResolveSampleFraction(t *testing.T) {
+	defer func(fraction float64) { *g_fSampleFraction = fraction }(*g_fSampleFraction)
+	*g_fSampleFraction = 0
+
+	inputs := func(fractions ...string) []*CoverFileInfo {
+		var fileInfos []*CoverFileInfo
+		for _, fraction := range fractions {
+			fileInfo := &CoverFileInfo{FileName: "cover.txt." + fraction}
+			if fraction != "" {
+				fileInfo.Tags = map[string]string{sampleFractionTag: fraction}
+			}
+			fileInfos = append(fileInfos, fileInfo)
+		}
+		return fileInfos
+	}
+	if fraction, err := ResolveSampleFraction(inputs("", "")); err != nil || fraction != 0 {
+		t.Errorf("no tags: %v, %v, want 0", fraction, err)
+	}
+	if fraction, err := ResolveSampleFraction(inputs("0.1", "", "0.1")); err != nil || fraction != 0.1 {
+		t.Errorf("same tags: %v, %v, want 0.1", fraction, err)
+	}
+	for _, bad := range [][]string{{"0.1", "0.2"}, {"0"}, {"1.5"}, {"x"}} {
+		if _, err := ResolveSampleFraction(inputs(bad...)); err == nil {
+			t.Errorf("tags %v: no error", bad)
+		}
+	}
+	*g_fSampleFraction = 0.3
+	if fraction, err := ResolveSampleFraction(inputs("0.1", "0.2")); err != nil || fraction != 0.3 {
+		t.Errorf("flag over tags: %v, %v, want 0.3", fraction, err)
+	}
+}
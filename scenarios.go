@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// 一个测试场景的运行时间
+type Scenario struct {
+	Name  string
+	Start int64
+	End   int64
+}
+
+// 一个场景执行过的一个包的语句
+type ScenarioPackage struct {
+	Scenario   string `json:"scenario"`
+	Package    string `json:"package"`
+	Statements int    `json:"statements"` // 场景运行期间执行过的语句
+	New        int    `json:"new"`        // 其中第一次被覆盖的语句
+	Shared     int    `json:"shared"`     // 其中来自同时有其他场景运行的时间段, 不能确定是哪个场景执行的
+}
+
+// 不在任何场景运行期间执行的语句(如后台任务或其他流量)
+const noScenario = "(no scenario)"
+
+func runScenarios(args []string) error {
+	fs := NewSubCommandFlagSet("scenarios", "-log scenarios.txt [-o report.csv] [cover.txt.timestamp.hash ...]")
+	strLog := fs.String("log", "", "场景日志, 每行 \"<场景名> <开始时间> <结束时间>\", 时间为 unix 时间戳或 RFC3339")
+	strOut := fs.String("o", "-", "输出文件, - 输出到标准输出, .csv 或 .json 结尾时输出 CSV 或 JSON")
+	fs.Parse(args)
+	if *strLog == "" || fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("Error: -log and cover.txt.xxx.xxx files required.")
+	}
+	scenarios, err := ReadScenarioLog(*strLog)
+	if err != nil {
+		return err
+	}
+	fileInfos, err := ParseCoverFileInfos(fs.Args())
+	if err != nil {
+		return err
+	}
+	rows, err := AttributeScenarios(fileInfos, scenarios)
+	if err != nil {
+		return err
+	}
+	return WriteScenarioReport(*strOut, rows)
+}
+
+// 读取场景日志, 空行和 # 开头的行忽略, 场景名可以包含空格
+func ReadScenarioLog(fileName string) ([]Scenario, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var scenarios []Scenario
+	s := bufio.NewScanner(f)
+	for lineNo := 1; s.Scan(); lineNo++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("%s:%d: expected \"<name> <start> <end>\": %q", fileName, lineNo, line)
+		}
+		start, err := parseScenarioTime(fields[len(fields)-2])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", fileName, lineNo, err)
+		}
+		end, err := parseScenarioTime(fields[len(fields)-1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", fileName, lineNo, err)
+		}
+		if end < start {
+			return nil, fmt.Errorf("%s:%d: scenario ends before it starts", fileName, lineNo)
+		}
+		scenarios = append(scenarios, Scenario{Name: strings.Join(fields[:len(fields)-2], " "), Start: start, End: end})
+	}
+	return scenarios, s.Err()
+}
+
+func parseScenarioTime(s string) (int64, error) {
+	if timestamp, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return timestamp, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected a unix timestamp or RFC3339", s)
+	}
+	return t.Unix(), nil
+}
+
+// 把长时间运行的服务按时间导出的累计覆盖率(同一版本和标签的输入为同一个服务)按时间排序,
+// 相邻两份的计数差是这段时间内执行的块, 归到这段时间内运行过的场景.
+// 每个服务的第一份覆盖率是基准, 计数变小时认为服务重启过, 以新的计数为差
+func AttributeScenarios(fileInfos []*CoverFileInfo, scenarios []Scenario) ([]ScenarioPackage, error) {
+	type blockKey struct {
+		file                                 string
+		startLine, startCol, endLine, endCol int
+	}
+	series := make(map[string][]*CoverFileInfo) // git hash 和标签 -> 按时间排序的输入
+	for _, fileInfo := range fileInfos {
+		key := fileInfo.GitHash + " " + FormatTags(fileInfo.Tags)
+		series[key] = append(series[key], fileInfo)
+	}
+	type packageStats struct {
+		executed, fresh, shared map[blockKey]int // 块 -> 语句数
+	}
+	stats := make(map[string]map[string]*packageStats) // 场景 -> 包 -> 统计
+	add := func(scenario, pkg string, key blockKey, numStmt int, bNew, bShared bool) {
+		if stats[scenario] == nil {
+			stats[scenario] = make(map[string]*packageStats)
+		}
+		ps := stats[scenario][pkg]
+		if ps == nil {
+			ps = &packageStats{executed: make(map[blockKey]int), fresh: make(map[blockKey]int), shared: make(map[blockKey]int)}
+			stats[scenario][pkg] = ps
+		}
+		ps.executed[key] = numStmt
+		if bNew {
+			ps.fresh[key] = numStmt
+		}
+		if bShared {
+			ps.shared[key] = numStmt
+		}
+	}
+
+	for _, infos := range series {
+		sort.SliceStable(infos, func(i, j int) bool { return infos[i].Timestamp < infos[j].Timestamp })
+		prev := make(map[blockKey]int) // 上一份的计数
+		for i, fileInfo := range infos {
+			profiles, err := fileInfo.ReadProfiles()
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse profiles %s: %v", fileInfo.FileName, err)
+			}
+			// 这段时间 (上一份的时间, 这一份的时间] 内运行过的场景
+			var names []string
+			if i > 0 {
+				for _, scenario := range scenarios {
+					if scenario.Start < fileInfo.Timestamp && scenario.End > infos[i-1].Timestamp {
+						names = append(names, scenario.Name)
+					}
+				}
+				if len(names) == 0 {
+					names = []string{noScenario}
+				}
+			}
+			curr := make(map[blockKey]int)
+			for _, p := range profiles {
+				pkg := path.Dir(p.FileName)
+				for _, b := range p.Blocks {
+					key := blockKey{fileInfo.GitHash + ":" + p.FileName, b.StartLine, b.StartCol, b.EndLine, b.EndCol}
+					curr[key] = AddCount(curr[key], b.Count)
+					if i == 0 {
+						continue
+					}
+					before, ok := prev[key]
+					diff := b.Count - before
+					if b.Count < before {
+						diff = b.Count
+					}
+					if diff <= 0 || b.NumStmt == 0 {
+						continue
+					}
+					for _, name := range names {
+						add(name, pkg, key, b.NumStmt, !ok || before == 0, len(names) > 1)
+					}
+				}
+			}
+			prev = curr
+		}
+	}
+
+	var rows []ScenarioPackage
+	for scenario, packages := range stats {
+		for pkg, ps := range packages {
+			row := ScenarioPackage{Scenario: scenario, Package: pkg}
+			for _, n := range ps.executed {
+				row.Statements += n
+			}
+			for _, n := range ps.fresh {
+				row.New += n
+			}
+			for _, n := range ps.shared {
+				row.Shared += n
+			}
+			rows = append(rows, row)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Scenario != rows[j].Scenario {
+			return rows[i].Scenario < rows[j].Scenario
+		}
+		return rows[i].Package < rows[j].Package
+	})
+	return rows, nil
+}
+
+func WriteScenarioReport(fileName string, rows []ScenarioPackage) error {
+	if fileName == "-" {
+		return PrintScenarioReport(os.Stdout, rows)
+	}
+	outFile, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("error creating outFile: %v", err)
+	}
+	defer outFile.Close()
+	switch path.Ext(fileName) {
+	case ".csv":
+		w := csv.NewWriter(outFile)
+		w.Write([]string{"scenario", "package", "statements", "new", "shared"})
+		for _, row := range rows {
+			w.Write([]string{row.Scenario, row.Package, strconv.Itoa(row.Statements), strconv.Itoa(row.New), strconv.Itoa(row.Shared)})
+		}
+		w.Flush()
+		err = w.Error()
+	case ".json":
+		enc := json.NewEncoder(outFile)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(rows)
+	default:
+		err = PrintScenarioReport(outFile, rows)
+	}
+	if err != nil {
+		return err
+	}
+	return outFile.Close()
+}
+
+func PrintScenarioReport(w io.Writer, rows []ScenarioPackage) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "scenario\tpackage\tstatements\tnew\tshared")
+	for _, row := range rows {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\n", row.Scenario, row.Package, row.Statements, row.New, row.Shared)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// selftest 的临时 git 仓库, 源码在 go/src/example.com/m 下
+type selftestRepo struct {
+	dir     string
+	commits []string // 每个提交的短 git hash, 下标 i 的提交时间戳为 (i+1)*100
+}
+
+// 一个用例: 用 covers 中的覆盖率合并, 检查输出的每个块的计数
+type selftestCase struct {
+	name   string
+	args   []string
+	covers map[int]string    // 提交下标 -> 覆盖率内容(不含 mode 行)
+	want   map[string]string // 输出的 <file>.<提交下标>:<块> -> 计数, 提交下标在比较前换成 git hash
+}
+
+const (
+	selftestV1 = "package m\n\nfunc F() int {\n\treturn 1\n}\n"
+	selftestV2 = "package m\n\nfunc F() int {\n\treturn 2\n}\n"
+	selftestB  = "package m\n\nfunc G() int {\n\treturn 3\n}\n"
+)
+
+func (repo *selftestRepo) git(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = repo.dir
+	cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=selftest", "GIT_AUTHOR_EMAIL=selftest@example.com",
+		"GIT_COMMITTER_NAME=selftest", "GIT_COMMITTER_EMAIL=selftest@example.com")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// 提交 go/src/example.com/m 下的文件, 内容为空时删除
+func (repo *selftestRepo) commit(files map[string]string) error {
+	for name, content := range files {
+		filePath := filepath.Join(repo.dir, "go", "src", "example.com", "m", name)
+		if content == "" {
+			if err := os.Remove(filePath); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+	if _, err := repo.git("add", "-A"); err != nil {
+		return err
+	}
+	if _, err := repo.git("commit", "-q", "--no-verify", "-m", fmt.Sprintf("commit %d", len(repo.commits))); err != nil {
+		return err
+	}
+	gitHash, err := repo.git("rev-parse", "--short=7", "HEAD")
+	if err != nil {
+		return err
+	}
+	repo.commits = append(repo.commits, gitHash)
+	return nil
+}
+
+// 把 <file>.<提交下标> 中的下标换成 git hash
+func (repo *selftestRepo) expand(s string) string {
+	for i := len(repo.commits) - 1; i >= 0; i-- {
+		s = strings.ReplaceAll(s, fmt.Sprintf(".go.%d:", i), ".go."+repo.commits[i]+":")
+	}
+	return s
+}
+
+// 在仓库中用 exe 合并用例的覆盖率, 返回与期望不同之处
+func (repo *selftestRepo) run(exe string, c selftestCase) error {
+	caseDir, err := os.MkdirTemp(repo.dir, "case-*")
+	if err != nil {
+		return err
+	}
+	outCover := filepath.Join(caseDir, "cover.txt")
+	args := append(append([]string(nil), c.args...), "-outcover", outCover, "-outhtml", filepath.Join(caseDir, "cover.html"))
+	for i, content := range c.covers {
+		name := filepath.Join(caseDir, fmt.Sprintf("cover.txt.%d.%s", (i+1)*100, repo.commits[i]))
+		if err := os.WriteFile(name, []byte("mode: count\n"+content), 0644); err != nil {
+			return err
+		}
+		args = append(args, name)
+	}
+	cmd := exec.Command(exe, args...)
+	cmd.Dir = repo.dir
+	cmd.Env = append(os.Environ(), "CI=1")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v:\n%s", err, out)
+	}
+	got, err := readSelftestCover(outCover)
+	if err != nil {
+		return err
+	}
+	want := make(map[string]string)
+	for block, count := range c.want {
+		want[repo.expand(block)] = count
+	}
+	var diffs []string
+	for block, count := range want {
+		if got[block] != count {
+			diffs = append(diffs, fmt.Sprintf("%s: got count %q, want %q", block, got[block], count))
+		}
+	}
+	for block := range got {
+		if _, ok := want[block]; !ok {
+			diffs = append(diffs, fmt.Sprintf("%s: unexpected block", block))
+		}
+	}
+	if len(diffs) > 0 {
+		sort.Strings(diffs)
+		return fmt.Errorf("%s", strings.Join(diffs, "\n"))
+	}
+	return nil
+}
+
+// 读取合并结果: <file>:<块> -> 计数
+func readSelftestCover(fileName string) (map[string]string, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	blocks := make(map[string]string)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := s.Text()
+		if strings.HasPrefix(line, "mode:") || line == "" {
+			continue
+		}
+		i := strings.LastIndex(line, " ")
+		blocks[line[:i]] = line[i+1:]
+	}
+	return blocks, s.Err()
+}
+
+// selftest: 在临时 git 仓库中构造多个提交和覆盖率, 用当前的可执行文件跑完整的合并, 检查按版本合并和拆分的结果.
+// 用于验证环境(git)和修改后的行为
+func runSelftest(args []string) error {
+	fs := NewSubCommandFlagSet("selftest", "[-keep] [-run name]")
+	bKeep := fs.Bool("keep", false, "保留临时仓库, 便于排查失败的用例")
+	strRun := fs.String("run", "", "只执行名称包含该字符串的用例")
+	fs.Parse(args)
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp("", "gocovmerge-selftest-*")
+	if err != nil {
+		return err
+	}
+	if *bKeep {
+		fmt.Println("selftest repository:", dir)
+	} else {
+		defer os.RemoveAll(dir)
+	}
+	repo, err := newSelftestRepo(dir)
+	if err != nil {
+		return err
+	}
+	failed, ran := 0, 0
+	for _, c := range selftestCases() {
+		if !strings.Contains(c.name, *strRun) {
+			continue
+		}
+		ran++
+		if err := repo.run(exe, c); err != nil {
+			failed++
+			fmt.Printf("FAIL %s\n%s\n", c.name, err)
+			continue
+		}
+		fmt.Printf("ok   %s\n", c.name)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d self tests failed", failed, ran)
+	}
+	fmt.Printf("%d self tests passed\n", ran)
+	return nil
+}
+
+// 在 dir 中创建 selftest 的 git 仓库和提交, go test 中的 TestSelftest 也使用它
+func newSelftestRepo(dir string) (*selftestRepo, error) {
+	repo := &selftestRepo{dir: dir}
+	if _, err := repo.git("init", "-q"); err != nil {
+		return nil, err
+	}
+	// 提交 0-5: a.go, 增加 b.go, 修改 a.go, 还原 a.go, b.go 改名为 c.go, 删除 c.go
+	for _, files := range []map[string]string{
+		{"a.go": selftestV1},
+		{"b.go": selftestB},
+		{"a.go": selftestV2},
+		{"a.go": selftestV1},
+		{"b.go": "", "c.go": selftestB},
+		{"c.go": ""},
+	} {
+		if err := repo.commit(files); err != nil {
+			return nil, err
+		}
+	}
+	return repo, nil
+}
+
+// selftest 的用例, 提交下标对应 newSelftestRepo 中的提交
+func selftestCases() []selftestCase {
+	const a, b, c = "example.com/m/a.go:3.14,5.2 1 1\n", "example.com/m/b.go:3.14,5.2 1 1\n", "example.com/m/c.go:3.14,5.2 1 1\n"
+	return []selftestCase{
+		{name: "unchanged file merges across versions",
+			covers: map[int]string{0: a, 1: a + b},
+			want:   map[string]string{"example.com/m/a.go.0:3.14,5.2 1": "2", "example.com/m/b.go.1:3.14,5.2 1": "1"}},
+		{name: "changed file is split by version",
+			covers: map[int]string{0: a, 2: a},
+			want:   map[string]string{"example.com/m/a.go.0:3.14,5.2 1": "1", "example.com/m/a.go.2:3.14,5.2 1": "1"}},
+		{name: "reverted file merges into the earliest version",
+			covers: map[int]string{0: a, 2: a, 3: a},
+			want:   map[string]string{"example.com/m/a.go.0:3.14,5.2 1": "2", "example.com/m/a.go.2:3.14,5.2 1": "1"}},
+		{name: "follow-renames merges a renamed file", args: []string{"-follow-renames"},
+			covers: map[int]string{1: b, 4: c},
+			want:   map[string]string{"example.com/m/c.go.4:3.14,5.2 1": "2"}},
+		{name: "drop-deleted drops deleted files", args: []string{"-drop-deleted"},
+			covers: map[int]string{1: a + b, 5: a},
+			want:   map[string]string{"example.com/m/a.go.1:3.14,5.2 1": "2"}},
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+)
+
+// 设置该环境变量时测试二进制作为 gocovmerge 运行, TestSelftest 用它执行合并
+const selftestMainEnv = "GOCOVMERGE_TEST_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(selftestMainEnv) == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// 与 gocovmerge selftest 相同的用例, 在临时 git 仓库中跑完整的合并
+func TestSelftest(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(selftestMainEnv, "1")
+	repo, err := newSelftestRepo(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range selftestCases() {
+		t.Run(c.name, func(t *testing.T) {
+			if err := repo.run(exe, c); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 版本号, 发布时用 go build -ldflags "-X main.g_version=1.2.3" 设置,
+// 没有设置时取 go install ...@v1.2.3 记录的模块版本
+var g_version = "dev"
+
+const selfUpdateTimeout = 5 * time.Minute
+
+// release 地址下的文件:
+//
+//	<url>/latest                               最新版本号, 如 1.3.0
+//	<url>/<version>/SHA256SUMS               sha256sum 格式的校验和
+//	<url>/<version>/gocovmerge-<os>-<arch>   可执行文件(windows 带 .exe)
+const selfUpdateURLEnv = "GOCOVMERGE_RELEASE_URL"
+
+// 要求的版本: 环境变量 GOCOVMERGE_REQUIRE_VERSION 或 -require-version, 形式为 1.3.0(必须相同) 或 >=1.3.0
+const requireVersionEnv = "GOCOVMERGE_REQUIRE_VERSION"
+
+func Version() string {
+	if g_version != "dev" {
+		return g_version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return strings.TrimPrefix(info.Main.Version, "v")
+	}
+	return g_version
+}
+
+// 解析 1.2.3(可以带 v 前缀, 以及 -rc1 等后缀)的数字部分
+func parseVersion(s string) ([3]int, bool) {
+	var v [3]int
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexAny(s, "-+"); i >= 0 {
+		s = s[:i]
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) == 0 || len(parts) > 3 {
+		return v, false
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return v, false
+		}
+		v[i] = n
+	}
+	return v, true
+}
+
+func compareVersions(a, b [3]int) int {
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// 检查当前版本是否满足要求, required 为空时不检查
+func CheckRequiredVersion(required string) error {
+	required = strings.TrimSpace(required)
+	if required == "" {
+		return nil
+	}
+	current := Version()
+	strMin, bAtLeast := strings.CutPrefix(required, ">=")
+	want, ok := parseVersion(strMin)
+	if !ok {
+		return fmt.Errorf("invalid required version %q", required)
+	}
+	have, ok := parseVersion(current)
+	if ok && ((bAtLeast && compareVersions(have, want) >= 0) || (!bAtLeast && compareVersions(have, want) == 0)) {
+		return nil
+	}
+	return fmt.Errorf("gocovmerge %s does not satisfy required version %s, run gocovmerge self-update -version %s", current, required, strings.TrimSpace(strMin))
+}
+
+// version: 打印版本号
+func runVersion(args []string) error {
+	fmt.Println("gocovmerge", Version(), runtime.GOOS+"/"+runtime.GOARCH)
+	return nil
+}
+
+// self-update: 从 release 地址下载最新(或指定)版本, 校验 sha256 后替换当前的可执行文件
+func runSelfUpdate(args []string) error {
+	fs := NewSubCommandFlagSet("self-update", "[options]")
+	strURL := fs.String("url", os.Getenv(selfUpdateURLEnv), "release 地址, 默认取 "+selfUpdateURLEnv)
+	strVersion := fs.String("version", "", "更新到该版本(为空更新到最新版本)")
+	bCheck := fs.Bool("check", false, "只检查是否有新版本, 不更新")
+	fs.Parse(args)
+	if *strURL == "" {
+		return fmt.Errorf("release url required: -url or %s", selfUpdateURLEnv)
+	}
+	baseURL := strings.TrimSuffix(*strURL, "/")
+	client := &http.Client{Timeout: selfUpdateTimeout}
+
+	version := strings.TrimPrefix(*strVersion, "v")
+	if version == "" {
+		data, err := fetchRelease(client, baseURL+"/latest")
+		if err != nil {
+			return err
+		}
+		version = strings.TrimPrefix(strings.TrimSpace(string(data)), "v")
+	}
+	if _, ok := parseVersion(version); !ok {
+		return fmt.Errorf("invalid release version %q", version)
+	}
+	if version == Version() {
+		fmt.Println("gocovmerge", version, "is up to date.")
+		return nil
+	}
+	if *bCheck {
+		fmt.Println("gocovmerge", version, "is available, current", Version()+".")
+		return nil
+	}
+
+	name := fmt.Sprintf("gocovmerge-%s-%s", runtime.GOOS, runtime.GOARCH)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	sums, err := fetchRelease(client, baseURL+"/"+version+"/SHA256SUMS")
+	if err != nil {
+		return err
+	}
+	want, err := findChecksum(sums, name)
+	if err != nil {
+		return err
+	}
+	binary, err := fetchRelease(client, baseURL+"/"+version+"/"+name)
+	if err != nil {
+		return err
+	}
+	if sum := sha256.Sum256(binary); hex.EncodeToString(sum[:]) != want {
+		return fmt.Errorf("checksum mismatch for %s %s: got %s, want %s", name, version, hex.EncodeToString(sum[:]), want)
+	}
+	if err := replaceExecutable(binary); err != nil {
+		return err
+	}
+	fmt.Println("update gocovmerge from", Version(), "to", version, "ok.")
+	return nil
+}
+
+// 下载 release 文件, 认证信息使用 release
+func fetchRelease(client *http.Client, strURL string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, strURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := AuthorizeRequest(req, "release"); err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %v", strURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s: %s", strURL, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// 在 sha256sum 格式(hash  文件名)的校验和中查找文件
+func findChecksum(sums []byte, name string) (string, error) {
+	s := bufio.NewScanner(bytes.NewReader(sums))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name && len(fields[0]) == sha256.Size*2 {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	if err := s.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no checksum for %s in SHA256SUMS", name)
+}
+
+// 写到同一目录的临时文件再改名, 替换过程中不会留下不完整的可执行文件
+func replaceExecutable(binary []byte) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	info, err := os.Stat(exe)
+	if err != nil {
+		return err
+	}
+	tmpFile, err := os.CreateTemp(filepath.Dir(exe), ".gocovmerge-update-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(binary); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpFile.Name(), info.Mode().Perm()); err != nil {
+		return err
+	}
+	// windows 不能覆盖正在运行的文件, 先把它移开
+	if runtime.GOOS == "windows" {
+		old := exe + ".old"
+		os.Remove(old)
+		if err := os.Rename(exe, old); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmpFile.Name(), exe)
+}
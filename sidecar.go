@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// 覆盖率文件旁边的元数据文件 <file>.meta.json, 存在时版本信息以它为准, 文件名不需要带版本:
+//
+//	{"timestamp": 1723042827, "git_hash": "e24dac6", "service": "user-api", "host": "10.0.0.1", "tags": {"env": "prod"}}
+//
+// service 和 host 作为同名标签, 与 tags 一起成为该输入的标签
+type SidecarMeta struct {
+	Timestamp int64             `json:"timestamp"`
+	GitHash   string            `json:"git_hash"`
+	Service   string            `json:"service"`
+	Host      string            `json:"host"`
+	Tags      map[string]string `json:"tags"`
+}
+
+const sidecarSuffix = ".meta.json"
+
+func SidecarPath(fileName string) string {
+	return filepath.Clean(fileName) + sidecarSuffix
+}
+
+// 读取输入的元数据文件, 不存在时 ok 为 false
+func ReadSidecar(fileName string) (fileInfo *CoverFileInfo, ok bool, err error) {
+	data, err := os.ReadFile(SidecarPath(fileName))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var meta SidecarMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, false, fmt.Errorf("bad sidecar %s: %v", SidecarPath(fileName), err)
+	}
+	if meta.GitHash == "" || meta.Timestamp == 0 {
+		return nil, false, fmt.Errorf("bad sidecar %s: timestamp and git_hash required", SidecarPath(fileName))
+	}
+
+	tags := MergeTags(nil, meta.Tags)
+	if meta.Service != "" {
+		tags["service"] = meta.Service
+	}
+	if meta.Host != "" {
+		tags["host"] = meta.Host
+	}
+	return &CoverFileInfo{
+		Timestamp: meta.Timestamp,
+		GitHash:   meta.GitHash,
+		FileName:  fileName,
+		Tags:      tags,
+	}, true, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+var g_bSkipInvalid = flag.Bool("skip-invalid", false, "跳过无法解析的输入(如服务在导出时被杀掉, 文件只写了一部分), 打印警告并在最后汇总, 而不是中止整个合并")
+
+// 因 -skip-invalid 跳过的输入, 按跳过的顺序
+var g_skippedInputs []*CoverFileInfo
+
+// 跳过输入并打印警告, 同一个输入只警告一次(-split-by-tag, -html-suites 和合并会分别读取输入)
+func SkipInvalidInput(fileInfo *CoverFileInfo, err error) {
+	if IsSkippedInput(fileInfo) {
+		return
+	}
+	// 对于 http(s) 输入, 文件名就是 URL
+	fmt.Println("warning: skip invalid input:", Redact(err.Error()))
+	g_skippedInputs = append(g_skippedInputs, fileInfo)
+}
+
+// 输入是否已经被跳过. 跳过的输入不能再读取: Reader 已经读了一部分
+func IsSkippedInput(fileInfo *CoverFileInfo) bool {
+	for _, skipped := range g_skippedInputs {
+		if skipped == fileInfo {
+			return true
+		}
+	}
+	return false
+}
+
+// 打印跳过的输入的汇总
+func PrintSkippedInputs() {
+	if len(g_skippedInputs) == 0 {
+		return
+	}
+	fmt.Printf("warning: skipped %d invalid inputs:\n", len(g_skippedInputs))
+	for _, fileInfo := range g_skippedInputs {
+		fmt.Println("  " + Redact(fileInfo.FileName))
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"sort"
+
+	"golang.org/x/tools/cover"
+)
+
+// SonarQube 通用测试覆盖率格式(sonar.coverageReportPaths):
+//
+//	<coverage version="1">
+//	  <file path="go/src/example.com/foo/foo.go">
+//	    <lineToCover lineNumber="6" covered="true"/>
+//	  </file>
+//	</coverage>
+type sonarCoverage struct {
+	XMLName xml.Name    `xml:"coverage"`
+	Version int         `xml:"version,attr"`
+	Files   []sonarFile `xml:"file"`
+}
+
+type sonarFile struct {
+	Path  string      `xml:"path,attr"`
+	Lines []sonarLine `xml:"lineToCover"`
+}
+
+type sonarLine struct {
+	LineNumber int  `xml:"lineNumber,attr"`
+	Covered    bool `xml:"covered,attr"`
+}
+
+// SonarQube 等只分析当前的源码, 每个文件取时间戳最新的版本(相同时取 git hash 较大的)的覆盖率, 返回文件名不带 git hash 的副本,
+// 以及每个文件取的版本: 文件名 -> git hash
+func LatestVersionProfiles(mergedByHash map[string][]*cover.Profile, timestamps map[string]int64) ([]*cover.Profile, map[string]string) {
+	latest := make(map[string]*cover.Profile)
+	latestTimestamps := make(map[string]int64)
+	gitHashes := make(map[string]string)
+	for gitHash, profiles := range mergedByHash {
+		for _, p := range profiles {
+			// 与 MergeAcrossVersions 的顺序一致: 时间戳相同时 git hash 较大的版本较新, 结果不依赖 map 的遍历顺序
+			if timestamp, ok := latestTimestamps[p.FileName]; ok &&
+				(timestamp > timestamps[gitHash] || timestamp == timestamps[gitHash] && gitHashes[p.FileName] > gitHash) {
+				continue
+			}
+			latestTimestamps[p.FileName] = timestamps[gitHash]
+			gitHashes[p.FileName] = gitHash
+			latest[p.FileName] = &cover.Profile{FileName: p.FileName, Mode: p.Mode, Blocks: p.Blocks}
+		}
+	}
+	result := make([]*cover.Profile, 0, len(latest))
+	for _, p := range latest {
+		result = append(result, p)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].FileName < result[j].FileName })
+	return result, gitHashes
+}
+
+// 写出 SonarQube 通用测试覆盖率 XML, 路径为仓库中的路径 go/src/<文件名>, 块覆盖的每一行都是要覆盖的行
+func WriteSonarQubeFile(fileName string, profiles []*cover.Profile) error {
+	report := sonarCoverage{Version: 1}
+	for _, p := range profiles {
+		file := sonarFile{Path: RepoPath(p.FileName)}
+		for _, row := range ProfileLines(p, "") {
+			file.Lines = append(file.Lines, sonarLine{LineNumber: row.Line, Covered: row.Covered})
+		}
+		report.Files = append(report.Files, file)
+	}
+
+	outFile, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("error creating outFile: %v", err)
+	}
+	defer outFile.Close()
+	w := bufio.NewWriter(outFile)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	if _, err := w.WriteString("\n"); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return outFile.Close()
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/tools/cover"
+)
+
+// 时间戳相同的版本按 git hash 取较大的, 每次运行结果相同
+func TestLatestVersionProfilesTie(t *testing.T) {
+	mergedByHash := make(map[string][]*cover.Profile)
+	timestamps := make(map[string]int64)
+	for _, gitHash := range []string{"0b57328", "0512fda", "e24dac6", "a1b2c3d"} {
+		mergedByHash[gitHash] = []*cover.Profile{{FileName: "example.com/foo/foo.go", Mode: "set"}}
+		timestamps[gitHash] = 100
+	}
+	for range 20 {
+		profiles, gitHashes := LatestVersionProfiles(mergedByHash, timestamps)
+		if len(profiles) != 1 || gitHashes["example.com/foo/foo.go"] != "e24dac6" {
+			t.Fatalf("latest version = %v, want e24dac6", gitHashes)
+		}
+	}
+}
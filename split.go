@@ -0,0 +1,228 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/cover"
+)
+
+var (
+	g_strSplitByTag  = flag.String("split-by-tag", "", "另外按标签值把每个值的合并覆盖率输出到该目录, 例如 out/ 下的 unit.txt, e2e.txt(为空不输出)")
+	g_strSplitTagKey = flag.String("split-tag-key", "suite", "-split-by-tag 使用的标签名")
+	g_strSplitByPkg  = flag.String("split-by-package", "", "另外把每个包的合并覆盖率输出到该目录, 按包的导入路径分目录, 如 out/example.com/foo.txt(为空不输出)")
+	g_strSplitByHash = flag.String("split-by-hash", "", "另外把每个版本的覆盖率写到该目录下的 cover.txt.timestamp.githash(文件名不带 git hash 后缀, 可以在检出该版本后直接用 go tool cover), 为空不输出")
+)
+
+// 合并标签, override 中的同名标签优先
+func MergeTags(base map[string]string, override map[string]string) map[string]string {
+	tags := make(map[string]string, len(base)+len(override))
+	for key, value := range base {
+		tags[key] = value
+	}
+	for key, value := range override {
+		tags[key] = value
+	}
+	return tags
+}
+
+// 输入的标签: -tags 指定的标签加上输入自己的标签
+func InputTags(fileInfo *CoverFileInfo) (map[string]string, error) {
+	tags, err := ParseTags(*g_strTags)
+	if err != nil {
+		return nil, err
+	}
+	return MergeTags(tags, fileInfo.Tags), nil
+}
+
+// 按标签 key 的值分组合并, 写到 dir/<value>.txt, 没有该标签的输入只参与总的合并
+func WriteSplitByTag(fileInfos []*CoverFileInfo, dir string, key string) error {
+	groups, err := MergeByTag(fileInfos, key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	latest := LatestVersion(fileInfos)
+	for _, value := range sortedKeys(groups) {
+		outFile := filepath.Join(dir, strings.NewReplacer("/", "_", "\\", "_").Replace(value)+".txt")
+		if err := WriteProfileFile(outFile, groups[value], latest); err != nil {
+			return err
+		}
+		fmt.Println("generate ", outFile, " ok.")
+	}
+	return nil
+}
+
+// 按包(文件所在目录)把合并结果分开, 写到 dir/<包的导入路径>.txt, 导入路径中的 / 是子目录,
+// 所以 a/b_c 和 a_b/c 不会写到同一个文件. 与合并输出一样带 git hash 后缀
+func WriteSplitByPackage(dir string, profiles []*cover.Profile, latest *CoverFileInfo) error {
+	packages := make(map[string][]*cover.Profile)
+	for _, p := range profiles {
+		pkg := path.Dir(p.FileName)
+		packages[pkg] = append(packages[pkg], p)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, pkg := range sortedKeys(packages) {
+		rel := filepath.FromSlash(pkg)
+		if pkg == "." || !filepath.IsLocal(rel) {
+			return fmt.Errorf("can not split package %q into %s", pkg, dir)
+		}
+		outFile := filepath.Join(dir, rel) + ".txt"
+		if err := os.MkdirAll(filepath.Dir(outFile), 0755); err != nil {
+			return err
+		}
+		if err := WriteProfileFile(outFile, packages[pkg], latest); err != nil {
+			return err
+		}
+		fmt.Println("generate ", outFile, " ok.")
+	}
+	return nil
+}
+
+// 按标签 key 的值分组, 每组单独合并(包括跨版本合并), 返回 value -> 文件名带 git hash 的覆盖率.
+// 每组都带上所有输入的版本(组内没有的版本覆盖率为空), 跨版本合并只取决于有哪些版本,
+// 所以同一个文件在各组和总的合并中对应同一个版本. 会先读取所有输入的覆盖率, 每组使用副本合并
+func MergeByTag(fileInfos []*CoverFileInfo, key string) (map[string][]*cover.Profile, error) {
+	groups := make(map[string][]*CoverFileInfo)
+	timestamps := make(map[string]int64) // git hash -> 最早的时间戳
+	for _, fileInfo := range fileInfos {
+		if IsSkippedInput(fileInfo) {
+			continue
+		}
+		profiles, err := fileInfo.ReadProfiles()
+		if err != nil {
+			err = fmt.Errorf("failed to parse profiles %s: %v", fileInfo.FileName, err)
+			if !*g_bSkipInvalid {
+				return nil, err
+			}
+			// 和合并一样跳过, 不参与任何分组
+			SkipInvalidInput(fileInfo, err)
+			continue
+		}
+		// Reader 只能读取一次, 之后的合并直接使用读取的结果
+		fileInfo.Profiles = profiles
+		fileInfo.Reader = nil
+		if timestamp, ok := timestamps[fileInfo.GitHash]; !ok || fileInfo.Timestamp < timestamp {
+			timestamps[fileInfo.GitHash] = fileInfo.Timestamp
+		}
+
+		tags, err := InputTags(fileInfo)
+		if err != nil {
+			return nil, err
+		}
+		value, ok := tags[key]
+		if !ok {
+			continue
+		}
+		groups[value] = append(groups[value], &CoverFileInfo{
+			Timestamp: fileInfo.Timestamp,
+			GitHash:   fileInfo.GitHash,
+			FileName:  fileInfo.FileName,
+			Profiles:  CloneProfiles(profiles),
+			Tags:      fileInfo.Tags,
+			Weight:    fileInfo.Weight,
+		})
+	}
+	if len(groups) == 0 {
+		return nil, fmt.Errorf("no input has tag %s", key)
+	}
+
+	merged := make(map[string][]*cover.Profile, len(groups))
+	for value, group := range groups {
+		for gitHash, timestamp := range timestamps {
+			group = append(group, &CoverFileInfo{
+				Timestamp: timestamp,
+				GitHash:   gitHash,
+				Profiles:  []*cover.Profile{},
+			})
+		}
+		mergedCoverFiles, err := mergeByGitHash(group, nil)
+		if err != nil {
+			return nil, err
+		}
+		merged[value] = RenameByHash(MergeAcrossVersions(mergedCoverFiles))
+	}
+	return merged, nil
+}
+
+func sortedKeys(m map[string][]*cover.Profile) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// 深拷贝覆盖率, 合并会修改传入的 Profile
+func CloneProfiles(profiles []*cover.Profile) []*cover.Profile {
+	clones := make([]*cover.Profile, 0, len(profiles))
+	for _, p := range profiles {
+		clone := *p
+		clone.Blocks = append([]cover.ProfileBlock(nil), p.Blocks...)
+		clones = append(clones, &clone)
+	}
+	return clones
+}
+
+// 每个版本写一个覆盖率文件. 跨版本合并时内容没有变化的文件合并到了较早的版本,
+// 这些文件也写到较晚版本的文件中, 每个文件都包含该版本的全部文件
+func WriteSplitByHash(dir string, mergedByHash map[string][]*cover.Profile, timestamps map[string]int64) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	hashes := make([]string, 0, len(mergedByHash))
+	for gitHash := range mergedByHash {
+		hashes = append(hashes, gitHash)
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		if timestamps[hashes[i]] != timestamps[hashes[j]] {
+			return timestamps[hashes[i]] < timestamps[hashes[j]]
+		}
+		return hashes[i] < hashes[j]
+	})
+	for i, gitHash := range hashes {
+		profiles := append([]*cover.Profile(nil), mergedByHash[gitHash]...)
+		have := make(map[string]bool)
+		for _, p := range profiles {
+			have[p.FileName] = true
+		}
+		// 从较新的版本往前找, 同一个文件取最近的内容相同的版本
+		for j := i - 1; j >= 0; j-- {
+			for _, p := range mergedByHash[hashes[j]] {
+				if have[p.FileName] {
+					continue
+				}
+				if bSame, _ := CompareVersions(hashes[j], gitHash, RepoPath(p.FileName)); bSame {
+					profiles = append(profiles, p)
+					have[p.FileName] = true
+				}
+			}
+		}
+		sort.Slice(profiles, func(i, j int) bool { return profiles[i].FileName < profiles[j].FileName })
+
+		fileName := filepath.Join(dir, fmt.Sprintf("cover.txt.%d.%s", timestamps[gitHash], gitHash))
+		outFile, err := os.Create(fileName)
+		if err != nil {
+			return fmt.Errorf("error creating outFile: %v", err)
+		}
+		err = DumpProfiles(profiles, outFile)
+		if closeErr := outFile.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Println("generate ", fileName, " ok.")
+	}
+	return nil
+}
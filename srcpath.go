@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+var g_strSrcTemplate = flag.String("src-template", "", "覆盖率中的文件在 git 仓库中的路径, text/template 格式: .File 为覆盖率中的文件名, .Module 为仓库根目录 go.mod 的模块名, .Path 为去掉模块名前缀的文件名. 为空时 go list -m 找到的模块中的文件对应到模块的目录, 其他文件为 go/src/{{.File}}")
+
+// -src-template 的数据
+type SourcePathData struct {
+	File   string // 覆盖率中的文件名, 如 example.com/foo/foo.go
+	Module string // 仓库根目录 go.mod 的模块名, 没有时为空
+	Path   string // 去掉 Module 前缀后的 File
+}
+
+var (
+	g_srcTemplate *template.Template // 为空时按 g_goModules 对应, 其他文件为 go/src/<file>
+	g_strModule   string
+	// 模板展开为 g_strSrcPrefix + 文件名 + g_strSrcSuffix, 用于从仓库路径反推文件名
+	g_strSrcPrefix, g_strSrcSuffix string
+	g_repoPaths                    = make(map[string]string)
+	g_repoPathsMutex               sync.Mutex
+)
+
+// 解析 -src-template, 需要 .Module 时从最新提交的 go.mod 读取模块名, 没有指定时查找模块. 在 SetupRepo 之后调用
+func SetupSourcePaths() error {
+	if *g_strSrcTemplate == "" {
+		LoadGoModules()
+		return nil
+	}
+	tmpl, err := template.New("src-template").Option("missingkey=error").Parse(*g_strSrcTemplate)
+	if err != nil {
+		return fmt.Errorf("-src-template: %v", err)
+	}
+	if strings.Contains(*g_strSrcTemplate, ".Module") || strings.Contains(*g_strSrcTemplate, ".Path") {
+		if content, err := GitGetFileContent("HEAD", "go.mod"); err == nil {
+			g_strModule = moduleOf(content)
+		}
+	}
+	// 用一个不会出现在路径中的文件名展开模板, 模板中必须正好出现一次文件名
+	const placeholder = "\x00"
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, sourcePathData(g_strModule, placeholder)); err != nil {
+		return fmt.Errorf("-src-template: %v", err)
+	}
+	prefix, suffix, ok := strings.Cut(sb.String(), placeholder)
+	if !ok || strings.Contains(suffix, placeholder) {
+		return fmt.Errorf("-src-template %q must use {{.File}} or {{.Path}} exactly once", *g_strSrcTemplate)
+	}
+	if g_strModule != "" {
+		prefix = strings.TrimSuffix(prefix, g_strModule+"/")
+	}
+	g_srcTemplate, g_strSrcPrefix, g_strSrcSuffix = tmpl, prefix, suffix
+	return nil
+}
+
+func sourcePathData(module string, fileName string) SourcePathData {
+	data := SourcePathData{File: fileName, Module: module, Path: fileName}
+	if module != "" {
+		data.File = module + "/" + fileName
+	}
+	return data
+}
+
+// go.mod 中的模块名
+func moduleOf(goMod string) string {
+	s := bufio.NewScanner(strings.NewReader(goMod))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) >= 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], `"`)
+		}
+	}
+	return ""
+}
+
+// 覆盖率中的文件在 git 仓库中的路径, 按 -src-template 展开, 没有指定时为所在模块的目录或 go/src/<file>
+func RepoPath(fileName string) string {
+	if g_srcTemplate == nil {
+		if repoPath, ok := moduleRepoPath(fileName); ok {
+			return repoPath
+		}
+		return "go/src/" + fileName
+	}
+	g_repoPathsMutex.Lock()
+	defer g_repoPathsMutex.Unlock()
+	if repoPath, ok := g_repoPaths[fileName]; ok {
+		return repoPath
+	}
+	data := SourcePathData{File: fileName, Module: g_strModule, Path: fileName}
+	if g_strModule != "" {
+		data.Path = strings.TrimPrefix(fileName, g_strModule+"/")
+	}
+	var sb strings.Builder
+	if err := g_srcTemplate.Execute(&sb, data); err != nil {
+		// 模板在 SetupSourcePaths 中已经检查过
+		panic(err)
+	}
+	g_repoPaths[fileName] = sb.String()
+	return sb.String()
+}
+
+// RepoPath 的反向: 仓库路径对应的覆盖率文件名, 不在模板对应的目录中时返回 false
+func SourceFileName(repoPath string) (string, bool) {
+	prefix, suffix := "go/src/", ""
+	if g_srcTemplate == nil {
+		if fileName, ok := moduleFileName(repoPath); ok {
+			return fileName, true
+		}
+	} else {
+		prefix, suffix = g_strSrcPrefix, g_strSrcSuffix
+	}
+	if !strings.HasPrefix(repoPath, prefix) || !strings.HasSuffix(repoPath, suffix) || len(repoPath) < len(prefix)+len(suffix) {
+		return "", false
+	}
+	fileName := repoPath[len(prefix) : len(repoPath)-len(suffix)]
+	if g_strModule != "" && g_srcTemplate != nil && !strings.HasPrefix(fileName, g_strModule+"/") {
+		fileName = g_strModule + "/" + fileName
+	}
+	return fileName, true
+}
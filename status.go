@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"time"
+
+	"golang.org/x/tools/cover"
+)
+
+var (
+	g_strOutStatus  = flag.String("outstatus", "", "输出给看板和自定义徽章轮询的状态 JSON 文件(总覆盖率, 每组覆盖率和变化量), 可以是对象存储 URL(为空不输出)")
+	g_strStatusBase = flag.String("status-base", "", "计算变化量的上一次状态 JSON 文件, 默认取 -outstatus 原有的内容")
+)
+
+// 覆盖率状态, 字段只增不改, 方便看板直接使用
+type CoverageStatus struct {
+	GeneratedAt string         `json:"generated_at"`
+	GitHash     string         `json:"git_hash,omitempty"`
+	Timestamp   int64          `json:"timestamp,omitempty"`
+	Total       StatusCoverage `json:"total"`
+	// 按 -groups 分组的覆盖率, 不属于任何组的文件在 name 为空的一项中
+	Groups []StatusCoverage `json:"groups,omitempty"`
+}
+
+type StatusCoverage struct {
+	Name       string  `json:"name,omitempty"`
+	Statements int     `json:"statements"`
+	Covered    int     `json:"covered"`
+	Percent    float64 `json:"percent"`
+	// 与上一次状态相比的变化(百分点), 没有上一次状态时省略
+	Delta *float64 `json:"delta,omitempty"`
+}
+
+func newStatusCoverage(stat FileCoverage) StatusCoverage {
+	return StatusCoverage{Name: stat.FileName, Statements: stat.Statements, Covered: stat.Covered, Percent: roundPercent(stat.Percent())}
+}
+
+func roundPercent(f float64) float64 {
+	return math.Round(f*100) / 100
+}
+
+func (c *StatusCoverage) setDelta(base *StatusCoverage) {
+	if base == nil {
+		return
+	}
+	delta := roundPercent(c.Percent - base.Percent)
+	c.Delta = &delta
+}
+
+// 生成合并结果的状态, base 为上一次的状态(可以为空)
+func BuildCoverageStatus(profiles []*cover.Profile, latest *CoverFileInfo, base *CoverageStatus) *CoverageStatus {
+	var total FileCoverage
+	for _, stat := range ComputeFileCoverage(profiles) {
+		total.Statements += stat.Statements
+		total.Covered += stat.Covered
+	}
+	status := &CoverageStatus{
+		GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+		Total:       newStatusCoverage(total),
+	}
+	if latest != nil {
+		status.GitHash, status.Timestamp = latest.GitHash, latest.Timestamp
+	}
+	if base != nil {
+		status.Total.setDelta(&base.Total)
+	}
+	if g_packageGroups != nil {
+		stats := g_packageGroups.Rollup(profiles)
+		for _, name := range append(append([]string(nil), g_packageGroups.names...), "") {
+			stat := stats[name]
+			if stat == nil {
+				if name == "" {
+					continue
+				}
+				stat = &FileCoverage{FileName: name}
+			}
+			group := newStatusCoverage(*stat)
+			if base != nil {
+				group.setDelta(base.group(name))
+			}
+			status.Groups = append(status.Groups, group)
+		}
+	}
+	return status
+}
+
+func (status *CoverageStatus) group(name string) *StatusCoverage {
+	for i := range status.Groups {
+		if status.Groups[i].Name == name {
+			return &status.Groups[i]
+		}
+	}
+	return nil
+}
+
+// 读取状态文件, 文件不存在时返回空
+func ReadCoverageStatus(name string) (*CoverageStatus, error) {
+	var data []byte
+	var err error
+	if IsObjectURL(name) {
+		data, err = ReadObject(name)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var status CoverageStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		return nil, fmt.Errorf("bad status file %s: %v", name, err)
+	}
+	return &status, nil
+}
+
+// 输出 -outstatus, 变化量相对 -status-base, 没有指定时相对输出文件原有的内容
+func WriteCoverageStatus(fileName string, profiles []*cover.Profile, latest *CoverFileInfo) error {
+	var base *CoverageStatus
+	var err error
+	if *g_strStatusBase != "" {
+		if base, err = ReadCoverageStatus(*g_strStatusBase); err != nil {
+			return err
+		}
+	} else if base, err = ReadCoverageStatus(fileName); err != nil {
+		// 对象存储上还没有状态文件时读取会失败, 不计算变化量
+		fmt.Println("no previous status:", Redact(err.Error()))
+		base = nil
+	}
+	data, err := json.MarshalIndent(BuildCoverageStatus(profiles, latest, base), "", "  ")
+	if err != nil {
+		return err
+	}
+	localFile, upload, err := LocalOutput(fileName)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(localFile, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return upload()
+}
+
+// status-serve: 通过 HTTP 提供状态 JSON 文件, 每次请求重新读取文件, 支持 ETag 和跨域访问, 供看板轮询
+func runStatusServe(args []string) error {
+	fs := NewSubCommandFlagSet("status-serve", "[options]")
+	strAddr := fs.String("addr", ":8080", "监听地址")
+	strFile := fs.String("file", "status.json", "状态 JSON 文件(由 -outstatus 生成)")
+	strDB := fs.String("db", "", "门禁豁免所在的历史库(SQLite), 指定时提供 /overrides 页面和 /api/overrides 接口")
+	strApprovers := fs.String("approvers", "", "审批人文件, 每行 \"<名字> <token 的 sha256>\", 只有审批人可以审批豁免")
+	fs.Parse(args)
+	approvers := map[string]string{}
+	if *strApprovers != "" {
+		var err error
+		if approvers, err = ReadApprovers(*strApprovers); err != nil {
+			return err
+		}
+	}
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		data, err := os.ReadFile(*strFile)
+		if err != nil {
+			http.Error(w, "status not available", http.StatusServiceUnavailable)
+			return
+		}
+		sum := sha256.Sum256(data)
+		etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", handler)
+	mux.HandleFunc("/status.json", handler)
+	if *strDB != "" {
+		overrideHandlers(mux, *strDB, approvers)
+	}
+	fmt.Println("serve", *strFile, "on", *strAddr)
+	return http.ListenAndServe(*strAddr, mux)
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/tools/cover"
+	_ "modernc.org/sqlite"
+)
+
+// 覆盖率历史库的表结构变更, 按顺序执行, 已执行的位置记录在 PRAGMA user_version 中
+var g_storeMigrations = []string{
+	`CREATE TABLE runs (
+		id          INTEGER PRIMARY KEY AUTOINCREMENT,
+		source      TEXT    NOT NULL,
+		git_hash    TEXT    NOT NULL,
+		timestamp   INTEGER NOT NULL,
+		mode        TEXT    NOT NULL,
+		imported_at INTEGER NOT NULL
+	);
+	CREATE TABLE run_tags (
+		run_id INTEGER NOT NULL REFERENCES runs(id),
+		key    TEXT    NOT NULL,
+		value  TEXT    NOT NULL,
+		PRIMARY KEY (run_id, key)
+	);
+	CREATE TABLE files (
+		id        INTEGER PRIMARY KEY AUTOINCREMENT,
+		run_id    INTEGER NOT NULL REFERENCES runs(id),
+		file_name TEXT    NOT NULL
+	);
+	CREATE TABLE blocks (
+		file_id    INTEGER NOT NULL REFERENCES files(id),
+		start_line INTEGER NOT NULL,
+		start_col  INTEGER NOT NULL,
+		end_line   INTEGER NOT NULL,
+		end_col    INTEGER NOT NULL,
+		num_stmt   INTEGER NOT NULL,
+		count      INTEGER NOT NULL
+	);
+	CREATE INDEX runs_git_hash ON runs(git_hash);
+	CREATE INDEX files_run_id ON files(run_id);
+	CREATE INDEX files_file_name ON files(file_name);
+	CREATE INDEX blocks_file_id ON blocks(file_id);`,
+	// 标记无效的运行, 以及对运行状态修改的审计记录
+	`ALTER TABLE runs ADD COLUMN invalid INTEGER NOT NULL DEFAULT 0;
+	CREATE TABLE run_audit (
+		id     INTEGER PRIMARY KEY AUTOINCREMENT,
+		run_id INTEGER NOT NULL REFERENCES runs(id),
+		action TEXT    NOT NULL,
+		reason TEXT    NOT NULL,
+		actor  TEXT    NOT NULL,
+		at     INTEGER NOT NULL
+	);
+	CREATE INDEX run_audit_run_id ON run_audit(run_id);`,
+	// 重复导入检测: 内容的校验和, 以及 CI 等外部系统的运行 id
+	`ALTER TABLE runs ADD COLUMN checksum TEXT NOT NULL DEFAULT '';
+	ALTER TABLE runs ADD COLUMN external_id TEXT NOT NULL DEFAULT '';
+	CREATE INDEX runs_checksum ON runs(checksum);
+	CREATE INDEX runs_external_id ON runs(external_id);`,
+	// 一次性的门禁豁免, 以及审批和使用豁免的审计记录
+	`CREATE TABLE gate_overrides (
+		id          INTEGER PRIMARY KEY AUTOINCREMENT,
+		git_hash    TEXT    NOT NULL,
+		reason      TEXT    NOT NULL,
+		approved_by TEXT    NOT NULL,
+		approved_at INTEGER NOT NULL,
+		used_by     TEXT    NOT NULL DEFAULT '',
+		used_at     INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE TABLE gate_audit (
+		id          INTEGER PRIMARY KEY AUTOINCREMENT,
+		override_id INTEGER NOT NULL REFERENCES gate_overrides(id),
+		git_hash    TEXT    NOT NULL,
+		action      TEXT    NOT NULL,
+		reason      TEXT    NOT NULL,
+		actor       TEXT    NOT NULL,
+		at          INTEGER NOT NULL
+	);
+	CREATE INDEX gate_overrides_git_hash ON gate_overrides(git_hash);`,
+}
+
+// 基于 SQLite 的覆盖率历史库
+type Store struct {
+	db *sql.DB
+}
+
+// 打开(不存在则创建)历史库, 并执行未执行过的表结构变更
+func OpenStore(path string) (*Store, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store %s: %v", path, err)
+	}
+	store := &Store{db: db}
+	if err := store.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate store %s: %v", path, err)
+	}
+	return store, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func (s *Store) migrate() error {
+	var version int
+	if err := s.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	for ; version < len(g_storeMigrations); version++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(g_storeMigrations[version]); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 运行内容的校验和: git hash, 时间戳和按文件名排序的覆盖率, 与输入文件名和标签无关
+func RunChecksum(fileInfo *CoverFileInfo, profiles []*cover.Profile) (string, error) {
+	sorted := append([]*cover.Profile(nil), profiles...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].FileName < sorted[j].FileName })
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %d\n", fileInfo.GitHash, fileInfo.Timestamp)
+	if err := DumpProfiles(sorted, h); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// 把一次运行(一个输入文件)的覆盖率写入历史库, 返回 run id. externalID 为外部系统的运行 id(可以为空).
+// 相同内容或相同 externalID 的运行已经导入过时(如 CI 重试重复上传)不再写入, 返回已有的 run id 和 bDuplicate
+func (s *Store) ImportRun(fileInfo *CoverFileInfo, profiles []*cover.Profile, tags map[string]string, externalID string) (runID int64, bDuplicate bool, err error) {
+	mode := ""
+	if len(profiles) > 0 {
+		mode = profiles[0].Mode
+	}
+	checksum, err := RunChecksum(fileInfo, profiles)
+	if err != nil {
+		return 0, false, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow("SELECT id FROM runs WHERE checksum = ? OR (external_id <> '' AND external_id = ?) ORDER BY id LIMIT 1", checksum, externalID).Scan(&runID)
+	if err == nil {
+		return runID, true, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, false, err
+	}
+
+	res, err := tx.Exec("INSERT INTO runs (source, git_hash, timestamp, mode, imported_at, checksum, external_id) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		fileInfo.FileName, fileInfo.GitHash, fileInfo.Timestamp, mode, time.Now().Unix(), checksum, externalID)
+	if err != nil {
+		return 0, false, err
+	}
+	if runID, err = res.LastInsertId(); err != nil {
+		return 0, false, err
+	}
+	for key, value := range tags {
+		if _, err := tx.Exec("INSERT INTO run_tags (run_id, key, value) VALUES (?, ?, ?)", runID, key, value); err != nil {
+			return 0, false, err
+		}
+	}
+
+	blockStmt, err := tx.Prepare("INSERT INTO blocks (file_id, start_line, start_col, end_line, end_col, num_stmt, count) VALUES (?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return 0, false, err
+	}
+	defer blockStmt.Close()
+	for _, p := range profiles {
+		res, err := tx.Exec("INSERT INTO files (run_id, file_name) VALUES (?, ?)", runID, p.FileName)
+		if err != nil {
+			return 0, false, err
+		}
+		fileID, err := res.LastInsertId()
+		if err != nil {
+			return 0, false, err
+		}
+		for _, b := range p.Blocks {
+			if _, err := blockStmt.Exec(fileID, b.StartLine, b.StartCol, b.EndLine, b.EndCol, b.NumStmt, b.Count); err != nil {
+				return 0, false, err
+			}
+		}
+	}
+	return runID, false, tx.Commit()
+}
+
+// 把运行标记为无效(bInvalid 为 true)或恢复为有效, 同时写入审计记录
+func (s *Store) SetRunInvalid(runID int64, bInvalid bool, reason string, actor string) error {
+	action := "restore"
+	if bInvalid {
+		action = "invalidate"
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE runs SET invalid = ? WHERE id = ?", bInvalid, runID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("run %d not found", runID)
+	}
+	if _, err := tx.Exec("INSERT INTO run_audit (run_id, action, reason, actor, at) VALUES (?, ?, ?, ?, ?)",
+		runID, action, reason, actor, time.Now().Unix()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// 解析 k=v,k=v 形式的标签
+func ParseTags(s string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, kv := range strings.Split(s, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("tag %q is not in key=value form", kv)
+		}
+		tags[key] = value
+	}
+	return tags, nil
+}
+
+// 把标签格式化为按 key 排序的 k=v,k=v
+func FormatTags(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for i, key := range keys {
+		keys[i] = key + "=" + tags[key]
+	}
+	return strings.Join(keys, ",")
+}
+
+// import: 把覆盖率文件导入历史库, 每个文件记为一次运行
+func runImport(args []string) error {
+	fs := NewSubCommandFlagSet("import", "[options] [cover.txt.timestamp.hash[#k=v,...] ...]")
+	strDB := fs.String("db", "cover.db", "覆盖率历史库(SQLite)")
+	strTags := fs.String("tags", "", "本次导入的运行标签, 例如 env=prod,suite=e2e")
+	strRunIDTag := fs.String("run-id-tag", "run_id", "作为外部运行 id 的标签, 同一运行 id 中相同文件名的输入只导入一次(内容相同的输入总是只导入一次)")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("Error: cover.txt.xxx.xxx file required.")
+	}
+
+	tags, err := ParseTags(*strTags)
+	if err != nil {
+		return err
+	}
+	fileInfos, err := ParseCoverFileInfos(fs.Args())
+	if err != nil {
+		return err
+	}
+
+	store, err := OpenStore(*strDB)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	for _, fileInfo := range fileInfos {
+		profiles, err := fileInfo.ReadProfiles()
+		if err != nil {
+			return fmt.Errorf("failed to parse profiles: %v", err)
+		}
+		// 一次运行可以有多个输入文件, 外部运行 id 加上文件名才是一次导入
+		runTags := MergeTags(tags, fileInfo.Tags)
+		externalID := ""
+		if runTags[*strRunIDTag] != "" {
+			externalID = runTags[*strRunIDTag] + "/" + filepath.Base(fileInfo.FileName)
+		}
+		runID, bDuplicate, err := store.ImportRun(fileInfo, profiles, runTags, externalID)
+		if err != nil {
+			return fmt.Errorf("failed to import %s: %v", fileInfo.FileName, err)
+		}
+		if bDuplicate {
+			fmt.Println("skip ", Redact(fileInfo.FileName), ", already imported as run ", runID, ".")
+			continue
+		}
+		fmt.Println("import ", Redact(fileInfo.FileName), " as run ", runID, " ok.")
+	}
+	return nil
+}
+
+// query: 对历史库执行任意 SQL, 结果按列对齐输出
+func runQuery(args []string) error {
+	fs := NewSubCommandFlagSet("query", "[options] \"SELECT ...\"")
+	strDB := fs.String("db", "cover.db", "覆盖率历史库(SQLite)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("Error: one SQL statement required.")
+	}
+
+	store, err := OpenStore(*strDB)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	rows, err := store.db.Query(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(columns, "\t"))
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		fields := make([]string, len(values))
+		for i, v := range values {
+			if v.Valid {
+				fields[i] = v.String
+			} else {
+				fields[i] = "NULL"
+			}
+		}
+		fmt.Fprintln(w, strings.Join(fields, "\t"))
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return w.Flush()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"golang.org/x/tools/cover"
+)
+
+var g_strOp = flag.String("op", "merge", "合并操作: merge 合并所有输入, subtract 第一个输入是基线, 从其他输入的合并结果中去掉基线已经覆盖的块, 只留下新覆盖的块")
+
+// -op subtract 的基线: git hash -> 跨版本合并后该版本的覆盖率(文件名不带 git hash 后缀)
+var g_baselineByHash map[string][]*cover.Profile
+
+// 合并基线, 并让基线和其他输入包含相同的版本.
+// 跨版本合并只取决于有哪些版本和每个版本的时间戳, 两边的版本相同时同一个文件对应同一个版本, 可以按块相减.
+// 返回补上基线版本的其他输入
+func PrepareSubtract(baselineInfos []*CoverFileInfo, fileInfos []*CoverFileInfo) ([]*CoverFileInfo, error) {
+	for _, infos := range [][]*CoverFileInfo{baselineInfos, fileInfos} {
+		fraction, err := ResolveSampleFraction(infos)
+		if err != nil {
+			return nil, err
+		}
+		if fraction > 0 {
+			return nil, fmt.Errorf("-sample-fraction or the %s tag can not be used with -op subtract", sampleFractionTag)
+		}
+	}
+	timestamps := make(map[string]int64) // git hash -> 最早的时间戳
+	for _, infos := range [][]*CoverFileInfo{baselineInfos, fileInfos} {
+		for _, fileInfo := range infos {
+			if timestamp, ok := timestamps[fileInfo.GitHash]; !ok || fileInfo.Timestamp < timestamp {
+				timestamps[fileInfo.GitHash] = fileInfo.Timestamp
+			}
+		}
+	}
+	// 每个版本都加上一个最早时间戳的空输入, 两边每个版本的时间戳相同
+	withVersions := func(infos []*CoverFileInfo) []*CoverFileInfo {
+		infos = append([]*CoverFileInfo(nil), infos...)
+		for gitHash, timestamp := range timestamps {
+			infos = append(infos, &CoverFileInfo{Timestamp: timestamp, GitHash: gitHash, Profiles: []*cover.Profile{}})
+		}
+		return infos
+	}
+	mergedBaseline, err := mergeByGitHash(withVersions(baselineInfos), nil)
+	if err != nil {
+		return nil, err
+	}
+	g_baselineByHash = MergeAcrossVersions(mergedBaseline)
+	return withVersions(fileInfos), nil
+}
+
+// 去掉基线已经覆盖的块: 基线中同一版本同一文件相同位置的块计数大于 0 时, 把计数改为 0
+func SubtractBaseline(mergedByHash map[string][]*cover.Profile, baselineByHash map[string][]*cover.Profile) {
+	type blockPos struct {
+		startLine, startCol, endLine, endCol int
+	}
+	for gitHash, profiles := range mergedByHash {
+		baseline := make(map[string]map[blockPos]bool)
+		for _, p := range baselineByHash[gitHash] {
+			covered := make(map[blockPos]bool)
+			for _, b := range p.Blocks {
+				if b.Count > 0 {
+					covered[blockPos{b.StartLine, b.StartCol, b.EndLine, b.EndCol}] = true
+				}
+			}
+			baseline[p.FileName] = covered
+		}
+		for _, p := range profiles {
+			covered := baseline[p.FileName]
+			if len(covered) == 0 {
+				continue
+			}
+			for i, b := range p.Blocks {
+				if covered[blockPos{b.StartLine, b.StartCol, b.EndLine, b.EndCol}] {
+					p.Blocks[i].Count = 0
+				}
+			}
+		}
+	}
+}
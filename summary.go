@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"golang.org/x/tools/cover"
+)
+
+var (
+	g_bSummaryLine = flag.Bool("summary-line", false, "最后在标准输出打印一行便于脚本解析的结果: RESULT total=83.4% files=512 threshold=pass")
+	g_fMinCoverage = flag.Float64("min-coverage", 0, "最低总覆盖率(%), 低于时输出仍然生成但以错误退出(为 0 不检查)")
+)
+
+// 合并结果的汇总, 由 MergeVersions 填充, 用于 -summary-line 和 -min-coverage
+type MergeSummary struct {
+	Total     float64  // 所有版本的总覆盖率(%)
+	Files     int      // 文件数, 同一文件的多个版本只算一次
+	Threshold string   // pass, fail, override(不通过但使用了 -gate-overrides 的豁免) 或 none(没有指定 -min-coverage)
+	Lua       *float64 // Lua 脚本的行覆盖率(%), 没有 -luacov 时为空
+	Override  string   // 使用的豁免, 没有使用时为空
+}
+
+var g_mergeSummary *MergeSummary
+
+// 汇总合并结果, latestProfiles 为每个文件的最新版本
+func SummarizeMerge(merged []*cover.Profile, latestProfiles []*cover.Profile) *MergeSummary {
+	var covered, total int
+	for _, stat := range ComputeFileCoverage(merged) {
+		covered += stat.Covered
+		total += stat.Statements
+	}
+	summary := &MergeSummary{
+		Total:     FileCoverage{Statements: total, Covered: covered}.Percent(),
+		Files:     len(latestProfiles),
+		Threshold: "none",
+	}
+	if g_luaScripts != nil {
+		lua := LuaTotals(g_luaScripts).Percent()
+		summary.Lua = &lua
+	}
+	if *g_fMinCoverage > 0 {
+		summary.Threshold = "pass"
+		if summary.Total < *g_fMinCoverage {
+			summary.Threshold = "fail"
+		}
+	}
+	return summary
+}
+
+// 低于 -min-coverage 时返回错误
+func (s *MergeSummary) Check() error {
+	if s.Threshold == "fail" {
+		return fmt.Errorf("total coverage %.1f%% is below %.1f%%", s.Total, *g_fMinCoverage)
+	}
+	return nil
+}
+
+// 便于脚本解析的结果行(格式保持稳定, 只能在末尾增加字段)
+func (s *MergeSummary) Line() string {
+	line := fmt.Sprintf("RESULT total=%.1f%% files=%d threshold=%s", s.Total, s.Files, s.Threshold)
+	if s.Lua != nil {
+		line += fmt.Sprintf(" lua=%.1f%%", *s.Lua)
+	}
+	return line
+}
+
+// 单个文件的语句覆盖统计
+type FileCoverage struct {
+	FileName   string
+	Statements int
+	Covered    int
+}
+
+// 覆盖率百分比, 没有语句时为 0
+func (c FileCoverage) Percent() float64 {
+	if c.Statements == 0 {
+		return 0
+	}
+	return float64(c.Covered) * 100 / float64(c.Statements)
+}
+
+// 统计每个文件的语句数和已覆盖语句数
+func ComputeFileCoverage(profiles []*cover.Profile) []FileCoverage {
+	stats := make([]FileCoverage, 0, len(profiles))
+	for _, p := range profiles {
+		stat := FileCoverage{FileName: p.FileName}
+		for _, b := range p.Blocks {
+			stat.Statements += b.NumStmt
+			if b.Count > 0 {
+				stat.Covered += b.NumStmt
+			}
+		}
+		stats = append(stats, stat)
+	}
+	return stats
+}
+
+// 按函数打印覆盖率(类似 go tool cover -func), 最后一行是总覆盖率. 函数取自导出的各版本源码,
+// 文件名带 git hash 后缀, 没有源码的文件整个作为一个函数
+func PrintFuncSummary(w io.Writer, profiles []*cover.Profile) error {
+	sorted := append([]*cover.Profile(nil), profiles...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].FileName < sorted[j].FileName })
+	tw := tabwriter.NewWriter(w, 1, 8, 1, '\t', 0)
+	var covered, total int
+	for _, p := range sorted {
+		funcs, _ := profileFuncs(p)
+		for _, f := range funcs {
+			line, statements, funcCovered := 0, 0, 0
+			for i, b := range f.blocks {
+				if i == 0 || b.StartLine < line {
+					line = b.StartLine
+				}
+				statements += b.NumStmt
+				if b.Count > 0 {
+					funcCovered += b.NumStmt
+				}
+			}
+			covered += funcCovered
+			total += statements
+			fmt.Fprintf(tw, "%s:%d:\t%s\t%.1f%%\n", p.FileName, line, f.name, FileCoverage{Statements: statements, Covered: funcCovered}.Percent())
+		}
+	}
+	// 抽样时这里都是观察到的覆盖率, 全量估计见抽样汇总
+	strTotal := "(statements)"
+	if g_sampleEstimator != nil {
+		strTotal = "(statements, sampled)"
+	}
+	fmt.Fprintf(tw, "total:\t%s\t%.1f%%\n", strTotal, FileCoverage{Statements: total, Covered: covered}.Percent())
+	return tw.Flush()
+}
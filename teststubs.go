@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/cover"
+)
+
+var (
+	g_strOutTestStubs = flag.String("outteststubs", "", "为完全没有覆盖的导出函数生成表驱动测试的骨架, 输出到该目录下的 <包的导入路径>/gocovmerge_stubs_test.go(为空不输出)")
+	g_nTestStubsMax   = flag.Int("teststubs-max", 20, "-outteststubs 最多生成的函数个数, 按未覆盖的语句数从多到少选取")
+)
+
+// 一个没有覆盖的导出函数
+type testGap struct {
+	pkg        string // 包的导入路径
+	pkgName    string
+	file       string
+	line       int
+	decl       *ast.FuncDecl
+	fset       *token.FileSet
+	imports    map[string]string // 源文件中的包名 -> 导入路径
+	statements int
+	callers    []string // 调用的位置, 文件:行号
+}
+
+// 函数名, 方法为 T.M
+func (gap *testGap) name() string {
+	if recv := gap.recvType(); recv != "" {
+		return strings.TrimPrefix(recv, "*") + "." + gap.decl.Name.Name
+	}
+	return gap.decl.Name.Name
+}
+
+// 接收者的类型, 如 *T, 不是方法时为空
+func (gap *testGap) recvType() string {
+	if gap.decl.Recv == nil || len(gap.decl.Recv.List) != 1 {
+		return ""
+	}
+	return gap.expr(gap.decl.Recv.List[0].Type)
+}
+
+func (gap *testGap) expr(e ast.Expr) string {
+	var buf bytes.Buffer
+	printer.Fprint(&buf, gap.fset, e)
+	return buf.String()
+}
+
+// 找出每个文件最新版本中完全没有覆盖的导出函数和方法(接收者也是导出类型, 不包括泛型), 按未覆盖的语句数排序
+func findTestGaps(profiles []*cover.Profile, latestHashes map[string]string) ([]*testGap, map[string]*ast.File, map[string]*token.FileSet) {
+	var gaps []*testGap
+	files := make(map[string]*ast.File) // 文件 -> 语法树, 用于查找调用的位置
+	fsets := make(map[string]*token.FileSet)
+	for _, p := range profiles {
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, VersionedSourcePath(p.FileName, latestHashes[p.FileName]), nil, parser.SkipObjectResolution)
+		if err != nil {
+			continue
+		}
+		files[p.FileName], fsets[p.FileName] = file, fset
+		if strings.HasSuffix(p.FileName, "_test.go") || file.Name.Name == "main" {
+			continue
+		}
+		imports := make(map[string]string)
+		for _, spec := range file.Imports {
+			importPath, _ := strconv.Unquote(spec.Path.Value)
+			name := path.Base(importPath)
+			if spec.Name != nil {
+				name = spec.Name.Name
+			}
+			imports[name] = importPath
+		}
+		for _, d := range file.Decls {
+			decl, ok := d.(*ast.FuncDecl)
+			if !ok || !decl.Name.IsExported() || decl.Type.TypeParams != nil || decl.Body == nil {
+				continue
+			}
+			gap := &testGap{pkg: path.Dir(p.FileName), pkgName: file.Name.Name, file: p.FileName, decl: decl, fset: fset, imports: imports,
+				line: fset.Position(decl.Pos()).Line}
+			if recv := gap.recvType(); recv != "" && (strings.Contains(recv, "[") || !ast.IsExported(strings.TrimPrefix(recv, "*"))) {
+				continue
+			}
+			r := funcRange{start: fset.Position(decl.Pos()), end: fset.Position(decl.End())}
+			covered := 0
+			for _, b := range p.Blocks {
+				if r.contains(b.StartLine, b.StartCol) {
+					gap.statements += b.NumStmt
+					if b.Count > 0 {
+						covered += b.NumStmt
+					}
+				}
+			}
+			if gap.statements > 0 && covered == 0 {
+				gaps = append(gaps, gap)
+			}
+		}
+	}
+	sort.SliceStable(gaps, func(i, j int) bool {
+		if gaps[i].statements != gaps[j].statements {
+			return gaps[i].statements > gaps[j].statements
+		}
+		return gaps[i].pkg+"."+gaps[i].name() < gaps[j].pkg+"."+gaps[j].name()
+	})
+	return gaps, files, fsets
+}
+
+// 查找调用的位置: 同一个包中直接调用函数名, 其他包中通过导入的包名调用, 方法按方法名查找(可能包括同名的其他方法)
+func findGapCallers(gaps []*testGap, files map[string]*ast.File, fsets map[string]*token.FileSet) {
+	fileNames := make([]string, 0, len(files))
+	for fileName := range files {
+		fileNames = append(fileNames, fileName)
+	}
+	sort.Strings(fileNames)
+	for _, gap := range gaps {
+		bMethod := gap.decl.Recv != nil
+		for _, fileName := range fileNames {
+			file := files[fileName]
+			importNames := make(map[string]bool) // 导入 gap.pkg 用的包名
+			for _, spec := range file.Imports {
+				if importPath, _ := strconv.Unquote(spec.Path.Value); importPath == gap.pkg {
+					name := gap.pkgName
+					if spec.Name != nil {
+						name = spec.Name.Name
+					}
+					importNames[name] = true
+				}
+			}
+			bSamePkg := path.Dir(fileName) == gap.pkg
+			ast.Inspect(file, func(n ast.Node) bool {
+				call, ok := n.(*ast.CallExpr)
+				if !ok {
+					return true
+				}
+				bMatch := false
+				switch fun := call.Fun.(type) {
+				case *ast.Ident:
+					bMatch = !bMethod && bSamePkg && fun.Name == gap.decl.Name.Name
+				case *ast.SelectorExpr:
+					if fun.Sel.Name != gap.decl.Name.Name {
+						break
+					}
+					x, bIdent := fun.X.(*ast.Ident)
+					bMatch = bMethod || bIdent && importNames[x.Name]
+				}
+				if bMatch && (fileName != gap.file || !(call.Pos() >= gap.decl.Pos() && call.Pos() < gap.decl.End())) {
+					gap.callers = append(gap.callers, fmt.Sprintf("%s:%d", fileName, fsets[fileName].Position(call.Pos()).Line))
+				}
+				return true
+			})
+		}
+	}
+}
+
+// 输出 -outteststubs, profiles 为每个文件的最新版本
+func WriteTestStubs(dir string, profiles []*cover.Profile, latestHashes map[string]string) error {
+	gaps, files, fsets := findTestGaps(profiles, latestHashes)
+	if len(gaps) > *g_nTestStubsMax {
+		gaps = gaps[:*g_nTestStubsMax]
+	}
+	findGapCallers(gaps, files, fsets)
+	byPkg := make(map[string][]*testGap)
+	var pkgs []string
+	for _, gap := range gaps {
+		if byPkg[gap.pkg] == nil {
+			pkgs = append(pkgs, gap.pkg)
+		}
+		byPkg[gap.pkg] = append(byPkg[gap.pkg], gap)
+	}
+	sort.Strings(pkgs)
+	for _, pkg := range pkgs {
+		src, err := testStubFile(byPkg[pkg])
+		if err != nil {
+			return fmt.Errorf("generate test stubs for %s: %v", pkg, err)
+		}
+		fileName := filepath.Join(dir, filepath.FromSlash(pkg), "gocovmerge_stubs_test.go")
+		if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(fileName, src, 0644); err != nil {
+			return err
+		}
+	}
+	fmt.Println("generate ", len(gaps), " test stubs in ", dir, " ok.")
+	return nil
+}
+
+// 测试表的字段不能与循环中用到的名字冲突
+var g_testStubReserved = map[string]bool{"name": true, "want": true, "wantErr": true, "tt": true, "t": true, "tests": true, "got": true, "err": true, "recv": true}
+
+// 生成一个包的测试文件, 每个函数一个表驱动测试
+func testStubFile(gaps []*testGap) ([]byte, error) {
+	var body bytes.Buffer
+	imports := map[string]string{"testing": "testing"}
+	bReflect := false
+	for _, gap := range gaps {
+		// 签名中用到的包
+		ast.Inspect(gap.decl.Type, func(n ast.Node) bool {
+			if sel, ok := n.(*ast.SelectorExpr); ok {
+				if x, ok := sel.X.(*ast.Ident); ok && gap.imports[x.Name] != "" {
+					imports[x.Name] = gap.imports[x.Name]
+				}
+			}
+			return true
+		})
+
+		var fields, args []string
+		if recv := gap.recvType(); recv != "" {
+			fields = append(fields, "recv "+recv)
+		}
+		i := 0
+		for _, field := range gap.decl.Type.Params.List {
+			typ := field.Type
+			bVariadic := false
+			if ellipsis, ok := typ.(*ast.Ellipsis); ok {
+				typ, bVariadic = &ast.ArrayType{Elt: ellipsis.Elt}, true
+			}
+			names := field.Names
+			if len(names) == 0 {
+				names = []*ast.Ident{nil}
+			}
+			for _, name := range names {
+				fieldName := fmt.Sprintf("arg%d", i)
+				if name != nil && name.Name != "_" {
+					fieldName = name.Name
+					if g_testStubReserved[fieldName] {
+						fieldName = "arg" + strings.ToUpper(fieldName[:1]) + fieldName[1:]
+					}
+				}
+				i++
+				fields = append(fields, fieldName+" "+gap.expr(typ))
+				arg := "tt." + fieldName
+				if bVariadic {
+					arg += "..."
+				}
+				args = append(args, arg)
+			}
+		}
+		var results []string // 除 error 外的返回值的类型
+		bError := false
+		if gap.decl.Type.Results != nil {
+			for _, field := range gap.decl.Type.Results.List {
+				n := max(len(field.Names), 1)
+				for k := 0; k < n; k++ {
+					results = append(results, gap.expr(field.Type))
+				}
+			}
+			if len(results) > 0 && results[len(results)-1] == "error" {
+				results, bError = results[:len(results)-1], true
+			}
+		}
+		var gots, checks []string
+		for k, typ := range results {
+			want := "want"
+			if len(results) > 1 {
+				want = fmt.Sprintf("want%d", k)
+			}
+			fields = append(fields, want+" "+typ)
+			got := strings.Replace(want, "want", "got", 1)
+			gots = append(gots, got)
+			checks = append(checks, fmt.Sprintf("if !reflect.DeepEqual(%s, tt.%s) {\nt.Errorf(\"%s() %s = %%v, want %%v\", %s, tt.%s)\n}\n", got, want, gap.name(), got, got, want))
+			bReflect = true
+		}
+		if bError {
+			fields = append(fields, "wantErr bool")
+			gots = append(gots, "err")
+		}
+
+		call := gap.decl.Name.Name + "(" + strings.Join(args, ", ") + ")"
+		if gap.decl.Recv != nil {
+			call = "tt.recv." + call
+		}
+		fmt.Fprintf(&body, "\n// %s is not covered: %d statements at %s:%d.\n", gap.name(), gap.statements, gap.file, gap.line)
+		if len(gap.callers) > 0 {
+			callers := gap.callers
+			if len(callers) > 5 {
+				callers = append(callers[:5:5], fmt.Sprintf("and %d more", len(gap.callers)-5))
+			}
+			fmt.Fprintf(&body, "// Called at %s.\n", strings.Join(callers, ", "))
+		}
+		fmt.Fprintf(&body, "func Test%s(t *testing.T) {\ntests := []struct {\nname string\n%s\n}{\n// TODO: add test cases.\n}\n", strings.ReplaceAll(gap.name(), ".", "_"), strings.Join(fields, "\n"))
+		body.WriteString("for _, tt := range tests {\nt.Run(tt.name, func(t *testing.T) {\n")
+		switch {
+		case len(gots) == 0:
+			body.WriteString(call + "\n")
+		default:
+			body.WriteString(strings.Join(gots, ", ") + " := " + call + "\n")
+		}
+		if bError {
+			fmt.Fprintf(&body, "if (err != nil) != tt.wantErr {\nt.Fatalf(\"%s() error = %%v, wantErr %%v\", err, tt.wantErr)\n}\n", gap.name())
+		}
+		body.WriteString(strings.Join(checks, ""))
+		body.WriteString("})\n}\n}\n")
+	}
+	if bReflect {
+		imports["reflect"] = "reflect"
+	}
+
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "// Test stubs generated by gocovmerge -outteststubs for exported functions without coverage.\n// Fill in the test cases and rename the file before committing.\n\npackage %s\n\nimport (\n", gaps[0].pkgName)
+	names := make([]string, 0, len(imports))
+	for name := range imports {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return imports[names[i]] < imports[names[j]] })
+	for _, name := range names {
+		importPath := imports[name]
+		if path.Base(importPath) == name {
+			fmt.Fprintf(&src, "%q\n", importPath)
+		} else {
+			fmt.Fprintf(&src, "%s %q\n", name, importPath)
+		}
+	}
+	src.WriteString(")\n")
+	src.Write(body.Bytes())
+	return format.Source(src.Bytes())
+}
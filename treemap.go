@@ -0,0 +1,66 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/cover"
+)
+
+var g_strOutTreemap = flag.String("outtreemap", "", "输出覆盖率 treemap HTML: 包按语句数决定面积, 按覆盖率着色, 点击包查看其中的文件(为空不输出)")
+
+//go:embed assets/treemap.html
+var g_treemapHTML string
+
+// treemap 的节点: 包有 children, 文件有语句数和已覆盖语句数(包的数字在页面中汇总)
+type treemapNode struct {
+	Name       string         `json:"name"`
+	Children   []*treemapNode `json:"children,omitempty"`
+	Statements int            `json:"statements,omitempty"`
+	Covered    int            `json:"covered,omitempty"`
+}
+
+// 生成 treemap, profiles 为每个文件的最新版本(来自 LatestVersionProfiles)
+func WriteTreemap(fileName string, profiles []*cover.Profile) error {
+	root := &treemapNode{}
+	packages := make(map[string]*treemapNode)
+	for _, stat := range ComputeFileCoverage(profiles) {
+		dir := path.Dir(stat.FileName)
+		pkg := packages[dir]
+		if pkg == nil {
+			pkg = &treemapNode{Name: dir}
+			packages[dir] = pkg
+			root.Children = append(root.Children, pkg)
+		}
+		pkg.Children = append(pkg.Children, &treemapNode{Name: path.Base(stat.FileName), Statements: stat.Statements, Covered: stat.Covered})
+	}
+	sort.Slice(root.Children, func(i, j int) bool { return root.Children[i].Name < root.Children[j].Name })
+	data, err := json.Marshal(root)
+	if err != nil {
+		return err
+	}
+	// 防止数据中的 </script> 提前结束脚本
+	content := strings.Replace(g_treemapHTML, "/*DATA*/null", strings.ReplaceAll(string(data), "</", `<\/`), 1)
+	if err := CheckOfflineHTML(content); err != nil {
+		return err
+	}
+
+	outFile, upload, err := LocalOutput(fileName)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(outFile, []byte(content), 0644); err != nil {
+		return err
+	}
+	if err := upload(); err != nil {
+		return err
+	}
+	fmt.Println("generate ", fileName, " ok.")
+	return nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"html"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+//go:embed assets/trends.css
+var g_trendsCSS string
+
+// 覆盖率目标, 从 -goal 指定的 JSON 文件读取:
+//
+//	{"target": 80, "deadline": "2026-12-31", "start": "2026-10-01"}
+//
+// start 可以省略, 默认为第一次运行的时间. 需要的进度从 start 时的覆盖率线性增长到 deadline 时的 target
+type CoverageGoal struct {
+	Target   float64 `json:"target"`
+	Start    string  `json:"start"`
+	Deadline string  `json:"deadline"`
+
+	startTime    int64
+	deadlineTime int64
+	baseline     float64 // start 之后第一次运行的覆盖率
+}
+
+// 读取覆盖率目标, runs 按时间排序, 用于确定开始时间和起点覆盖率
+func ReadCoverageGoal(fileName string, runs []*StoredRun) (*CoverageGoal, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	goal := &CoverageGoal{}
+	if err := json.Unmarshal(data, goal); err != nil {
+		return nil, fmt.Errorf("bad goal file %s: %v", fileName, err)
+	}
+	if goal.Target <= 0 || goal.Target > 100 {
+		return nil, fmt.Errorf("bad goal file %s: target must be between 0 and 100", fileName)
+	}
+	now := time.Now()
+	if goal.deadlineTime, err = ParseTimeBound(goal.Deadline, now); err != nil {
+		return nil, fmt.Errorf("bad goal file %s: deadline: %v", fileName, err)
+	}
+	if goal.Start != "" {
+		if goal.startTime, err = ParseTimeBound(goal.Start, now); err != nil {
+			return nil, fmt.Errorf("bad goal file %s: start: %v", fileName, err)
+		}
+	} else if len(runs) > 0 {
+		goal.startTime = runs[0].Timestamp
+	} else {
+		goal.startTime = now.Unix()
+	}
+	if goal.deadlineTime <= goal.startTime {
+		return nil, fmt.Errorf("bad goal file %s: deadline must be after start", fileName)
+	}
+	for _, run := range runs {
+		if run.Timestamp >= goal.startTime {
+			goal.baseline = runPercent(run)
+			break
+		}
+	}
+	return goal, nil
+}
+
+// 在 timestamp 时按计划应该达到的覆盖率
+func (goal *CoverageGoal) RequiredAt(timestamp int64) float64 {
+	if timestamp <= goal.startTime {
+		return goal.baseline
+	}
+	if timestamp >= goal.deadlineTime {
+		return goal.Target
+	}
+	progress := float64(timestamp-goal.startTime) / float64(goal.deadlineTime-goal.startTime)
+	return goal.baseline + (goal.Target-goal.baseline)*progress
+}
+
+func runPercent(run *StoredRun) float64 {
+	return FileCoverage{Statements: run.Statements, Covered: run.Covered}.Percent()
+}
+
+// trends: 从历史库生成覆盖率趋势页面, 指定 -goal 时同时画出达到目标需要的进度(burn-up)
+func runTrends(args []string) error {
+	fs := NewSubCommandFlagSet("trends", "[options]")
+	strDB := fs.String("db", "cover.db", "覆盖率历史库(SQLite)")
+	strTags := fs.String("tags", "", "只使用带有这些标签的运行, 例如 env=prod,suite=e2e")
+	strSince := fs.String("since", "", "只使用该时间之后的运行: 7d, 2006-01-02 或 unix 时间戳")
+	strUntil := fs.String("until", "", "只使用该时间之前的运行: 7d, 2006-01-02 或 unix 时间戳")
+	strGoal := fs.String("goal", "", "覆盖率目标文件(JSON): {\"target\": 80, \"deadline\": \"2026-12-31\", \"start\": \"2026-10-01\"}")
+	strOut := fs.String("o", "trends.html", "输出的趋势页面")
+	fs.Parse(args)
+
+	filter, err := parseRunFilter("", *strTags, *strSince, *strUntil)
+	if err != nil {
+		return err
+	}
+	store, err := OpenStore(*strDB)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	runs, err := store.ListRuns(filter)
+	if err != nil {
+		return err
+	}
+	var goal *CoverageGoal
+	if *strGoal != "" {
+		if goal, err = ReadCoverageGoal(*strGoal, runs); err != nil {
+			return err
+		}
+	}
+	if err := WriteTrendsHTML(*strOut, runs, goal); err != nil {
+		return err
+	}
+	fmt.Println("generate ", *strOut, " ok.")
+	return nil
+}
+
+// 趋势图的尺寸
+const (
+	trendsWidth  = 800
+	trendsHeight = 300
+	trendsMargin = 40
+)
+
+// 生成趋势页面: 每次运行的覆盖率折线, 有目标时加上需要的进度线和目标线, 以及每次运行的明细表
+func WriteTrendsHTML(fileName string, runs []*StoredRun, goal *CoverageGoal) error {
+	var sb strings.Builder
+	sb.WriteString("<!DOCTYPE html>\n<html lang=\"en\">\n<head>\n<meta charset=\"utf-8\">\n<title>Coverage trends</title>\n<style>\n")
+	sb.WriteString(g_trendsCSS)
+	sb.WriteString("</style>\n</head>\n<body>\n<h1>Coverage trends</h1>\n")
+	if len(runs) == 0 {
+		sb.WriteString("<p>No runs.</p>\n")
+	}
+	if goal != nil {
+		writeGoalSummary(&sb, runs, goal)
+	}
+	if len(runs) > 0 {
+		writeTrendsChart(&sb, runs, goal)
+		writeTrendsTable(&sb, runs, goal)
+	}
+	sb.WriteString("</body>\n</html>\n")
+	if err := CheckOfflineHTML(sb.String()); err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, []byte(sb.String()), 0644)
+}
+
+func formatDate(timestamp int64) string {
+	return time.Unix(timestamp, 0).Format("2006-01-02")
+}
+
+// 目标和最近一次运行的进度: 领先或落后多少, 剩余时间每周需要提高多少
+func writeGoalSummary(sb *strings.Builder, runs []*StoredRun, goal *CoverageGoal) {
+	fmt.Fprintf(sb, "<p class=\"goal\">Goal: <strong>%.1f%%</strong> by %s, starting from %.1f%% on %s.",
+		goal.Target, formatDate(goal.deadlineTime), goal.baseline, formatDate(goal.startTime))
+	if len(runs) > 0 {
+		last := runs[len(runs)-1]
+		actual, required := runPercent(last), goal.RequiredAt(last.Timestamp)
+		gap := actual - required
+		if gap >= 0 {
+			fmt.Fprintf(sb, " Latest %.1f%%, required %.1f%%: <span class=\"ahead\">ahead by %.1f points</span>.", actual, required, gap)
+		} else {
+			fmt.Fprintf(sb, " Latest %.1f%%, required %.1f%%: <span class=\"behind\">behind by %.1f points</span>.", actual, required, -gap)
+		}
+		if remaining := goal.deadlineTime - last.Timestamp; remaining > 0 && actual < goal.Target {
+			weeks := float64(remaining) / float64(7*24*3600)
+			fmt.Fprintf(sb, " %.2f points per week needed to reach the goal.", (goal.Target-actual)/weeks)
+		}
+	}
+	sb.WriteString("</p>\n")
+}
+
+func writeTrendsChart(sb *strings.Builder, runs []*StoredRun, goal *CoverageGoal) {
+	minTime, maxTime := runs[0].Timestamp, runs[len(runs)-1].Timestamp
+	if goal != nil {
+		minTime = min(minTime, goal.startTime)
+		maxTime = max(maxTime, goal.deadlineTime)
+	}
+	if maxTime == minTime {
+		maxTime = minTime + 1
+	}
+	plotWidth, plotHeight := float64(trendsWidth-2*trendsMargin), float64(trendsHeight-2*trendsMargin)
+	x := func(timestamp int64) float64 {
+		return trendsMargin + plotWidth*float64(timestamp-minTime)/float64(maxTime-minTime)
+	}
+	y := func(percent float64) float64 {
+		return trendsMargin + plotHeight*(100-percent)/100
+	}
+
+	label := "Coverage per run"
+	if goal != nil {
+		label += " against the required progress to the goal"
+	}
+	fmt.Fprintf(sb, "<svg viewBox=\"0 0 %d %d\" width=\"%d\" height=\"%d\" role=\"img\" aria-label=\"%s\">\n", trendsWidth, trendsHeight, trendsWidth, trendsHeight, label)
+	for percent := 0; percent <= 100; percent += 25 {
+		fmt.Fprintf(sb, "<line class=\"grid\" x1=\"%d\" y1=\"%.1f\" x2=\"%d\" y2=\"%.1f\"/>", trendsMargin, y(float64(percent)), trendsWidth-trendsMargin, y(float64(percent)))
+		fmt.Fprintf(sb, "<text x=\"%d\" y=\"%.1f\" text-anchor=\"end\">%d%%</text>\n", trendsMargin-4, y(float64(percent))+4, percent)
+	}
+	fmt.Fprintf(sb, "<line class=\"axis\" x1=\"%d\" y1=\"%.1f\" x2=\"%d\" y2=\"%.1f\"/>\n", trendsMargin, y(0), trendsWidth-trendsMargin, y(0))
+	fmt.Fprintf(sb, "<text x=\"%d\" y=\"%d\">%s</text>", trendsMargin, trendsHeight-trendsMargin/2, formatDate(minTime))
+	fmt.Fprintf(sb, "<text x=\"%d\" y=\"%d\" text-anchor=\"end\">%s</text>\n", trendsWidth-trendsMargin, trendsHeight-trendsMargin/2, formatDate(maxTime))
+
+	if goal != nil {
+		fmt.Fprintf(sb, "<line class=\"target\" x1=\"%d\" y1=\"%.1f\" x2=\"%d\" y2=\"%.1f\"/>\n", trendsMargin, y(goal.Target), trendsWidth-trendsMargin, y(goal.Target))
+		fmt.Fprintf(sb, "<polyline class=\"required\" points=\"%.1f,%.1f %.1f,%.1f\"/>\n",
+			x(goal.startTime), y(goal.baseline), x(goal.deadlineTime), y(goal.Target))
+	}
+	points := make([]string, 0, len(runs))
+	for _, run := range runs {
+		points = append(points, fmt.Sprintf("%.1f,%.1f", x(run.Timestamp), y(runPercent(run))))
+	}
+	fmt.Fprintf(sb, "<polyline class=\"actual\" points=\"%s\"/>\n", strings.Join(points, " "))
+	for i, run := range runs {
+		xy := strings.Split(points[i], ",")
+		fmt.Fprintf(sb, "<circle class=\"actual-point\" cx=\"%s\" cy=\"%s\" r=\"3\"><title>%s %s: %s</title></circle>\n",
+			xy[0], xy[1], formatDate(run.Timestamp), html.EscapeString(run.GitHash), formatPercent(run.Covered, run.Statements))
+	}
+	sb.WriteString("</svg>\n")
+	sb.WriteString("<p class=\"legend\"><span>solid line: actual coverage</span>")
+	if goal != nil {
+		sb.WriteString("<span>dashed line: required progress</span><span>dotted line: target</span>")
+	}
+	sb.WriteString("</p>\n")
+}
+
+func writeTrendsTable(sb *strings.Builder, runs []*StoredRun, goal *CoverageGoal) {
+	sb.WriteString("<table>\n<tr><th>Time</th><th>Git hash</th><th>Tags</th><th class=\"num\">Coverage</th>")
+	if goal != nil {
+		sb.WriteString("<th class=\"num\">Required</th><th class=\"num\">Gap</th>")
+	}
+	sb.WriteString("</tr>\n")
+	for _, run := range runs {
+		fmt.Fprintf(sb, "<tr><td>%s</td><td>%s</td><td>%s</td><td class=\"num\">%s</td>", time.Unix(run.Timestamp, 0).Format("2006-01-02 15:04"),
+			html.EscapeString(run.GitHash), html.EscapeString(FormatTags(run.Tags)), formatPercent(run.Covered, run.Statements))
+		if goal != nil {
+			required := goal.RequiredAt(run.Timestamp)
+			gap := runPercent(run) - required
+			// 显示为 0.0 的差距不带负号
+			if math.Abs(gap) < 0.05 {
+				gap = 0
+			}
+			fmt.Fprintf(sb, "<td class=\"num\">%.1f%%</td><td class=\"num\">%+.1f</td>", required, gap)
+		}
+		sb.WriteString("</tr>\n")
+	}
+	sb.WriteString("</table>\n")
+}
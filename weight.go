@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/cover"
+)
+
+// 拆分输入末尾的 :<权重>, 例如 load/cover.txt.1723042827.e24dac6:0.1, 没有权重时返回 1.
+// 标签写在权重之后(file:0.5#suite=load). URL 输入(带 ://)不支持权重, 避免把端口当作权重
+func SplitInputWeight(input string) (string, float64, error) {
+	i := strings.LastIndex(input, ":")
+	if i < 0 || strings.Contains(input, "://") {
+		return input, 1, nil
+	}
+	weight, err := strconv.ParseFloat(input[i+1:], 64)
+	if err != nil {
+		return input, 1, nil
+	}
+	if weight <= 0 || math.IsInf(weight, 0) || math.IsNaN(weight) {
+		return "", 0, fmt.Errorf("input %s: weight must be a positive number", input)
+	}
+	return input[:i], weight, nil
+}
+
+// 按权重缩放计数(四舍五入), 执行过的块至少保留 1, 不改变是否覆盖. set 模式只记录是否执行过, 不缩放
+func ApplyWeight(profiles []*cover.Profile, weight float64) {
+	if weight == 0 || weight == 1 {
+		return
+	}
+	for _, p := range profiles {
+		if p.Mode == "set" {
+			continue
+		}
+		for i, b := range p.Blocks {
+			if b.Count == 0 {
+				continue
+			}
+			p.Blocks[i].Count = max(floatCount(math.Round(float64(b.Count)*weight)), 1)
+		}
+	}
+}
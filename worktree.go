@@ -0,0 +1,131 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	g_strWorktreeSafety    = flag.String("worktree-safety", "temp", "工作区有未提交的改动, 在受保护的分支上或 go/src 不可写时, 各版本源码的导出方式: temp 导出到临时目录, refuse 报错退出, off 仍然导出到工作区的 go/src")
+	g_strProtectedBranches = flag.String("protected-branches", "", "受保护的分支, 逗号分隔, 支持通配符, 例如 main,release/*(为空不检查)")
+)
+
+// 导出的各版本源码 <file>.<githash> 所在的目录, 默认在工作区中, 见 -worktree-safety
+var g_strSourceRoot = filepath.Join("go", "src")
+
+// 导出的 fileName 在 gitHash 版本的源码路径
+func VersionedSourcePath(fileName string, gitHash string) string {
+	return filepath.Join(g_strSourceRoot, fileName+"."+gitHash)
+}
+
+func checkWorktreeSafety(mode string) error {
+	switch mode {
+	case "temp", "refuse", "off":
+		return nil
+	}
+	return fmt.Errorf("unsupported worktree-safety '%s', expected temp, refuse or off", mode)
+}
+
+// 工作区不适合写入导出源码的原因, 可以写入时返回空
+func worktreeUnsafeReason() (string, error) {
+	modified, untracked, branch, err := worktreeStatus()
+	if err != nil {
+		return "", err
+	}
+	if modified {
+		return "the worktree has uncommitted changes", nil
+	}
+	// 未跟踪的文件(如之前的运行遗留的 <file>.<githash>)容易和导出的源码一起被提交
+	if len(untracked) > 0 {
+		files := strings.Join(untracked, ", ")
+		if len(untracked) > 3 {
+			files = fmt.Sprintf("%s and %d more", strings.Join(untracked[:3], ", "), len(untracked)-3)
+		}
+		return "the worktree has untracked files: " + files, nil
+	}
+	if *g_strProtectedBranches != "" {
+		if branch != "" {
+			for _, pattern := range strings.Split(*g_strProtectedBranches, ",") {
+				if ok, _ := path.Match(strings.TrimSpace(pattern), branch); ok {
+					return fmt.Sprintf("branch %s is protected", branch), nil
+				}
+			}
+		}
+	}
+	root := defaultSourceRoot()
+	if f, err := os.CreateTemp(root, ".gocovmerge-*"); err != nil {
+		if !os.IsNotExist(err) {
+			return fmt.Sprintf("%s is not writable", root), nil
+		}
+	} else {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	return "", nil
+}
+
+// 已跟踪的文件是否有改动, 未被 .gitignore 忽略的未跟踪文件, 以及当前分支(不在分支上时为空)
+func worktreeStatus() (modified bool, untracked []string, branch string, err error) {
+	if g_gitBackend != nil {
+		return g_gitBackend.Status()
+	}
+	out, err := GitCommand("status", "--porcelain", "-z").Output()
+	if err != nil {
+		return false, nil, "", fmt.Errorf("git status: %v", err)
+	}
+	// XY SP <路径> NUL, 重命名和复制之后还有 <原路径> NUL
+	entries := strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if len(entry) < 4 {
+			continue
+		}
+		switch {
+		case entry[:2] == "??":
+			untracked = append(untracked, entry[3:])
+		case entry[0] == 'R' || entry[0] == 'C':
+			modified = true
+			i++
+		default:
+			modified = true
+		}
+	}
+	if *g_strProtectedBranches != "" {
+		if out, err := GitCommand("symbolic-ref", "--short", "-q", "HEAD").Output(); err == nil {
+			branch = strings.TrimSpace(string(out))
+		}
+	}
+	return modified, untracked, branch, nil
+}
+
+// 按 -worktree-safety 决定导出源码的目录, 需要时(包括裸仓库没有工作区时)创建临时目录, 返回的 cleanup 删除临时目录
+func PrepareSourceRoot() (cleanup func(), err error) {
+	cleanup = func() {}
+	reason := "there is no work tree"
+	if defaultSourceRoot() != "" {
+		if *g_strWorktreeSafety == "off" {
+			return cleanup, nil
+		}
+		reason, err = worktreeUnsafeReason()
+		if err != nil || reason == "" {
+			return cleanup, err
+		}
+	}
+	if *g_strWorktreeSafety == "refuse" {
+		return cleanup, fmt.Errorf("refusing to extract versioned sources into the worktree: %s (use -worktree-safety temp)", reason)
+	}
+	dir, err := os.MkdirTemp("", "gocovmerge-src-*")
+	if err != nil {
+		return cleanup, err
+	}
+	g_strSourceRoot = filepath.Join(dir, "go", "src")
+	fmt.Println("warning:", reason+", extracting versioned sources to", dir)
+	return func() {
+		os.RemoveAll(dir)
+		g_strSourceRoot = defaultSourceRoot()
+	}, nil
+}
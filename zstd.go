@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var g_strCompressFormat = flag.String("compress-format", "gzip", "-compress 的压缩格式: gzip 或 zstd(压缩率更高, 适合服务器上大量保存的快照和中间文件)")
+
+// 判断内容是否为 zstd 压缩数据
+func IsZstdContent(head []byte) bool {
+	return len(head) >= 4 && head[0] == 0x28 && head[1] == 0xb5 && head[2] == 0x2f && head[3] == 0xfd
+}
+
+func checkCompressFormat(format string) error {
+	switch format {
+	case "gzip", "zstd":
+		return nil
+	}
+	return fmt.Errorf("unsupported compress format '%s', expected gzip or zstd", format)
+}
+
+// 按格式压缩写入 w, Close 时写完压缩数据(不关闭 w)
+func NewCompressWriter(w io.Writer, format string) (io.WriteCloser, error) {
+	switch format {
+	case "gzip":
+		return gzip.NewWriter(w), nil
+	case "zstd":
+		return zstd.NewWriter(w)
+	}
+	return nil, checkCompressFormat(format)
+}
+
+// 根据内容识别 gzip 和 zstd 压缩的数据并解压, 未压缩的原样返回. 读完后调用返回的 close
+func NewDecompressReader(r io.Reader) (io.Reader, func(), error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(4)
+	switch {
+	case IsGzipContent(head):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, err
+		}
+		return gz, func() { gz.Close() }, nil
+	case IsZstdContent(head):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, zr.Close, nil
+	}
+	return br, func() {}, nil
+}
+
+// 解压 zstd 数据的开头, 数据不完整时能解压出多少返回多少
+func peekZstdHead(head []byte, limit int64) []byte {
+	zr, err := zstd.NewReader(bytes.NewReader(head))
+	if err != nil {
+		return nil
+	}
+	defer zr.Close()
+	data, _ := io.ReadAll(io.LimitReader(zr, limit))
+	return data
+}